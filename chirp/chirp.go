@@ -8,20 +8,25 @@
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"net"
+	"net/netip"
 	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/atomicfile"
 )
 
+// execTimeout is how long a command and its reply may take before
+// the connection to BIRD is given up on.
+var execTimeout = 10 * time.Second
+
 // New creates a BIRDClient.
 func New(socket string) (*BIRDClient, error) {
-	conn, err := net.Dial("unix", socket)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to BIRD: %w", err)
-	}
-	b := &BIRDClient{socket: socket, conn: conn, scanner: bufio.NewScanner(conn)}
-	// Read and discard the first line as that is the welcome message.
-	if _, err := b.readResponse(); err != nil {
+	b := &BIRDClient{socket: socket}
+	if err := b.connectLocked(); err != nil {
 		return nil, err
 	}
 	return b, nil
@@ -29,13 +34,42 @@ func New(socket string) (*BIRDClient, error) {
 
 // BIRDClient handles communication with the BIRD Internet Routing Daemon.
 type BIRDClient struct {
-	socket  string
-	conn    net.Conn
+	socket string
+
+	mu      sync.Mutex // serializes exec, as replies aren't tagged with their request
+	conn    net.Conn   // or nil after an error, until the next exec reconnects
 	scanner *bufio.Scanner
+	closed  bool
+}
+
+// connectLocked dials BIRD and reads its welcome message.
+func (b *BIRDClient) connectLocked() error {
+	conn, err := net.DialTimeout("unix", b.socket, execTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to BIRD: %w", err)
+	}
+	b.conn = conn
+	b.scanner = bufio.NewScanner(conn)
+	conn.SetDeadline(time.Now().Add(execTimeout))
+	// Read and discard the first line as that is the welcome message.
+	if _, err := b.readResponse(); err != nil {
+		conn.Close()
+		b.conn = nil
+		return err
+	}
+	return nil
 }
 
 // Close closes the underlying connection to BIRD.
-func (b *BIRDClient) Close() error { return b.conn.Close() }
+func (b *BIRDClient) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	if b.conn == nil {
+		return nil
+	}
+	return b.conn.Close()
+}
 
 // DisableProtocol disables the provided protocol.
 func (b *BIRDClient) DisableProtocol(protocol string) error {
@@ -65,6 +99,116 @@ func (b *BIRDClient) EnableProtocol(protocol string) error {
 	return fmt.Errorf("failed to enable %s: %v", protocol, out)
 }
 
+// Configure makes BIRD reread its configuration files.
+func (b *BIRDClient) Configure() error {
+	out, err := b.exec("configure")
+	if err != nil {
+		return err
+	}
+	if isErrorResponse(out) {
+		return fmt.Errorf("failed to configure: %v", out)
+	}
+	return nil
+}
+
+// Protocol is a protocol instance in BIRD, as listed by "show protocols".
+type Protocol struct {
+	Name  string
+	Proto string // type of protocol, such as "BGP", "Static" or "Kernel"
+	Table string // "---" for protocols without a single table
+	State string // "up", "down", "start" or "stop"
+	Since string // when State last changed, in BIRD's configured time format
+	Info  string // protocol-specific detail, such as "Established" for BGP
+}
+
+// Protocols returns the protocols configured in BIRD.
+func (b *BIRDClient) Protocols() ([]Protocol, error) {
+	out, err := b.exec("show protocols")
+	if err != nil {
+		return nil, err
+	}
+	if isErrorResponse(out) {
+		return nil, fmt.Errorf("failed to show protocols: %v", out)
+	}
+	var ps []Protocol
+	for _, l := range replyLines(out, "1002") {
+		f := strings.Fields(l)
+		if len(f) < 4 {
+			continue
+		}
+		p := Protocol{Name: f[0], Proto: f[1], Table: f[2], State: f[3]}
+		f = f[4:]
+		if len(f) > 0 {
+			p.Since = f[0]
+			f = f[1:]
+			// With a time format like "iso short", Since is a
+			// date and a time.
+			if len(f) > 0 && strings.Count(f[0], ":") == 2 {
+				p.Since += " " + f[0]
+				f = f[1:]
+			}
+		}
+		p.Info = strings.Join(f, " ")
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+// RouteCount returns the number of routes that the named protocol has
+// in BIRD's tables. Unlike listing them, counting them takes a short
+// reply regardless of the size of the tables.
+func (b *BIRDClient) RouteCount(protocol string) (int, error) {
+	out, err := b.exec("show route protocol %s count", protocol)
+	if err != nil {
+		return 0, err
+	}
+	if isErrorResponse(out) {
+		return 0, fmt.Errorf("failed to count routes of %s: %v", protocol, out)
+	}
+	// The reply ends with a total, like "Total: 4 of 9 routes for 7
+	// networks in 2 tables", or without "Total: " on older versions
+	// of BIRD, where the first number is the routes that matched.
+	lines := replyLines(out, "0014")
+	if len(lines) == 0 {
+		return 0, fmt.Errorf("no route count in reply: %v", out)
+	}
+	var n int
+	if _, err := fmt.Sscanf(strings.TrimPrefix(lines[0], "Total: "), "%d of", &n); err != nil {
+		return 0, fmt.Errorf("unexpected route count %q: %w", lines[0], err)
+	}
+	return n, nil
+}
+
+// WriteStaticRoutes writes a BIRD configuration file to path that
+// defines static protocols named name+"4" and name+"6" with routes
+// pointing at the network interface dev. It's meant to be pulled into
+// BIRD's configuration with an include statement, after which
+// Configure makes BIRD pick up changes.
+func WriteStaticRoutes(path, name, dev string, routes []netip.Prefix) error {
+	return atomicfile.WriteFile(path, StaticRoutesConfig(name, dev, routes), 0644)
+}
+
+// StaticRoutesConfig returns the contents of the file written by
+// WriteStaticRoutes.
+func StaticRoutesConfig(name, dev string, routes []netip.Prefix) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("# Generated by tailscaled; DO NOT EDIT.\n")
+	for _, fam := range []struct {
+		suffix string
+		is4    bool
+	}{{"4", true}, {"6", false}} {
+		fmt.Fprintf(&buf, "\nprotocol static %s%s {\n", name, fam.suffix)
+		fmt.Fprintf(&buf, "\tipv%s;\n", fam.suffix)
+		for _, r := range routes {
+			if r.Addr().Is4() == fam.is4 {
+				fmt.Fprintf(&buf, "\troute %s via %q;\n", r.Masked(), dev)
+			}
+		}
+		buf.WriteString("}\n")
+	}
+	return buf.Bytes()
+}
+
 // BIRD CLI docs from https://bird.network.cz/?get_doc&v=20&f=prog-2.html#ss2.9
 
 // Each session of the CLI consists of a sequence of request and replies,
@@ -81,10 +225,32 @@ func (b *BIRDClient) EnableProtocol(protocol string) error {
 // 1 means ‘table entry’, 8 ‘runtime error’ and 9 ‘syntax error’.
 
 func (b *BIRDClient) exec(cmd string, args ...any) (string, error) {
-	if _, err := fmt.Fprintf(b.conn, cmd, args...); err != nil {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return "", net.ErrClosed
+	}
+	if b.conn == nil {
+		if err := b.connectLocked(); err != nil {
+			return "", err
+		}
+	}
+	b.conn.SetDeadline(time.Now().Add(execTimeout))
+	out, err := b.execLocked(cmd, args...)
+	if err != nil {
+		// The rest of the reply may still arrive and be taken for
+		// the reply to the next command, so start over with a new
+		// connection.
+		b.conn.Close()
+		b.conn = nil
+	}
+	return out, err
+}
+
+func (b *BIRDClient) execLocked(cmd string, args ...any) (string, error) {
+	if _, err := fmt.Fprintf(b.conn, cmd+"\n", args...); err != nil {
 		return "", err
 	}
-	fmt.Fprintln(b.conn)
 	return b.readResponse()
 }
 
@@ -104,16 +270,45 @@ func hasResponseCode(s []byte) bool {
 	return s[4] == ' ' || s[4] == '-'
 }
 
+// isErrorResponse reports whether the BIRD reply out ends with a
+// runtime or syntax error code.
+func isErrorResponse(out string) bool {
+	last := out[strings.LastIndex(out, "\n")+1:]
+	return hasResponseCode([]byte(last)) && (last[0] == '8' || last[0] == '9')
+}
+
+// replyLines returns the text of the lines of the BIRD reply out that
+// have response code code, including continuation lines without a code,
+// which retain their leading white space.
+func replyLines(out, code string) []string {
+	var lines []string
+	var cur string
+	for _, l := range strings.Split(out, "\n") {
+		if hasResponseCode([]byte(l)) {
+			cur = l[:4]
+			l = l[5:]
+		} else if strings.HasPrefix(l, " ") {
+			l = l[1:]
+		} else {
+			continue
+		}
+		if cur == code {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
 func (b *BIRDClient) readResponse() (string, error) {
 	var resp strings.Builder
 	var done bool
 	for !done {
 		if !b.scanner.Scan() {
+			if err := b.scanner.Err(); err != nil {
+				return "", fmt.Errorf("reading response from bird failed: %w", err)
+			}
 			return "", fmt.Errorf("reading response from bird failed: %q", resp.String())
 		}
-		if err := b.scanner.Err(); err != nil {
-			return "", err
-		}
 		out := b.scanner.Bytes()
 		if _, err := resp.Write(out); err != nil {
 			return "", err
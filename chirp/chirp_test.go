@@ -8,15 +8,21 @@
 	"errors"
 	"fmt"
 	"net"
+	"net/netip"
+	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 type fakeBIRD struct {
 	net.Listener
 	protocolsEnabled map[string]bool
 	sock             string
+	stall            atomic.Int32 // number of commands to not reply to
 }
 
 func newFakeBIRD(t *testing.T, protocols ...string) *fakeBIRD {
@@ -54,6 +60,9 @@ func (fb *fakeBIRD) handle(c net.Conn) {
 	sc := bufio.NewScanner(c)
 	for sc.Scan() {
 		cmd := sc.Text()
+		if fb.stall.Add(-1) >= 0 {
+			continue
+		}
 		args := strings.Split(cmd, " ")
 		switch args[0] {
 		case "enable":
@@ -78,6 +87,29 @@ func (fb *fakeBIRD) handle(c net.Conn) {
 			}
 			fmt.Fprintln(c, "0000 ")
 			fb.protocolsEnabled[args[1]] = false
+		case "configure":
+			fmt.Fprintln(c, "0002-Reading configuration from /etc/bird.conf")
+			fmt.Fprintln(c, "0003 Reconfigured")
+		case "show":
+			switch {
+			case cmd == "show protocols":
+				fmt.Fprint(c, "2002-Name       Proto      Table      State  Since         Info\n"+
+					"1002-device1    Device     ---        up     2022-10-01 10:01:02  \n"+
+					" tailscale  Static     master4    down   2022-10-01 10:01:02  \n"+
+					" bgp1       BGP        ---        up     2022-10-01 10:01:05  Established   \n"+
+					"0000 \n")
+			case cmd == "show route protocol bgp1 count":
+				fmt.Fprint(c, "1007-Table master4:\n"+
+					" 3 of 9 routes for 6 networks in table master4\n"+
+					" \n"+
+					"1007-Table master6:\n"+
+					" 1 of 2 routes for 2 networks in table master6\n"+
+					"0014 Total: 4 of 11 routes for 8 networks in 2 tables\n")
+			case cmd == "show route protocol static1 count":
+				fmt.Fprint(c, "0014 2 of 5 routes for 5 networks\n")
+			default:
+				fmt.Fprintln(c, "9001 syntax error, unexpected CF_SYM_UNDEFINED")
+			}
 		}
 	}
 }
@@ -109,3 +141,104 @@ func TestChirp(t *testing.T) {
 		t.Fatalf("disabling %q succeded", "rando")
 	}
 }
+
+func TestShow(t *testing.T) {
+	fb := newFakeBIRD(t)
+	defer fb.Close()
+	go fb.listen()
+	c, err := New(fb.sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.Configure(); err != nil {
+		t.Fatal(err)
+	}
+
+	ps, err := c.Protocols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPS := []Protocol{
+		{Name: "device1", Proto: "Device", Table: "---", State: "up", Since: "2022-10-01 10:01:02"},
+		{Name: "tailscale", Proto: "Static", Table: "master4", State: "down", Since: "2022-10-01 10:01:02"},
+		{Name: "bgp1", Proto: "BGP", Table: "---", State: "up", Since: "2022-10-01 10:01:05", Info: "Established"},
+	}
+	if !reflect.DeepEqual(ps, wantPS) {
+		t.Errorf("Protocols = %+v; want %+v", ps, wantPS)
+	}
+
+	for proto, want := range map[string]int{"bgp1": 4, "static1": 2} {
+		n, err := c.RouteCount(proto)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != want {
+			t.Errorf("RouteCount(%q) = %d; want %d", proto, n, want)
+		}
+	}
+
+	if _, err := c.RouteCount("rando"); err == nil {
+		t.Errorf("RouteCount(%q) succeeded", "rando")
+	}
+}
+
+func TestExecTimeout(t *testing.T) {
+	defer func(old time.Duration) { execTimeout = old }(execTimeout)
+	execTimeout = 100 * time.Millisecond
+
+	fb := newFakeBIRD(t)
+	defer fb.Close()
+	go fb.listen()
+	c, err := New(fb.sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	fb.stall.Store(1)
+	if _, err := c.Protocols(); err == nil {
+		t.Fatal("Protocols succeeded without a reply")
+	}
+	// The next command reconnects rather than reading a stale reply.
+	ps, err := c.Protocols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 3 {
+		t.Errorf("Protocols = %+v; want 3", ps)
+	}
+}
+
+func TestWriteStaticRoutes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tailscale.conf")
+	routes := []netip.Prefix{
+		netip.MustParsePrefix("100.64.0.0/10"),
+		netip.MustParsePrefix("fd7a:115c:a1e0::/48"),
+		netip.MustParsePrefix("10.0.0.1/24"),
+	}
+	if err := WriteStaticRoutes(path, "tailscale_routes", "tailscale0", routes); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# Generated by tailscaled; DO NOT EDIT.
+
+protocol static tailscale_routes4 {
+	ipv4;
+	route 100.64.0.0/10 via "tailscale0";
+	route 10.0.0.0/24 via "tailscale0";
+}
+
+protocol static tailscale_routes6 {
+	ipv6;
+	route fd7a:115c:a1e0::/48 via "tailscale0";
+}
+`
+	if string(got) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
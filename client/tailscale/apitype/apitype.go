@@ -30,3 +30,20 @@ type WaitingFile struct {
 	Name string
 	Size int64
 }
+
+// LogLevels is the JSON type returned by the local API's log-level
+// handler. It describes the verbosity of the logs that tailscaled
+// writes to stderr; logs of all levels are still uploaded.
+type LogLevels struct {
+	// Level is tailscaled's overall verbosity level. 0 is the
+	// default (not verbose); 1 or higher are increasingly verbose.
+	Level int
+
+	// Subsystems maps the subsystems whose verbosity level has been
+	// set independently of Level to their level.
+	Subsystems map[string]int `json:",omitempty"`
+
+	// Known are the names of the subsystems whose verbosity level
+	// can be set.
+	Known []string `json:",omitempty"`
+}
@@ -46,17 +46,18 @@ type Device struct {
 	Name      string   `json:"name"`
 	Hostname  string   `json:"hostname"`
 
-	ClientVersion     string `json:"clientVersion"`   // Empty for external devices.
-	UpdateAvailable   bool   `json:"updateAvailable"` // Empty for external devices.
-	OS                string `json:"os"`
-	Created           string `json:"created"` // Empty for external devices.
-	LastSeen          string `json:"lastSeen"`
-	KeyExpiryDisabled bool   `json:"keyExpiryDisabled"`
-	Expires           string `json:"expires"`
-	Authorized        bool   `json:"authorized"`
-	IsExternal        bool   `json:"isExternal"`
-	MachineKey        string `json:"machineKey"` // Empty for external devices.
-	NodeKey           string `json:"nodeKey"`
+	ClientVersion     string   `json:"clientVersion"`   // Empty for external devices.
+	UpdateAvailable   bool     `json:"updateAvailable"` // Empty for external devices.
+	OS                string   `json:"os"`
+	Created           string   `json:"created"` // Empty for external devices.
+	LastSeen          string   `json:"lastSeen"`
+	KeyExpiryDisabled bool     `json:"keyExpiryDisabled"`
+	Expires           string   `json:"expires"`
+	Authorized        bool     `json:"authorized"`
+	IsExternal        bool     `json:"isExternal"`
+	MachineKey        string   `json:"machineKey"` // Empty for external devices.
+	NodeKey           string   `json:"nodeKey"`
+	Tags              []string `json:"tags"` // ACL tags applied to the device, if any.
 
 	// BlocksIncomingConnections is configured via the device's
 	// Tailscale client preferences. This field is only reported
@@ -260,3 +261,75 @@ func (c *Client) SetTags(ctx context.Context, deviceID string, tags []string) er
 
 	return nil
 }
+
+// SetKeyExpiryDisabled disables or re-enables node key expiry for a
+// device. Devices with key expiry disabled don't need to re-authenticate
+// periodically.
+func (c *Client) SetKeyExpiryDisabled(ctx context.Context, deviceID string, disabled bool) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.SetKeyExpiryDisabled: %w", err)
+		}
+	}()
+
+	params := &struct {
+		KeyExpiryDisabled bool `json:"keyExpiryDisabled"`
+	}{KeyExpiryDisabled: disabled}
+	path := fmt.Sprintf("%s/api/v2/device/%s/key", c.baseURL(), url.PathEscape(deviceID))
+	return c.doJSON(ctx, "POST", path, params, nil)
+}
+
+// DevicePostureAttributes returns the posture attributes of a device, such
+// as "node:os" or custom "custom:..." attributes set with
+// SetDevicePostureAttribute. Values are strings, numbers or booleans.
+func (c *Client) DevicePostureAttributes(ctx context.Context, deviceID string) (attrs map[string]any, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.DevicePostureAttributes: %w", err)
+		}
+	}()
+
+	var res struct {
+		Attributes map[string]any `json:"attributes"`
+	}
+	path := fmt.Sprintf("%s/api/v2/device/%s/attributes", c.baseURL(), url.PathEscape(deviceID))
+	if err := c.doJSON(ctx, "GET", path, nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Attributes, nil
+}
+
+// SetDevicePostureAttribute sets the custom posture attribute key
+// (which must start with "custom:") of a device to value, which must be a
+// string, number or boolean.
+func (c *Client) SetDevicePostureAttribute(ctx context.Context, deviceID, key string, value any) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.SetDevicePostureAttribute: %w", err)
+		}
+	}()
+
+	switch value.(type) {
+	case string, bool, int, int64, float64:
+	default:
+		return fmt.Errorf("unsupported posture attribute value type %T", value)
+	}
+	params := &struct {
+		Value any `json:"value"`
+	}{Value: value}
+	path := fmt.Sprintf("%s/api/v2/device/%s/attributes/%s", c.baseURL(), url.PathEscape(deviceID), url.PathEscape(key))
+	return c.doJSON(ctx, "POST", path, params, nil)
+}
+
+// DeleteDevicePostureAttribute removes the custom posture attribute key
+// from a device.
+func (c *Client) DeleteDevicePostureAttribute(ctx context.Context, deviceID, key string) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.DeleteDevicePostureAttribute: %w", err)
+		}
+	}()
+
+	path := fmt.Sprintf("%s/api/v2/device/%s/attributes/%s", c.baseURL(), url.PathEscape(deviceID), url.PathEscape(key))
+	return c.doJSON(ctx, "DELETE", path, nil, nil)
+}
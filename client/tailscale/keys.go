@@ -0,0 +1,131 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.19
+// +build go1.19
+
+package tailscale
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Key is an auth key or API key of a tailnet.
+type Key struct {
+	ID           string          `json:"id"`
+	Key          string          `json:"key,omitempty"` // the secret; only set by CreateKey
+	Description  string          `json:"description,omitempty"`
+	Created      time.Time       `json:"created"`
+	Expires      time.Time       `json:"expires"`
+	Revoked      time.Time       `json:"revoked"` // zero if not revoked
+	Invalid      bool            `json:"invalid,omitempty"`
+	UserID       string          `json:"userId,omitempty"`
+	Capabilities KeyCapabilities `json:"capabilities"`
+}
+
+// KeyCapabilities are the capabilities of a Key.
+type KeyCapabilities struct {
+	Devices KeyDeviceCapabilities `json:"devices"`
+}
+
+// KeyDeviceCapabilities are the device-related capabilities of a Key.
+type KeyDeviceCapabilities struct {
+	Create KeyDeviceCreateCapabilities `json:"create"`
+}
+
+// KeyDeviceCreateCapabilities are the properties of devices added to the
+// tailnet with an auth key.
+type KeyDeviceCreateCapabilities struct {
+	Reusable      bool     `json:"reusable"`
+	Ephemeral     bool     `json:"ephemeral"`
+	Preauthorized bool     `json:"preauthorized"`
+	Tags          []string `json:"tags,omitempty"`
+}
+
+// CreateKeyRequest is the parameters of a new auth key.
+type CreateKeyRequest struct {
+	Capabilities KeyCapabilities `json:"capabilities"`
+
+	// ExpirySeconds is how long the key is valid for. If zero, the
+	// server's default (90 days) is used.
+	ExpirySeconds int64 `json:"expirySeconds,omitempty"`
+
+	// Description is a short description of the key's purpose.
+	Description string `json:"description,omitempty"`
+}
+
+// Keys returns the auth keys of the Client's tailnet that the caller can
+// see. The secrets of the keys are not included.
+func (c *Client) Keys(ctx context.Context) (keys []*Key, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.Keys: %w", err)
+		}
+	}()
+
+	path := fmt.Sprintf("%s/api/v2/tailnet/%s/keys", c.baseURL(), url.PathEscape(c.tailnet))
+	err = c.getPages(ctx, path, nil, func(b []byte) error {
+		var page struct {
+			Keys []*Key `json:"keys"`
+		}
+		if err := json.Unmarshal(b, &page); err != nil {
+			return err
+		}
+		keys = append(keys, page.Keys...)
+		return nil
+	})
+	return keys, err
+}
+
+// Key returns the auth key with the given ID. The secret of the key is
+// not included.
+func (c *Client) Key(ctx context.Context, keyID string) (key *Key, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.Key: %w", err)
+		}
+	}()
+
+	path := fmt.Sprintf("%s/api/v2/tailnet/%s/keys/%s", c.baseURL(), url.PathEscape(c.tailnet), url.PathEscape(keyID))
+	if err := c.doJSON(ctx, "GET", path, nil, &key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// CreateKey creates a new auth key in the Client's tailnet. The returned
+// Key's Key field is the secret, which can't be retrieved again later.
+//
+// If the server rejects the request, such as because of tags the caller
+// doesn't own, the error is a ValidationError.
+func (c *Client) CreateKey(ctx context.Context, req CreateKeyRequest) (key *Key, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.CreateKey: %w", err)
+		}
+	}()
+
+	path := fmt.Sprintf("%s/api/v2/tailnet/%s/keys", c.baseURL(), url.PathEscape(c.tailnet))
+	if err := c.doJSON(ctx, "POST", path, req, &key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// RevokeKey revokes the auth key with the given ID. Devices already added
+// to the tailnet with the key are not affected.
+func (c *Client) RevokeKey(ctx context.Context, keyID string) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.RevokeKey: %w", err)
+		}
+	}()
+
+	path := fmt.Sprintf("%s/api/v2/tailnet/%s/keys/%s", c.baseURL(), url.PathEscape(c.tailnet), url.PathEscape(keyID))
+	return c.doJSON(ctx, "DELETE", path, nil, nil)
+}
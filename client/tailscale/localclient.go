@@ -276,6 +276,26 @@ func (lc *LocalClient) BugReport(ctx context.Context, note string) (string, erro
 	return strings.TrimSpace(string(body)), nil
 }
 
+// BugReportBundle logs a bug report marker, like BugReport, and
+// returns it along with a gzipped tar archive of local diagnostics that
+// the user can share with support. The caller must close rc.
+func (lc *LocalClient) BugReportBundle(ctx context.Context, note string) (logMarker string, rc io.ReadCloser, err error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", "http://local-tailscaled.sock/localapi/v0/bugreport-bundle?note="+url.QueryEscape(note), nil)
+	if err != nil {
+		return "", nil, err
+	}
+	res, err := lc.doLocalRequestNiceError(req)
+	if err != nil {
+		return "", nil, err
+	}
+	if res.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		return "", nil, fmt.Errorf("HTTP %s: %s", res.Status, body)
+	}
+	return res.Header.Get("Tailscale-Bugreport-Marker"), res.Body, nil
+}
+
 // DebugAction invokes a debug action, such as "rebind" or "restun".
 // These are development tools and subject to change or removal over time.
 func (lc *LocalClient) DebugAction(ctx context.Context, action string) error {
@@ -286,6 +306,39 @@ func (lc *LocalClient) DebugAction(ctx context.Context, action string) error {
 	return nil
 }
 
+// LogLevels returns the verbosity levels of the logs that the Tailscale
+// daemon writes to stderr.
+func (lc *LocalClient) LogLevels(ctx context.Context) (*apitype.LogLevels, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/log-level")
+	if err != nil {
+		return nil, err
+	}
+	ll := new(apitype.LogLevels)
+	if err := json.Unmarshal(body, ll); err != nil {
+		return nil, err
+	}
+	return ll, nil
+}
+
+// SetSubsystemLogLevel sets the verbosity level of the logs from the
+// Tailscale daemon's subsys subsystem (such as "magicsock" or "dns")
+// that are written to stderr. A negative level reverts subsys to the
+// daemon's overall verbosity level. It returns the resulting levels.
+func (lc *LocalClient) SetSubsystemLogLevel(ctx context.Context, subsys string, level int) (*apitype.LogLevels, error) {
+	v := url.Values{}
+	v.Set("subsys", subsys)
+	v.Set("level", fmt.Sprint(level))
+	body, err := lc.send(ctx, "POST", "/localapi/v0/log-level?"+v.Encode(), 200, nil)
+	if err != nil {
+		return nil, err
+	}
+	ll := new(apitype.LogLevels)
+	if err := json.Unmarshal(body, ll); err != nil {
+		return nil, err
+	}
+	return ll, nil
+}
+
 // Status returns the Tailscale daemon's status.
 func Status(ctx context.Context) (*ipnstate.Status, error) {
 	return defaultLocalClient.Status(ctx)
@@ -318,6 +371,35 @@ func (lc *LocalClient) status(ctx context.Context, queryString string) (*ipnstat
 	return st, nil
 }
 
+// HealthWarnings returns the Tailscale daemon's current health
+// problems, most severe first.
+func (lc *LocalClient) HealthWarnings(ctx context.Context) ([]ipnstate.UnhealthyState, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/health")
+	if err != nil {
+		return nil, err
+	}
+	var ws []ipnstate.UnhealthyState
+	if err := json.Unmarshal(body, &ws); err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
+
+// RunHealthChecks asks the Tailscale daemon to run its active health
+// checks now, and returns their results. Failed checks are also
+// reported by HealthWarnings. It requires write access to the daemon.
+func (lc *LocalClient) RunHealthChecks(ctx context.Context) ([]ipnstate.HealthCheckResult, error) {
+	body, err := lc.send(ctx, "POST", "/localapi/v0/health-check", 200, nil)
+	if err != nil {
+		return nil, err
+	}
+	var res []ipnstate.HealthCheckResult
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
 // IDToken is a request to get an OIDC ID token for an audience.
 // The token can be presented to any resource provider which offers OIDC
 // Federation.
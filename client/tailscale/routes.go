@@ -95,3 +95,54 @@ func (c *Client) SetRoutes(ctx context.Context, deviceID string, subnets []netip
 	}
 	return srr, err
 }
+
+// EnableRoutes enables the given subnet routes for a device, in addition
+// to those already enabled, and returns the updated routes.
+//
+// It's not atomic: routes enabled or disabled by someone else between
+// reading the device's routes and updating them may be lost.
+func (c *Client) EnableRoutes(ctx context.Context, deviceID string, subnets ...netip.Prefix) (*Routes, error) {
+	cur, err := c.Routes(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	enabled := append([]netip.Prefix(nil), cur.EnabledRoutes...)
+	for _, p := range subnets {
+		if !containsPrefix(enabled, p) {
+			enabled = append(enabled, p)
+		}
+	}
+	if len(enabled) == len(cur.EnabledRoutes) {
+		return cur, nil
+	}
+	return c.SetRoutes(ctx, deviceID, enabled)
+}
+
+// DisableRoutes disables the given subnet routes for a device, leaving
+// any others enabled, and returns the updated routes. Like EnableRoutes,
+// it's not atomic.
+func (c *Client) DisableRoutes(ctx context.Context, deviceID string, subnets ...netip.Prefix) (*Routes, error) {
+	cur, err := c.Routes(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	enabled := []netip.Prefix{} // not nil, which would be sent as null
+	for _, p := range cur.EnabledRoutes {
+		if !containsPrefix(subnets, p) {
+			enabled = append(enabled, p)
+		}
+	}
+	if len(enabled) == len(cur.EnabledRoutes) {
+		return cur, nil
+	}
+	return c.SetRoutes(ctx, deviceID, enabled)
+}
+
+func containsPrefix(ps []netip.Prefix, p netip.Prefix) bool {
+	for _, q := range ps {
+		if q == p {
+			return true
+		}
+	}
+	return false
+}
@@ -13,12 +13,16 @@
 package tailscale
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
 )
 
 // I_Acknowledge_This_API_Is_Unstable must be set true to use this package
@@ -149,12 +153,131 @@ func (e ErrResponse) Error() string {
 }
 
 // handleErrorResponse decodes the error message from the server and returns
-// an ErrResponse from it.
+// an ErrResponse from it, or a ValidationError if the server rejected
+// specific fields of the request.
 func handleErrorResponse(b []byte, resp *http.Response) error {
-	var errResp ErrResponse
-	if err := json.Unmarshal(b, &errResp); err != nil {
+	var ve ValidationError
+	if err := json.Unmarshal(b, &ve); err != nil {
 		return err
 	}
-	errResp.Status = resp.StatusCode
-	return errResp
+	ve.Status = resp.StatusCode
+	if len(ve.Data) > 0 {
+		return ve
+	}
+	return ve.ErrResponse
+}
+
+// ValidationErrorDetail is a field of a request that the server rejected,
+// and why.
+type ValidationErrorDetail struct {
+	Field  string   `json:"field"`
+	Errors []string `json:"errors"`
+}
+
+// ValidationError is ErrResponse but with an extra field listing the
+// fields of the request that were invalid, such as unknown tags when
+// creating an auth key.
+type ValidationError struct {
+	ErrResponse
+	Data []ValidationErrorDetail `json:"data"`
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%s, Data: %+v", e.ErrResponse.Error(), e.Data)
+}
+
+// Unwrap returns the embedded ErrResponse, so that errors.As finds it.
+func (e ValidationError) Unwrap() error {
+	return e.ErrResponse
+}
+
+// IsNotFound reports whether err is an ErrResponse (or an error wrapping
+// or embedding one) for a resource that doesn't exist.
+func IsNotFound(err error) bool {
+	var er ErrResponse
+	var ate ACLTestError
+	switch {
+	case errors.As(err, &er):
+	case errors.As(err, &ate):
+		er = ate.ErrResponse
+	default:
+		return false
+	}
+	return er.Status == http.StatusNotFound
+}
+
+// pageSize is the number of items requested per page from list
+// endpoints.
+const pageSize = 100
+
+// getPages sends GET requests to the list endpoint path, following the
+// "nextCursor" in each response until there are no more pages, and calls
+// page with the body of each.
+func (c *Client) getPages(ctx context.Context, path string, query url.Values, page func(b []byte) error) error {
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Set("limit", strconv.Itoa(pageSize))
+	for {
+		req, err := http.NewRequestWithContext(ctx, "GET", path+"?"+q.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		b, resp, err := c.sendRequest(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return handleErrorResponse(b, resp)
+		}
+		if err := page(b); err != nil {
+			return err
+		}
+		var next struct {
+			NextCursor string `json:"nextCursor"`
+		}
+		if err := json.Unmarshal(b, &next); err != nil {
+			return err
+		}
+		if next.NextCursor == "" {
+			return nil
+		}
+		if next.NextCursor == q.Get("cursor") {
+			return errors.New("server returned the same page cursor twice")
+		}
+		q.Set("cursor", next.NextCursor)
+	}
+}
+
+// doJSON sends a request with the given method to path, with body encoded
+// as JSON if non-nil, and decodes a successful response into res if
+// non-nil.
+func (c *Client) doJSON(ctx context.Context, method, path string, body, res any) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, path, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	b, resp, err := c.sendRequest(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return handleErrorResponse(b, resp)
+	}
+	if res == nil {
+		return nil
+	}
+	return json.Unmarshal(b, res)
 }
@@ -0,0 +1,179 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.19
+// +build go1.19
+
+package tailscale
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// UserRole is the role of a user in a tailnet.
+type UserRole string
+
+const (
+	UserRoleOwner        UserRole = "owner"
+	UserRoleAdmin        UserRole = "admin"
+	UserRoleITAdmin      UserRole = "it-admin"
+	UserRoleNetworkAdmin UserRole = "network-admin"
+	UserRoleBillingAdmin UserRole = "billing-admin"
+	UserRoleAuditor      UserRole = "auditor"
+	UserRoleMember       UserRole = "member"
+)
+
+// UserType is whether a user is a member of the tailnet or was shared a
+// device from another tailnet.
+type UserType string
+
+const (
+	UserTypeMember UserType = "member"
+	UserTypeShared UserType = "shared"
+)
+
+// UserStatus is the status of a user in a tailnet.
+type UserStatus string
+
+const (
+	UserStatusActive           UserStatus = "active"
+	UserStatusIdle             UserStatus = "idle"
+	UserStatusSuspended        UserStatus = "suspended"
+	UserStatusNeedsApproval    UserStatus = "needs-approval"
+	UserStatusOverBillingLimit UserStatus = "over-billing-limit"
+)
+
+// User is a user of a tailnet.
+type User struct {
+	ID                 string     `json:"id"`
+	DisplayName        string     `json:"displayName"`
+	LoginName          string     `json:"loginName"`
+	ProfilePicURL      string     `json:"profilePicUrl"`
+	TailnetID          string     `json:"tailnetId"`
+	Created            time.Time  `json:"created"`
+	Type               UserType   `json:"type"`
+	Role               UserRole   `json:"role"`
+	Status             UserStatus `json:"status"`
+	DeviceCount        int        `json:"deviceCount"`
+	LastSeen           time.Time  `json:"lastSeen"`
+	CurrentlyConnected bool       `json:"currentlyConnected"`
+}
+
+// UsersOptions filters the users returned by Client.Users.
+// The zero value returns all users.
+type UsersOptions struct {
+	Type UserType // if non-empty, only users of this type
+	Role UserRole // if non-empty, only users with this role
+}
+
+// Users returns the users of the Client's tailnet that match opts, which
+// may be nil.
+func (c *Client) Users(ctx context.Context, opts *UsersOptions) (users []*User, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.Users: %w", err)
+		}
+	}()
+
+	q := url.Values{}
+	if opts != nil {
+		if opts.Type != "" {
+			q.Set("type", string(opts.Type))
+		}
+		if opts.Role != "" {
+			q.Set("role", string(opts.Role))
+		}
+	}
+	path := fmt.Sprintf("%s/api/v2/tailnet/%s/users", c.baseURL(), url.PathEscape(c.tailnet))
+	err = c.getPages(ctx, path, q, func(b []byte) error {
+		var page struct {
+			Users []*User `json:"users"`
+		}
+		if err := json.Unmarshal(b, &page); err != nil {
+			return err
+		}
+		users = append(users, page.Users...)
+		return nil
+	})
+	return users, err
+}
+
+// User returns the user with the given ID.
+func (c *Client) User(ctx context.Context, userID string) (user *User, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.User: %w", err)
+		}
+	}()
+
+	path := fmt.Sprintf("%s/api/v2/users/%s", c.baseURL(), url.PathEscape(userID))
+	if err := c.doJSON(ctx, "GET", path, nil, &user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// SetUserRole changes the role of the user with the given ID.
+func (c *Client) SetUserRole(ctx context.Context, userID string, role UserRole) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.SetUserRole: %w", err)
+		}
+	}()
+
+	params := &struct {
+		Role UserRole `json:"role"`
+	}{Role: role}
+	return c.doJSON(ctx, "POST", c.userActionPath(userID, "role"), params, nil)
+}
+
+// ApproveUser approves a user whose status is UserStatusNeedsApproval,
+// in tailnets that require new users to be approved.
+func (c *Client) ApproveUser(ctx context.Context, userID string) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.ApproveUser: %w", err)
+		}
+	}()
+	return c.doJSON(ctx, "POST", c.userActionPath(userID, "approve"), nil, nil)
+}
+
+// SuspendUser suspends a user, disconnecting their devices until they
+// are restored with RestoreUser.
+func (c *Client) SuspendUser(ctx context.Context, userID string) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.SuspendUser: %w", err)
+		}
+	}()
+	return c.doJSON(ctx, "POST", c.userActionPath(userID, "suspend"), nil, nil)
+}
+
+// RestoreUser restores a user suspended with SuspendUser.
+func (c *Client) RestoreUser(ctx context.Context, userID string) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.RestoreUser: %w", err)
+		}
+	}()
+	return c.doJSON(ctx, "POST", c.userActionPath(userID, "restore"), nil, nil)
+}
+
+// DeleteUser deletes a user and their devices from the tailnet.
+func (c *Client) DeleteUser(ctx context.Context, userID string) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.DeleteUser: %w", err)
+		}
+	}()
+	return c.doJSON(ctx, "POST", c.userActionPath(userID, "delete"), nil, nil)
+}
+
+func (c *Client) userActionPath(userID, action string) string {
+	return fmt.Sprintf("%s/api/v2/users/%s/%s", c.baseURL(), url.PathEscape(userID), action)
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.19
+// +build go1.19
+
+package tailscale
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// WebhookProviderType is the format of the requests sent to a webhook's
+// endpoint. The empty string sends Tailscale's own JSON format.
+type WebhookProviderType string
+
+const (
+	WebhookEmptyProviderType      WebhookProviderType = ""
+	WebhookSlackProviderType      WebhookProviderType = "slack"
+	WebhookMattermostProviderType WebhookProviderType = "mattermost"
+	WebhookGoogleChatProviderType WebhookProviderType = "googlechat"
+	WebhookDiscordProviderType    WebhookProviderType = "discord"
+)
+
+// WebhookSubscriptionType is an event that a webhook is notified of.
+type WebhookSubscriptionType string
+
+const (
+	WebhookNodeCreated                    WebhookSubscriptionType = "nodeCreated"
+	WebhookNodeNeedsApproval              WebhookSubscriptionType = "nodeNeedsApproval"
+	WebhookNodeApproved                   WebhookSubscriptionType = "nodeApproved"
+	WebhookNodeKeyExpiringInOneDay        WebhookSubscriptionType = "nodeKeyExpiringInOneDay"
+	WebhookNodeKeyExpired                 WebhookSubscriptionType = "nodeKeyExpired"
+	WebhookNodeDeleted                    WebhookSubscriptionType = "nodeDeleted"
+	WebhookPolicyUpdate                   WebhookSubscriptionType = "policyUpdate"
+	WebhookUserCreated                    WebhookSubscriptionType = "userCreated"
+	WebhookUserNeedsApproval              WebhookSubscriptionType = "userNeedsApproval"
+	WebhookUserSuspended                  WebhookSubscriptionType = "userSuspended"
+	WebhookUserRestored                   WebhookSubscriptionType = "userRestored"
+	WebhookUserDeleted                    WebhookSubscriptionType = "userDeleted"
+	WebhookUserApproved                   WebhookSubscriptionType = "userApproved"
+	WebhookUserRoleUpdated                WebhookSubscriptionType = "userRoleUpdated"
+	WebhookSubnetIPForwardingNotEnabled   WebhookSubscriptionType = "subnetIPForwardingNotEnabled"
+	WebhookExitNodeIPForwardingNotEnabled WebhookSubscriptionType = "exitNodeIPForwardingNotEnabled"
+)
+
+// Webhook is an endpoint notified of events in a tailnet.
+type Webhook struct {
+	EndpointID       string                    `json:"endpointId"`
+	EndpointURL      string                    `json:"endpointUrl"`
+	ProviderType     WebhookProviderType       `json:"providerType"`
+	CreatorLoginName string                    `json:"creatorLoginName"`
+	Created          time.Time                 `json:"created"`
+	LastModified     time.Time                 `json:"lastModified"`
+	Subscriptions    []WebhookSubscriptionType `json:"subscriptions"`
+
+	// Secret is the secret used to sign the requests sent to the
+	// endpoint. It's only set by CreateWebhook and RotateWebhookSecret.
+	Secret string `json:"secret,omitempty"`
+}
+
+// CreateWebhookRequest is the parameters of a new webhook.
+type CreateWebhookRequest struct {
+	EndpointURL   string                    `json:"endpointUrl"`
+	ProviderType  WebhookProviderType       `json:"providerType"`
+	Subscriptions []WebhookSubscriptionType `json:"subscriptions"`
+}
+
+// Webhooks returns the webhooks of the Client's tailnet.
+func (c *Client) Webhooks(ctx context.Context) (hooks []*Webhook, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.Webhooks: %w", err)
+		}
+	}()
+
+	path := fmt.Sprintf("%s/api/v2/tailnet/%s/webhooks", c.baseURL(), url.PathEscape(c.tailnet))
+	err = c.getPages(ctx, path, nil, func(b []byte) error {
+		var page struct {
+			Webhooks []*Webhook `json:"webhooks"`
+		}
+		if err := json.Unmarshal(b, &page); err != nil {
+			return err
+		}
+		hooks = append(hooks, page.Webhooks...)
+		return nil
+	})
+	return hooks, err
+}
+
+// Webhook returns the webhook with the given endpoint ID.
+func (c *Client) Webhook(ctx context.Context, endpointID string) (hook *Webhook, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.Webhook: %w", err)
+		}
+	}()
+
+	path := fmt.Sprintf("%s/api/v2/webhooks/%s", c.baseURL(), url.PathEscape(endpointID))
+	if err := c.doJSON(ctx, "GET", path, nil, &hook); err != nil {
+		return nil, err
+	}
+	return hook, nil
+}
+
+// CreateWebhook creates a webhook in the Client's tailnet. The returned
+// Webhook's Secret field is set.
+func (c *Client) CreateWebhook(ctx context.Context, req CreateWebhookRequest) (hook *Webhook, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.CreateWebhook: %w", err)
+		}
+	}()
+
+	path := fmt.Sprintf("%s/api/v2/tailnet/%s/webhooks", c.baseURL(), url.PathEscape(c.tailnet))
+	if err := c.doJSON(ctx, "POST", path, req, &hook); err != nil {
+		return nil, err
+	}
+	return hook, nil
+}
+
+// SetWebhookSubscriptions changes the events that the webhook with the
+// given endpoint ID is notified of.
+func (c *Client) SetWebhookSubscriptions(ctx context.Context, endpointID string, subscriptions []WebhookSubscriptionType) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.SetWebhookSubscriptions: %w", err)
+		}
+	}()
+
+	params := &struct {
+		Subscriptions []WebhookSubscriptionType `json:"subscriptions"`
+	}{Subscriptions: subscriptions}
+	path := fmt.Sprintf("%s/api/v2/webhooks/%s", c.baseURL(), url.PathEscape(endpointID))
+	return c.doJSON(ctx, "PATCH", path, params, nil)
+}
+
+// RotateWebhookSecret replaces the secret of the webhook with the given
+// endpoint ID. The returned Webhook's Secret field is the new secret.
+func (c *Client) RotateWebhookSecret(ctx context.Context, endpointID string) (hook *Webhook, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.RotateWebhookSecret: %w", err)
+		}
+	}()
+
+	path := fmt.Sprintf("%s/api/v2/webhooks/%s/rotate", c.baseURL(), url.PathEscape(endpointID))
+	if err := c.doJSON(ctx, "POST", path, nil, &hook); err != nil {
+		return nil, err
+	}
+	return hook, nil
+}
+
+// DeleteWebhook deletes the webhook with the given endpoint ID.
+func (c *Client) DeleteWebhook(ctx context.Context, endpointID string) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.DeleteWebhook: %w", err)
+		}
+	}()
+
+	path := fmt.Sprintf("%s/api/v2/webhooks/%s", c.baseURL(), url.PathEscape(endpointID))
+	return c.doJSON(ctx, "DELETE", path, nil, nil)
+}
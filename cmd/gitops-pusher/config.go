@@ -0,0 +1,126 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/tailscale/hujson"
+)
+
+// Config is the format of the file passed to --config, for managing the
+// policy files of several tailnets from one repository.
+//
+// Example:
+//
+//	{
+//		"tailnets": [
+//			{"tailnet": "example.com", "policyFile": "prod.hujson"},
+//			{"tailnet": "example.org", "policyFile": "dev.hujson", "apiKeyEnv": "TS_DEV_API_KEY"},
+//		],
+//	}
+type Config struct {
+	Tailnets []Target `json:"tailnets"`
+}
+
+// Target is a tailnet and the policy file that is pushed to it.
+type Target struct {
+	// Tailnet is the name of the tailnet.
+	Tailnet string `json:"tailnet"`
+
+	// APIKeyEnv is the name of the environment variable containing the
+	// API key for Tailnet. If empty, TS_API_KEY is used.
+	APIKeyEnv string `json:"apiKeyEnv,omitempty"`
+
+	// PolicyFile is the policy file for Tailnet. Relative paths are
+	// relative to the directory containing the config file.
+	PolicyFile string `json:"policyFile"`
+
+	// CacheFile is where the previous known version hash of Tailnet's
+	// policy is stored. Relative paths are relative to the directory
+	// containing the config file. If empty, it defaults to PolicyFile
+	// with ".cache.json" appended.
+	CacheFile string `json:"cacheFile,omitempty"`
+}
+
+// loadConfig loads the HuJSON config file fname, resolving the paths in
+// it relative to the file's directory.
+func loadConfig(fname string) ([]Target, error) {
+	b, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	b, err = hujson.Standardize(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fname, err)
+	}
+	var cfg Config
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", fname, err)
+	}
+	if len(cfg.Tailnets) == 0 {
+		return nil, fmt.Errorf("%s: no tailnets configured", fname)
+	}
+	dir := filepath.Dir(fname)
+	seen := map[string]bool{}
+	for i := range cfg.Tailnets {
+		t := &cfg.Tailnets[i]
+		if t.Tailnet == "" {
+			return nil, fmt.Errorf("%s: tailnets[%d]: missing tailnet", fname, i)
+		}
+		if seen[t.Tailnet] {
+			return nil, fmt.Errorf("%s: tailnet %q listed more than once", fname, t.Tailnet)
+		}
+		seen[t.Tailnet] = true
+		if t.PolicyFile == "" {
+			return nil, fmt.Errorf("%s: tailnet %q: missing policyFile", fname, t.Tailnet)
+		}
+		if t.CacheFile == "" {
+			t.CacheFile = t.PolicyFile + ".cache.json"
+		}
+		if !filepath.IsAbs(t.PolicyFile) {
+			t.PolicyFile = filepath.Join(dir, t.PolicyFile)
+		}
+		if !filepath.IsAbs(t.CacheFile) {
+			t.CacheFile = filepath.Join(dir, t.CacheFile)
+		}
+	}
+	return cfg.Tailnets, nil
+}
+
+// targets returns the tailnets to operate on: those in the --config
+// file if set, otherwise the one named by $TS_TAILNET, using the
+// --policy-file and --cache-file flags.
+func targets() ([]Target, error) {
+	if *configFname != "" {
+		return loadConfig(*configFname)
+	}
+	tailnet, ok := os.LookupEnv("TS_TAILNET")
+	if !ok {
+		return nil, errors.New("set envvar TS_TAILNET to your tailnet's name, or use --config")
+	}
+	return []Target{{
+		Tailnet:    tailnet,
+		PolicyFile: *policyFname,
+		CacheFile:  *cacheFname,
+	}}, nil
+}
+
+// apiKey returns the API key for t from the environment.
+func (t Target) apiKey() (string, error) {
+	env := t.APIKeyEnv
+	if env == "" {
+		env = "TS_API_KEY"
+	}
+	k, ok := os.LookupEnv(env)
+	if !ok {
+		return "", fmt.Errorf("set envvar %s to your Tailscale API key for %s", env, t.Tailnet)
+	}
+	return k, nil
+}
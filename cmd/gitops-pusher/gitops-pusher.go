@@ -22,155 +22,224 @@
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"github.com/tailscale/hujson"
+	"tailscale.com/client/tailscale"
+	"tailscale.com/util/multierr"
 )
 
 var (
 	rootFlagSet  = flag.NewFlagSet("gitops-pusher", flag.ExitOnError)
 	policyFname  = rootFlagSet.String("policy-file", "./policy.hujson", "filename for policy file")
 	cacheFname   = rootFlagSet.String("cache-file", "./version-cache.json", "filename for the previous known version hash")
+	configFname  = rootFlagSet.String("config", "", "if set, HuJSON file listing several tailnets and their policy files to manage, instead of $TS_TAILNET, --policy-file and --cache-file")
+	apiServer    = rootFlagSet.String("api-server", "https://api.tailscale.com", "base URL of the Tailscale API server")
 	timeout      = rootFlagSet.Duration("timeout", 5*time.Minute, "timeout for the entire CI run")
 	githubSyntax = rootFlagSet.Bool("github-syntax", true, "use GitHub Action error syntax (https://docs.github.com/en/actions/using-workflows/workflow-commands-for-github-actions#setting-an-error-message)")
 
 	modifiedExternallyFailure = make(chan struct{}, 1)
 )
 
-func modifiedExternallyError() {
+func modifiedExternallyError(policyFname string) {
 	if *githubSyntax {
-		fmt.Printf("::error file=%s,line=1,col=1,title=Policy File Modified Externally::The policy file was modified externally in the admin console.\n", *policyFname)
+		fmt.Printf("::error file=%s,line=1,col=1,title=Policy File Modified Externally::The policy file was modified externally in the admin console.\n", policyFname)
 	} else {
-		fmt.Printf("The policy file was modified externally in the admin console.\n")
+		fmt.Printf("%s: the policy file was modified externally in the admin console.\n", policyFname)
+	}
+	select {
+	case modifiedExternallyFailure <- struct{}{}:
+	default:
 	}
-	modifiedExternallyFailure <- struct{}{}
 }
 
-func apply(cache *Cache, tailnet, apiKey string) func(context.Context, []string) error {
+// targetFunc runs a command against a single tailnet.
+type targetFunc func(ctx context.Context, t Target, apiKey string, cache *Cache) error
+
+// forEachTarget returns a command that checks the policy files of all the
+// configured tailnets locally, and, if they're all valid, runs fn against
+// each tailnet in turn, loading and saving their caches. If fn fails for a
+// tailnet, the others are still run.
+func forEachTarget(fn targetFunc) func(context.Context, []string) error {
 	return func(ctx context.Context, args []string) error {
-		controlEtag, err := getACLETag(ctx, tailnet, apiKey)
+		ts, err := targets()
 		if err != nil {
 			return err
 		}
-
-		localEtag, err := sumFile(*policyFname)
-		if err != nil {
+		if err := validateTargets(ts); err != nil {
 			return err
 		}
-
-		if cache.PrevETag == "" {
-			log.Println("no previous etag found, assuming local file is correct and recording that")
-			cache.PrevETag = localEtag
+		var errs []error
+		for _, t := range ts {
+			if len(ts) > 1 {
+				log.Printf("tailnet %s (%s)", t.Tailnet, t.PolicyFile)
+			}
+			if err := runTarget(ctx, t, fn); err != nil {
+				if len(ts) > 1 {
+					err = fmt.Errorf("tailnet %s: %w", t.Tailnet, err)
+				}
+				errs = append(errs, err)
+			}
 		}
+		return multierr.New(errs...)
+	}
+}
 
-		log.Printf("control: %s", controlEtag)
-		log.Printf("local:   %s", localEtag)
-		log.Printf("cache:   %s", cache.PrevETag)
-
-		if cache.PrevETag != controlEtag {
-			modifiedExternallyError()
+func runTarget(ctx context.Context, t Target, fn targetFunc) error {
+	apiKey, err := t.apiKey()
+	if err != nil {
+		return err
+	}
+	cache, err := LoadCache(t.CacheFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			cache = &Cache{}
+		} else {
+			return fmt.Errorf("error loading cache: %w", err)
 		}
+	}
+	defer cache.Save(t.CacheFile)
+	return fn(ctx, t, apiKey, cache)
+}
 
-		if controlEtag == localEtag {
-			cache.PrevETag = localEtag
-			log.Println("no update needed, doing nothing")
-			return nil
+// validateTargets checks the policy files of ts for errors that can be
+// found without the API server.
+func validateTargets(ts []Target) error {
+	var errs []error
+	for _, t := range ts {
+		b, err := os.ReadFile(t.PolicyFile)
+		if err != nil {
+			errs = append(errs, err)
+			continue
 		}
-
-		if err := applyNewACL(ctx, tailnet, apiKey, *policyFname, controlEtag); err != nil {
-			return err
+		pes, warnings := checkPolicy(b)
+		if len(warnings) > 0 {
+			fmt.Println(policyErrors{file: t.PolicyFile, errs: warnings}.format("warning"))
+		}
+		if len(pes) > 0 {
+			errs = append(errs, policyErrors{file: t.PolicyFile, errs: pes})
 		}
-
-		cache.PrevETag = localEtag
-
-		return nil
 	}
+	return multierr.New(errs...)
 }
 
-func test(cache *Cache, tailnet, apiKey string) func(context.Context, []string) error {
-	return func(ctx context.Context, args []string) error {
-		controlEtag, err := getACLETag(ctx, tailnet, apiKey)
-		if err != nil {
-			return err
-		}
+func validate(ctx context.Context, args []string) error {
+	ts, err := targets()
+	if err != nil {
+		return err
+	}
+	if err := validateTargets(ts); err != nil {
+		return err
+	}
+	for _, t := range ts {
+		log.Printf("%s: ok", t.PolicyFile)
+	}
+	return nil
+}
 
-		localEtag, err := sumFile(*policyFname)
-		if err != nil {
-			return err
-		}
+func apply(ctx context.Context, t Target, apiKey string, cache *Cache) error {
+	controlEtag, err := getACLETag(ctx, t.Tailnet, apiKey)
+	if err != nil {
+		return err
+	}
 
-		if cache.PrevETag == "" {
-			log.Println("no previous etag found, assuming local file is correct and recording that")
-			cache.PrevETag = localEtag
-		}
+	localEtag, err := sumFile(t.PolicyFile)
+	if err != nil {
+		return err
+	}
 
-		log.Printf("control: %s", controlEtag)
-		log.Printf("local:   %s", localEtag)
-		log.Printf("cache:   %s", cache.PrevETag)
+	if cache.PrevETag == "" {
+		log.Println("no previous etag found, assuming local file is correct and recording that")
+		cache.PrevETag = localEtag
+	}
 
-		if cache.PrevETag != controlEtag {
-			modifiedExternallyError()
-		}
+	log.Printf("control: %s", controlEtag)
+	log.Printf("local:   %s", localEtag)
+	log.Printf("cache:   %s", cache.PrevETag)
 
-		if controlEtag == localEtag {
-			log.Println("no updates found, doing nothing")
-			return nil
-		}
+	if cache.PrevETag != controlEtag {
+		modifiedExternallyError(t.PolicyFile)
+	}
 
-		if err := testNewACLs(ctx, tailnet, apiKey, *policyFname); err != nil {
-			return err
-		}
+	if controlEtag == localEtag {
+		cache.PrevETag = localEtag
+		log.Println("no update needed, doing nothing")
 		return nil
 	}
+
+	if err := applyNewACL(ctx, t.Tailnet, apiKey, t.PolicyFile, controlEtag); err != nil {
+		return err
+	}
+
+	cache.PrevETag = localEtag
+
+	return nil
 }
 
-func getChecksums(cache *Cache, tailnet, apiKey string) func(context.Context, []string) error {
-	return func(ctx context.Context, args []string) error {
-		controlEtag, err := getACLETag(ctx, tailnet, apiKey)
-		if err != nil {
-			return err
-		}
+func test(ctx context.Context, t Target, apiKey string, cache *Cache) error {
+	controlEtag, err := getACLETag(ctx, t.Tailnet, apiKey)
+	if err != nil {
+		return err
+	}
 
-		localEtag, err := sumFile(*policyFname)
-		if err != nil {
-			return err
-		}
+	localEtag, err := sumFile(t.PolicyFile)
+	if err != nil {
+		return err
+	}
 
-		if cache.PrevETag == "" {
-			log.Println("no previous etag found, assuming local file is correct and recording that")
-			cache.PrevETag = Shuck(localEtag)
-		}
+	if cache.PrevETag == "" {
+		log.Println("no previous etag found, assuming local file is correct and recording that")
+		cache.PrevETag = localEtag
+	}
 
-		log.Printf("control: %s", controlEtag)
-		log.Printf("local:   %s", localEtag)
-		log.Printf("cache:   %s", cache.PrevETag)
+	log.Printf("control: %s", controlEtag)
+	log.Printf("local:   %s", localEtag)
+	log.Printf("cache:   %s", cache.PrevETag)
 
+	if cache.PrevETag != controlEtag {
+		modifiedExternallyError(t.PolicyFile)
+	}
+
+	if controlEtag == localEtag {
+		log.Println("no updates found, doing nothing")
 		return nil
 	}
-}
 
-func main() {
-	tailnet, ok := os.LookupEnv("TS_TAILNET")
-	if !ok {
-		log.Fatal("set envvar TS_TAILNET to your tailnet's name")
+	if err := testNewACLs(ctx, t.Tailnet, apiKey, t.PolicyFile); err != nil {
+		return err
 	}
-	apiKey, ok := os.LookupEnv("TS_API_KEY")
-	if !ok {
-		log.Fatal("set envvar TS_API_KEY to your Tailscale API key")
+	return nil
+}
+
+func getChecksums(ctx context.Context, t Target, apiKey string, cache *Cache) error {
+	controlEtag, err := getACLETag(ctx, t.Tailnet, apiKey)
+	if err != nil {
+		return err
 	}
-	cache, err := LoadCache(*cacheFname)
+
+	localEtag, err := sumFile(t.PolicyFile)
 	if err != nil {
-		if os.IsNotExist(err) {
-			cache = &Cache{}
-		} else {
-			log.Fatalf("error loading cache: %v", err)
-		}
+		return err
 	}
-	defer cache.Save(*cacheFname)
+
+	if cache.PrevETag == "" {
+		log.Println("no previous etag found, assuming local file is correct and recording that")
+		cache.PrevETag = Shuck(localEtag)
+	}
+
+	log.Printf("control: %s", controlEtag)
+	log.Printf("local:   %s", localEtag)
+	log.Printf("cache:   %s", cache.PrevETag)
+
+	return nil
+}
+
+func main() {
+	tailscale.I_Acknowledge_This_API_Is_Unstable = true
 
 	applyCmd := &ffcli.Command{
 		Name:       "apply",
 		ShortUsage: "gitops-pusher [options] apply",
 		ShortHelp:  "Pushes changes to CONTROL",
 		LongHelp:   `Pushes changes to CONTROL`,
-		Exec:       apply(cache, tailnet, apiKey),
+		Exec:       forEachTarget(apply),
 	}
 
 	testCmd := &ffcli.Command{
@@ -178,7 +247,7 @@ func main() {
 		ShortUsage: "gitops-pusher [options] test",
 		ShortHelp:  "Tests ACL changes",
 		LongHelp:   "Tests ACL changes",
-		Exec:       test(cache, tailnet, apiKey),
+		Exec:       forEachTarget(test),
 	}
 
 	cksumCmd := &ffcli.Command{
@@ -186,13 +255,31 @@ func main() {
 		ShortUsage: "Shows checksums of ACL files",
 		ShortHelp:  "Fetch checksum of CONTROL's ACL and the local ACL for comparison",
 		LongHelp:   "Fetch checksum of CONTROL's ACL and the local ACL for comparison",
-		Exec:       getChecksums(cache, tailnet, apiKey),
+		Exec:       forEachTarget(getChecksums),
+	}
+
+	planCmd := &ffcli.Command{
+		Name:       "plan",
+		ShortUsage: "gitops-pusher [options] plan",
+		ShortHelp:  "Shows the changes that apply would make",
+		LongHelp:   "Fetches the policy from CONTROL and prints how the local policy file differs from it, ignoring comments and formatting",
+		Exec: forEachTarget(func(ctx context.Context, t Target, apiKey string, cache *Cache) error {
+			return plan(ctx, t, apiKey, cache, os.Stdout)
+		}),
+	}
+
+	validateCmd := &ffcli.Command{
+		Name:       "validate",
+		ShortUsage: "gitops-pusher [options] validate",
+		ShortHelp:  "Checks policy files locally",
+		LongHelp:   "Checks policy files for syntax errors and unknown or malformed sections, without contacting CONTROL or needing an API key",
+		Exec:       validate,
 	}
 
 	root := &ffcli.Command{
 		ShortUsage:  "gitops-pusher [options] <command>",
 		ShortHelp:   "Push Tailscale ACLs to CONTROL using a GitOps workflow",
-		Subcommands: []*ffcli.Command{applyCmd, cksumCmd, testCmd},
+		Subcommands: []*ffcli.Command{applyCmd, cksumCmd, planCmd, testCmd, validateCmd},
 		FlagSet:     rootFlagSet,
 	}
 
@@ -240,7 +327,7 @@ func applyNewACL(ctx context.Context, tailnet, apiKey, policyFname, oldEtag stri
 	}
 	defer fin.Close()
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("https://api.tailscale.com/api/v2/tailnet/%s/acl", tailnet), fin)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/api/v2/tailnet/%s/acl", *apiServer, tailnet), fin)
 	if err != nil {
 		return err
 	}
@@ -258,7 +345,7 @@ func applyNewACL(ctx context.Context, tailnet, apiKey, policyFname, oldEtag stri
 	got := resp.StatusCode
 	want := http.StatusOK
 	if got != want {
-		var ate ACLTestError
+		ate := ACLTestError{file: policyFname}
 		err := json.NewDecoder(resp.Body).Decode(&ate)
 		if err != nil {
 			return err
@@ -277,7 +364,7 @@ func testNewACLs(ctx context.Context, tailnet, apiKey, policyFname string) error
 	}
 	defer fin.Close()
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("https://api.tailscale.com/api/v2/tailnet/%s/acl/validate", tailnet), fin)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/api/v2/tailnet/%s/acl/validate", *apiServer, tailnet), fin)
 	if err != nil {
 		return err
 	}
@@ -291,7 +378,7 @@ func testNewACLs(ctx context.Context, tailnet, apiKey, policyFname string) error
 	}
 	defer resp.Body.Close()
 
-	var ate ACLTestError
+	ate := ACLTestError{file: policyFname}
 	err = json.NewDecoder(resp.Body).Decode(&ate)
 	if err != nil {
 		return err
@@ -315,6 +402,8 @@ func testNewACLs(ctx context.Context, tailnet, apiKey, policyFname string) error
 type ACLTestError struct {
 	Message string               `json:"message"`
 	Data    []ACLTestErrorDetail `json:"data"`
+
+	file string // policy file name, for GitHub error syntax
 }
 
 func (ate ACLTestError) Error() string {
@@ -327,7 +416,7 @@ func (ate ACLTestError) Error() string {
 		col := sp[2]
 		msg := sp[3]
 
-		fmt.Fprintf(&sb, "::error file=%s,line=%s,col=%s::%s", *policyFname, line, col, msg)
+		fmt.Fprintf(&sb, "::error file=%s,line=%s,col=%s::%s", ate.file, line, col, msg)
 	} else {
 		fmt.Fprintln(&sb, ate.Message)
 	}
@@ -349,7 +438,7 @@ type ACLTestErrorDetail struct {
 }
 
 func getACLETag(ctx context.Context, tailnet, apiKey string) (string, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("https://api.tailscale.com/api/v2/tailnet/%s/acl", tailnet), nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/api/v2/tailnet/%s/acl", *apiServer, tailnet), nil)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,370 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tailscale/hujson"
+	"tailscale.com/client/tailscale"
+)
+
+// fakeAdminAPI is a fake of the parts of the Tailscale admin API that
+// gitops-pusher uses, holding one policy per tailnet.
+type fakeAdminAPI struct {
+	t *testing.T
+
+	mu        sync.Mutex
+	policies  map[string][]byte // tailnet => HuJSON policy
+	apiKeys   map[string]string // tailnet => API key
+	validated map[string]int    // tailnet => number of calls to acl/validate
+}
+
+func (f *fakeAdminAPI) etag(tailnet string) string {
+	formatted, err := hujson.Format(f.policies[tailnet])
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return fmt.Sprintf(`"%x"`, sha256.Sum256(formatted))
+}
+
+func (f *fakeAdminAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !strings.HasPrefix(r.URL.Path, "/api/v2/tailnet/") {
+		http.NotFound(w, r)
+		return
+	}
+	tailnet, op, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/v2/tailnet/"), "/")
+	if _, ok := f.policies[tailnet]; !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if key, _, _ := r.BasicAuth(); key != f.apiKeys[tailnet] {
+		http.Error(w, "bad API key", http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case op == "acl" && r.Method == "GET":
+		w.Header().Set("ETag", f.etag(tailnet))
+		if r.URL.Query().Get("details") == "1" {
+			json.NewEncoder(w).Encode(map[string]any{"acl": f.policies[tailnet]})
+			return
+		}
+		w.Write(f.policies[tailnet])
+	case op == "acl" && r.Method == "POST":
+		if r.Header.Get("If-Match") != f.etag(tailnet) {
+			http.Error(w, `{"message": "precondition failed"}`, http.StatusPreconditionFailed)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		f.policies[tailnet] = b
+	case op == "acl/validate" && r.Method == "POST":
+		f.validated[tailnet]++
+		io.WriteString(w, "{}")
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newFakeAdminAPI(t *testing.T, policies map[string]string) *fakeAdminAPI {
+	f := &fakeAdminAPI{
+		t:         t,
+		policies:  map[string][]byte{},
+		apiKeys:   map[string]string{},
+		validated: map[string]int{},
+	}
+	for tailnet, p := range policies {
+		f.policies[tailnet] = []byte(p)
+		f.apiKeys[tailnet] = "key-" + tailnet
+	}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	oldServer, oldConfig := *apiServer, *configFname
+	*apiServer = srv.URL
+	t.Cleanup(func() {
+		*apiServer = oldServer
+		*configFname = oldConfig
+		select {
+		case <-modifiedExternallyFailure:
+		default:
+		}
+	})
+	tailscale.I_Acknowledge_This_API_Is_Unstable = true
+	return f
+}
+
+func writeFile(t *testing.T, name, contents string) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const (
+	policyV1 = `{
+	// Everyone can reach everything.
+	"acls": [{"action": "accept", "src": ["*"], "dst": ["*:*"]}],
+}`
+	policyV2 = `{
+	"groups": {"group:eng": ["alice@example.com"]},
+	"acls": [{"action": "accept", "src": ["group:eng"], "dst": ["*:*"]}],
+}`
+)
+
+func TestDiffPolicies(t *testing.T) {
+	changes, err := diffPolicies([]byte(policyV1), []byte(policyV2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range changes {
+		got = append(got, c.String())
+	}
+	want := []string{
+		`~ /acls/0/src/0: "*" -> "group:eng"`,
+		`+ /groups: {"group:eng":["alice@example.com"]}`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Comments, formatting and key order don't matter.
+	reordered := `{"acls": [{"dst": ["*:*"], "src": ["*"], "action": "accept"}]}`
+	changes, err = diffPolicies([]byte(policyV1), []byte(reordered))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("got changes %v; want none", changes)
+	}
+
+	changes, err = diffPolicies([]byte(`{"hosts": {"a/b": "1.2.3.4"}, "tests": [{}, {}]}`), []byte(`{"tests": [{}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = nil
+	for _, c := range changes {
+		got = append(got, c.String())
+	}
+	if want := `- /hosts: {"a/b":"1.2.3.4"}|- /tests/1: {}`; strings.Join(got, "|") != want {
+		t.Errorf("got %q; want %q", strings.Join(got, "|"), want)
+	}
+}
+
+func TestCheckPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		want   []string // "line:col: message substring"
+	}{
+		{"valid", policyV2, nil},
+		{"valid-mixed-case", `{"ACLs": [{"Action": "accept", "Users": ["*"], "Ports": ["*:*"]}], "TagOwners": {"tag:x": []}}`, nil},
+		{"syntax", "{\n\t\"acls\": [,\n}", []string{"2:11: invalid character"}},
+		{"not-object", `[]`, []string{"1:1: policy must be an object"}},
+		{"duplicate-key", `{"acls": [], "ACLs": []}`, []string{"1:14: duplicate top-level key"}},
+		{"bad-action", `{"acls": [{"action": "drop", "src": ["*"], "dst": ["*:*"]}]}`, []string{`1:22: "action" must be "accept"`}},
+		{"missing-dst", `{"acls": [{"action": "accept", "src": ["*"]}]}`, []string{`1:11: ACL rule missing "dst"`}},
+		{"src-not-array", `{"acls": [{"action": "accept", "src": "*", "dst": ["*:*"]}]}`, []string{`1:39: ACL rule "src" must be an array`}},
+		{"bad-group", `{"groups": {"eng": ["a@b.c", 1]}}`, []string{`1:13: group "eng" must start with`, "1:30: group \"eng\" element must be a string"}},
+		{"bad-tag", `{"tagOwners": {"prod": []}}`, []string{`1:16: tag "prod" must start with`}},
+		{"bad-host", `{"hosts": {"a": "1.2.3.4", "b": "10.0.0.0/8", "c": "example.com"}}`, []string{`1:52: host "c": "example.com" is not an IP`}},
+		{"bad-ssh", `{"ssh": [{"action": "allow", "src": ["*"], "dst": ["*"]}]}`, []string{`1:21: SSH rule "action" must be "accept" or "check"`, `1:10: SSH rule missing "users"`}},
+		{"bad-test", `{"tests": [{"src": "a@b.c", "accept": ["x:1"], "bogus": []}]}`, []string{`1:48: unknown key "bogus" in test`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs, _ := checkPolicy([]byte(tt.policy))
+			if len(errs) != len(tt.want) {
+				t.Fatalf("got errors %v; want %d", errs, len(tt.want))
+			}
+			for i, e := range errs {
+				pos, msg, _ := strings.Cut(tt.want[i], ": ")
+				if got := fmt.Sprintf("%d:%d", e.Line, e.Column); got != pos || !strings.Contains(e.Message, msg) {
+					t.Errorf("error %d = %d:%d: %s; want %s", i, e.Line, e.Column, e.Message, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCheckPolicyUnknownKey(t *testing.T) {
+	// Keys that are newer than the checker are warned about, but the
+	// values of known keys are still checked.
+	errs, warnings := checkPolicy([]byte("{\n  \"newSection\": {},\n  \"tagOwners\": {\"prod\": []}}"))
+	if len(warnings) != 1 || warnings[0].Line != 2 || warnings[0].Column != 3 || !strings.Contains(warnings[0].Message, `unknown top-level key "newSection"`) {
+		t.Errorf("warnings = %v", warnings)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Message, `tag "prod" must start with`) {
+		t.Errorf("errors = %v", errs)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	cfg := filepath.Join(dir, "tailnets.hujson")
+	writeFile(t, cfg, `{
+		"tailnets": [
+			{"tailnet": "example.com", "policyFile": "prod.hujson"},
+			{"tailnet": "example.org", "policyFile": "/abs/dev.hujson", "apiKeyEnv": "DEV_KEY", "cacheFile": "dev-cache.json"},
+		],
+	}`)
+	ts, err := loadConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Target{
+		{Tailnet: "example.com", PolicyFile: filepath.Join(dir, "prod.hujson"), CacheFile: filepath.Join(dir, "prod.hujson.cache.json")},
+		{Tailnet: "example.org", PolicyFile: "/abs/dev.hujson", CacheFile: filepath.Join(dir, "dev-cache.json"), APIKeyEnv: "DEV_KEY"},
+	}
+	if fmt.Sprint(ts) != fmt.Sprint(want) {
+		t.Errorf("got %+v; want %+v", ts, want)
+	}
+
+	writeFile(t, cfg, `{"tailnets": [{"tailnet": "a", "policyFile": "a"}, {"tailnet": "a", "policyFile": "b"}]}`)
+	if _, err := loadConfig(cfg); err == nil || !strings.Contains(err.Error(), "more than once") {
+		t.Errorf("duplicate tailnet: got err %v", err)
+	}
+}
+
+func TestApplyMultipleTailnets(t *testing.T) {
+	f := newFakeAdminAPI(t, map[string]string{
+		"prod.example.com": policyV1,
+		"dev.example.com":  policyV1,
+	})
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "prod.hujson"), policyV1)
+	writeFile(t, filepath.Join(dir, "dev.hujson"), policyV2)
+	*configFname = filepath.Join(dir, "tailnets.hujson")
+	writeFile(t, *configFname, `{"tailnets": [
+		{"tailnet": "prod.example.com", "policyFile": "prod.hujson", "apiKeyEnv": "TEST_PROD_KEY"},
+		{"tailnet": "dev.example.com", "policyFile": "dev.hujson", "apiKeyEnv": "TEST_DEV_KEY"},
+	]}`)
+	t.Setenv("TEST_PROD_KEY", "key-prod.example.com")
+	t.Setenv("TEST_DEV_KEY", "key-dev.example.com")
+	for _, name := range []string{"prod.hujson.cache.json", "dev.hujson.cache.json"} {
+		c := &Cache{PrevETag: Shuck(f.etag("prod.example.com"))}
+		if err := c.Save(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := context.Background()
+	if err := forEachTarget(test)(ctx, nil); err != nil {
+		t.Fatalf("test: %v", err)
+	}
+	if f.validated["dev.example.com"] != 1 || f.validated["prod.example.com"] != 0 {
+		t.Errorf("validate calls = %v; want only dev", f.validated)
+	}
+
+	if err := forEachTarget(apply)(ctx, nil); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if got := string(f.policies["dev.example.com"]); got != policyV2 {
+		t.Errorf("dev policy = %q; want %q", got, policyV2)
+	}
+	if got := string(f.policies["prod.example.com"]); got != policyV1 {
+		t.Errorf("prod policy = %q; want unchanged", got)
+	}
+	for _, name := range []string{"prod.hujson.cache.json", "dev.hujson.cache.json"} {
+		c, err := LoadCache(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := Shuck(f.etag(strings.TrimSuffix(name, ".hujson.cache.json") + ".example.com")); c.PrevETag != want {
+			t.Errorf("%s: ETag %q; want %q", name, c.PrevETag, want)
+		}
+	}
+	if len(modifiedExternallyFailure) != 0 {
+		t.Error("unexpected modified externally failure")
+	}
+
+	// Invalid policy files stop everything before the API is called.
+	writeFile(t, filepath.Join(dir, "prod.hujson"), `{"acls": [{"action": "deny"}]}`)
+	writeFile(t, filepath.Join(dir, "dev.hujson"), policyV1)
+	err := forEachTarget(apply)(ctx, nil)
+	if err == nil || !strings.Contains(err.Error(), `"action" must be "accept"`) {
+		t.Fatalf("apply of invalid policy: got err %v", err)
+	}
+	if got := string(f.policies["dev.example.com"]); got != policyV2 {
+		t.Errorf("dev policy changed to %q despite invalid prod policy", got)
+	}
+
+	// A missing API key fails that tailnet only.
+	writeFile(t, filepath.Join(dir, "prod.hujson"), policyV2)
+	os.Unsetenv("TEST_PROD_KEY")
+	err = forEachTarget(apply)(ctx, nil)
+	if err == nil || !strings.Contains(err.Error(), "TEST_PROD_KEY") {
+		t.Fatalf("apply without prod key: got err %v", err)
+	}
+	if got := string(f.policies["dev.example.com"]); got != policyV1 {
+		t.Errorf("dev policy = %q; want %q", got, policyV1)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	f := newFakeAdminAPI(t, map[string]string{"example.com": policyV1})
+	dir := t.TempDir()
+	tgt := Target{
+		Tailnet:    "example.com",
+		PolicyFile: filepath.Join(dir, "policy.hujson"),
+		CacheFile:  filepath.Join(dir, "cache.json"),
+	}
+	writeFile(t, tgt.PolicyFile, policyV2)
+
+	var buf bytes.Buffer
+	cache := &Cache{PrevETag: Shuck(f.etag("example.com"))}
+	if err := plan(context.Background(), tgt, "key-example.com", cache, &buf); err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf(`tailnet example.com (%s):
+  ~ /acls/0/src/0: "*" -> "group:eng"
+  + /groups: {"group:eng":["alice@example.com"]}
+`, tgt.PolicyFile)
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+	if len(modifiedExternallyFailure) != 0 {
+		t.Error("unexpected modified externally failure")
+	}
+	if got := string(f.policies["example.com"]); got != policyV1 {
+		t.Errorf("plan changed the policy to %q", got)
+	}
+
+	buf.Reset()
+	writeFile(t, tgt.PolicyFile, policyV1)
+	if err := plan(context.Background(), tgt, "key-example.com", cache, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "no changes") {
+		t.Errorf("got %q; want no changes", buf.String())
+	}
+
+	cache.PrevETag = "0123"
+	if err := plan(context.Background(), tgt, "key-example.com", cache, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if len(modifiedExternallyFailure) == 0 {
+		t.Error("policy modified externally wasn't reported")
+	}
+
+	if err := plan(context.Background(), tgt, "wrong-key", cache, io.Discard); err == nil {
+		t.Error("plan with wrong API key succeeded")
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tailscale/hujson"
+	"tailscale.com/client/tailscale"
+)
+
+// plan prints the changes that applying t's policy file would make to
+// the tailnet's current policy.
+func plan(ctx context.Context, t Target, apiKey string, cache *Cache, w io.Writer) error {
+	c := tailscale.NewClient(t.Tailnet, tailscale.APIKey(apiKey))
+	c.BaseURL = *apiServer
+	acl, err := c.ACLHuJSON(ctx)
+	if err != nil {
+		return err
+	}
+	local, err := os.ReadFile(t.PolicyFile)
+	if err != nil {
+		return err
+	}
+	if cache.PrevETag != "" && acl.ETag != "" && cache.PrevETag != Shuck(acl.ETag) {
+		modifiedExternallyError(t.PolicyFile)
+	}
+	for _, warning := range acl.Warnings {
+		fmt.Fprintf(w, "warning: %s\n", warning)
+	}
+
+	changes, err := diffPolicies([]byte(acl.ACL), local)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "tailnet %s (%s):\n", t.Tailnet, t.PolicyFile)
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "  no changes")
+		return nil
+	}
+	for _, c := range changes {
+		fmt.Fprintf(w, "  %s\n", c)
+	}
+	return nil
+}
+
+// policyChange is a single difference between two policies.
+type policyChange struct {
+	Op       byte   // '+' (added), '-' (removed) or '~' (changed)
+	Path     string // JSON pointer (RFC 6901) to the changed value
+	Old, New any    // the values before and after; nil if added or removed
+}
+
+func (c policyChange) String() string {
+	switch c.Op {
+	case '+':
+		return fmt.Sprintf("+ %s: %s", c.Path, jsonString(c.New))
+	case '-':
+		return fmt.Sprintf("- %s: %s", c.Path, jsonString(c.Old))
+	}
+	return fmt.Sprintf("~ %s: %s -> %s", c.Path, jsonString(c.Old), jsonString(c.New))
+}
+
+func jsonString(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// diffPolicies returns the semantic differences between the HuJSON
+// policies old and new. Comments, formatting and the order of object
+// keys are ignored.
+func diffPolicies(old, new []byte) ([]policyChange, error) {
+	ov, err := decodeHuJSON(old)
+	if err != nil {
+		return nil, fmt.Errorf("current policy: %w", err)
+	}
+	nv, err := decodeHuJSON(new)
+	if err != nil {
+		return nil, fmt.Errorf("new policy: %w", err)
+	}
+	var changes []policyChange
+	diffValues(&changes, "", ov, nv)
+	return changes, nil
+}
+
+func decodeHuJSON(b []byte) (any, error) {
+	b, err := hujson.Standardize(b)
+	if err != nil {
+		return nil, err
+	}
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// jsonPointerEscaper escapes object keys for use in a JSON pointer.
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+func diffValues(changes *[]policyChange, path string, old, new any) {
+	switch o := old.(type) {
+	case map[string]any:
+		n, ok := new.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(o)+len(n))
+		for k := range o {
+			keys = append(keys, k)
+		}
+		for k := range n {
+			if _, ok := o[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p := path + "/" + jsonPointerEscaper.Replace(k)
+			ov, inOld := o[k]
+			nv, inNew := n[k]
+			switch {
+			case !inOld:
+				*changes = append(*changes, policyChange{Op: '+', Path: p, New: nv})
+			case !inNew:
+				*changes = append(*changes, policyChange{Op: '-', Path: p, Old: ov})
+			default:
+				diffValues(changes, p, ov, nv)
+			}
+		}
+		return
+	case []any:
+		n, ok := new.([]any)
+		if !ok {
+			break
+		}
+		for i := 0; i < len(o) || i < len(n); i++ {
+			p := path + "/" + strconv.Itoa(i)
+			switch {
+			case i >= len(o):
+				*changes = append(*changes, policyChange{Op: '+', Path: p, New: n[i]})
+			case i >= len(n):
+				*changes = append(*changes, policyChange{Op: '-', Path: p, Old: o[i]})
+			default:
+				diffValues(changes, p, o[i], n[i])
+			}
+		}
+		return
+	}
+	if !reflect.DeepEqual(old, new) {
+		*changes = append(*changes, policyChange{Op: '~', Path: path, Old: old, New: new})
+	}
+}
@@ -0,0 +1,338 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/tailscale/hujson"
+)
+
+// policyError is a problem with a policy file that was found locally,
+// without asking the API server.
+type policyError struct {
+	Line, Column int
+	Message      string
+}
+
+func (e policyError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Message)
+}
+
+// policyErrors is the list of problems found by checkPolicy.
+type policyErrors struct {
+	file string // policy file name, for GitHub error syntax
+	errs []policyError
+}
+
+func (pe policyErrors) Error() string {
+	return pe.format("error")
+}
+
+// format formats pe's problems as being of kind "error" or "warning".
+func (pe policyErrors) format(kind string) string {
+	var sb strings.Builder
+	for _, e := range pe.errs {
+		if *githubSyntax {
+			fmt.Fprintf(&sb, "::%s file=%s,line=%d,col=%d::%s\n", kind, pe.file, e.Line, e.Column, e.Message)
+		} else if kind == "error" {
+			fmt.Fprintf(&sb, "%s:%v\n", pe.file, e)
+		} else {
+			fmt.Fprintf(&sb, "%s:%v (%s)\n", pe.file, e, kind)
+		}
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// checkPolicy checks the HuJSON policy file in b for syntax errors and
+// for problems with its structure that the API server would reject. It
+// doesn't check anything that needs the tailnet's state, such as whether
+// users exist, or run the policy's tests.
+//
+// Problems that the API server might not reject, such as top-level keys
+// that are newer than this checker, are returned as warnings.
+func checkPolicy(b []byte) (errs, warnings []policyError) {
+	v, err := hujson.Parse(b)
+	if err != nil {
+		// Parse errors start with "hujson: line N, column M: ".
+		if sp := lineColMessageSplit.FindStringSubmatch(err.Error()); sp != nil {
+			var pe policyError
+			fmt.Sscan(sp[1], &pe.Line)
+			fmt.Sscan(sp[2], &pe.Column)
+			pe.Message = sp[3]
+			return []policyError{pe}, nil
+		}
+		return []policyError{{Line: 1, Column: 1, Message: err.Error()}}, nil
+	}
+	c := &policyChecker{src: b}
+	c.checkTopLevel(v)
+	return c.errs, c.warnings
+}
+
+type policyChecker struct {
+	src      []byte
+	errs     []policyError
+	warnings []policyError
+}
+
+func (c *policyChecker) errorf(v hujson.Value, format string, args ...any) {
+	c.errs = append(c.errs, c.problem(v, format, args...))
+}
+
+func (c *policyChecker) warnf(v hujson.Value, format string, args ...any) {
+	c.warnings = append(c.warnings, c.problem(v, format, args...))
+}
+
+// problem returns a policyError at the position of v.
+func (c *policyChecker) problem(v hujson.Value, format string, args ...any) policyError {
+	n := v.StartOffset
+	line := 1 + bytes.Count(c.src[:n], []byte("\n"))
+	col := 1 + n - (bytes.LastIndexByte(c.src[:n], '\n') + 1)
+	return policyError{
+		Line:    line,
+		Column:  col,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// topLevelKeys are the known keys at the top level of a policy file,
+// lowercased, and the checks for their values. A nil check means any
+// value is allowed. Other keys are only warned about, as the API server
+// may have gained support for them.
+var topLevelKeys = map[string]func(*policyChecker, hujson.Value){
+	"acls":                (*policyChecker).checkACLs,
+	"groups":              (*policyChecker).checkGroups,
+	"hosts":               (*policyChecker).checkHosts,
+	"tagowners":           (*policyChecker).checkTagOwners,
+	"tests":               (*policyChecker).checkTests,
+	"ssh":                 (*policyChecker).checkSSH,
+	"sshtests":            nil,
+	"autoapprovers":       nil,
+	"derpmap":             nil,
+	"nodeattrs":           nil,
+	"disableipv4":         nil,
+	"onecgnatroute":       nil,
+	"randomizeclientport": nil,
+}
+
+func (c *policyChecker) checkTopLevel(v hujson.Value) {
+	obj, ok := v.Value.(*hujson.Object)
+	if !ok {
+		c.errorf(v, "policy must be an object")
+		return
+	}
+	seen := map[string]bool{}
+	for _, m := range obj.Members {
+		name := literalString(m.Name)
+		key := strings.ToLower(name)
+		check, ok := topLevelKeys[key]
+		if !ok {
+			c.warnf(m.Name, "unknown top-level key %q", name)
+			continue
+		}
+		if seen[key] {
+			c.errorf(m.Name, "duplicate top-level key %q", name)
+			continue
+		}
+		seen[key] = true
+		if check != nil {
+			check(c, m.Value)
+		}
+	}
+}
+
+// literalString returns the string value of v, which must be a string
+// literal, such as an object member name.
+func literalString(v hujson.Value) string {
+	if lit, ok := v.Value.(hujson.Literal); ok && lit.Kind() == '"' {
+		return lit.String()
+	}
+	return ""
+}
+
+// object returns the members of v, reporting an error if v isn't an
+// object.
+func (c *policyChecker) object(v hujson.Value, what string) []hujson.ObjectMember {
+	obj, ok := v.Value.(*hujson.Object)
+	if !ok {
+		c.errorf(v, "%s must be an object", what)
+		return nil
+	}
+	return obj.Members
+}
+
+// array returns the elements of v, reporting an error if v isn't an
+// array.
+func (c *policyChecker) array(v hujson.Value, what string) []hujson.Value {
+	arr, ok := v.Value.(*hujson.Array)
+	if !ok {
+		c.errorf(v, "%s must be an array", what)
+		return nil
+	}
+	return arr.Elements
+}
+
+// str returns the value of v, reporting an error if v isn't a string.
+func (c *policyChecker) str(v hujson.Value, what string) (string, bool) {
+	lit, ok := v.Value.(hujson.Literal)
+	if !ok || lit.Kind() != '"' {
+		c.errorf(v, "%s must be a string", what)
+		return "", false
+	}
+	return lit.String(), true
+}
+
+// strings checks that v is an array of strings.
+func (c *policyChecker) strings(v hujson.Value, what string) []string {
+	var ret []string
+	for _, e := range c.array(v, what) {
+		if s, ok := c.str(e, what+" element"); ok {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+// checkRule checks the object v, an element of a list of rules such as
+// "acls", whose keys must be in keys (lowercased) with the values being
+// strings (for "string") or arrays of strings (for "strings").
+// It returns the members of v by lowercased key.
+func (c *policyChecker) checkRule(v hujson.Value, what string, keys map[string]string) map[string]hujson.Value {
+	ret := map[string]hujson.Value{}
+	for _, m := range c.object(v, what) {
+		name := literalString(m.Name)
+		key := strings.ToLower(name)
+		switch keys[key] {
+		case "string":
+			c.str(m.Value, fmt.Sprintf("%s %q", what, name))
+		case "strings":
+			c.strings(m.Value, fmt.Sprintf("%s %q", what, name))
+		default:
+			c.errorf(m.Name, "unknown key %q in %s", name, what)
+			continue
+		}
+		ret[key] = m.Value
+	}
+	return ret
+}
+
+func (c *policyChecker) checkACLs(v hujson.Value) {
+	keys := map[string]string{
+		"action": "string",
+		"proto":  "string",
+		"src":    "strings",
+		"dst":    "strings",
+		"users":  "strings", // old name for src
+		"ports":  "strings", // old name for dst
+	}
+	for _, e := range c.array(v, `"acls"`) {
+		rule := c.checkRule(e, "ACL rule", keys)
+		if rule == nil {
+			continue
+		}
+		if a, ok := rule["action"]; !ok {
+			c.errorf(e, `ACL rule missing "action"`)
+		} else if s := literalString(a); s != "accept" {
+			c.errorf(a, `ACL rule "action" must be "accept", not %q`, s)
+		}
+		c.checkOneOf(e, rule, "ACL rule", "src", "users")
+		c.checkOneOf(e, rule, "ACL rule", "dst", "ports")
+	}
+}
+
+// checkOneOf checks that rule, the object v, has exactly one of the keys
+// key and oldKey.
+func (c *policyChecker) checkOneOf(v hujson.Value, rule map[string]hujson.Value, what, key, oldKey string) {
+	_, hasKey := rule[key]
+	_, hasOld := rule[oldKey]
+	switch {
+	case !hasKey && !hasOld:
+		c.errorf(v, "%s missing %q", what, key)
+	case hasKey && hasOld:
+		c.errorf(v, "%s can't have both %q and %q", what, key, oldKey)
+	}
+}
+
+func (c *policyChecker) checkGroups(v hujson.Value) {
+	for _, m := range c.object(v, `"groups"`) {
+		name := literalString(m.Name)
+		if !strings.HasPrefix(name, "group:") {
+			c.errorf(m.Name, "group %q must start with \"group:\"", name)
+		}
+		c.strings(m.Value, fmt.Sprintf("group %q", name))
+	}
+}
+
+func (c *policyChecker) checkTagOwners(v hujson.Value) {
+	for _, m := range c.object(v, `"tagOwners"`) {
+		name := literalString(m.Name)
+		if !strings.HasPrefix(name, "tag:") {
+			c.errorf(m.Name, "tag %q must start with \"tag:\"", name)
+		}
+		c.strings(m.Value, fmt.Sprintf("owners of %q", name))
+	}
+}
+
+func (c *policyChecker) checkHosts(v hujson.Value) {
+	for _, m := range c.object(v, `"hosts"`) {
+		name := literalString(m.Name)
+		s, ok := c.str(m.Value, fmt.Sprintf("host %q", name))
+		if !ok {
+			continue
+		}
+		if _, err := netip.ParseAddr(s); err == nil {
+			continue
+		}
+		if _, err := netip.ParsePrefix(s); err == nil {
+			continue
+		}
+		c.errorf(m.Value, "host %q: %q is not an IP address or CIDR prefix", name, s)
+	}
+}
+
+func (c *policyChecker) checkTests(v hujson.Value) {
+	keys := map[string]string{
+		"src":    "string",
+		"user":   "string", // old name for src
+		"proto":  "string",
+		"accept": "strings",
+		"allow":  "strings", // old name for accept
+		"deny":   "strings",
+	}
+	for _, e := range c.array(v, `"tests"`) {
+		if test := c.checkRule(e, "test", keys); test != nil {
+			c.checkOneOf(e, test, "test", "src", "user")
+		}
+	}
+}
+
+func (c *policyChecker) checkSSH(v hujson.Value) {
+	keys := map[string]string{
+		"action":      "string",
+		"src":         "strings",
+		"dst":         "strings",
+		"users":       "strings",
+		"checkperiod": "string",
+	}
+	for _, e := range c.array(v, `"ssh"`) {
+		rule := c.checkRule(e, "SSH rule", keys)
+		if rule == nil {
+			continue
+		}
+		if a, ok := rule["action"]; !ok {
+			c.errorf(e, `SSH rule missing "action"`)
+		} else if s := literalString(a); s != "accept" && s != "check" {
+			c.errorf(a, `SSH rule "action" must be "accept" or "check", not %q`, s)
+		}
+		for _, k := range []string{"src", "dst", "users"} {
+			if _, ok := rule[k]; !ok {
+				c.errorf(e, "SSH rule missing %q", k)
+			}
+		}
+	}
+}
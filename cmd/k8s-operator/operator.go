@@ -0,0 +1,86 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// The k8s-operator command exposes Kubernetes Services to a tailnet.
+//
+// It watches for Services annotated with "tailscale.com/expose: true" and,
+// for each one, runs a proxy StatefulSet in the operator's own namespace.
+// The proxy joins the tailnet using an auth key, keeps its state in a
+// Kubernetes Secret, and forwards the traffic it receives to the
+// Service's ClusterIP. When the Service is deleted or the annotation is
+// removed, the proxy is torn down and its device is removed from the
+// tailnet.
+//
+// The operator reads the auth key for proxies from $TS_AUTH_KEY or the
+// --auth-key-file flag. If $TS_API_KEY (and optionally $TS_TAILNET) is
+// set, it also uses the Tailscale API to keep the ACL tags of proxy
+// devices in sync and to delete them on cleanup.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"tailscale.com/client/tailscale"
+	"tailscale.com/kube"
+)
+
+var (
+	proxyImage   = flag.String("proxy-image", "ghcr.io/tailscale/tailscale:latest", "container image to run proxies with")
+	authKeyFile  = flag.String("auth-key-file", "", "if non-empty, a file containing the auth key for proxies, instead of $TS_AUTH_KEY")
+	defaultTags  = flag.String("default-tags", "tag:k8s", "comma-separated ACL tags to apply to proxies whose Service doesn't have a "+annotationTags+" annotation")
+	resyncPeriod = flag.Duration("resync", 30*time.Second, "how often to reconcile all Services, in addition to whenever a Service changes")
+)
+
+func main() {
+	flag.Parse()
+
+	authKey := os.Getenv("TS_AUTH_KEY")
+	if *authKeyFile != "" {
+		b, err := os.ReadFile(*authKeyFile)
+		if err != nil {
+			log.Fatalf("reading auth key: %v", err)
+		}
+		authKey = strings.TrimSpace(string(b))
+	}
+	if authKey == "" {
+		log.Fatal("set envvar TS_AUTH_KEY or --auth-key-file to an auth key for proxies")
+	}
+	tags, err := parseTags(*defaultTags)
+	if err != nil {
+		log.Fatalf("--default-tags: %v", err)
+	}
+	kc, err := kube.New()
+	if err != nil {
+		log.Fatalf("creating kube client: %v", err)
+	}
+
+	op := &operator{
+		kc:          kc,
+		logf:        log.Printf,
+		authKey:     authKey,
+		image:       *proxyImage,
+		defaultTags: tags,
+	}
+	if apiKey := os.Getenv("TS_API_KEY"); apiKey != "" {
+		tailnet := os.Getenv("TS_TAILNET")
+		if tailnet == "" {
+			tailnet = "-" // the tailnet the API key belongs to
+		}
+		tailscale.I_Acknowledge_This_API_Is_Unstable = true
+		op.ts = tailscale.NewClient(tailnet, tailscale.APIKey(apiKey))
+	} else {
+		log.Printf("TS_API_KEY not set; proxy device tags won't be synced and devices won't be deleted")
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	op.run(ctx, *resyncPeriod)
+}
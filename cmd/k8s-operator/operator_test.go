@@ -0,0 +1,341 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/client/tailscale"
+	"tailscale.com/ipn"
+	"tailscale.com/kube"
+	"tailscale.com/kube/kubetest"
+	"tailscale.com/types/key"
+	"tailscale.com/types/persist"
+)
+
+// fakeTS is a fake of the Tailscale API.
+type fakeTS struct {
+	devs    []*tailscale.Device
+	deleted []string
+}
+
+func (f *fakeTS) Devices(ctx context.Context, fields *tailscale.DeviceFieldsOpts) ([]*tailscale.Device, error) {
+	return f.devs, nil
+}
+
+func (f *fakeTS) DeleteDevice(ctx context.Context, id string) error {
+	for i, d := range f.devs {
+		if d.DeviceID == id {
+			f.devs = append(f.devs[:i], f.devs[i+1:]...)
+			f.deleted = append(f.deleted, id)
+			return nil
+		}
+	}
+	return tailscale.ErrResponse{Status: 404, Message: "not found"}
+}
+
+func (f *fakeTS) SetTags(ctx context.Context, id string, tags []string) error {
+	for _, d := range f.devs {
+		if d.DeviceID == id {
+			d.Tags = tags
+			return nil
+		}
+	}
+	return tailscale.ErrResponse{Status: 404, Message: "not found"}
+}
+
+const opNS = "tailscale"
+
+func svcPath(ns, name string) string {
+	return "/api/v1/namespaces/" + ns + "/services/" + name
+}
+
+func stsPath(name string) string {
+	return "/apis/apps/v1/namespaces/" + opNS + "/statefulsets/" + name
+}
+
+func secretPath(name string) string {
+	return "/api/v1/namespaces/" + opNS + "/secrets/" + name
+}
+
+func saPath(name string) string {
+	return "/api/v1/namespaces/" + opNS + "/serviceaccounts/" + name
+}
+
+func rbacPath(resource, name string) string {
+	return "/apis/rbac.authorization.k8s.io/v1/namespaces/" + opNS + "/" + resource + "/" + name
+}
+
+func setService(t *testing.T, srv *kubetest.Server, ns, name, clusterIP string, annotations map[string]string) {
+	t.Helper()
+	err := srv.Set(svcPath(ns, name), &kube.Service{
+		TypeMeta:   kube.TypeMeta{APIVersion: "v1", Kind: "Service"},
+		ObjectMeta: kube.ObjectMeta{Name: name, Namespace: ns, Annotations: annotations},
+		Spec:       kube.ServiceSpec{ClusterIP: clusterIP},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func getStatefulSet(t *testing.T, srv *kubetest.Server, name string) *kube.StatefulSet {
+	t.Helper()
+	ss := new(kube.StatefulSet)
+	ok, err := srv.Get(stsPath(name), ss)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		return nil
+	}
+	return ss
+}
+
+func envOf(ss *kube.StatefulSet) map[string]string {
+	m := map[string]string{}
+	for _, e := range ss.Spec.Template.Spec.Containers[0].Env {
+		m[e.Name] = e.Value
+	}
+	return m
+}
+
+func mustReconcile(t *testing.T, op *operator) {
+	t.Helper()
+	if err := op.reconcile(context.Background()); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+}
+
+func TestOperator(t *testing.T) {
+	srv := kubetest.New(t)
+	ts := &fakeTS{}
+	op := &operator{
+		kc:          srv.Client(opNS),
+		ts:          ts,
+		logf:        t.Logf,
+		authKey:     "tskey-123",
+		image:       "tailscale:test",
+		defaultTags: []string{"tag:k8s"},
+	}
+
+	setService(t, srv, "default", "web", "10.0.0.5", map[string]string{
+		annotationExpose:   "true",
+		annotationHostname: "web",
+	})
+	setService(t, srv, "default", "other", "10.0.0.6", nil)
+	setService(t, srv, "default", "headless", "None", map[string]string{annotationExpose: "true"})
+	mustReconcile(t, op)
+
+	name := proxyName("default", "web")
+	ss := getStatefulSet(t, srv, name)
+	if ss == nil {
+		t.Fatalf("no statefulset %s created", name)
+	}
+	wantEnv := map[string]string{
+		"TS_KUBE_SECRET": name,
+		"TS_USERSPACE":   "false",
+		"TS_DEST_IP":     "10.0.0.5",
+		"TS_EXTRA_ARGS":  "--hostname=web --advertise-tags=tag:k8s",
+		"TS_AUTH_KEY":    "",
+	}
+	if got := envOf(ss); !reflect.DeepEqual(got, wantEnv) {
+		t.Errorf("env = %v; want %v", got, wantEnv)
+	}
+	if got := ss.Spec.Template.Spec.ServiceAccountName; got != name {
+		t.Errorf("service account = %q; want %q", got, name)
+	}
+	if ok, _ := srv.Get(saPath(name), new(kube.ServiceAccount)); !ok {
+		t.Errorf("no service account %s created", name)
+	}
+	// The proxy may only access its own state Secret, not the operator's
+	// or other proxies'.
+	role := new(kube.Role)
+	if ok, err := srv.Get(rbacPath("roles", name), role); !ok || err != nil {
+		t.Fatalf("getting role: %v, %v", ok, err)
+	}
+	wantRules := []kube.PolicyRule{{
+		APIGroups:     []string{""},
+		Resources:     []string{"secrets"},
+		ResourceNames: []string{name},
+		Verbs:         []string{"get", "update", "patch"},
+	}}
+	if !reflect.DeepEqual(role.Rules, wantRules) {
+		t.Errorf("role rules = %+v; want %+v", role.Rules, wantRules)
+	}
+	rb := new(kube.RoleBinding)
+	if ok, err := srv.Get(rbacPath("rolebindings", name), rb); !ok || err != nil {
+		t.Fatalf("getting role binding: %v, %v", ok, err)
+	}
+	wantSubjects := []kube.Subject{{Kind: "ServiceAccount", Name: name, Namespace: opNS}}
+	if !reflect.DeepEqual(rb.Subjects, wantSubjects) || rb.RoleRef.Kind != "Role" || rb.RoleRef.Name != name {
+		t.Errorf("role binding = %+v", rb)
+	}
+	if ss.Labels[labelParentNamespace] != "default" || ss.Labels[labelParentName] != "web" {
+		t.Errorf("labels = %v", ss.Labels)
+	}
+	for _, n := range []string{"other", "headless"} {
+		if getStatefulSet(t, srv, proxyName("default", n)) != nil {
+			t.Errorf("unexpected proxy for service %q", n)
+		}
+	}
+
+	secret, err := op.kc.GetSecret(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(secret.Data[authKeySecretKey]); got != "tskey-123" {
+		t.Errorf("auth key in secret = %q", got)
+	}
+
+	// Reconciling again without changes must not touch the StatefulSet.
+	mustReconcile(t, op)
+	if ss2 := getStatefulSet(t, srv, name); ss2.ResourceVersion != ss.ResourceVersion {
+		t.Errorf("statefulset updated without changes")
+	}
+
+	// Simulate the proxy logging in, which writes its state to the secret
+	// and registers a device.
+	nodeKey := key.NewNode()
+	prefs := ipn.NewPrefs()
+	prefs.Persist = &persist.Persist{PrivateNodeKey: nodeKey}
+	secret.Data[string(ipn.GlobalDaemonStateKey)] = prefs.ToBytes()
+	if err := op.kc.UpdateSecret(context.Background(), secret); err != nil {
+		t.Fatal(err)
+	}
+	ts.devs = []*tailscale.Device{
+		{DeviceID: "other-dev", NodeKey: key.NewNode().Public().String()},
+		{DeviceID: "proxy-dev", NodeKey: nodeKey.Public().String(), Tags: []string{"tag:k8s"}},
+	}
+
+	// Changing the hostname and tags updates the StatefulSet and the
+	// device's tags.
+	setService(t, srv, "default", "web", "10.0.0.5", map[string]string{
+		annotationExpose:   "true",
+		annotationHostname: "web2",
+		annotationTags:     "tag:web, tag:prod",
+	})
+	mustReconcile(t, op)
+	ss = getStatefulSet(t, srv, name)
+	if got, want := envOf(ss)["TS_EXTRA_ARGS"], "--hostname=web2 --advertise-tags=tag:web,tag:prod"; got != want {
+		t.Errorf("TS_EXTRA_ARGS = %q; want %q", got, want)
+	}
+	if got := ts.devs[1].Tags; !sameTags(got, []string{"tag:prod", "tag:web"}) {
+		t.Errorf("device tags = %v", got)
+	}
+
+	// Invalid tags are reported and leave the proxy alone.
+	setService(t, srv, "default", "web", "10.0.0.5", map[string]string{
+		annotationExpose: "true",
+		annotationTags:   "web",
+	})
+	if err := op.reconcile(context.Background()); err == nil || !strings.Contains(err.Error(), annotationTags) {
+		t.Errorf("reconcile with invalid tags = %v", err)
+	}
+	if getStatefulSet(t, srv, name).ResourceVersion != ss.ResourceVersion {
+		t.Errorf("statefulset updated with invalid tags")
+	}
+
+	// Deleting the Service removes the proxy and its device.
+	srv.Delete(svcPath("default", "web"))
+	mustReconcile(t, op)
+	if getStatefulSet(t, srv, name) != nil {
+		t.Errorf("statefulset not deleted")
+	}
+	if ok, _ := srv.Get(secretPath(name), new(kube.Secret)); ok {
+		t.Errorf("secret not deleted")
+	}
+	if !reflect.DeepEqual(ts.deleted, []string{"proxy-dev"}) {
+		t.Errorf("deleted devices = %v; want [proxy-dev]", ts.deleted)
+	}
+	for _, p := range []string{saPath(name), rbacPath("roles", name), rbacPath("rolebindings", name)} {
+		if ok, _ := srv.Get(p, new(map[string]any)); ok {
+			t.Errorf("%s not deleted", p)
+		}
+	}
+}
+
+func TestOperatorUnexpose(t *testing.T) {
+	srv := kubetest.New(t)
+	op := &operator{
+		kc:      srv.Client(opNS),
+		logf:    t.Logf,
+		authKey: "tskey-123",
+	}
+	setService(t, srv, "ns1", "db", "10.0.0.7", map[string]string{annotationExpose: "true"})
+	mustReconcile(t, op)
+	name := proxyName("ns1", "db")
+	if getStatefulSet(t, srv, name) == nil {
+		t.Fatal("proxy not created")
+	}
+
+	// Removing the annotation removes the proxy, even without access
+	// to the Tailscale API.
+	setService(t, srv, "ns1", "db", "10.0.0.7", nil)
+	mustReconcile(t, op)
+	if getStatefulSet(t, srv, name) != nil {
+		t.Errorf("statefulset not deleted")
+	}
+	if ok, _ := srv.Get(secretPath(name), new(kube.Secret)); ok {
+		t.Errorf("secret not deleted")
+	}
+}
+
+func TestOperatorWatchesServices(t *testing.T) {
+	srv := kubetest.New(t)
+	op := &operator{
+		kc:      srv.Client(opNS),
+		logf:    t.Logf,
+		authKey: "tskey-123",
+	}
+	setService(t, srv, "default", "first", "10.0.0.4", map[string]string{annotationExpose: "true"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		op.run(ctx, time.Hour)
+	}()
+
+	waitFor := func(desc string, cond func() bool) {
+		t.Helper()
+		for deadline := time.Now().Add(10 * time.Second); !cond(); {
+			if time.Now().After(deadline) {
+				t.Fatalf("timeout waiting for %s", desc)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitFor("initial reconcile", func() bool { return getStatefulSet(t, srv, proxyName("default", "first")) != nil })
+
+	// With an hour between resyncs, only the watch can pick up changes
+	// made after the initial reconcile.
+	setService(t, srv, "default", "web", "10.0.0.5", map[string]string{annotationExpose: "true"})
+	name := proxyName("default", "web")
+	waitFor("proxy creation", func() bool { return getStatefulSet(t, srv, name) != nil })
+
+	srv.Delete(svcPath("default", "web"))
+	waitFor("proxy deletion", func() bool { return getStatefulSet(t, srv, name) == nil })
+
+	cancel()
+	<-done
+}
+
+func TestProxyName(t *testing.T) {
+	a := proxyName("default", "web")
+	if a != proxyName("default", "web") {
+		t.Error("proxyName not stable")
+	}
+	if a == proxyName("other", "web") {
+		t.Error("proxyName not unique across namespaces")
+	}
+	long := proxyName("default", strings.Repeat("x", 100))
+	if len(long) > 52 {
+		t.Errorf("proxyName too long for a StatefulSet: %q", long)
+	}
+}
@@ -0,0 +1,555 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"tailscale.com/client/tailscale"
+	"tailscale.com/ipn"
+	"tailscale.com/kube"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/dnsname"
+	"tailscale.com/util/multierr"
+)
+
+// Annotations on Services that the operator acts on.
+const (
+	// annotationExpose, when "true", exposes the Service to the tailnet.
+	annotationExpose = "tailscale.com/expose"
+	// annotationHostname optionally sets the proxy's tailnet hostname.
+	// It defaults to "<namespace>-<name>" of the Service.
+	annotationHostname = "tailscale.com/hostname"
+	// annotationTags optionally sets the comma-separated ACL tags of the
+	// proxy, overriding the operator's --default-tags.
+	annotationTags = "tailscale.com/tags"
+)
+
+// Labels that the operator puts on the resources it creates.
+const (
+	labelManaged         = "tailscale.com/managed"
+	labelParentName      = "tailscale.com/parent-resource"
+	labelParentNamespace = "tailscale.com/parent-resource-ns"
+)
+
+// annotationConfigHash is set on proxy StatefulSets to a hash of the pod
+// template the operator generated, so that changes can be detected
+// without comparing against the defaults the API server fills in.
+const annotationConfigHash = "tailscale.com/proxy-config-hash"
+
+// authKeySecretKey is the key of the proxy's state Secret that holds the
+// auth key the proxy logs in with.
+const authKeySecretKey = "authkey"
+
+// tsClient is the subset of the Tailscale API used by the operator.
+type tsClient interface {
+	Devices(ctx context.Context, fields *tailscale.DeviceFieldsOpts) ([]*tailscale.Device, error)
+	DeleteDevice(ctx context.Context, deviceID string) error
+	SetTags(ctx context.Context, deviceID string, tags []string) error
+}
+
+// operator reconciles proxies for annotated Services.
+type operator struct {
+	kc          *kube.Client
+	ts          tsClient // or nil, to not manage tailnet devices
+	logf        logger.Logf
+	authKey     string
+	image       string
+	defaultTags []string
+}
+
+// run reconciles all Services whenever a Service changes, and at least
+// every resync period, until ctx is done.
+func (o *operator) run(ctx context.Context, resync time.Duration) {
+	changed := make(chan struct{}, 1)
+	go o.watchServices(ctx, changed)
+	t := time.NewTicker(resync)
+	defer t.Stop()
+	for {
+		if err := o.reconcile(ctx); err != nil {
+			o.logf("reconcile: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-changed:
+		}
+	}
+}
+
+// watchServices sends to changed, without blocking, whenever a Service
+// changes, until ctx is done.
+func (o *operator) watchServices(ctx context.Context, changed chan<- struct{}) {
+	for {
+		err := o.watchServicesOnce(ctx, changed)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != io.EOF {
+			// io.EOF is the API server ending the watch, which it
+			// does periodically; anything else is worth a delay.
+			o.logf("watching services: %v", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}
+}
+
+func (o *operator) watchServicesOnce(ctx context.Context, changed chan<- struct{}) error {
+	w, err := kube.Watch[kube.Service](ctx, o.kc, kube.Services, "", kube.ListOptions{})
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	for {
+		ev, err := w.Next()
+		if err != nil {
+			return err
+		}
+		if ev.Type == kube.EventBookmark {
+			continue
+		}
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// reconcile makes sure that there is an up-to-date proxy for every exposed
+// Service, and no proxies for any other Services.
+func (o *operator) reconcile(ctx context.Context) error {
+	svcs, err := o.kc.ListServices(ctx, "")
+	if err != nil {
+		return fmt.Errorf("listing services: %w", err)
+	}
+	want := map[string]*kube.Service{} // by proxy name
+	for i := range svcs {
+		if svc := &svcs[i]; wantsProxy(svc) {
+			want[proxyName(svc.Namespace, svc.Name)] = svc
+		}
+	}
+	devs := &deviceCache{ts: o.ts}
+
+	var errs []error
+	for _, name := range sortedKeys(want) {
+		svc := want[name]
+		if err := o.ensureProxy(ctx, name, svc, devs); err != nil {
+			errs = append(errs, fmt.Errorf("service %s/%s: %w", svc.Namespace, svc.Name, err))
+		}
+	}
+
+	sel := labelManaged + "=true"
+	stale := map[string]bool{}
+	ssets, err := o.kc.ListStatefulSets(ctx, sel)
+	if err != nil {
+		return fmt.Errorf("listing statefulsets: %w", err)
+	}
+	for _, ss := range ssets {
+		if want[ss.Name] == nil {
+			stale[ss.Name] = true
+		}
+	}
+	secrets, err := o.kc.ListSecrets(ctx, sel)
+	if err != nil {
+		return fmt.Errorf("listing secrets: %w", err)
+	}
+	for _, s := range secrets {
+		if want[s.Name] == nil {
+			stale[s.Name] = true
+		}
+	}
+	sas, _, err := kube.List[kube.ServiceAccount](ctx, o.kc, kube.ServiceAccounts, o.kc.Namespace(), kube.ListOptions{LabelSelector: sel})
+	if err != nil {
+		return fmt.Errorf("listing service accounts: %w", err)
+	}
+	for _, sa := range sas {
+		if want[sa.Name] == nil {
+			stale[sa.Name] = true
+		}
+	}
+	for _, name := range sortedKeys(stale) {
+		if err := o.cleanupProxy(ctx, name, devs); err != nil {
+			errs = append(errs, fmt.Errorf("cleaning up proxy %s: %w", name, err))
+		}
+	}
+	return multierr.New(errs...)
+}
+
+// wantsProxy reports whether svc should be exposed to the tailnet.
+func wantsProxy(svc *kube.Service) bool {
+	if svc.Annotations[annotationExpose] != "true" || svc.DeletionTimestamp != nil {
+		return false
+	}
+	// Headless and ExternalName Services have no ClusterIP to forward to.
+	return svc.Spec.ClusterIP != "" && svc.Spec.ClusterIP != "None"
+}
+
+// proxyName returns the name of the StatefulSet and Secret of the proxy
+// for the Service ns/name. It is stable, unique per Service, and short
+// enough to be used as a StatefulSet name.
+func proxyName(ns, name string) string {
+	sum := sha256.Sum256([]byte(ns + "/" + name))
+	if len(name) > 32 {
+		name = strings.TrimRight(name[:32], "-.")
+	}
+	return fmt.Sprintf("ts-%s-%s", name, hex.EncodeToString(sum[:4]))
+}
+
+// ensureProxy creates or updates the proxy named name for svc.
+func (o *operator) ensureProxy(ctx context.Context, name string, svc *kube.Service, devs *deviceCache) error {
+	hostname := svc.Annotations[annotationHostname]
+	if hostname == "" {
+		hostname = svc.Namespace + "-" + svc.Name
+	}
+	hostname = dnsname.SanitizeHostname(hostname)
+	tags := o.defaultTags
+	if v, ok := svc.Annotations[annotationTags]; ok {
+		var err error
+		if tags, err = parseTags(v); err != nil {
+			return fmt.Errorf("invalid %s annotation: %w", annotationTags, err)
+		}
+	}
+	labels := map[string]string{
+		labelManaged:         "true",
+		labelParentName:      svc.Name,
+		labelParentNamespace: svc.Namespace,
+	}
+
+	if err := o.ensureProxyAccount(ctx, name, labels); err != nil {
+		return err
+	}
+	secret, err := o.kc.GetSecret(ctx, name)
+	if kube.IsNotFound(err) {
+		secret = nil
+		err = o.kc.CreateSecret(ctx, &kube.Secret{
+			TypeMeta: kube.TypeMeta{
+				APIVersion: "v1",
+				Kind:       "Secret",
+			},
+			ObjectMeta: kube.ObjectMeta{
+				Name:   name,
+				Labels: labels,
+			},
+			Data: map[string][]byte{
+				authKeySecretKey: []byte(o.authKey),
+			},
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("state secret: %w", err)
+	}
+
+	want := o.proxyStatefulSet(name, svc.Spec.ClusterIP, hostname, tags, labels)
+	cur, err := o.kc.GetStatefulSet(ctx, name)
+	switch {
+	case kube.IsNotFound(err):
+		if err := o.kc.CreateStatefulSet(ctx, want); err != nil {
+			return fmt.Errorf("creating statefulset: %w", err)
+		}
+		o.logf("created proxy %s for service %s/%s", name, svc.Namespace, svc.Name)
+	case err != nil:
+		return fmt.Errorf("getting statefulset: %w", err)
+	case cur.Annotations[annotationConfigHash] != want.Annotations[annotationConfigHash]:
+		cur.Labels = want.Labels
+		cur.Annotations = want.Annotations
+		cur.Spec = want.Spec
+		if err := o.kc.UpdateStatefulSet(ctx, cur); err != nil {
+			return fmt.Errorf("updating statefulset: %w", err)
+		}
+		o.logf("updated proxy %s for service %s/%s", name, svc.Namespace, svc.Name)
+	}
+
+	// Tags only take effect through "tailscale up" when a device first
+	// registers, so update those of already registered devices through the
+	// API.
+	if secret == nil || o.ts == nil {
+		return nil
+	}
+	nk, ok := nodeKeyOf(secret)
+	if !ok {
+		return nil // not logged in yet
+	}
+	dev, err := devs.byNodeKey(ctx, nk)
+	if err != nil || dev == nil {
+		return err
+	}
+	if !sameTags(dev.Tags, tags) {
+		if err := o.ts.SetTags(ctx, dev.DeviceID, tags); err != nil {
+			return fmt.Errorf("setting tags of device %s: %w", dev.DeviceID, err)
+		}
+		o.logf("set tags of proxy %s (device %s) to %v", name, dev.DeviceID, tags)
+	}
+	return nil
+}
+
+// ensureProxyAccount creates the ServiceAccount that the proxy named name
+// runs as, if it doesn't exist yet, along with a Role and RoleBinding that
+// let it access its own state Secret and no other.
+func (o *operator) ensureProxyAccount(ctx context.Context, name string, labels map[string]string) error {
+	meta := kube.ObjectMeta{Name: name, Labels: labels}
+	sa := &kube.ServiceAccount{
+		TypeMeta:   kube.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+		ObjectMeta: meta,
+	}
+	if err := createIfMissing(ctx, o.kc, kube.ServiceAccounts, name, sa); err != nil {
+		return fmt.Errorf("service account: %w", err)
+	}
+	role := &kube.Role{
+		TypeMeta:   kube.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role"},
+		ObjectMeta: meta,
+		Rules: []kube.PolicyRule{{
+			APIGroups:     []string{""},
+			Resources:     []string{"secrets"},
+			ResourceNames: []string{name},
+			Verbs:         []string{"get", "update", "patch"},
+		}},
+	}
+	if err := createIfMissing(ctx, o.kc, kube.Roles, name, role); err != nil {
+		return fmt.Errorf("role: %w", err)
+	}
+	rb := &kube.RoleBinding{
+		TypeMeta:   kube.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+		ObjectMeta: meta,
+		Subjects: []kube.Subject{{
+			Kind:      "ServiceAccount",
+			Name:      name,
+			Namespace: o.kc.Namespace(),
+		}},
+		RoleRef: kube.RoleRef{
+			APIGroup: "rbac.authorization.k8s.io",
+			Kind:     "Role",
+			Name:     name,
+		},
+	}
+	if err := createIfMissing(ctx, o.kc, kube.RoleBindings, name, rb); err != nil {
+		return fmt.Errorf("role binding: %w", err)
+	}
+	return nil
+}
+
+// createIfMissing creates obj, of type r and named name, in kc's namespace
+// unless it already exists.
+func createIfMissing[T any](ctx context.Context, kc *kube.Client, r kube.Resource, name string, obj *T) error {
+	_, err := kube.Get[T](ctx, kc, r, kc.Namespace(), name)
+	if kube.IsNotFound(err) {
+		_, err = kube.Create(ctx, kc, r, kc.Namespace(), obj)
+	}
+	return err
+}
+
+// deleteProxyAccount deletes what ensureProxyAccount creates for the
+// proxy named name. Resources that are already gone are ignored.
+func (o *operator) deleteProxyAccount(ctx context.Context, name string) error {
+	for _, r := range []kube.Resource{kube.RoleBindings, kube.Roles, kube.ServiceAccounts} {
+		if err := o.kc.Delete(ctx, r, o.kc.Namespace(), name); err != nil && !kube.IsNotFound(err) {
+			return fmt.Errorf("deleting %s: %w", r.Name, err)
+		}
+	}
+	return nil
+}
+
+// proxyStatefulSet returns the StatefulSet of a proxy named name that
+// forwards tailnet traffic to destIP.
+func (o *operator) proxyStatefulSet(name, destIP, hostname string, tags []string, labels map[string]string) *kube.StatefulSet {
+	extraArgs := "--hostname=" + hostname
+	if len(tags) > 0 {
+		extraArgs += " --advertise-tags=" + strings.Join(tags, ",")
+	}
+	optional := true
+	privileged := true
+	one := int32(1)
+	podLabels := map[string]string{"app": name}
+	tmpl := kube.PodTemplateSpec{
+		ObjectMeta: kube.ObjectMeta{
+			Labels: podLabels,
+		},
+		Spec: kube.PodSpec{
+			ServiceAccountName: name,
+			// IP forwarding has to be enabled for the proxy to DNAT
+			// traffic to the Service, and the net.ipv4.ip_forward sysctl
+			// isn't allowed by the kubelet by default.
+			InitContainers: []kube.Container{{
+				Name:            "sysctler",
+				Image:           "busybox",
+				Command:         []string{"/bin/sh"},
+				Args:            []string{"-c", "sysctl -w net.ipv4.ip_forward=1"},
+				SecurityContext: &kube.SecurityContext{Privileged: &privileged},
+			}},
+			Containers: []kube.Container{{
+				Name:  "tailscale",
+				Image: o.image,
+				Env: []kube.EnvVar{
+					{Name: "TS_KUBE_SECRET", Value: name},
+					{Name: "TS_USERSPACE", Value: "false"},
+					{Name: "TS_DEST_IP", Value: destIP},
+					{Name: "TS_EXTRA_ARGS", Value: extraArgs},
+					{Name: "TS_AUTH_KEY", ValueFrom: &kube.EnvVarSource{
+						SecretKeyRef: &kube.SecretKeySelector{
+							Name:     name,
+							Key:      authKeySecretKey,
+							Optional: &optional,
+						},
+					}},
+				},
+				SecurityContext: &kube.SecurityContext{
+					Capabilities: &kube.Capabilities{Add: []string{"NET_ADMIN"}},
+				},
+			}},
+		},
+	}
+	b, _ := json.Marshal(tmpl)
+	sum := sha256.Sum256(b)
+	return &kube.StatefulSet{
+		TypeMeta: kube.TypeMeta{
+			APIVersion: "apps/v1",
+			Kind:       "StatefulSet",
+		},
+		ObjectMeta: kube.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+			Annotations: map[string]string{
+				annotationConfigHash: hex.EncodeToString(sum[:8]),
+			},
+		},
+		Spec: kube.StatefulSetSpec{
+			Replicas:    &one,
+			Selector:    &kube.LabelSelector{MatchLabels: podLabels},
+			ServiceName: name,
+			Template:    tmpl,
+		},
+	}
+}
+
+// cleanupProxy removes the proxy named name: its StatefulSet, its device
+// on the tailnet, its state Secret and its ServiceAccount, in that order.
+// Resources that are already gone are ignored.
+func (o *operator) cleanupProxy(ctx context.Context, name string, devs *deviceCache) error {
+	if err := o.kc.DeleteStatefulSet(ctx, name); err != nil && !kube.IsNotFound(err) {
+		return fmt.Errorf("deleting statefulset: %w", err)
+	}
+	secret, err := o.kc.GetSecret(ctx, name)
+	if kube.IsNotFound(err) {
+		return o.deleteProxyAccount(ctx, name)
+	}
+	if err != nil {
+		return fmt.Errorf("getting state secret: %w", err)
+	}
+	if nk, ok := nodeKeyOf(secret); ok && o.ts != nil {
+		dev, err := devs.byNodeKey(ctx, nk)
+		if err != nil {
+			return err
+		}
+		if dev != nil {
+			if err := o.ts.DeleteDevice(ctx, dev.DeviceID); err != nil {
+				return fmt.Errorf("deleting device %s: %w", dev.DeviceID, err)
+			}
+			o.logf("deleted device %s of proxy %s", dev.DeviceID, name)
+		}
+	}
+	if err := o.kc.DeleteSecret(ctx, name); err != nil && !kube.IsNotFound(err) {
+		return fmt.Errorf("deleting state secret: %w", err)
+	}
+	if err := o.deleteProxyAccount(ctx, name); err != nil {
+		return err
+	}
+	o.logf("cleaned up proxy %s", name)
+	return nil
+}
+
+// nodeKeyOf returns the node key that the proxy whose state is in secret
+// is logged in with, if any.
+func nodeKeyOf(secret *kube.Secret) (_ key.NodePublic, ok bool) {
+	b := secret.Data[string(ipn.GlobalDaemonStateKey)]
+	if len(b) == 0 {
+		return key.NodePublic{}, false
+	}
+	prefs, err := ipn.PrefsFromBytes(b)
+	if err != nil || prefs.Persist == nil || prefs.Persist.PrivateNodeKey.IsZero() {
+		return key.NodePublic{}, false
+	}
+	return prefs.Persist.PrivateNodeKey.Public(), true
+}
+
+// deviceCache fetches the tailnet's devices at most once per reconcile
+// pass.
+type deviceCache struct {
+	ts      tsClient
+	fetched bool
+	devs    []*tailscale.Device
+}
+
+// byNodeKey returns the device with node key nk, or nil if there's none.
+func (c *deviceCache) byNodeKey(ctx context.Context, nk key.NodePublic) (*tailscale.Device, error) {
+	if !c.fetched {
+		devs, err := c.ts.Devices(ctx, tailscale.DeviceDefaultFields)
+		if err != nil {
+			return nil, fmt.Errorf("listing devices: %w", err)
+		}
+		c.devs, c.fetched = devs, true
+	}
+	for _, d := range c.devs {
+		if d.NodeKey == nk.String() {
+			return d, nil
+		}
+	}
+	return nil, nil
+}
+
+// parseTags parses a comma-separated list of ACL tags.
+func parseTags(s string) ([]string, error) {
+	var tags []string
+	for _, t := range strings.Split(s, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		if err := tailcfg.CheckTag(t); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, nil
+}
+
+// sameTags reports whether a and b contain the same tags, in any order.
+func sameTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
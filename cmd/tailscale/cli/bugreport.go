@@ -7,6 +7,12 @@
 import (
 	"context"
 	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 )
@@ -15,7 +21,28 @@
 	Name:       "bugreport",
 	Exec:       runBugReport,
 	ShortHelp:  "Print a shareable identifier to help diagnose issues",
-	ShortUsage: "bugreport [note]",
+	ShortUsage: "bugreport [--diagnose] [--bundle=<file.tar.gz>] [note]",
+	LongHelp: strings.TrimSpace(`
+The 'tailscale bugreport' command logs a marker in tailscaled's logs and
+prints it, so it can be shared with support to find the relevant logs.
+
+With --diagnose or --bundle, it also collects local diagnostics into a
+gzipped tar archive that can be inspected and shared even when the logs
+can't be uploaded: status, prefs (with secrets redacted), the last
+netcheck report, interface, route and DNS configuration, health state,
+recent logs, a goroutine dump and metrics.
+`),
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("bugreport")
+		fs.BoolVar(&bugReportArgs.diagnose, "diagnose", false, "also write a diagnostics bundle, to tailscale-bugreport-<time>.tar.gz unless --bundle is given")
+		fs.StringVar(&bugReportArgs.bundle, "bundle", "", `if non-empty, file to write a diagnostics bundle to, or "-" for stdout`)
+		return fs
+	})(),
+}
+
+var bugReportArgs struct {
+	diagnose bool
+	bundle   string
 }
 
 func runBugReport(ctx context.Context, args []string) error {
@@ -27,6 +54,9 @@ func runBugReport(ctx context.Context, args []string) error {
 	default:
 		return errors.New("unknown argumets")
 	}
+	if bugReportArgs.diagnose || bugReportArgs.bundle != "" {
+		return runBugReportBundle(ctx, note)
+	}
 	logMarker, err := localClient.BugReport(ctx, note)
 	if err != nil {
 		return err
@@ -34,3 +64,39 @@ func runBugReport(ctx context.Context, args []string) error {
 	outln(logMarker)
 	return nil
 }
+
+func runBugReportBundle(ctx context.Context, note string) error {
+	fileName := bugReportArgs.bundle
+	if fileName == "" {
+		fileName = "tailscale-bugreport-" + time.Now().UTC().Format("20060102T150405Z") + ".tar.gz"
+	}
+	logMarker, rc, err := localClient.BugReportBundle(ctx, note)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if fileName == "-" {
+		if _, err := io.Copy(Stdout, rc); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, logMarker)
+		return nil
+	}
+	f, err := os.OpenFile(fileName, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, rc); err != nil {
+		f.Close()
+		os.Remove(fileName)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(fileName)
+		return err
+	}
+	outln(logMarker)
+	printf("Wrote diagnostics bundle to %s\n", fileName)
+	return nil
+}
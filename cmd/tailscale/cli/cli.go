@@ -161,8 +161,10 @@ func Run(args []string) (err error) {
 			netcheckCmd,
 			ipCmd,
 			statusCmd,
+			healthCmd,
 			pingCmd,
 			ncCmd,
+			speedtestCmd,
 			sshCmd,
 			versionCmd,
 			webCmd,
@@ -98,6 +98,24 @@
 			Exec:      localAPIAction("rebind"),
 			ShortHelp: "force a magicsock rebind",
 		},
+		{
+			Name:       "log-level",
+			Exec:       runLogLevel,
+			ShortUsage: "log-level [subsys=N ...]",
+			ShortHelp:  "print or set the verbosity of tailscaled's logs, per subsystem",
+			LongHelp: strings.TrimSpace(`
+With no arguments, "tailscale debug log-level" prints the verbosity level
+of the logs tailscaled writes to stderr, overall and per subsystem.
+
+Each subsys=N argument sets the verbosity level of a subsystem (such as
+"magicsock" or "dns") to N, where 0 is not verbose and higher numbers are
+increasingly verbose. Setting N to "default" or a negative number reverts
+the subsystem to tailscaled's overall level.
+
+Logs of all levels are uploaded regardless; this only changes what's
+written to the local log.
+`),
+		},
 		{
 			Name:      "prefs",
 			Exec:      runPrefs,
@@ -297,6 +315,39 @@ func localAPIAction(action string) func(context.Context, []string) error {
 	}
 }
 
+func runLogLevel(ctx context.Context, args []string) error {
+	for _, arg := range args {
+		subsys, levelStr, ok := strings.Cut(arg, "=")
+		if !ok {
+			return fmt.Errorf("invalid argument %q; want subsys=N", arg)
+		}
+		level := -1
+		if levelStr != "default" {
+			var err error
+			level, err = strconv.Atoi(levelStr)
+			if err != nil {
+				return fmt.Errorf("invalid level in %q: %w", arg, err)
+			}
+		}
+		if _, err := localClient.SetSubsystemLogLevel(ctx, subsys, level); err != nil {
+			return err
+		}
+	}
+	ll, err := localClient.LogLevels(ctx)
+	if err != nil {
+		return err
+	}
+	printf("overall: %d\n", ll.Level)
+	for _, subsys := range ll.Known {
+		if v, ok := ll.Subsystems[subsys]; ok {
+			printf("%s: %d\n", subsys, v)
+		} else {
+			printf("%s: %d (default)\n", subsys, ll.Level)
+		}
+	}
+	return nil
+}
+
 func runEnv(ctx context.Context, args []string) error {
 	for _, e := range os.Environ() {
 		outln(e)
@@ -489,7 +540,7 @@ func runTS2021(ctx context.Context, args []string) error {
 		return c, err
 	}
 
-	conn, err := controlhttp.Dial(ctx, net.JoinHostPort(ts2021Args.host, "80"), machinePrivate, keys.PublicKey, uint16(ts2021Args.version), dialFunc)
+	conn, err := controlhttp.Dial(ctx, ts2021Args.host, "80", "443", machinePrivate, keys.PublicKey, uint16(ts2021Args.version), dialFunc)
 	log.Printf("controlhttp.Dial = %p, %v", conn, err)
 	if err != nil {
 		return err
@@ -0,0 +1,138 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/health"
+	"tailscale.com/ipn/ipnstate"
+)
+
+var healthCmd = &ffcli.Command{
+	Name:       "health",
+	ShortUsage: "health [check] [--json]",
+	ShortHelp:  "Show health problems, or run active health checks",
+	LongHelp: strings.TrimSpace(`
+'tailscale health' prints the health problems that tailscaled currently
+knows about, most severe first.
+
+'tailscale health check' has tailscaled actively check that it can
+resolve this device's MagicDNS name, ping its home DERP relay server,
+and reach the coordination server, and prints the results. Failed
+checks are also reported as health problems until they next pass.
+tailscaled can run these checks periodically by setting the
+TS_HEALTH_CHECK_INTERVAL environment variable to a duration, such as
+"5m".
+`),
+	Exec: runHealth,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("health")
+		fs.BoolVar(&healthArgs.json, "json", false, "output in JSON format")
+		return fs
+	})(),
+	Subcommands: []*ffcli.Command{
+		{
+			Name:       "check",
+			ShortUsage: "health check [--json]",
+			ShortHelp:  "Run active health checks",
+			Exec:       runHealthCheck,
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("check")
+				fs.BoolVar(&healthArgs.json, "json", false, "output in JSON format")
+				return fs
+			})(),
+		},
+	},
+}
+
+var healthArgs struct {
+	json bool
+}
+
+func runHealth(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	ws, err := localClient.HealthWarnings(ctx)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	if healthArgs.json {
+		return printJSON(ws)
+	}
+	if len(ws) == 0 {
+		outln("No health problems.")
+		return nil
+	}
+	for _, w := range ws {
+		printf("%s\n", formatHealthWarning(w))
+		if w.Remediation != "" {
+			printf("    %s\n", w.Remediation)
+		}
+		if w.BrokenSince != nil {
+			printf("    since %v\n", w.BrokenSince.Local().Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+func runHealthCheck(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	res, err := localClient.RunHealthChecks(ctx)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	if healthArgs.json {
+		return printJSON(res)
+	}
+	failed := 0
+	tw := tabwriter.NewWriter(Stdout, 0, 0, 2, ' ', 0)
+	for _, r := range res {
+		switch {
+		case r.Skipped:
+			fmt.Fprintf(tw, "%s\tskipped\t%s\n", r.Name, strings.TrimPrefix(r.Error, health.ErrCheckSkipped.Error()+": "))
+		case r.OK:
+			fmt.Fprintf(tw, "%s\tok\t%v\n", r.Name, r.Latency.Round(time.Millisecond))
+		default:
+			failed++
+			fmt.Fprintf(tw, "%s\tFAILED\t%s\n", r.Name, r.Error)
+		}
+	}
+	tw.Flush()
+	if failed > 0 {
+		os.Exit(1)
+	}
+	return nil
+}
+
+// formatHealthWarning returns a one-line description of w, prefixed by
+// its severity.
+func formatHealthWarning(w ipnstate.UnhealthyState) string {
+	if strings.EqualFold(w.Title, w.Text) {
+		return fmt.Sprintf("[%s] %s", w.Severity, w.Text)
+	}
+	return fmt.Sprintf("[%s] %s: %s", w.Severity, w.Title, w.Text)
+}
+
+func printJSON(v any) error {
+	j, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	printf("%s\n", j)
+	return nil
+}
@@ -0,0 +1,157 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/net/speedtest"
+)
+
+var speedtestCmd = &ffcli.Command{
+	Name:       "speedtest",
+	ShortUsage: "speedtest [flags] <hostname-or-IP>",
+	ShortHelp:  "Measure throughput and latency to a peer",
+	LongHelp: strings.TrimSpace(`
+
+The 'tailscale speedtest' command measures the throughput between this
+node's tailscaled and a peer's, using a speedtest server built into the
+peer's peerapi. The peer must be owned by the same user, or grant this
+node debug access.
+
+By default it downloads from the peer over one TCP connection while
+sampling the round-trip time. Use --reverse to upload instead, or
+--bidir to do both at once. With --udp, it sends UDP datagrams at a
+fixed --udp-bitrate and reports packet loss and jitter.
+
+`),
+	Exec: runSpeedtest,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("speedtest")
+		fs.DurationVar(&speedtestArgs.duration, "t", speedtest.DefaultDuration, "duration of the test")
+		fs.BoolVar(&speedtestArgs.reverse, "reverse", false, "upload to the peer instead of downloading from it")
+		fs.BoolVar(&speedtestArgs.bidir, "bidir", false, "upload and download at the same time")
+		fs.IntVar(&speedtestArgs.streams, "P", 1, "number of parallel TCP streams per direction")
+		fs.BoolVar(&speedtestArgs.udp, "udp", false, "test with UDP datagrams instead of TCP")
+		fs.Float64Var(&speedtestArgs.udpMbps, "udp-bitrate", speedtest.DefaultUDPBitrate/1e6, "with --udp, the rate to send at, in Mbits/sec")
+		fs.BoolVar(&speedtestArgs.latency, "latency", true, "measure the round-trip time before and during the test")
+		return fs
+	})(),
+}
+
+var speedtestArgs struct {
+	duration time.Duration
+	reverse  bool
+	bidir    bool
+	streams  int
+	udp      bool
+	udpMbps  float64
+	latency  bool
+}
+
+func runSpeedtest(ctx context.Context, args []string) error {
+	st, err := localClient.Status(ctx)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	description, ok := isRunningOrStarting(st)
+	if !ok {
+		printf("%s\n", description)
+		os.Exit(1)
+	}
+	if len(args) != 1 || args[0] == "" {
+		return errors.New("usage: speedtest [flags] <hostname-or-IP>")
+	}
+	if speedtestArgs.reverse && speedtestArgs.bidir {
+		return errors.New("--reverse and --bidir are mutually exclusive")
+	}
+
+	ip, self, err := tailscaleIPFromArg(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if self {
+		return fmt.Errorf("%v is local Tailscale IP", ip)
+	}
+
+	opts := speedtest.Options{
+		Direction: speedtest.Download,
+		Duration:  speedtestArgs.duration,
+		Streams:   speedtestArgs.streams,
+		UDP:       speedtestArgs.udp,
+		Latency:   speedtestArgs.latency,
+	}
+	switch {
+	case speedtestArgs.reverse:
+		opts.Direction = speedtest.Upload
+	case speedtestArgs.bidir:
+		opts.Direction = speedtest.Bidirectional
+	}
+	if opts.UDP {
+		opts.UDPBitrate = int64(speedtestArgs.udpMbps * 1e6)
+	}
+
+	proto := "TCP"
+	if opts.UDP {
+		proto = "UDP"
+	}
+	printf("Starting a %v %s %s test with %s\n", opts.Duration, opts.Direction, proto, args[0])
+	rep, err := runLocalSpeedtest(ctx, netip.MustParseAddr(ip), opts)
+	if err != nil {
+		return err
+	}
+	return rep.WriteText(Stdout)
+}
+
+// runLocalSpeedtest has tailscaled run the speed test described by opts
+// to the peer with Tailscale IP ip, using the speedtest server in its
+// peerapi. It's not a LocalClient method so that other users of the
+// client package, such as derper, don't link the speedtest package.
+func runLocalSpeedtest(ctx context.Context, ip netip.Addr, opts speedtest.Options) (*speedtest.Report, error) {
+	oj, err := json.Marshal(opts)
+	if err != nil {
+		return nil, err
+	}
+	v := url.Values{}
+	v.Set("ip", ip.String())
+	req, err := http.NewRequestWithContext(ctx, "POST", "http://local-tailscaled.sock/localapi/v0/speedtest?"+v.Encode(), bytes.NewReader(oj))
+	if err != nil {
+		return nil, err
+	}
+	res, err := localClient.DoLocalRequest(req)
+	if err != nil {
+		return nil, fixTailscaledConnectError(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		var e struct{ Error string }
+		if json.Unmarshal(body, &e) == nil && e.Error != "" {
+			return nil, errors.New(e.Error)
+		}
+		return nil, fmt.Errorf("%v: %s", res.Status, bytes.TrimSpace(body))
+	}
+	rep := new(speedtest.Report)
+	if err := json.Unmarshal(body, rep); err != nil {
+		return nil, err
+	}
+	return rep, nil
+}
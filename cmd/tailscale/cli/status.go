@@ -130,7 +130,18 @@ func runStatus(ctx context.Context, args []string) error {
 
 	// print health check information prior to checking LocalBackend state as
 	// it may provide an explanation to the user if we choose to exit early
-	if len(st.Health) > 0 {
+	switch {
+	case len(st.HealthWarnings) > 0:
+		printf("# Health check:\n")
+		for _, w := range st.HealthWarnings {
+			printf("#     - %s\n", formatHealthWarning(w))
+			if w.Remediation != "" {
+				printf("#       %s\n", w.Remediation)
+			}
+		}
+		outln()
+	case len(st.Health) > 0:
+		// Older tailscaled without HealthWarnings.
 		printf("# Health check:\n")
 		for _, m := range st.Health {
 			printf("#     - %s\n", m)
@@ -213,6 +224,19 @@ func runStatus(ctx context.Context, args []string) error {
 			printPS(ps)
 		}
 	}
+	if len(st.BIRDProtocols) > 0 {
+		f("\n# BIRD protocols:\n")
+		for _, p := range st.BIRDProtocols {
+			f("#     - %s (%s): %s since %s", p.Name, p.Proto, p.State, p.Since)
+			if p.Info != "" {
+				f("; %s", p.Info)
+			}
+			if p.Proto == "BGP" {
+				f("; %d routes", p.Routes)
+			}
+			f("\n")
+		}
+	}
 	Stdout.Write(buf.Bytes())
 	return nil
 }
@@ -7,10 +7,12 @@
 import (
 	"bytes"
 	"context"
+	"crypto/subtle"
 	"crypto/tls"
 	_ "embed"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
@@ -28,6 +30,7 @@
 	"strings"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/ipn"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/preftype"
@@ -59,6 +62,15 @@ type tmplData struct {
 	IP                string
 	AdvertiseExitNode bool
 	AdvertiseRoutes   string
+	CSRFToken         string
+
+	// The rest is only populated when Status is "Running".
+	Health     []string
+	Peers      []webPeer
+	ExitNodeID tailcfg.StableNodeID
+	CorpDNS    bool
+	RunSSH     bool
+	Files      []apitype.WaitingFile
 }
 
 var webCmd = &ffcli.Command{
@@ -71,21 +83,39 @@ type tmplData struct {
 
 It's primarily intended for use on Synology, QNAP, and other
 NAS devices where a web interface is the natural place to control
-Tailscale, as opposed to a CLI or a native app.
+Tailscale, as opposed to a CLI or a native app, but it works on any
+Linux machine. Besides logging in, it lists peers and how they're
+connected, and can choose an exit node, change DNS and SSH settings,
+and download files received with Taildrop.
+
+The --auth flag selects how users of the web interface are
+authenticated: "synology" and "qnap" use the NAS's own login, "basic"
+uses HTTP basic authentication with the "username:password" in the
+--basic-auth-file, and "none" allows anyone who can reach the
+listen address. The default, "auto", uses the NAS login on Synology
+and QNAP; elsewhere --auth must be given explicitly.
+
+Unless run as a CGI script, the server only answers requests that
+address it by IP address, as localhost, or by the host in --listen,
+so that other websites can't reach it by pointing a DNS name at it.
 `),
 
 	FlagSet: (func() *flag.FlagSet {
 		webf := newFlagSet("web")
 		webf.StringVar(&webArgs.listen, "listen", "localhost:8088", "listen address; use port 0 for automatic")
 		webf.BoolVar(&webArgs.cgi, "cgi", false, "run as CGI script")
+		webf.StringVar(&webArgs.auth, "auth", "auto", `how to authenticate users: "auto", "none", "synology", "qnap" or "basic"`)
+		webf.StringVar(&webArgs.basicAuthFile, "basic-auth-file", "", `path of a file containing the "username:password" for --auth=basic`)
 		return webf
 	})(),
 	Exec: runWeb,
 }
 
 var webArgs struct {
-	listen string
-	cgi    bool
+	listen        string
+	cgi           bool
+	auth          string
+	basicAuthFile string
 }
 
 func tlsConfigFromEnvironment() *tls.Config {
@@ -115,6 +145,20 @@ func runWeb(ctx context.Context, args []string) error {
 	if len(args) > 0 {
 		return fmt.Errorf("too many non-flag arguments: %q", args)
 	}
+	switch webAuthMode() {
+	case "none", "synology", "qnap":
+	case "basic":
+		if _, _, err := readBasicAuthFile(); err != nil {
+			return err
+		}
+	case "auto":
+		return errors.New(`--auth is required on this platform: use --auth=basic, or --auth=none if everyone who can reach --listen may control Tailscale`)
+	default:
+		return fmt.Errorf("invalid --auth mode %q", webArgs.auth)
+	}
+	if webAuthMode() == "none" && !webArgs.cgi && !isLoopbackListenAddr(webArgs.listen) {
+		log.Printf("warning: with --auth=none, anyone who can reach %s can control Tailscale", webArgs.listen)
+	}
 
 	if webArgs.cgi {
 		if err := cgi.Serve(http.HandlerFunc(webHandler)); err != nil {
@@ -149,14 +193,102 @@ func urlOfListenAddr(addr string) string {
 	return fmt.Sprintf("http://%s", net.JoinHostPort(host, port))
 }
 
+// isLoopbackListenAddr reports whether the listen address addr only
+// accepts connections from this machine.
+func isLoopbackListenAddr(addr string) bool {
+	host, _, _ := net.SplitHostPort(addr)
+	if host == "localhost" {
+		return true
+	}
+	ip, err := netip.ParseAddr(host)
+	return err == nil && ip.IsLoopback()
+}
+
+// webAuthMode returns the --auth mode in effect, resolving "auto" on
+// NAS devices with their own login. Elsewhere it returns "auto", as
+// there's no safe default.
+func webAuthMode() string {
+	if webArgs.auth != "" && webArgs.auth != "auto" {
+		return webArgs.auth
+	}
+	switch distro.Get() {
+	case distro.Synology:
+		return "synology"
+	case distro.QNAP:
+		return "qnap"
+	}
+	return "auto"
+}
+
+// validWebHost reports whether host, the Host header of a request,
+// names the server in a way that a DNS rebinding attack can't: as an
+// IP address, localhost, or the host of the --listen address.
+func validWebHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if _, err := netip.ParseAddr(host); err == nil {
+		return true
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	listenHost, _, _ := net.SplitHostPort(webArgs.listen)
+	return listenHost != "" && strings.EqualFold(host, listenHost)
+}
+
+// sameOrigin reports whether r, if it was sent by a browser, came from
+// a page served by this server. Requests without an Origin header are
+// allowed, as browsers send it with all cross-origin requests that can
+// change state.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
+
+// readBasicAuthFile returns the username and password in the
+// --basic-auth-file.
+func readBasicAuthFile() (user, pass string, err error) {
+	if webArgs.basicAuthFile == "" {
+		return "", "", errors.New("--auth=basic requires --basic-auth-file")
+	}
+	b, err := os.ReadFile(webArgs.basicAuthFile)
+	if err != nil {
+		return "", "", err
+	}
+	user, pass, ok := strings.Cut(strings.TrimSpace(string(b)), ":")
+	if !ok || user == "" || pass == "" {
+		return "", "", fmt.Errorf("%s: want \"username:password\"", webArgs.basicAuthFile)
+	}
+	return user, pass, nil
+}
+
 // authorize returns the name of the user accessing the web UI after verifying
 // whether the user has access to the web UI. The function will write the
 // error to the provided http.ResponseWriter.
 // Note: This is different from a tailscale user, and is typically the local
 // user on the node.
 func authorize(w http.ResponseWriter, r *http.Request) (string, error) {
-	switch distro.Get() {
-	case distro.Synology:
+	switch webAuthMode() {
+	case "basic":
+		wantUser, wantPass, err := readBasicAuthFile()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return "", err
+		}
+		user, pass, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(wantUser)) != 1 || subtle.ConstantTimeCompare([]byte(pass), []byte(wantPass)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="Tailscale"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return "", errors.New("unauthorized")
+		}
+		return user, nil
+	case "synology":
 		user, err := synoAuthn()
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusUnauthorized)
@@ -167,7 +299,7 @@ func authorize(w http.ResponseWriter, r *http.Request) (string, error) {
 			return "", err
 		}
 		return user, nil
-	case distro.QNAP:
+	case "qnap":
 		user, resp, err := qnapAuthn(r)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusUnauthorized)
@@ -178,8 +310,12 @@ func authorize(w http.ResponseWriter, r *http.Request) (string, error) {
 			return "", err
 		}
 		return user, nil
+	case "none":
+		return "", nil
 	}
-	return "", nil
+	err := fmt.Errorf("invalid --auth mode %q", webAuthMode())
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+	return "", err
 }
 
 // authorizeSynology checks whether the provided user has access to the web UI
@@ -277,7 +413,7 @@ func synoAuthn() (string, error) {
 }
 
 func authRedirect(w http.ResponseWriter, r *http.Request) bool {
-	if distro.Get() == distro.Synology {
+	if webAuthMode() == "synology" {
 		return synoTokenRedirect(w, r)
 	}
 	return false
@@ -317,6 +453,16 @@ func synoTokenRedirect(w http.ResponseWriter, r *http.Request) bool {
 `
 
 func webHandler(w http.ResponseWriter, r *http.Request) {
+	// As a CGI script, the Host is that of the web server running us,
+	// which does its own checks.
+	if !webArgs.cgi && !validWebHost(r.Host) {
+		http.Error(w, "invalid Host header", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" && r.Method != "HEAD" && !sameOrigin(r) {
+		http.Error(w, "cross-origin request", http.StatusForbidden)
+		return
+	}
 	if authRedirect(w, r) {
 		return
 	}
@@ -331,8 +477,17 @@ func webHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		serveWebAPI(w, r)
+		return
+	}
+
 	if r.Method == "POST" {
 		defer r.Body.Close()
+		if !validCSRF(r) {
+			http.Error(w, "invalid CSRF token", http.StatusForbidden)
+			return
+		}
 		var postData struct {
 			AdvertiseRoutes   string
 			AdvertiseExitNode bool
@@ -392,6 +547,7 @@ func webHandler(w http.ResponseWriter, r *http.Request) {
 		Profile:      profile,
 		Status:       st.BackendState,
 		DeviceName:   deviceName,
+		CSRFToken:    csrfToken(w, r),
 	}
 	exitNodeRouteV4 := netip.MustParsePrefix("0.0.0.0/0")
 	exitNodeRouteV6 := netip.MustParsePrefix("::/0")
@@ -408,6 +564,18 @@ func webHandler(w http.ResponseWriter, r *http.Request) {
 	if len(st.TailscaleIPs) != 0 {
 		data.IP = st.TailscaleIPs[0].String()
 	}
+	for _, hw := range st.HealthWarnings {
+		data.Health = append(data.Health, formatHealthWarning(hw))
+	}
+	if st.BackendState == ipn.Running.String() {
+		data.Peers = webPeers(st)
+		data.ExitNodeID = prefs.ExitNodeID
+		data.CorpDNS = prefs.CorpDNS
+		data.RunSSH = prefs.RunSSH
+		if data.Files, err = localClient.WaitingFiles(r.Context()); err != nil {
+			log.Printf("web: listing Taildrop files: %v", err)
+		}
+	}
 
 	buf := new(bytes.Buffer)
 	if err := tmpl.Execute(buf, data); err != nil {
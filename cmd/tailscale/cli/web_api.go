@@ -0,0 +1,242 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cli
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+)
+
+// The web UI protects its state-changing requests against cross-site
+// request forgery with the double-submit cookie pattern: pages are
+// served with a random token in a cookie and in the page itself, and
+// POSTs must send the token back in a header. That keeps working when
+// each request is handled by a new process, as in CGI mode.
+const (
+	csrfCookie = "TS_WEB_CSRF"
+	csrfHeader = "X-Csrf-Token"
+)
+
+// csrfToken returns the CSRF token of the request's cookie, or sets a
+// cookie with a new token and returns it.
+func csrfToken(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(csrfCookie); err == nil && len(c.Value) == 32 {
+		return c.Value
+	}
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	tok := hex.EncodeToString(b[:])
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    tok,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return tok
+}
+
+// validCSRF reports whether r carries a CSRF token header matching its
+// cookie.
+func validCSRF(r *http.Request) bool {
+	c, err := r.Cookie(csrfCookie)
+	if err != nil || c.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(c.Value), []byte(r.Header.Get(csrfHeader))) == 1
+}
+
+// webPeer is a peer as listed in the web UI.
+type webPeer struct {
+	ID             tailcfg.StableNodeID
+	Name           string
+	IP             string
+	OS             string
+	Online         bool
+	Conn           string // "direct", "relay", or empty if idle
+	Addr           string // the direct address or relay region
+	ExitNodeOption bool
+}
+
+// webPeers returns the peers of st to list in the web UI, sorted by
+// name.
+func webPeers(st *ipnstate.Status) []webPeer {
+	var peers []*ipnstate.PeerStatus
+	for _, k := range st.Peers() {
+		if ps := st.Peer[k]; !ps.ShareeNode {
+			peers = append(peers, ps)
+		}
+	}
+	ipnstate.SortPeers(peers)
+	ret := make([]webPeer, 0, len(peers))
+	for _, ps := range peers {
+		p := webPeer{
+			ID:             ps.ID,
+			Name:           dnsOrQuoteHostname(st, ps),
+			IP:             firstIPString(ps.TailscaleIPs),
+			OS:             ps.OS,
+			Online:         ps.Online,
+			ExitNodeOption: ps.ExitNodeOption,
+		}
+		switch {
+		case !ps.Active:
+		case ps.CurAddr != "":
+			p.Conn, p.Addr = "direct", ps.CurAddr
+		case ps.Relay != "":
+			p.Conn, p.Addr = "relay", ps.Relay
+		}
+		ret = append(ret, p)
+	}
+	return ret
+}
+
+// serveWebAPI serves the web UI's JSON API, under /api/.
+func serveWebAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && !validCSRF(r) {
+		http.Error(w, "invalid CSRF token", http.StatusForbidden)
+		return
+	}
+	switch path := r.URL.Path; {
+	case path == "/api/prefs":
+		serveWebPrefs(w, r)
+	case path == "/api/ping":
+		serveWebPing(w, r)
+	case strings.HasPrefix(path, "/api/files/"):
+		serveWebFile(w, r, strings.TrimPrefix(path, "/api/files/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeWebJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeWebError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+// serveWebPrefs changes the subset of prefs the web UI manages.
+func serveWebPrefs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "want POST", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		ExitNodeID *tailcfg.StableNodeID
+		CorpDNS    *bool
+		RunSSH     *bool
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeWebError(w, http.StatusBadRequest, err)
+		return
+	}
+	mp := new(ipn.MaskedPrefs)
+	if req.ExitNodeID != nil {
+		mp.ExitNodeID = *req.ExitNodeID
+		mp.ExitNodeIDSet = true
+		mp.ExitNodeIPSet = true // clear any exit node set by IP
+	}
+	if req.CorpDNS != nil {
+		mp.CorpDNS = *req.CorpDNS
+		mp.CorpDNSSet = true
+	}
+	if req.RunSSH != nil {
+		mp.RunSSH = *req.RunSSH
+		mp.RunSSHSet = true
+	}
+	if _, err := localClient.EditPrefs(r.Context(), mp); err != nil {
+		writeWebError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeWebJSON(w, struct{}{})
+}
+
+// serveWebPing pings a peer, for the web UI to show its latency.
+func serveWebPing(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "want POST", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct{ IP string }
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeWebError(w, http.StatusBadRequest, err)
+		return
+	}
+	ip, err := netip.ParseAddr(req.IP)
+	if err != nil {
+		writeWebError(w, http.StatusBadRequest, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	pr, err := localClient.Ping(ctx, ip, tailcfg.PingDisco)
+	if err != nil {
+		writeWebError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if pr.Err != "" {
+		writeWebError(w, http.StatusInternalServerError, fmt.Errorf("%s", pr.Err))
+		return
+	}
+	via := pr.Endpoint
+	if pr.DERPRegionCode != "" {
+		via = "DERP(" + pr.DERPRegionCode + ")"
+	}
+	writeWebJSON(w, map[string]any{
+		"LatencyMs": pr.LatencySeconds * 1000,
+		"Via":       via,
+	})
+}
+
+// serveWebFile downloads (GET) or deletes (DELETE) a file in the
+// Taildrop inbox.
+func serveWebFile(w http.ResponseWriter, r *http.Request, name string) {
+	if name == "" || strings.Contains(name, "/") {
+		http.Error(w, "bad file name", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		rc, size, err := localClient.GetWaitingFile(r.Context(), name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		defer rc.Close()
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		io.Copy(w, rc)
+	case "DELETE":
+		if err := localClient.DeleteWaitingFile(r.Context(), name); err != nil {
+			writeWebError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeWebJSON(w, struct{}{})
+	default:
+		http.Error(w, "want GET or DELETE", http.StatusMethodNotAllowed)
+	}
+}
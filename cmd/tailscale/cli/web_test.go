@@ -4,7 +4,18 @@
 
 package cli
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+)
 
 func TestUrlOfListenAddr(t *testing.T) {
 	tests := []struct {
@@ -42,3 +53,164 @@ func TestUrlOfListenAddr(t *testing.T) {
 		})
 	}
 }
+
+func TestWebTemplate(t *testing.T) {
+	data := tmplData{
+		Status:     "Running",
+		DeviceName: "nas",
+		IP:         "100.64.0.1",
+		CSRFToken:  "0123456789abcdef0123456789abcdef",
+		Health:     []string{"[medium] Test problem: broken"},
+		Peers: []webPeer{
+			{ID: "n1", Name: "exit", IP: "100.64.0.2", OS: "linux", Online: true, Conn: "direct", Addr: "1.2.3.4:41641", ExitNodeOption: true},
+			{ID: "n2", Name: "laptop", IP: "100.64.0.3", OS: "macOS"},
+		},
+		ExitNodeID: "n1",
+		RunSSH:     true,
+		Files:      []apitype.WaitingFile{{Name: "a b.txt", Size: 3}},
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+	for _, want := range []string{
+		`<option value="n1" selected>exit</option>`,
+		`data-pref="RunSSH" checked>`,
+		`href="api/files/a%20b.txt"`,
+		`data-ip="100.64.0.2"`,
+		`Test problem: broken`,
+		`const csrfToken = "0123456789abcdef0123456789abcdef";`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("page doesn't contain %q", want)
+		}
+	}
+}
+
+func TestWebCSRF(t *testing.T) {
+	rec := httptest.NewRecorder()
+	tok := csrfToken(rec, httptest.NewRequest("GET", "/", nil))
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value != tok {
+		t.Fatalf("got cookies %v; want one with %q", cookies, tok)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		cookie bool
+		header string
+		want   int
+	}{
+		{"no-token", false, "", http.StatusForbidden},
+		{"no-header", true, "", http.StatusForbidden},
+		{"wrong-header", true, "x" + tok[1:], http.StatusForbidden},
+		{"bad-request", true, tok, http.StatusBadRequest},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/prefs", strings.NewReader("not json"))
+			if tt.cookie {
+				req.AddCookie(cookies[0])
+			}
+			if tt.header != "" {
+				req.Header.Set(csrfHeader, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			serveWebAPI(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("got status %d; want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebBasicAuth(t *testing.T) {
+	oldArgs := webArgs
+	defer func() { webArgs = oldArgs }()
+	webArgs.auth = "basic"
+	webArgs.basicAuthFile = filepath.Join(t.TempDir(), "creds")
+	if err := os.WriteFile(webArgs.basicAuthFile, []byte("admin:hunter2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		user, pass string
+		wantErr    bool
+	}{
+		{"admin", "hunter2", false},
+		{"admin", "wrong", true},
+		{"", "", true},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		if tt.user != "" {
+			req.SetBasicAuth(tt.user, tt.pass)
+		}
+		rec := httptest.NewRecorder()
+		user, err := authorize(rec, req)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("authorize(%q, %q) error = %v; want error %v", tt.user, tt.pass, err, tt.wantErr)
+		}
+		if err == nil && user != tt.user {
+			t.Errorf("authorize(%q, %q) user = %q", tt.user, tt.pass, user)
+		}
+		if err != nil && rec.Code != http.StatusUnauthorized {
+			t.Errorf("authorize(%q, %q) status = %d; want 401", tt.user, tt.pass, rec.Code)
+		}
+	}
+}
+
+func TestWebHostAndOrigin(t *testing.T) {
+	oldArgs := webArgs
+	defer func() { webArgs = oldArgs }()
+	webArgs.listen = "nas.lan:8088"
+	webArgs.auth = "none"
+
+	for _, tt := range []struct {
+		method, host, origin string
+		want                 bool
+	}{
+		{"GET", "localhost:8088", "", true},
+		{"GET", "127.0.0.1:8088", "", true},
+		{"GET", "[::1]:8088", "", true},
+		{"GET", "192.168.1.5:8088", "", true},
+		{"GET", "NAS.lan:8088", "", true},
+		{"GET", "nas.lan", "", true},
+		{"GET", "attacker.example:8088", "", false},
+		{"GET", "localhost.attacker.example:8088", "", false},
+		{"POST", "localhost:8088", "http://localhost:8088", true},
+		{"POST", "localhost:8088", "", true},
+		{"POST", "localhost:8088", "http://attacker.example", false},
+		{"POST", "localhost:8088", "null", false},
+		{"POST", "attacker.example:8088", "http://attacker.example:8088", false},
+	} {
+		req := httptest.NewRequest(tt.method, "/api/nonexistent", nil)
+		req.Host = tt.host
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		rec := httptest.NewRecorder()
+		webHandler(rec, req)
+		// Allowed requests get as far as the API's CSRF check or
+		// its 404.
+		rejected := rec.Code == http.StatusForbidden && !strings.Contains(rec.Body.String(), "CSRF")
+		if rejected == tt.want {
+			t.Errorf("%s Host %q Origin %q: status %d %q; want allowed %v", tt.method, tt.host, tt.origin, rec.Code, rec.Body.String(), tt.want)
+		}
+	}
+}
+
+func TestWebAuthModeAuto(t *testing.T) {
+	oldArgs := webArgs
+	defer func() { webArgs = oldArgs }()
+	webArgs.auth = "auto"
+	if mode := webAuthMode(); mode != "auto" {
+		t.Skipf("auth mode resolves to %q on this machine", mode)
+	}
+	if err := runWeb(context.Background(), nil); err == nil || !strings.Contains(err.Error(), "--auth is required") {
+		t.Errorf("runWeb with --auth=auto: got error %v", err)
+	}
+	rec := httptest.NewRecorder()
+	if _, err := authorize(rec, httptest.NewRequest("GET", "/", nil)); err == nil {
+		t.Error("authorize allowed a request with no auth mode")
+	}
+}
@@ -10,24 +10,203 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/http/httputil"
+	"net/netip"
 	"strings"
 )
 
-// httpProxyHandler returns an HTTP proxy http.Handler using the
-// provided backend dialer.
-func httpProxyHandler(dialer func(ctx context.Context, netw, addr string) (net.Conn, error)) http.Handler {
+// How the outbound HTTP proxy reaches destinations that aren't on the
+// tailnet, as set by --outbound-http-proxy-internet.
+const (
+	// proxyInternetAuto dials them with the tailnet dialer, which uses
+	// the exit node if one is in use and the host's network otherwise.
+	proxyInternetAuto = "auto"
+	// proxyInternetDirect dials them from the host's network, even if
+	// an exit node is in use.
+	proxyInternetDirect = "direct"
+	// proxyInternetDeny refuses to proxy them.
+	proxyInternetDeny = "deny"
+)
+
+// pacPath is the path at which the outbound HTTP proxy serves a proxy
+// auto-config file.
+const pacPath = "/proxy.pac"
+
+type dialFunc func(ctx context.Context, netw, addr string) (net.Conn, error)
+
+// httpProxy is the outbound HTTP proxy.
+type httpProxy struct {
+	// tailnetDial dials via the tailnet, or via the exit node if one is
+	// in use and the destination isn't on the tailnet.
+	tailnetDial dialFunc
+	// directDial dials from the host's network.
+	directDial dialFunc
+	// isTailnet reports whether host (an IP address or name) is on the
+	// tailnet.
+	isTailnet func(host string) bool
+	// tailnetRoutes returns the tailnet's routes and MagicDNS names,
+	// for the proxy auto-config file.
+	tailnetRoutes func() ([]netip.Prefix, []string)
+
+	internet string // one of the proxyInternet constants
+
+	// username and password, if either is non-empty, are the
+	// credentials that clients must send in Proxy-Authorization.
+	username, password string
+}
+
+// errProxyDenied is returned by httpProxy.dial for destinations that the
+// proxy isn't configured to reach.
+var errProxyDenied = errors.New("destination is not on the tailnet")
+
+// dial dials addr over the route configured for it.
+func (p *httpProxy) dial(ctx context.Context, netw, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if p.isTailnet(host) {
+		return p.tailnetDial(ctx, netw, addr)
+	}
+	switch p.internet {
+	case proxyInternetDirect:
+		return p.directDial(ctx, netw, addr)
+	case proxyInternetDeny:
+		return nil, fmt.Errorf("%s: %w", host, errProxyDenied)
+	}
+	return p.tailnetDial(ctx, netw, addr)
+}
+
+// checkAuth reports whether the credentials in header (either
+// "Proxy-Authorization" or "Authorization") of r are valid.
+func (p *httpProxy) checkAuth(r *http.Request, header string) bool {
+	if p.username == "" && p.password == "" {
+		return true
+	}
+	user, pass, ok := parseBasicAuth(r.Header.Get(header))
+	if !ok {
+		return false
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(p.username))
+	passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(p.password))
+	return userOK&passOK == 1
+}
+
+// parseBasicAuth parses the value of an HTTP Basic authorization header.
+func parseBasicAuth(v string) (user, pass string, ok bool) {
+	const prefix = "Basic "
+	if len(v) < len(prefix) || !strings.EqualFold(v[:len(prefix)], prefix) {
+		return "", "", false
+	}
+	b, err := base64.StdEncoding.DecodeString(v[len(prefix):])
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(b), ":")
+}
+
+// servePAC serves a proxy auto-config file that sends requests for
+// tailnet destinations to this proxy, at the address that the client
+// used to fetch it.
+func (p *httpProxy) servePAC(w http.ResponseWriter, r *http.Request) {
+	if !p.checkAuth(r, "Authorization") {
+		w.Header().Set("WWW-Authenticate", `Basic realm="tailscale"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Host == "" {
+		http.Error(w, "missing Host", http.StatusBadRequest)
+		return
+	}
+	routes, names := p.tailnetRoutes()
+	w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+	w.Write(genPAC(r.Host, p.internet, routes, names))
+}
+
+// genPAC returns a proxy auto-config file that uses the proxy at
+// proxyAddr for the tailnet's routes and names. Other destinations use
+// the proxy too, unless internet is proxyInternetDirect or
+// proxyInternetDeny.
+func genPAC(proxyAddr, internet string, routes []netip.Prefix, names []string) []byte {
+	proxy := fmt.Sprintf("PROXY %s", proxyAddr)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "function FindProxyForURL(url, host) {\n")
+	fmt.Fprintf(&b, "\tvar proxy = %q;\n", proxy)
+	if len(names) > 0 {
+		fmt.Fprintf(&b, "\tvar h = host.toLowerCase().replace(/\\.$/, \"\");\n")
+		fmt.Fprintf(&b, "\tswitch (h) {\n")
+		for _, n := range names {
+			fmt.Fprintf(&b, "\tcase %q:\n", n)
+		}
+		fmt.Fprintf(&b, "\t\treturn proxy;\n")
+		fmt.Fprintf(&b, "\t}\n")
+	}
+	if len(routes) > 0 {
+		// isInNet only supports IPv4; isInNetEx, where supported, also
+		// handles IPv6.
+		fmt.Fprintf(&b, "\tvar ip = dnsResolve(host);\n")
+		fmt.Fprintf(&b, "\tif (ip) {\n")
+		for _, r := range routes {
+			if r.Addr().Is4() {
+				mask := net.IP(net.CIDRMask(r.Bits(), 32))
+				fmt.Fprintf(&b, "\t\tif (isInNet(ip, %q, %q)) return proxy;\n", r.Masked().Addr(), mask)
+			}
+		}
+		fmt.Fprintf(&b, "\t\tif (typeof isInNetEx === \"function\") {\n")
+		for _, r := range routes {
+			if r.Addr().Is6() {
+				fmt.Fprintf(&b, "\t\t\tif (isInNetEx(ip, %q)) return proxy;\n", r.Masked())
+			}
+		}
+		fmt.Fprintf(&b, "\t\t}\n")
+		fmt.Fprintf(&b, "\t}\n")
+	}
+	if internet == proxyInternetAuto {
+		fmt.Fprintf(&b, "\treturn proxy;\n")
+	} else {
+		fmt.Fprintf(&b, "\treturn \"DIRECT\";\n")
+	}
+	fmt.Fprintf(&b, "}\n")
+	return b.Bytes()
+}
+
+// httpProxyHandler returns an HTTP proxy http.Handler that dials
+// destinations as configured by p.
+func httpProxyHandler(p *httpProxy) http.Handler {
 	rp := &httputil.ReverseProxy{
-		Director: func(r *http.Request) {}, // no change
+		Director: func(r *http.Request) {
+			r.Header.Del("Proxy-Authorization")
+		},
 		Transport: &http.Transport{
-			DialContext: dialer,
+			DialContext: p.dial,
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if errors.Is(err, errProxyDenied) {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadGateway)
 		},
 	}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && r.RequestURI == pacPath {
+			p.servePAC(w, r)
+			return
+		}
+		if !p.checkAuth(r, "Proxy-Authorization") {
+			w.Header().Set("Proxy-Authenticate", `Basic realm="tailscale"`)
+			http.Error(w, "proxy authentication required", http.StatusProxyAuthRequired)
+			return
+		}
 		if r.Method != "CONNECT" {
 			backURL := r.RequestURI
 			if strings.HasPrefix(backURL, "/") || backURL == "*" {
@@ -41,10 +220,14 @@ func httpProxyHandler(dialer func(ctx context.Context, netw, addr string) (net.C
 		// CONNECT support:
 
 		dst := r.RequestURI
-		c, err := dialer(r.Context(), "tcp", dst)
+		c, err := p.dial(r.Context(), "tcp", dst)
 		if err != nil {
 			w.Header().Set("Tailscale-Connect-Error", err.Error())
-			http.Error(w, err.Error(), 500)
+			code := 500
+			if errors.Is(err, errProxyDenied) {
+				code = http.StatusForbidden
+			}
+			http.Error(w, err.Error(), code)
 			return
 		}
 		defer c.Close()
@@ -0,0 +1,188 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// newTestProxy returns an httpProxy that considers host to be on the
+// tailnet, and records the dial function used for each dial in dials.
+func newTestProxy(internet, tailnetHost string, dials *[]string) *httpProxy {
+	var d net.Dialer
+	record := func(name string) dialFunc {
+		return func(ctx context.Context, netw, addr string) (net.Conn, error) {
+			*dials = append(*dials, name+" "+addr)
+			return d.DialContext(ctx, netw, addr)
+		}
+	}
+	return &httpProxy{
+		tailnetDial: record("tailnet"),
+		directDial:  record("direct"),
+		isTailnet:   func(host string) bool { return host == tailnetHost },
+		tailnetRoutes: func() ([]netip.Prefix, []string) {
+			return []netip.Prefix{
+				netip.MustParsePrefix("100.101.102.103/32"),
+				netip.MustParsePrefix("10.1.0.0/16"),
+				netip.MustParsePrefix("fd7a:115c:a1e0::/48"),
+			}, []string{"foo", "foo.tailnet.ts.net"}
+		},
+		internet: internet,
+	}
+}
+
+func TestHTTPProxyRouting(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "backend")
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+	_, port, _ := net.SplitHostPort(backendURL.Host)
+
+	tests := []struct {
+		internet  string
+		host      string
+		wantCode  int
+		wantDials []string
+	}{
+		{proxyInternetAuto, "127.0.0.1", 200, []string{"tailnet 127.0.0.1:" + port}},
+		{proxyInternetAuto, "localhost", 200, []string{"tailnet localhost:" + port}},
+		{proxyInternetDirect, "127.0.0.1", 200, []string{"tailnet 127.0.0.1:" + port}},
+		{proxyInternetDirect, "localhost", 200, []string{"direct localhost:" + port}},
+		{proxyInternetDeny, "127.0.0.1", 200, []string{"tailnet 127.0.0.1:" + port}},
+		{proxyInternetDeny, "localhost", 403, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.internet+"_"+tt.host, func(t *testing.T) {
+			var dials []string
+			ps := httptest.NewServer(httpProxyHandler(newTestProxy(tt.internet, "127.0.0.1", &dials)))
+			defer ps.Close()
+			psURL, _ := url.Parse(ps.URL)
+			c := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(psURL)}}
+
+			res, err := c.Get(fmt.Sprintf("http://%s:%s/", tt.host, port))
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != tt.wantCode {
+				t.Errorf("status = %v; want %v", res.StatusCode, tt.wantCode)
+			}
+			if strings.Join(dials, ",") != strings.Join(tt.wantDials, ",") {
+				t.Errorf("dials = %q; want %q", dials, tt.wantDials)
+			}
+		})
+	}
+}
+
+func TestHTTPProxyAuth(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v := r.Header.Get("Proxy-Authorization"); v != "" {
+			t.Errorf("backend got Proxy-Authorization %q", v)
+		}
+		io.WriteString(w, "backend")
+	}))
+	defer backend.Close()
+
+	var dials []string
+	p := newTestProxy(proxyInternetAuto, "", &dials)
+	p.username, p.password = "user", "pass"
+	ps := httptest.NewServer(httpProxyHandler(p))
+	defer ps.Close()
+
+	for _, tt := range []struct {
+		name     string
+		userinfo *url.Userinfo
+		wantCode int
+	}{
+		{"none", nil, http.StatusProxyAuthRequired},
+		{"wrong", url.UserPassword("user", "nope"), http.StatusProxyAuthRequired},
+		{"ok", url.UserPassword("user", "pass"), 200},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			psURL, _ := url.Parse(ps.URL)
+			psURL.User = tt.userinfo
+			c := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(psURL)}}
+			res, err := c.Get(backend.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != tt.wantCode {
+				t.Errorf("status = %v; want %v", res.StatusCode, tt.wantCode)
+			}
+			if tt.wantCode == http.StatusProxyAuthRequired && res.Header.Get("Proxy-Authenticate") == "" {
+				t.Error("missing Proxy-Authenticate")
+			}
+		})
+	}
+
+	// The PAC file requires the same credentials.
+	req, _ := http.NewRequest("GET", ps.URL+pacPath, nil)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("PAC without credentials: status = %v; want 401", res.StatusCode)
+	}
+	req.SetBasicAuth("user", "pass")
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 200 {
+		t.Errorf("PAC with credentials: status = %v; want 200", res.StatusCode)
+	}
+}
+
+func TestPAC(t *testing.T) {
+	var dials []string
+	ps := httptest.NewServer(httpProxyHandler(newTestProxy(proxyInternetDirect, "", &dials)))
+	defer ps.Close()
+
+	res, err := http.Get(ps.URL + pacPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != "application/x-ns-proxy-autoconfig" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	psURL, _ := url.Parse(ps.URL)
+	for _, want := range []string{
+		fmt.Sprintf(`var proxy = "PROXY %s";`, psURL.Host),
+		`case "foo":`,
+		`case "foo.tailnet.ts.net":`,
+		`if (isInNet(ip, "100.101.102.103", "255.255.255.255")) return proxy;`,
+		`if (isInNet(ip, "10.1.0.0", "255.255.0.0")) return proxy;`,
+		`if (isInNetEx(ip, "fd7a:115c:a1e0::/48")) return proxy;`,
+		`return "DIRECT";`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("PAC file missing %q; got:\n%s", want, body)
+		}
+	}
+
+	auto := string(genPAC("127.0.0.1:8080", proxyInternetAuto, nil, nil))
+	if want := "function FindProxyForURL(url, host) {\n\tvar proxy = \"PROXY 127.0.0.1:8080\";\n\treturn proxy;\n}\n"; auto != want {
+		t.Errorf("empty auto PAC = %q; want %q", auto, want)
+	}
+}
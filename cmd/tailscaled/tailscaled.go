@@ -18,6 +18,7 @@
 	"flag"
 	"fmt"
 	"log"
+	"math"
 	"net"
 	"net/http"
 	"net/http/pprof"
@@ -27,6 +28,7 @@
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -34,10 +36,12 @@
 	"tailscale.com/control/controlclient"
 	"tailscale.com/envknob"
 	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnlocal"
 	"tailscale.com/ipn/ipnserver"
 	"tailscale.com/ipn/store"
 	"tailscale.com/logpolicy"
 	"tailscale.com/logtail"
+	"tailscale.com/net/bgp"
 	"tailscale.com/net/dns"
 	"tailscale.com/net/netns"
 	"tailscale.com/net/proxymux"
@@ -110,15 +114,23 @@ func defaultTunName() string {
 	statedir       string
 	socketpath     string
 	birdSocketPath string
+	birdRoutesPath string // file to export tailnet routes to BIRD in, if non-empty
+	bgpNeighbor    string // address of the BGP neighbor to announce routes to, if non-empty
+	bgpASN         uint
+	bgpNeighborASN uint
+	bgpRouterID    string
 	verbose        int
 	socksAddr      string // listen address for SOCKS5 server
 	httpProxyAddr  string // listen address for HTTP proxy server
+	proxyCredsPath string // path of "username:password" file for proxy auth
+	httpProxyNet   string // how the HTTP proxy reaches non-tailnet destinations
+	metricsAddr    string // listen address for Prometheus metrics server
 }
 
 var (
-	installSystemDaemon   func([]string) error                      // non-nil on some platforms
-	uninstallSystemDaemon func([]string) error                      // non-nil on some platforms
-	createBIRDClient      func(string) (wgengine.BIRDClient, error) // non-nil on some platforms
+	installSystemDaemon   func([]string) error                                            // non-nil on some platforms
+	uninstallSystemDaemon func([]string) error                                            // non-nil on some platforms
+	createBIRDClient      func(ctlSocket, routesFile string) (wgengine.BIRDClient, error) // non-nil on some platforms
 )
 
 var subCommands = map[string]*func([]string) error{
@@ -135,14 +147,22 @@ func main() {
 	flag.IntVar(&args.verbose, "verbose", 0, "log verbosity level; 0 is default, 1 or higher are increasingly verbose")
 	flag.BoolVar(&args.cleanup, "cleanup", false, "clean up system state and exit")
 	flag.StringVar(&args.debug, "debug", "", "listen address ([ip]:port) of optional debug server")
+	flag.StringVar(&args.metricsAddr, "metrics-listen", "", `optional [ip]:port to serve Prometheus metrics on at /metrics (e.g. "localhost:9100"); they're also available over Tailscale at the node's peerapi /v0/metrics to the node's owner and peers with debug access`)
 	flag.StringVar(&args.socksAddr, "socks5-server", "", `optional [ip]:port to run a SOCK5 server (e.g. "localhost:1080")`)
 	flag.StringVar(&args.httpProxyAddr, "outbound-http-proxy-listen", "", `optional [ip]:port to run an outbound HTTP proxy (e.g. "localhost:8080")`)
+	flag.StringVar(&args.httpProxyNet, "outbound-http-proxy-internet", proxyInternetAuto, `how the outbound HTTP proxy reaches destinations not on the tailnet: "auto" (via the exit node, if one is in use), "direct" (from this host's network, even if an exit node is in use), or "deny"`)
+	flag.StringVar(&args.proxyCredsPath, "proxy-credentials-file", "", `optional path of a file containing "username:password" credentials that clients of the SOCKS5 server and outbound HTTP proxy must authenticate with`)
 	flag.StringVar(&args.tunname, "tun", defaultTunName(), `tunnel interface name; use "userspace-networking" (beta) to not use TUN`)
 	flag.Var(flagtype.PortValue(&args.port, 0), "port", "UDP port to listen on for WireGuard and peer-to-peer traffic; 0 means automatically select")
-	flag.StringVar(&args.statepath, "state", "", "absolute path of state file; use 'kube:<secret-name>' to use Kubernetes secrets or 'arn:aws:ssm:...' to store in AWS SSM; use 'mem:' to not store state and register as an emphemeral node. If empty and --statedir is provided, the default is <statedir>/tailscaled.state. Default: "+paths.DefaultTailscaledStateFile())
+	flag.StringVar(&args.statepath, "state", "", "absolute path of state file; use 'kube:<secret-name>' to use Kubernetes secrets or 'arn:aws:ssm:...' to store in AWS SSM; use 'mem:' to not store state and register as an emphemeral node; use 'enc:<path>?key=<provider>:<arg>' to encrypt the state file with a key from a file ('file:<path>'), an environment variable ('env:<name>'), or on Linux, the kernel keyring ('keyring:<description>') or a file sealed to the machine ('sealed:<path>'). If empty and --statedir is provided, the default is <statedir>/tailscaled.state. Default: "+paths.DefaultTailscaledStateFile())
 	flag.StringVar(&args.statedir, "statedir", "", "path to directory for storage of config state, TLS certs, temporary incoming Taildrop files, etc. If empty, it's derived from --state when possible.")
 	flag.StringVar(&args.socketpath, "socket", paths.DefaultTailscaledSocket(), "path of the service unix socket")
 	flag.StringVar(&args.birdSocketPath, "bird-socket", "", "path of the bird unix socket")
+	flag.StringVar(&args.birdRoutesPath, "bird-routes-file", "", `optional path of a file to write BIRD static protocols "tailscale_routes4" and "tailscale_routes6" to, with routes to the tailnet's peers and accepted subnet routes; bird.conf should include it. Requires --bird-socket.`)
+	flag.StringVar(&args.bgpNeighbor, "bgp-neighbor", "", "optional host[:port] of an eBGP neighbor to announce the subnet routes this node is the primary router for to, using the built-in BGP speaker instead of BIRD")
+	flag.UintVar(&args.bgpASN, "bgp-asn", 0, "this node's BGP autonomous system number; required with --bgp-neighbor")
+	flag.UintVar(&args.bgpNeighborASN, "bgp-neighbor-asn", 0, "the --bgp-neighbor's autonomous system number; required with --bgp-neighbor")
+	flag.StringVar(&args.bgpRouterID, "bgp-router-id", "", "optional IPv4 BGP router ID; if empty, the local IPv4 address of the BGP session is used")
 	flag.BoolVar(&printVersion, "version", false, "print version information and exit")
 
 	if len(os.Args) > 0 && filepath.Base(os.Args[0]) == "tailscale" && beCLI != nil {
@@ -192,6 +212,27 @@ func main() {
 		log.SetFlags(0)
 		log.Fatalf("--bird-socket is not supported on %s", runtime.GOOS)
 	}
+	if args.birdRoutesPath != "" && args.birdSocketPath == "" {
+		log.SetFlags(0)
+		log.Fatalf("--bird-routes-file requires --bird-socket")
+	}
+	if args.bgpNeighbor != "" {
+		if args.birdSocketPath != "" {
+			log.SetFlags(0)
+			log.Fatalf("--bgp-neighbor and --bird-socket are mutually exclusive")
+		}
+		if args.bgpASN == 0 || args.bgpNeighborASN == 0 || args.bgpASN > math.MaxUint32 || args.bgpNeighborASN > math.MaxUint32 {
+			log.SetFlags(0)
+			log.Fatalf("--bgp-neighbor requires valid --bgp-asn and --bgp-neighbor-asn")
+		}
+	}
+
+	switch args.httpProxyNet {
+	case proxyInternetAuto, proxyInternetDirect, proxyInternetDeny:
+	default:
+		log.SetFlags(0)
+		log.Fatalf("invalid --outbound-http-proxy-internet value %q", args.httpProxyNet)
+	}
 
 	// Only apply a default statepath when neither have been provided, so that a
 	// user may specify only --statedir if they wish.
@@ -251,6 +292,16 @@ func statePathOrDefault() string {
 	return ""
 }
 
+// stateFile returns the file that the --state value statepath keeps state
+// in, which for "enc:PATH?key=..." is PATH.
+func stateFile(statepath string) string {
+	if !strings.HasPrefix(statepath, "enc:") {
+		return statepath
+	}
+	path, _, _ := strings.Cut(strings.TrimPrefix(statepath, "enc:"), "?")
+	return path
+}
+
 func ipnServerOpts() (o ipnserver.Options) {
 	// Allow changing the OS-specific IPN behavior for tests
 	// so we can e.g. test Windows-specific behaviors on Linux.
@@ -263,8 +314,8 @@ func ipnServerOpts() (o ipnserver.Options) {
 
 	// If an absolute --state is provided but not --statedir, try to derive
 	// a state directory.
-	if o.VarRoot == "" && filepath.IsAbs(args.statepath) {
-		if dir := filepath.Dir(args.statepath); strings.EqualFold(filepath.Base(dir), "tailscale") {
+	if statefile := stateFile(args.statepath); o.VarRoot == "" && filepath.IsAbs(statefile) {
+		if dir := filepath.Dir(statefile); strings.EqualFold(filepath.Base(dir), "tailscale") {
 			o.VarRoot = dir
 		}
 	}
@@ -380,18 +431,40 @@ func run() error {
 		dialer.NetstackDialTCP = func(ctx context.Context, dst netip.AddrPort) (net.Conn, error) {
 			return ns.DialContextTCP(ctx, dst)
 		}
+		dialer.NetstackDialUDP = func(ctx context.Context, dst netip.AddrPort) (net.Conn, error) {
+			return ns.DialContextUDP(ctx, dst)
+		}
 	}
 	if socksListener != nil || httpProxyListener != nil {
+		var proxyUser, proxyPass string
+		if args.proxyCredsPath != "" {
+			proxyUser, proxyPass, err = readProxyCredentials(args.proxyCredsPath)
+			if err != nil {
+				return err
+			}
+		}
 		if httpProxyListener != nil {
-			hs := &http.Server{Handler: httpProxyHandler(dialer.UserDial)}
+			hs := &http.Server{Handler: httpProxyHandler(&httpProxy{
+				tailnetDial: dialer.UserDial,
+				directDial:  dialer.SystemDial,
+				isTailnet:   dialer.IsTailnetDestination,
+				tailnetRoutes: func() ([]netip.Prefix, []string) {
+					return dialer.TailnetRoutes(), dialer.TailnetNames()
+				},
+				internet: args.httpProxyNet,
+				username: proxyUser,
+				password: proxyPass,
+			})}
 			go func() {
 				log.Fatalf("HTTP proxy exited: %v", hs.Serve(httpProxyListener))
 			}()
 		}
 		if socksListener != nil {
 			ss := &socks5.Server{
-				Logf:   logger.WithPrefix(logf, "socks5: "),
-				Dialer: dialer.UserDial,
+				Logf:     logger.WithPrefix(logf, "socks5: "),
+				Dialer:   dialer.UserDial,
+				Username: proxyUser,
+				Password: proxyPass,
 			}
 			go func() {
 				log.Fatalf("SOCKS5 server exited: %v", ss.Serve(socksListener))
@@ -431,6 +504,13 @@ func run() error {
 		return fmt.Errorf("ipnserver.New: %w", err)
 	}
 	ns.SetLocalBackend(srv.LocalBackend())
+	srv.LocalBackend().SetLogtailLogger(pol.Logtail)
+	metricsBackend.Store(srv.LocalBackend())
+	if args.metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", servePrometheusMetrics)
+		go runDebugServer(mux, args.metricsAddr)
+	}
 	if err := ns.Start(); err != nil {
 		log.Fatalf("failed to start netstack: %v", err)
 	}
@@ -489,6 +569,24 @@ func shouldWrapNetstack() bool {
 	return false
 }
 
+// newBGPSpeaker returns a BGP speaker for the --bgp-* flags.
+func newBGPSpeaker(logf logger.Logf) (*bgp.Speaker, error) {
+	cfg := bgp.Config{
+		LocalAS:  uint32(args.bgpASN),
+		PeerAS:   uint32(args.bgpNeighborASN),
+		Neighbor: args.bgpNeighbor,
+		Logf:     logf,
+	}
+	if args.bgpRouterID != "" {
+		ip, err := netip.ParseAddr(args.bgpRouterID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --bgp-router-id: %w", err)
+		}
+		cfg.RouterID = ip
+	}
+	return bgp.New(cfg)
+}
+
 func tryEngine(logf logger.Logf, linkMon *monitor.Mon, dialer *tsdial.Dialer, name string) (e wgengine.Engine, useNetstack bool, err error) {
 	conf := wgengine.Config{
 		ListenPort:  args.port,
@@ -501,11 +599,25 @@ func tryEngine(logf logger.Logf, linkMon *monitor.Mon, dialer *tsdial.Dialer, na
 
 	if args.birdSocketPath != "" && createBIRDClient != nil {
 		log.Printf("Connecting to BIRD at %s ...", args.birdSocketPath)
-		conf.BIRDClient, err = createBIRDClient(args.birdSocketPath)
+		conf.BIRDClient, err = createBIRDClient(args.birdSocketPath, args.birdRoutesPath)
 		if err != nil {
 			return nil, false, fmt.Errorf("createBIRDClient: %w", err)
 		}
 	}
+	if args.bgpNeighbor != "" {
+		var bgpSpeaker *bgp.Speaker
+		bgpSpeaker, err = newBGPSpeaker(logf)
+		if err != nil {
+			return nil, false, err
+		}
+		defer func() {
+			if err != nil {
+				bgpSpeaker.Close()
+			}
+		}()
+		conf.BGPSpeaker = bgpSpeaker
+		go bgpSpeaker.Run(context.Background())
+	}
 	if useNetstack {
 		if runtime.GOOS == "linux" && distro.Get() == distro.Synology {
 			// On Synology in netstack mode, still init a DNS
@@ -565,10 +677,19 @@ func newDebugMux() *http.ServeMux {
 	return mux
 }
 
+// metricsBackend is the LocalBackend whose per-peer metrics
+// servePrometheusMetrics includes, once it's been created.
+var metricsBackend atomic.Pointer[ipnlocal.LocalBackend]
+
+// servePrometheusMetrics serves all of tailscaled's metrics: expvars,
+// clientmetrics, and per-peer metrics.
 func servePrometheusMetrics(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/plain")
 	tsweb.VarzHandler(w, r)
 	clientmetric.WritePrometheusExpositionFormat(w)
+	if lb := metricsBackend.Load(); lb != nil {
+		lb.WritePeerMetrics(w)
+	}
 }
 
 func runDebugServer(mux *http.ServeMux, addr string) {
@@ -633,6 +754,20 @@ func mustStartProxyListeners(socksAddr, httpAddr string) (socksListener, httpLis
 	return socksListener, httpListener
 }
 
+// readProxyCredentials reads the "username:password" credentials that
+// proxy clients must authenticate with from the file at path.
+func readProxyCredentials(path string) (user, pass string, err error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", fmt.Errorf("reading proxy credentials: %w", err)
+	}
+	user, pass, ok := strings.Cut(strings.TrimSpace(string(b)), ":")
+	if !ok || user == "" {
+		return "", "", fmt.Errorf("proxy credentials file %s: want username:password", path)
+	}
+	return user, pass, nil
+}
+
 var beChildFunc = beChild
 
 func beChild(args []string) error {
@@ -9,12 +9,65 @@
 package main
 
 import (
+	"net/netip"
+
 	"tailscale.com/chirp"
+	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/wgengine"
 )
 
 func init() {
-	createBIRDClient = func(ctlSocket string) (wgengine.BIRDClient, error) {
-		return chirp.New(ctlSocket)
+	createBIRDClient = func(ctlSocket, routesFile string) (wgengine.BIRDClient, error) {
+		c, err := chirp.New(ctlSocket)
+		if err != nil {
+			return nil, err
+		}
+		return &birdClient{BIRDClient: c, routesFile: routesFile}, nil
+	}
+}
+
+// birdRoutesProtocol is the name prefix of the static protocols
+// written to the --bird-routes-file.
+const birdRoutesProtocol = "tailscale_routes"
+
+// birdClient adapts a chirp.BIRDClient to wgengine.BIRDClient.
+type birdClient struct {
+	*chirp.BIRDClient
+	routesFile string // or empty to not export routes
+}
+
+func (c *birdClient) ExportRoutes(dev string, routes []netip.Prefix) error {
+	if c.routesFile == "" {
+		return nil
+	}
+	if err := chirp.WriteStaticRoutes(c.routesFile, birdRoutesProtocol, dev, routes); err != nil {
+		return err
+	}
+	return c.Configure()
+}
+
+func (c *birdClient) Protocols() ([]*ipnstate.BIRDProtocol, error) {
+	ps, err := c.BIRDClient.Protocols()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*ipnstate.BIRDProtocol, 0, len(ps))
+	for _, p := range ps {
+		bp := &ipnstate.BIRDProtocol{
+			Name:  p.Name,
+			Proto: p.Proto,
+			State: p.State,
+			Since: p.Since,
+			Info:  p.Info,
+		}
+		if p.Proto == "BGP" {
+			n, err := c.RouteCount(p.Name)
+			if err != nil {
+				return nil, err
+			}
+			bp.Routes = n
+		}
+		ret = append(ret, bp)
 	}
+	return ret, nil
 }
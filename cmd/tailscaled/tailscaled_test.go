@@ -11,3 +11,20 @@ func TestNothing(t *testing.T) {
 	// GODEBUG=memprofilerate=1 go test -v -run=Nothing -memprofile=prof.mem
 	// without any errors about no matching tests.
 }
+
+func TestStateFile(t *testing.T) {
+	tests := []struct {
+		statepath, want string
+	}{
+		{"/var/lib/tailscale/tailscaled.state", "/var/lib/tailscale/tailscaled.state"},
+		{"enc:/var/lib/tailscale/tailscaled.state?key=file:/etc/ts.key", "/var/lib/tailscale/tailscaled.state"},
+		{"enc:/var/lib/tailscale/tailscaled.state", "/var/lib/tailscale/tailscaled.state"},
+		{"kube:tailscale", "kube:tailscale"},
+		{"mem:", "mem:"},
+	}
+	for _, tt := range tests {
+		if got := stateFile(tt.statepath); got != tt.want {
+			t.Errorf("stateFile(%q) = %q; want %q", tt.statepath, got, tt.want)
+		}
+	}
+}
@@ -115,8 +115,9 @@ func generateServeIndex(distFS fs.FS) ([]byte, error) {
 }
 
 var entryPointsToDefaultDistPaths = map[string]string{
-	"src/index.css": "dist/index.css",
-	"src/index.ts":  "dist/index.js",
+	"src/index.css":        "dist/index.css",
+	"src/index.ts":         "dist/index.js",
+	"src/shared-worker.ts": "dist/shared-worker.js",
 }
 
 func handleServeDist(w http.ResponseWriter, r *http.Request, distFS fs.FS) {
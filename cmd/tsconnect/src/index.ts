@@ -6,9 +6,25 @@ import "./wasm_exec"
 import wasmUrl from "./main.wasm"
 import { sessionStateStorage } from "./js-state-store"
 import { renderApp } from "./app"
+import { connectSharedIPN } from "./shared-ipn"
 
 async function main() {
   const app = await renderApp()
+
+  const params = new URLSearchParams(window.location.search)
+  // authKey allows for an auth key to be specified as a url param which
+  // automatically authorizes the client for use.
+  const authKey = DEBUG ? params.get("authkey") ?? undefined : undefined
+
+  // Where possible, all tabs share one node, run by a SharedWorker that
+  // persists its state. Otherwise (or when using an auth key) each tab runs
+  // its own ephemeral node, since tabs cannot share persisted state safely.
+  if (typeof SharedWorker !== "undefined" && !authKey) {
+    const worker = new SharedWorker(workerURL(), { name: "ipn" })
+    app.runWithIPN(connectSharedIPN(worker))
+    return
+  }
+
   const go = new Go()
   const wasmInstance = await WebAssembly.instantiateStreaming(
     fetch(`./dist/${wasmUrl}`),
@@ -19,19 +35,20 @@ async function main() {
     app.handleGoPanic("Unexpected shutdown")
   )
 
-  const params = new URLSearchParams(window.location.search)
-  const authKey = params.get("authkey") ?? undefined
-
   const ipn = newIPN({
     // Persist IPN state in sessionStorage in development, so that we don't need
     // to re-authorize every time we reload the page.
     stateStorage: DEBUG ? sessionStateStorage : undefined,
-    // authKey allows for an auth key to be
-    // specified as a url param which automatically
-    // authorizes the client for use.
-    authKey: DEBUG ? authKey : undefined,
+    authKey,
   })
   app.runWithIPN(ipn)
 }
 
+/** Returns the URL of the shared worker script, which has a hashed name in
+ * production builds. */
+function workerURL(): string {
+  const meta = document.querySelector<HTMLMetaElement>('meta[name="ipn-worker"]')
+  return meta?.content ?? "./dist/shared-worker.js"
+}
+
 main()
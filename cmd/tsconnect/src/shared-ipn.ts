@@ -0,0 +1,269 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/**
+ * @fileoverview Sharing of one IPN instance between tabs. The instance runs
+ * in a SharedWorker (see shared-worker.ts), which serves it with serveIPN.
+ * Each tab connects to it with connectSharedIPN, which returns an IPN proxy.
+ *
+ * The proxy supports run, login, logout, ssh and fetch (the latter without
+ * streaming). dial and listen are not supported, since their streams cannot
+ * be transferred to the tab in all browsers.
+ */
+
+type SharedIPNRequest =
+  | { type: "run" }
+  | { type: "login" }
+  | { type: "logout" }
+  | { type: "fetch"; id: number; url: string; init?: IPNFetchInit }
+  | {
+      type: "ssh"
+      host: string
+      username: string
+      rows: number
+      cols: number
+      port: MessagePort
+    }
+
+type SharedIPNMessage =
+  | { type: "notify"; method: keyof IPNCallbacks; arg: any }
+  | { type: "fetch"; id: number; response?: SharedFetchResponse; error?: string }
+
+type SharedFetchResponse = {
+  status: number
+  statusText: string
+  ok: boolean
+  headers: Record<string, string>
+  body: ArrayBuffer
+}
+
+/** Messages on the port of an SSH session, in either direction. */
+type SharedSSHMessage =
+  | { type: "write"; data: string }
+  | { type: "input"; data: string }
+  | { type: "resize"; rows: number; cols: number }
+  | { type: "close" }
+  | { type: "done" }
+
+/**
+ * Serves ipn to tabs that connect to the SharedWorker. The first tab to call
+ * run starts it; later tabs are sent the latest state so that they catch up.
+ */
+export function serveIPN(ipn: IPN): (port: MessagePort) => void {
+  const ports = new Set<MessagePort>()
+  const latest = new Map<keyof IPNCallbacks, any>()
+  let running = false
+
+  const notify = (method: keyof IPNCallbacks) => (arg: any) => {
+    latest.set(method, arg)
+    for (const port of ports) {
+      port.postMessage({ type: "notify", method, arg } as SharedIPNMessage)
+    }
+  }
+
+  const handle = async (port: MessagePort, req: SharedIPNRequest) => {
+    switch (req.type) {
+      case "run":
+        ports.add(port)
+        if (!running) {
+          running = true
+          ipn.run({
+            notifyState: notify("notifyState"),
+            notifyNetMap: notify("notifyNetMap"),
+            notifyBrowseToURL: notify("notifyBrowseToURL"),
+            notifyPanicRecover: notify("notifyPanicRecover"),
+          })
+        } else {
+          for (const [method, arg] of latest) {
+            port.postMessage({ type: "notify", method, arg } as SharedIPNMessage)
+          }
+        }
+        break
+      case "login":
+        ipn.login()
+        break
+      case "logout":
+        ipn.logout()
+        break
+      case "fetch":
+        try {
+          const res = await ipn.fetch(req.url, req.init)
+          const body = await res.arrayBuffer()
+          const response: SharedFetchResponse = {
+            status: res.status,
+            statusText: res.statusText,
+            ok: res.ok,
+            headers: res.headers,
+            body,
+          }
+          port.postMessage({ type: "fetch", id: req.id, response }, [body])
+        } catch (err) {
+          port.postMessage({
+            type: "fetch",
+            id: req.id,
+            error: String(err),
+          } as SharedIPNMessage)
+        }
+        break
+      case "ssh":
+        serveSSH(ipn, req)
+        break
+    }
+  }
+
+  return (port: MessagePort) => {
+    port.onmessage = (e: MessageEvent<SharedIPNRequest>) => handle(port, e.data)
+    port.start()
+  }
+}
+
+function serveSSH(
+  ipn: IPN,
+  req: Extract<SharedIPNRequest, { type: "ssh" }>
+) {
+  const { port } = req
+  const post = (msg: SharedSSHMessage) => port.postMessage(msg)
+  let readFn: ((data: string) => void) | undefined
+  const session = ipn.ssh(req.host, req.username, {
+    writeFn: (data) => post({ type: "write", data }),
+    setReadFn: (fn) => (readFn = fn),
+    rows: req.rows,
+    cols: req.cols,
+    onDone: () => {
+      post({ type: "done" })
+      port.close()
+    },
+  })
+  port.onmessage = (e: MessageEvent<SharedSSHMessage>) => {
+    const msg = e.data
+    switch (msg.type) {
+      case "input":
+        readFn?.(msg.data)
+        break
+      case "resize":
+        session.resize(msg.rows, msg.cols)
+        break
+      case "close":
+        session.close()
+        break
+    }
+  }
+  port.start()
+}
+
+/** Returns an IPN proxy for the instance served by the SharedWorker. */
+export function connectSharedIPN(worker: SharedWorker): IPN {
+  const port = worker.port
+  const send = (req: SharedIPNRequest, transfer: Transferable[] = []) =>
+    port.postMessage(req, transfer)
+  let callbacks: IPNCallbacks | undefined
+  let nextFetchID = 0
+  const pendingFetches = new Map<
+    number,
+    { resolve: (r: IPNFetchResponse) => void; reject: (err: string) => void }
+  >()
+
+  port.onmessage = (e: MessageEvent<SharedIPNMessage>) => {
+    const msg = e.data
+    switch (msg.type) {
+      case "notify":
+        callbacks?.[msg.method](msg.arg)
+        break
+      case "fetch": {
+        const pending = pendingFetches.get(msg.id)
+        pendingFetches.delete(msg.id)
+        if (!pending) {
+          break
+        }
+        if (msg.response) {
+          pending.resolve(sharedFetchResponse(msg.response))
+        } else {
+          pending.reject(msg.error ?? "unknown error")
+        }
+        break
+      }
+    }
+  }
+  port.start()
+
+  const unsupported = (method: string) => () =>
+    Promise.reject(`${method} is not supported by a shared IPN`)
+
+  return {
+    run(cb) {
+      callbacks = cb
+      send({ type: "run" })
+    },
+    login() {
+      send({ type: "login" })
+    },
+    logout() {
+      send({ type: "logout" })
+    },
+    ssh(host, username, termConfig) {
+      const channel = new MessageChannel()
+      const sshPort = channel.port1
+      const post = (msg: SharedSSHMessage) => sshPort.postMessage(msg)
+      sshPort.onmessage = (e: MessageEvent<SharedSSHMessage>) => {
+        const msg = e.data
+        switch (msg.type) {
+          case "write":
+            termConfig.writeFn(msg.data)
+            break
+          case "done":
+            sshPort.close()
+            termConfig.onDone()
+            break
+        }
+      }
+      sshPort.start()
+      termConfig.setReadFn((data) => post({ type: "input", data }))
+      send(
+        {
+          type: "ssh",
+          host,
+          username,
+          rows: termConfig.rows,
+          cols: termConfig.cols,
+          port: channel.port2,
+        },
+        [channel.port2]
+      )
+      return {
+        resize(rows, cols) {
+          post({ type: "resize", rows, cols })
+          return true
+        },
+        close() {
+          post({ type: "close" })
+          return true
+        },
+      }
+    },
+    fetch(url, init) {
+      if (init?.body instanceof ReadableStream) {
+        return Promise.reject("streaming request bodies are not supported")
+      }
+      const id = nextFetchID++
+      return new Promise((resolve, reject) => {
+        pendingFetches.set(id, { resolve, reject })
+        send({ type: "fetch", id, url, init })
+      })
+    },
+    dial: unsupported("dial"),
+    listen: unsupported("listen"),
+  }
+}
+
+function sharedFetchResponse(res: SharedFetchResponse): IPNFetchResponse {
+  return {
+    status: res.status,
+    statusText: res.statusText,
+    ok: res.ok,
+    headers: res.headers,
+    body: new Blob([res.body]).stream(),
+    text: () => Promise.resolve(new TextDecoder().decode(res.body)),
+    arrayBuffer: () => Promise.resolve(res.body),
+  }
+}
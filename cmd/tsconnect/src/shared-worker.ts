@@ -0,0 +1,43 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/**
+ * @fileoverview SharedWorker that runs the IPN instance shared by all tabs,
+ * with its state persisted in IndexedDB, so that they act as one node that
+ * survives reloads.
+ */
+
+import "./wasm_exec"
+import wasmUrl from "./main.wasm"
+import { serveIPN } from "./shared-ipn"
+
+type ConnectEvent = MessageEvent & { ports: readonly MessagePort[] }
+
+const scope = self as unknown as {
+  onconnect: ((e: ConnectEvent) => void) | null
+}
+
+const ipnPromise = (async () => {
+  const go = new Go()
+  // The worker script is in the same directory as the wasm file.
+  const wasmInstance = await WebAssembly.instantiateStreaming(
+    fetch(new URL(wasmUrl, location.href).href),
+    go.importObject
+  )
+  go.run(wasmInstance.instance)
+  const stateStorage = await openIndexedDBStateStorage()
+  return serveIPN(newIPN({ stateStorage, ephemeral: false }))
+})()
+
+scope.onconnect = (e) => {
+  const port = e.ports[0]
+  ipnPromise.then(
+    (serve) => serve(port),
+    (err) => port.postMessage({
+      type: "notify",
+      method: "notifyPanicRecover",
+      arg: String(err),
+    })
+  )
+}
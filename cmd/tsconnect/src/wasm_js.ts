@@ -10,6 +10,12 @@
 
 declare global {
   function newIPN(config: IPNConfig): IPN
+  function openIndexedDBStateStorage(options?: {
+    /** Defaults to "tailscale-ipn". */
+    name?: string
+    /** If set, the state is encrypted with a key derived from it. */
+    passphrase?: string
+  }): Promise<IPNStateStorage>
 
   interface IPN {
     run(callbacks: IPNCallbacks): void
@@ -26,13 +32,54 @@ declare global {
         onDone: () => void
       }
     ): IPNSSHSession
-    fetch(
-      url: string
-    ): Promise<{
-      status: number
-      statusText: string
-      text: () => Promise<string>
-    }>
+    fetch(url: string, init?: IPNFetchInit): Promise<IPNFetchResponse>
+    dial(network: "tcp" | "udp", addr: string): Promise<IPNConn>
+    listen(network: "tcp", addr: string): Promise<IPNListener>
+  }
+
+  type IPNFetchInit = {
+    method?: string
+    headers?: Record<string, string>
+    body?: string | Uint8Array | ArrayBuffer | ReadableStream<Uint8Array>
+  }
+
+  /**
+   * A subset of the Fetch API Response. The body can only be consumed once,
+   * either via the body stream or one of text() and arrayBuffer().
+   */
+  interface IPNFetchResponse {
+    status: number
+    statusText: string
+    ok: boolean
+    /** Keyed by lower-case header name. */
+    headers: Record<string, string>
+    /** Undefined if the JS runtime does not support streams. */
+    body?: ReadableStream<Uint8Array>
+    text(): Promise<string>
+    arrayBuffer(): Promise<ArrayBuffer>
+  }
+
+  /**
+   * A connection over the tailnet. Data can be read and written with the
+   * read and write methods or via the readable and writable streams (if
+   * supported by the JS runtime), but not both.
+   */
+  interface IPNConn {
+    localAddr: string
+    remoteAddr: string
+    /** Resolves to null at EOF. */
+    read(): Promise<Uint8Array | null>
+    write(data: string | Uint8Array | ArrayBuffer): Promise<void>
+    closeWrite(): Promise<void>
+    close(): void
+    readable?: ReadableStream<Uint8Array>
+    writable?: WritableStream<string | Uint8Array | ArrayBuffer>
+  }
+
+  interface IPNListener {
+    port: number
+    accept(): Promise<IPNConn>
+    close(): void
   }
 
   interface IPNSSHSession {
@@ -48,6 +95,8 @@ declare global {
   type IPNConfig = {
     stateStorage?: IPNStateStorage
     authKey?: string
+    /** Whether the node is ephemeral. Defaults to true. */
+    ephemeral?: boolean
   }
 
   type IPNCallbacks = {
@@ -0,0 +1,436 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall/js"
+	"time"
+)
+
+// readChunkSize is the most that is read at once from a connection or
+// body that is being streamed to JS.
+const readChunkSize = 32 << 10
+
+// newJSConn returns a JS object for c, with the methods:
+//
+//	read(): Promise<Uint8Array | null>  // null at EOF
+//	write(data: Uint8Array | string): Promise<void>
+//	closeWrite(): Promise<void>
+//	close(): void
+//
+// the properties localAddr and remoteAddr, and, where the JS runtime
+// supports streams, the properties readable (a ReadableStream) and
+// writable (a WritableStream). The streams and the read and write
+// methods are two views of the same connection; callers should only
+// use one of them for each direction.
+func newJSConn(c net.Conn) map[string]any {
+	return map[string]any{
+		"localAddr":  c.LocalAddr().String(),
+		"remoteAddr": c.RemoteAddr().String(),
+		"read": js.FuncOf(func(this js.Value, args []js.Value) any {
+			return makePromise(func() (any, error) {
+				b, err := readChunk(c)
+				if err == io.EOF {
+					return nil, nil
+				}
+				if err != nil {
+					return nil, err
+				}
+				return b, nil
+			})
+		}),
+		"write": js.FuncOf(func(this js.Value, args []js.Value) any {
+			var data js.Value
+			if len(args) > 0 {
+				data = args[0]
+			}
+			b, err := jsBytes(data)
+			return makePromise(func() (any, error) {
+				if err != nil {
+					return nil, err
+				}
+				_, err := c.Write(b)
+				return nil, err
+			})
+		}),
+		"closeWrite": js.FuncOf(func(this js.Value, args []js.Value) any {
+			return makePromise(func() (any, error) {
+				return nil, closeWrite(c)
+			})
+		}),
+		"close": js.FuncOf(func(this js.Value, args []js.Value) any {
+			c.Close()
+			return nil
+		}),
+		"readable": newReadableStream(c),
+		"writable": newWritableStream(c),
+	}
+}
+
+// closeWrite shuts down the writing side of c if it supports that, and
+// closes it otherwise.
+func closeWrite(c io.WriteCloser) error {
+	if cw, ok := c.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Close()
+}
+
+// readChunk reads up to readChunkSize bytes from r and returns them as
+// a JS Uint8Array. It only returns an error if it read nothing.
+func readChunk(r io.Reader) (js.Value, error) {
+	buf := make([]byte, readChunkSize)
+	n, err := r.Read(buf)
+	if n == 0 {
+		if err == nil {
+			err = io.ErrNoProgress
+		}
+		return js.Undefined(), err
+	}
+	a := js.Global().Get("Uint8Array").New(n)
+	js.CopyBytesToJS(a, buf[:n])
+	return a, nil
+}
+
+// jsBytes returns the contents of v, which must be a string, a
+// Uint8Array or an ArrayBuffer.
+func jsBytes(v js.Value) ([]byte, error) {
+	switch {
+	case v.Type() == js.TypeString:
+		return []byte(v.String()), nil
+	case v.Type() != js.TypeObject:
+	case v.InstanceOf(js.Global().Get("Uint8Array")):
+		b := make([]byte, v.Get("length").Int())
+		js.CopyBytesToGo(b, v)
+		return b, nil
+	case v.InstanceOf(js.Global().Get("ArrayBuffer")):
+		return jsBytes(js.Global().Get("Uint8Array").New(v))
+	}
+	return nil, errors.New("data must be a string, Uint8Array or ArrayBuffer")
+}
+
+// streamsSupported reports whether the JS runtime has the Streams API.
+func streamsSupported() bool {
+	return js.Global().Get("ReadableStream").Type() == js.TypeFunction &&
+		js.Global().Get("WritableStream").Type() == js.TypeFunction
+}
+
+// newReadableStream returns a JS ReadableStream of Uint8Array chunks
+// read from r, or undefined if the JS runtime lacks streams. Canceling
+// the stream closes r.
+//
+// The stream has a high water mark of zero, so that it only reads from
+// r when JS reads from it, leaving r to other readers otherwise.
+func newReadableStream(r io.ReadCloser) js.Value {
+	if !streamsSupported() {
+		return js.Undefined()
+	}
+	return js.Global().Get("ReadableStream").New(map[string]any{
+		"pull": js.FuncOf(func(this js.Value, args []js.Value) any {
+			controller := args[0]
+			return makePromise(func() (any, error) {
+				b, err := readChunk(r)
+				switch {
+				case err == io.EOF:
+					controller.Call("close")
+				case err != nil:
+					controller.Call("error", err.Error())
+				default:
+					controller.Call("enqueue", b)
+				}
+				return nil, nil
+			})
+		}),
+		"cancel": js.FuncOf(func(this js.Value, args []js.Value) any {
+			r.Close()
+			return nil
+		}),
+	}, map[string]any{"highWaterMark": 0})
+}
+
+// newWritableStream returns a JS WritableStream that writes its chunks
+// to w, or undefined if the JS runtime lacks streams. Closing the
+// stream closes the writing side of w, aborting it closes w.
+func newWritableStream(w io.WriteCloser) js.Value {
+	if !streamsSupported() {
+		return js.Undefined()
+	}
+	return js.Global().Get("WritableStream").New(map[string]any{
+		"write": js.FuncOf(func(this js.Value, args []js.Value) any {
+			b, err := jsBytes(args[0])
+			return makePromise(func() (any, error) {
+				if err != nil {
+					return nil, err
+				}
+				_, err := w.Write(b)
+				return nil, err
+			})
+		}),
+		"close": js.FuncOf(func(this js.Value, args []js.Value) any {
+			return makePromise(func() (any, error) {
+				return nil, closeWrite(w)
+			})
+		}),
+		"abort": js.FuncOf(func(this js.Value, args []js.Value) any {
+			w.Close()
+			return nil
+		}),
+	})
+}
+
+// jsStreamReader is an io.ReadCloser reading from a JS ReadableStream.
+// It must not be used from the goroutine running JS callbacks.
+type jsStreamReader struct {
+	reader js.Value // ReadableStreamDefaultReader
+	buf    []byte
+}
+
+func newJSStreamReader(stream js.Value) *jsStreamReader {
+	return &jsStreamReader{reader: stream.Call("getReader")}
+}
+
+func (r *jsStreamReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		res, err := await(r.reader.Call("read"))
+		if err != nil {
+			return 0, err
+		}
+		if res.Get("done").Bool() {
+			return 0, io.EOF
+		}
+		if r.buf, err = jsBytes(res.Get("value")); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *jsStreamReader) Close() error {
+	r.reader.Call("cancel")
+	return nil
+}
+
+// await waits for the JS promise p to settle and returns the value it
+// resolved to, or an error if it was rejected. It must not be called
+// from the goroutine running JS callbacks, which settles promises.
+func await(p js.Value) (js.Value, error) {
+	type result struct {
+		v   js.Value
+		err error
+	}
+	ch := make(chan result, 1)
+	onResolve := js.FuncOf(func(this js.Value, args []js.Value) any {
+		ch <- result{v: args[0]}
+		return nil
+	})
+	defer onResolve.Release()
+	onReject := js.FuncOf(func(this js.Value, args []js.Value) any {
+		ch <- result{err: fmt.Errorf("%s", js.Global().Get("String").Invoke(args[0]).String())}
+		return nil
+	})
+	defer onReject.Release()
+	p.Call("then", onResolve, onReject)
+	r := <-ch
+	return r.v, r.err
+}
+
+// newFetchRequest returns the request for a fetch of url with init (see
+// jsIPN.fetch).
+func newFetchRequest(url string, init js.Value) (*http.Request, error) {
+	method := "GET"
+	var body io.ReadCloser
+	header := http.Header{}
+	if init.Type() == js.TypeObject {
+		if v := init.Get("method"); v.Type() == js.TypeString {
+			method = strings.ToUpper(v.String())
+		}
+		if h := init.Get("headers"); h.Type() == js.TypeObject {
+			keys := js.Global().Get("Object").Call("keys", h)
+			for i := 0; i < keys.Length(); i++ {
+				k := keys.Index(i).String()
+				header.Add(k, h.Get(k).String())
+			}
+		}
+		switch v := init.Get("body"); {
+		case v.IsUndefined() || v.IsNull():
+		case v.Type() == js.TypeObject && v.Get("getReader").Type() == js.TypeFunction:
+			body = newJSStreamReader(v)
+		default:
+			b, err := jsBytes(v)
+			if err != nil {
+				return nil, err
+			}
+			body = io.NopCloser(bytes.NewReader(b))
+		}
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	return req, nil
+}
+
+// newFetchResponse returns a JS object for res, resembling a JS
+// Response. Its body can be consumed either as the ReadableStream in
+// body or with one of text() and arrayBuffer().
+func newFetchResponse(res *http.Response) map[string]any {
+	headers := map[string]any{}
+	for k, vv := range res.Header {
+		headers[strings.ToLower(k)] = strings.Join(vv, ", ")
+	}
+	readAll := func() ([]byte, error) {
+		defer res.Body.Close()
+		return io.ReadAll(res.Body)
+	}
+	return map[string]any{
+		"status":     res.StatusCode,
+		"statusText": res.Status,
+		"ok":         res.StatusCode >= 200 && res.StatusCode < 300,
+		"headers":    headers,
+		"body":       newReadableStream(res.Body),
+		"text": js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			return makePromise(func() (any, error) {
+				b, err := readAll()
+				if err != nil {
+					return nil, err
+				}
+				return string(b), nil
+			})
+		}),
+		"arrayBuffer": js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			return makePromise(func() (any, error) {
+				b, err := readAll()
+				if err != nil {
+					return nil, err
+				}
+				a := js.Global().Get("Uint8Array").New(len(b))
+				js.CopyBytesToJS(a, b)
+				return a.Get("buffer"), nil
+			})
+		}),
+	}
+}
+
+// tcpListeners routes inbound tailnet TCP connections to the listeners
+// that JS opened with listen.
+type tcpListeners struct {
+	mu sync.Mutex
+	m  map[uint16]*tcpListener
+}
+
+// forwardTCP is the netstack ForwardTCPIn hook. It hands c to the
+// listener for port, or closes it if there is none or it doesn't accept
+// c in time.
+func (ls *tcpListeners) forwardTCP(c net.Conn, port uint16) {
+	ls.mu.Lock()
+	ln, ok := ls.m[port]
+	ls.mu.Unlock()
+	if !ok {
+		c.Close()
+		return
+	}
+	t := time.NewTimer(time.Second)
+	defer t.Stop()
+	select {
+	case ln.conns <- c:
+	case <-ln.done:
+		c.Close()
+	case <-t.C:
+		c.Close()
+	}
+}
+
+// listen starts listening for TCP connections to addr, which must be of
+// the form ":port".
+func (ls *tcpListeners) listen(network, addr string) (*tcpListener, error) {
+	if network != "tcp" {
+		return nil, fmt.Errorf("unsupported network %q", network)
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if host != "" {
+		return nil, fmt.Errorf("listening on a specific address (%q) is not supported", host)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || port == 0 {
+		return nil, fmt.Errorf("invalid port %q", portStr)
+	}
+	ln := &tcpListener{
+		ls:    ls,
+		port:  uint16(port),
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if _, ok := ls.m[ln.port]; ok {
+		return nil, fmt.Errorf("listener already open for port %d", port)
+	}
+	if ls.m == nil {
+		ls.m = map[uint16]*tcpListener{}
+	}
+	ls.m[ln.port] = ln
+	return ln, nil
+}
+
+type tcpListener struct {
+	ls    *tcpListeners
+	port  uint16
+	conns chan net.Conn
+	done  chan struct{} // closed by close
+}
+
+func (ln *tcpListener) accept() (net.Conn, error) {
+	select {
+	case c := <-ln.conns:
+		return c, nil
+	case <-ln.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (ln *tcpListener) close() {
+	ln.ls.mu.Lock()
+	defer ln.ls.mu.Unlock()
+	if ln.ls.m[ln.port] == ln {
+		delete(ln.ls.m, ln.port)
+		close(ln.done)
+	}
+}
+
+// jsValue returns the JS object for ln, with the property port and the
+// methods accept(): Promise<IPNConn> and close(): void.
+func (ln *tcpListener) jsValue() map[string]any {
+	return map[string]any{
+		"port": int(ln.port),
+		"accept": js.FuncOf(func(this js.Value, args []js.Value) any {
+			return makePromise(func() (any, error) {
+				c, err := ln.accept()
+				if err != nil {
+					return nil, err
+				}
+				return newJSConn(c), nil
+			})
+		}),
+		"close": js.FuncOf(func(this js.Value, args []js.Value) any {
+			ln.close()
+			return nil
+		}),
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall/js"
+	"testing"
+)
+
+func mustAwait(t *testing.T, p js.Value) js.Value {
+	t.Helper()
+	v, err := await(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func jsString(t *testing.T, v js.Value) string {
+	t.Helper()
+	b, err := jsBytes(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestConnReadWrite(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	conn := js.ValueOf(newJSConn(a))
+	defer conn.Call("close")
+
+	go io.WriteString(b, "hello")
+	if got := jsString(t, mustAwait(t, conn.Call("read"))); got != "hello" {
+		t.Errorf("read = %q; want hello", got)
+	}
+
+	go func() {
+		buf := make([]byte, 5)
+		io.ReadFull(b, buf)
+		b.Write(buf)
+		b.Close()
+	}()
+	mustAwait(t, conn.Call("write", "world"))
+	if got := jsString(t, mustAwait(t, conn.Call("read"))); got != "world" {
+		t.Errorf("read = %q; want world", got)
+	}
+	if v := mustAwait(t, conn.Call("read")); !v.IsNull() {
+		t.Errorf("read at EOF = %v; want null", v)
+	}
+}
+
+func TestConnStreams(t *testing.T) {
+	if !streamsSupported() {
+		t.Skip("no Streams API")
+	}
+	a, b := net.Pipe()
+	conn := js.ValueOf(newJSConn(a))
+
+	go func() {
+		io.WriteString(b, "ping")
+		b.Close()
+	}()
+	got, err := io.ReadAll(newJSStreamReader(conn.Get("readable")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "ping" {
+		t.Errorf("readable = %q; want ping", got)
+	}
+
+	a, b = net.Pipe()
+	conn = js.ValueOf(newJSConn(a))
+	done := make(chan string)
+	go func() {
+		got, _ := io.ReadAll(b)
+		done <- string(got)
+	}()
+	w := conn.Get("writable").Call("getWriter")
+	mustAwait(t, w.Call("write", "po"))
+	mustAwait(t, w.Call("write", js.Global().Get("TextEncoder").New().Call("encode", "ng")))
+	mustAwait(t, w.Call("close"))
+	if got := <-done; got != "pong" {
+		t.Errorf("writable = %q; want pong", got)
+	}
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	if !streamsSupported() {
+		t.Skip("no Streams API")
+	}
+	want := strings.Repeat("0123456789", readChunkSize/4)
+	stream := newReadableStream(io.NopCloser(strings.NewReader(want)))
+	got, err := io.ReadAll(newJSStreamReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("got %d bytes; want %d", len(got), len(want))
+	}
+}
+
+func TestFetchRequest(t *testing.T) {
+	init := js.ValueOf(map[string]any{
+		"method":  "post",
+		"headers": map[string]any{"Content-Type": "text/plain", "X-Foo": "bar"},
+		"body":    "hello",
+	})
+	req, err := newFetchRequest("http://100.64.0.1/x", init)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Method != "POST" || req.URL.Host != "100.64.0.1" {
+		t.Errorf("got %s %s; want POST to 100.64.0.1", req.Method, req.URL)
+	}
+	if got := req.Header.Get("X-Foo"); got != "bar" {
+		t.Errorf("X-Foo = %q; want bar", got)
+	}
+	if b, _ := io.ReadAll(req.Body); string(b) != "hello" {
+		t.Errorf("body = %q; want hello", b)
+	}
+
+	if !streamsSupported() {
+		return
+	}
+	init = js.ValueOf(map[string]any{
+		"method": "PUT",
+		"body":   newReadableStream(io.NopCloser(strings.NewReader("streamed"))),
+	})
+	if req, err = newFetchRequest("http://100.64.0.1/x", init); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(req.Body); string(b) != "streamed" {
+		t.Errorf("body = %q; want streamed", b)
+	}
+}
+
+func TestFetchResponse(t *testing.T) {
+	newRes := func() js.Value {
+		return js.ValueOf(newFetchResponse(&http.Response{
+			StatusCode: 404,
+			Status:     "404 Not Found",
+			Header:     http.Header{"Content-Type": {"text/plain"}},
+			Body:       io.NopCloser(strings.NewReader("not here")),
+		}))
+	}
+	res := newRes()
+	if res.Get("ok").Bool() || res.Get("status").Int() != 404 {
+		t.Errorf("ok, status = %v, %v; want false, 404", res.Get("ok"), res.Get("status"))
+	}
+	if got := res.Get("headers").Get("content-type").String(); got != "text/plain" {
+		t.Errorf("content-type = %q; want text/plain", got)
+	}
+	if got := mustAwait(t, res.Call("text")).String(); got != "not here" {
+		t.Errorf("text = %q; want %q", got, "not here")
+	}
+
+	buf := mustAwait(t, newRes().Call("arrayBuffer"))
+	if got := jsString(t, buf); got != "not here" {
+		t.Errorf("arrayBuffer = %q; want %q", got, "not here")
+	}
+
+	if !streamsSupported() {
+		return
+	}
+	got, err := io.ReadAll(newJSStreamReader(newRes().Get("body")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "not here" {
+		t.Errorf("body = %q; want %q", got, "not here")
+	}
+}
+
+func TestListeners(t *testing.T) {
+	var ls tcpListeners
+	for _, addr := range []string{"8080", "1.2.3.4:80", ":http", ":0"} {
+		if _, err := ls.listen("tcp", addr); err == nil {
+			t.Errorf("listen(%q) succeeded; want error", addr)
+		}
+	}
+	if _, err := ls.listen("udp", ":80"); err == nil {
+		t.Error("listen on udp succeeded; want error")
+	}
+	ln, err := ls.listen("tcp", ":80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ls.listen("tcp", ":80"); err == nil {
+		t.Error("second listen on :80 succeeded; want error")
+	}
+	jsLn := js.ValueOf(ln.jsValue())
+
+	// Connections to other ports are closed.
+	a, b := net.Pipe()
+	ls.forwardTCP(a, 81)
+	if _, err := b.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read from unrouted conn = %v; want EOF", err)
+	}
+
+	a, b = net.Pipe()
+	defer b.Close()
+	go ls.forwardTCP(a, 80)
+	conn := mustAwait(t, jsLn.Call("accept"))
+	go io.WriteString(b, "hi")
+	if got := jsString(t, mustAwait(t, conn.Call("read"))); got != "hi" {
+		t.Errorf("read = %q; want hi", got)
+	}
+
+	jsLn.Call("close")
+	if _, err := await(jsLn.Call("accept")); err == nil {
+		t.Error("accept after close succeeded; want error")
+	}
+	if _, err := ls.listen("tcp", ":80"); err != nil {
+		t.Errorf("listen after close: %v", err)
+	}
+}
@@ -0,0 +1,339 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"syscall/js"
+
+	"tailscale.com/ipn"
+)
+
+const (
+	idbStateStore = "state" // object store of ipn.StateKey to state
+	idbMetaStore  = "meta"  // object store of the keys below
+
+	idbSaltKey  = "salt"  // PBKDF2 salt, if encrypted
+	idbCheckKey = "check" // checkPlaintext sealed with the key, if encrypted
+
+	// pbkdf2Iterations is the number of PBKDF2-HMAC-SHA256 iterations
+	// used to derive the encryption key from a passphrase.
+	pbkdf2Iterations = 600000
+)
+
+// checkPlaintext is sealed and stored in encrypted stores, so that a
+// wrong passphrase is detected even if there is no state yet.
+var checkPlaintext = []byte("tailscale ipn state")
+
+var errWrongPassphrase = errors.New("wrong passphrase for IPN state")
+
+// idbStore is an ipn.StateStore that persists state in IndexedDB, so
+// that a browser node keeps its identity across page reloads. The state
+// is optionally encrypted with AES-GCM, using a key derived from a user
+// passphrase.
+//
+// IndexedDB is asynchronous and ipn.StateStore isn't, so all state is
+// read into memory when the store is opened. Writes update the memory
+// copy and are persisted in the background; IndexedDB runs them in the
+// order that they were made.
+type idbStore struct {
+	db   js.Value    // IDBDatabase
+	aead cipher.AEAD // nil if unencrypted
+
+	mu    sync.Mutex
+	cache map[ipn.StateKey][]byte
+}
+
+// openIDBStore opens (creating if needed) the IndexedDB database name
+// and reads all state in it. If passphrase is non-empty the state is
+// encrypted; opening a store with a different passphrase than it was
+// created with, or without one, fails.
+//
+// It must not be called from the goroutine running JS callbacks.
+func openIDBStore(name, passphrase string) (*idbStore, error) {
+	idb := js.Global().Get("indexedDB")
+	if idb.IsUndefined() {
+		return nil, errors.New("IndexedDB is not supported")
+	}
+	req := idb.Call("open", name, 1)
+	onUpgrade := js.FuncOf(func(this js.Value, args []js.Value) any {
+		db := req.Get("result")
+		db.Call("createObjectStore", idbStateStore)
+		db.Call("createObjectStore", idbMetaStore)
+		return nil
+	})
+	defer onUpgrade.Release()
+	req.Set("onupgradeneeded", onUpgrade)
+	db, err := awaitIDBRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("opening IndexedDB %q: %w", name, err)
+	}
+	s := &idbStore{
+		db:    db,
+		cache: map[ipn.StateKey][]byte{},
+	}
+	if err := s.initEncryption(passphrase); err != nil {
+		db.Call("close")
+		return nil, err
+	}
+	if err := s.load(); err != nil {
+		db.Call("close")
+		return nil, err
+	}
+	return s, nil
+}
+
+// initEncryption sets up s.aead from passphrase, checking it against
+// the stored check value.
+func (s *idbStore) initEncryption(passphrase string) error {
+	salt, err := s.get(idbMetaStore, idbSaltKey)
+	if err != nil {
+		return err
+	}
+	check, err := s.get(idbMetaStore, idbCheckKey)
+	if err != nil {
+		return err
+	}
+	if passphrase == "" {
+		if check != nil {
+			return errors.New("IPN state is encrypted; a passphrase is required")
+		}
+		return nil
+	}
+	if salt == nil {
+		if n, err := s.count(idbStateStore); err != nil {
+			return err
+		} else if n > 0 {
+			return errors.New("IPN state is not encrypted; cannot open it with a passphrase")
+		}
+		salt = make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return err
+		}
+		s.put(idbMetaStore, idbSaltKey, salt)
+	}
+	key, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	if s.aead, err = newAEAD(key); err != nil {
+		return err
+	}
+	if check == nil {
+		s.put(idbMetaStore, idbCheckKey, seal(s.aead, checkPlaintext, additionalData(idbMetaStore, idbCheckKey)))
+		return nil
+	}
+	if _, err := unseal(s.aead, check, additionalData(idbMetaStore, idbCheckKey)); err != nil {
+		return errWrongPassphrase
+	}
+	return nil
+}
+
+// load reads all state into s.cache.
+func (s *idbStore) load() error {
+	store := s.db.Call("transaction", idbStateStore, "readonly").Call("objectStore", idbStateStore)
+	keys, err := awaitIDBRequest(store.Call("getAllKeys"))
+	if err != nil {
+		return err
+	}
+	vals, err := awaitIDBRequest(store.Call("getAll"))
+	if err != nil {
+		return err
+	}
+	if keys.Length() != vals.Length() {
+		return errors.New("IndexedDB state changed while loading")
+	}
+	for i := 0; i < keys.Length(); i++ {
+		b, err := jsBytes(vals.Index(i))
+		if err != nil {
+			return err
+		}
+		key := keys.Index(i).String()
+		if s.aead != nil {
+			if b, err = unseal(s.aead, b, additionalData(idbStateStore, key)); err != nil {
+				return fmt.Errorf("decrypting state %q: %w", key, err)
+			}
+		}
+		s.cache[ipn.StateKey(key)] = b
+	}
+	return nil
+}
+
+// get returns the value of key in the named object store, or nil if
+// there is none.
+func (s *idbStore) get(storeName, key string) ([]byte, error) {
+	v, err := awaitIDBRequest(s.db.Call("transaction", storeName, "readonly").Call("objectStore", storeName).Call("get", key))
+	if err != nil || v.IsUndefined() {
+		return nil, err
+	}
+	return jsBytes(v)
+}
+
+func (s *idbStore) count(storeName string) (int, error) {
+	v, err := awaitIDBRequest(s.db.Call("transaction", storeName, "readonly").Call("objectStore", storeName).Call("count"))
+	if err != nil {
+		return 0, err
+	}
+	return v.Int(), nil
+}
+
+// put stores b as the value of key in the named object store, in the
+// background.
+func (s *idbStore) put(storeName, key string, b []byte) {
+	a := js.Global().Get("Uint8Array").New(len(b))
+	js.CopyBytesToJS(a, b)
+	tx := s.db.Call("transaction", storeName, "readwrite")
+	var done js.Func
+	done = js.FuncOf(func(this js.Value, args []js.Value) any {
+		if err := tx.Get("error"); err.Truthy() {
+			log.Printf("IndexedDB: writing %q: %s", key, js.Global().Get("String").Invoke(err).String())
+		}
+		tx.Set("oncomplete", js.Null())
+		tx.Set("onabort", js.Null())
+		done.Release()
+		return nil
+	})
+	tx.Set("oncomplete", done)
+	tx.Set("onabort", done)
+	tx.Call("objectStore", storeName).Call("put", a, key)
+}
+
+func (s *idbStore) ReadState(id ipn.StateKey) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.cache[id]
+	if !ok {
+		return nil, ipn.ErrStateNotExist
+	}
+	return append([]byte(nil), b...), nil
+}
+
+func (s *idbStore) WriteState(id ipn.StateKey, bs []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache[id] = append([]byte(nil), bs...)
+	if s.aead != nil {
+		bs = seal(s.aead, bs, additionalData(idbStateStore, string(id)))
+	}
+	s.put(idbStateStore, string(id), bs)
+	return nil
+}
+
+// jsValue returns the JS object for s, which implements the
+// IPNStateStorage interface that newIPN's stateStorage option takes.
+func (s *idbStore) jsValue() map[string]any {
+	return map[string]any{
+		"getState": js.FuncOf(func(this js.Value, args []js.Value) any {
+			b, err := s.ReadState(ipn.StateKey(args[0].String()))
+			if err != nil {
+				return ""
+			}
+			return hex.EncodeToString(b)
+		}),
+		"setState": js.FuncOf(func(this js.Value, args []js.Value) any {
+			b, err := hex.DecodeString(args[1].String())
+			if err != nil {
+				log.Printf("setState(%q): %v", args[0].String(), err)
+				return nil
+			}
+			s.WriteState(ipn.StateKey(args[0].String()), b)
+			return nil
+		}),
+	}
+}
+
+// deriveKey derives a 256-bit key from passphrase and salt with PBKDF2,
+// using the WebCrypto API. It must not be called from the goroutine
+// running JS callbacks.
+func deriveKey(passphrase string, salt []byte) ([]byte, error) {
+	subtle := js.Global().Get("crypto").Get("subtle")
+	if subtle.IsUndefined() {
+		return nil, errors.New("WebCrypto is not supported")
+	}
+	pass := js.Global().Get("TextEncoder").New().Call("encode", passphrase)
+	base, err := await(subtle.Call("importKey", "raw", pass, "PBKDF2", false, []any{"deriveBits"}))
+	if err != nil {
+		return nil, err
+	}
+	jsSalt := js.Global().Get("Uint8Array").New(len(salt))
+	js.CopyBytesToJS(jsSalt, salt)
+	bits, err := await(subtle.Call("deriveBits", map[string]any{
+		"name":       "PBKDF2",
+		"hash":       "SHA-256",
+		"salt":       jsSalt,
+		"iterations": pbkdf2Iterations,
+	}, base, 256))
+	if err != nil {
+		return nil, err
+	}
+	return jsBytes(bits)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// additionalData returns the AEAD additional data for the value of key
+// in the named object store. It binds sealed values to where they're
+// stored, so that they can't be swapped between keys undetected.
+func additionalData(storeName, key string) []byte {
+	return []byte(storeName + "/" + key)
+}
+
+// seal encrypts b with a random nonce, which it prepends to the result,
+// and authenticates it along with ad.
+func seal(aead cipher.AEAD, b, ad []byte) []byte {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(b)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	return aead.Seal(nonce, nonce, b, ad)
+}
+
+// unseal decrypts b, as sealed by seal with the same ad.
+func unseal(aead cipher.AEAD, b, ad []byte) ([]byte, error) {
+	if len(b) < aead.NonceSize() {
+		return nil, errors.New("sealed data too short")
+	}
+	return aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], ad)
+}
+
+// awaitIDBRequest waits for the IDBRequest req to complete and returns
+// its result. It must not be called from the goroutine running JS
+// callbacks.
+func awaitIDBRequest(req js.Value) (js.Value, error) {
+	type result struct {
+		v   js.Value
+		err error
+	}
+	ch := make(chan result, 1)
+	onSuccess := js.FuncOf(func(this js.Value, args []js.Value) any {
+		ch <- result{v: req.Get("result")}
+		return nil
+	})
+	defer onSuccess.Release()
+	onError := js.FuncOf(func(this js.Value, args []js.Value) any {
+		ch <- result{err: fmt.Errorf("%s", js.Global().Get("String").Invoke(req.Get("error")).String())}
+		return nil
+	})
+	defer onError.Release()
+	req.Set("onsuccess", onSuccess)
+	req.Set("onerror", onError)
+	r := <-ch
+	req.Set("onsuccess", js.Null())
+	req.Set("onerror", js.Null())
+	return r.v, r.err
+}
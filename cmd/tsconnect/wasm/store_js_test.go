@@ -0,0 +1,67 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"syscall/js"
+	"testing"
+)
+
+func TestSealing(t *testing.T) {
+	if js.Global().Get("crypto").Get("subtle").IsUndefined() {
+		t.Skip("no WebCrypto")
+	}
+	salt := []byte("0123456789abcdef")
+	key, err := deriveKey("correct horse", salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != 32 {
+		t.Fatalf("key is %d bytes; want 32", len(key))
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte(`{"Config":{}}`)
+	ad := additionalData(idbStateStore, "_machinekey")
+	sealed := seal(aead, want, ad)
+	if bytes.Contains(sealed, want) {
+		t.Fatal("sealed data contains plaintext")
+	}
+	got, err := unseal(aead, sealed, ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("unseal = %q; want %q", got, want)
+	}
+
+	key2, err := deriveKey("correct horse", salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, key2) {
+		t.Error("deriveKey is not deterministic")
+	}
+	wrong, err := deriveKey("battery staple", salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead2, err := newAEAD(wrong)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := unseal(aead2, sealed, ad); err == nil {
+		t.Error("unseal with wrong key succeeded")
+	}
+	if _, err := unseal(aead, sealed, additionalData(idbStateStore, "profile-1234")); err == nil {
+		t.Error("unseal of data sealed for another state key succeeded")
+	}
+	if _, err := unseal(aead, sealed[:4], ad); err == nil {
+		t.Error("unseal of truncated data succeeded")
+	}
+}
@@ -7,7 +7,14 @@
 //
 // When run in the browser, a newIPN(config) function is added to the global JS
 // namespace. When called it returns an ipn object with the methods
-// run(callbacks), login(), logout(), and ssh(...).
+// run(callbacks), login(), logout(), ssh(...), fetch(...), dial(...) and
+// listen(...). An openIndexedDBStateStorage(options) function is added too,
+// which returns a promise of persistent state storage that can be passed to
+// newIPN.
+//
+// Its tests run under Node, with:
+//
+//	GOOS=js GOARCH=wasm go test -exec="$(go env GOROOT)/misc/wasm/go_js_wasm_exec" ./cmd/tsconnect/wasm
 package main
 
 import (
@@ -45,6 +52,9 @@
 // ControlURL defines the URL to be used for connection to Control.
 var ControlURL = ipn.DefaultControlURL
 
+// wasmStateKey is the state key of the node's prefs.
+const wasmStateKey ipn.StateKey = "wasm"
+
 func main() {
 	js.Global().Set("newIPN", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
 		if len(args) != 1 {
@@ -53,6 +63,24 @@ func main() {
 		}
 		return newIPN(args[0])
 	}))
+	js.Global().Set("openIndexedDBStateStorage", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		name, passphrase := "tailscale-ipn", ""
+		if len(args) == 1 && args[0].Type() == js.TypeObject {
+			if v := args[0].Get("name"); v.Type() == js.TypeString {
+				name = v.String()
+			}
+			if v := args[0].Get("passphrase"); v.Type() == js.TypeString {
+				passphrase = v.String()
+			}
+		}
+		return makePromise(func() (any, error) {
+			s, err := openIDBStore(name, passphrase)
+			if err != nil {
+				return nil, err
+			}
+			return s.jsValue(), nil
+		})
+	}))
 	// Keep Go runtime alive, otherwise it will be shut down before newIPN gets
 	// called.
 	<-make(chan bool)
@@ -69,6 +97,13 @@ func newIPN(jsConfig js.Value) map[string]any {
 		store = &jsStateStore{jsStateStorage}
 	}
 
+	// Nodes are ephemeral unless asked otherwise, since by default
+	// their state doesn't outlive the page.
+	loginFlags := controlclient.LoginEphemeral
+	if v := jsConfig.Get("ephemeral"); v.Type() == js.TypeBoolean && !v.Bool() {
+		loginFlags = controlclient.LoginDefault
+	}
+
 	jsAuthKey := jsConfig.Get("authKey")
 	var authKey string
 	if jsAuthKey.Type() == js.TypeString {
@@ -104,6 +139,8 @@ func newIPN(jsConfig js.Value) map[string]any {
 	}
 	ns.ProcessLocalIPs = true
 	ns.ProcessSubnets = true
+	listeners := new(tcpListeners)
+	ns.ForwardTCPIn = listeners.forwardTCP
 	if err := ns.Start(); err != nil {
 		log.Fatalf("failed to start netstack: %v", err)
 	}
@@ -116,7 +153,7 @@ func newIPN(jsConfig js.Value) map[string]any {
 
 	srv, err := ipnserver.New(logf, lpc.PublicID.String(), store, eng, dialer, nil, ipnserver.Options{
 		SurviveDisconnects: true,
-		LoginFlags:         controlclient.LoginEphemeral,
+		LoginFlags:         loginFlags,
 	})
 	if err != nil {
 		log.Fatalf("ipnserver.New: %v", err)
@@ -125,9 +162,11 @@ func newIPN(jsConfig js.Value) map[string]any {
 	ns.SetLocalBackend(lb)
 
 	jsIPN := &jsIPN{
-		dialer: dialer,
-		srv:    srv,
-		lb:     lb,
+		dialer:    dialer,
+		srv:       srv,
+		lb:        lb,
+		store:     store,
+		listeners: listeners,
 	}
 
 	return map[string]any{
@@ -171,21 +210,41 @@ func newIPN(jsConfig js.Value) map[string]any {
 				args[2])
 		}),
 		"fetch": js.FuncOf(func(this js.Value, args []js.Value) interface{} {
-			if len(args) != 1 {
-				log.Printf("Usage: fetch(url)")
+			if len(args) != 1 && len(args) != 2 {
+				log.Printf("Usage: fetch(url, init?)")
 				return nil
 			}
 
 			url := args[0].String()
-			return jsIPN.fetch(url)
+			init := js.Undefined()
+			if len(args) == 2 {
+				init = args[1]
+			}
+			return jsIPN.fetch(url, init)
+		}),
+		"dial": js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			if len(args) != 2 {
+				log.Printf("Usage: dial(network, addr)")
+				return nil
+			}
+			return jsIPN.dial(args[0].String(), args[1].String())
+		}),
+		"listen": js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			if len(args) != 2 {
+				log.Printf("Usage: listen(network, addr)")
+				return nil
+			}
+			return jsIPN.listen(args[0].String(), args[1].String())
 		}),
 	}
 }
 
 type jsIPN struct {
-	dialer *tsdial.Dialer
-	srv    *ipnserver.Server
-	lb     *ipnlocal.LocalBackend
+	dialer    *tsdial.Dialer
+	srv       *ipnserver.Server
+	lb        *ipnlocal.LocalBackend
+	store     ipn.StateStore
+	listeners *tcpListeners
 }
 
 func (i *jsIPN) run(jsCallbacks js.Value, authKey string) {
@@ -251,13 +310,13 @@ func (i *jsIPN) run(jsCallbacks js.Value, authKey string) {
 
 	go func() {
 		err := i.lb.Start(ipn.Options{
-			StateKey: "wasm",
+			StateKey: wasmStateKey,
 			UpdatePrefs: &ipn.Prefs{
 				ControlURL:       ControlURL,
 				RouteAll:         false,
 				AllowSingleHosts: true,
 				WantRunning:      true,
-				Hostname:         generateHostname(),
+				Hostname:         i.hostname(),
 			},
 			AuthKey: authKey,
 		})
@@ -277,6 +336,17 @@ func (i *jsIPN) run(jsCallbacks js.Value, authKey string) {
 	}()
 }
 
+// hostname returns the hostname of the node's saved prefs, so that a
+// node that persists its state keeps its name, or a new random one.
+func (i *jsIPN) hostname() string {
+	if bs, err := i.store.ReadState(wasmStateKey); err == nil {
+		if p, err := ipn.PrefsFromBytes(bs); err == nil && p.Hostname != "" {
+			return p.Hostname
+		}
+	}
+	return generateHostname()
+}
+
 func (i *jsIPN) login() {
 	go i.lb.StartLoginInteractive()
 }
@@ -413,33 +483,52 @@ func (s *jsSSHSession) Resize(rows, cols int) error {
 	return s.session.WindowChange(rows, cols)
 }
 
-func (i *jsIPN) fetch(url string) js.Value {
+// fetch makes an HTTP request over the tailnet. init optionally has the
+// method, headers (an object of header names to values) and body (a
+// string, Uint8Array or ReadableStream) of the request, like the init
+// argument of the JS fetch function.
+func (i *jsIPN) fetch(url string, init js.Value) js.Value {
+	req, err := newFetchRequest(url, init)
 	return makePromise(func() (any, error) {
+		if err != nil {
+			return nil, err
+		}
 		c := &http.Client{
 			Transport: &http.Transport{
 				DialContext: i.dialer.UserDial,
 			},
 		}
-		res, err := c.Get(url)
+		res, err := c.Do(req)
 		if err != nil {
 			return nil, err
 		}
+		return newFetchResponse(res), nil
+	})
+}
 
-		return map[string]any{
-			"status":     res.StatusCode,
-			"statusText": res.Status,
-			"text": js.FuncOf(func(this js.Value, args []js.Value) interface{} {
-				return makePromise(func() (any, error) {
-					defer res.Body.Close()
-					buf := new(bytes.Buffer)
-					if _, err := buf.ReadFrom(res.Body); err != nil {
-						return nil, err
-					}
-					return buf.String(), nil
-				})
-			}),
-			// TODO: populate a more complete JS Response object
-		}, nil
+// dial opens a connection over the tailnet and returns a promise of
+// its JS object (see newJSConn).
+func (i *jsIPN) dial(network, addr string) js.Value {
+	return makePromise(func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		c, err := i.dialer.UserDial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return newJSConn(c), nil
+	})
+}
+
+// listen starts listening for inbound tailnet TCP connections on addr,
+// of the form ":port", and returns a promise of the JS listener object.
+func (i *jsIPN) listen(network, addr string) js.Value {
+	return makePromise(func() (any, error) {
+		ln, err := i.listeners.listen(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return ln.jsValue(), nil
 	})
 }
 
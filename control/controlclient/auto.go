@@ -727,3 +727,9 @@ func (c *Auto) SetDNS(ctx context.Context, req *tailcfg.SetDNSRequest) error {
 func (c *Auto) DoNoiseRequest(req *http.Request) (*http.Response, error) {
 	return c.direct.DoNoiseRequest(req)
 }
+
+// CheckReachable reports whether the control plane server can be
+// reached. See Direct.CheckReachable.
+func (c *Auto) CheckReachable(ctx context.Context) error {
+	return c.direct.CheckReachable(ctx)
+}
@@ -878,6 +878,9 @@ func (c *Direct) sendMapRequest(ctx context.Context, maxPolls int, readOnly bool
 		}
 
 		metricMapResponseMessages.Add(1)
+		if i == 0 {
+			metricMapResponseLatency.Observe(time.Since(t0).Milliseconds())
+		}
 
 		if allowStream {
 			health.GotStreamedMapResponse()
@@ -1093,6 +1096,14 @@ func encode(v any, serverKey, serverNoiseKey key.MachinePublic, mkey key.Machine
 	return mkey.SealTo(serverKey, b), nil
 }
 
+// CheckReachable reports whether the control plane server can be
+// reached, by fetching its public keys over HTTPS. It doesn't check
+// that this node's long poll is working; see health for that.
+func (c *Direct) CheckReachable(ctx context.Context) error {
+	_, err := loadServerPubKeys(ctx, c.httpc, c.serverURL)
+	return err
+}
+
 func loadServerPubKeys(ctx context.Context, httpc *http.Client, serverURL string) (*tailcfg.OverTLSPublicKeyResponse, error) {
 	keyURL := fmt.Sprintf("%v/key?v=%d", serverURL, tailcfg.CurrentCapabilityVersion)
 	req, err := http.NewRequestWithContext(ctx, "GET", keyURL, nil)
@@ -1490,6 +1501,10 @@ func postPingResult(start time.Time, logf logger.Logf, c *http.Client, pr *tailc
 	metricMapResponseMap        = clientmetric.NewCounter("controlclient_map_response_map")       // any non-keepalive map response
 	metricMapResponseMapDelta   = clientmetric.NewCounter("controlclient_map_response_map_delta") // 2nd+ non-keepalive map response
 
+	// metricMapResponseLatency is the time from starting a map request to
+	// decoding its first response.
+	metricMapResponseLatency = clientmetric.NewHistogram("controlclient_map_response_latency_ms", []int64{50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000})
+
 	metricSetDNS      = clientmetric.NewCounter("controlclient_setdns")
 	metricSetDNSError = clientmetric.NewCounter("controlclient_setdns_error")
 )
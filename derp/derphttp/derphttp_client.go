@@ -461,12 +461,13 @@ func (c *Client) connect(ctx context.Context, caller string) (client *derp.Clien
 	return c.client, c.connGen, nil
 }
 
-// SetURLDialer sets the dialer to use for dialing URLs.
-// This dialer is only use for clients created with NewClient, not NewRegionClient.
+// SetURLDialer sets the dialer to use for dialing URLs, or for clients
+// created with NewRegionClient, the region's nodes.
 // If unset or nil, the default dialer is used.
 //
 // The primary use for this is the derper mesh mode to connect to each
-// other over a VPC network.
+// other over a VPC network. Tests also use it to dial DERP servers on
+// simulated networks.
 func (c *Client) SetURLDialer(dialer func(ctx context.Context, network, addr string) (net.Conn, error)) {
 	c.dialer = dialer
 }
@@ -572,6 +573,9 @@ func (c *Client) DialRegionTLS(ctx context.Context, reg *tailcfg.DERPRegion) (tl
 }
 
 func (c *Client) dialContext(ctx context.Context, proto, addr string) (net.Conn, error) {
+	if c.dialer != nil {
+		return c.dialer(ctx, proto, addr)
+	}
 	return netns.NewDialer(c.logf).DialContext(ctx, proto, addr)
 }
 
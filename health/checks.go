@@ -0,0 +1,97 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+)
+
+// ErrCheckSkipped is returned (possibly wrapped, to say why) by a
+// Check's Run func when the check doesn't currently apply.
+var ErrCheckSkipped = errors.New("skipped")
+
+// A Check is an active self-check, such as resolving a name or pinging
+// a server, as opposed to the state that other packages passively
+// report to this one.
+type Check struct {
+	// Name is a short identifier of the check, such as "dns".
+	Name string
+
+	// Warnable is set to Run's error when the check fails, and
+	// cleared when it passes or is skipped.
+	Warnable *Warnable
+
+	// Run performs the check. It returns nil if the check passed, or
+	// an error wrapping ErrCheckSkipped if it doesn't apply.
+	Run func(context.Context) error
+}
+
+// CheckResult is the result of running a Check.
+type CheckResult struct {
+	Name         string
+	WarnableCode WarnableCode
+	OK           bool
+	Skipped      bool `json:",omitempty"`
+	// Error is why the check failed or was skipped.
+	Error   string `json:",omitempty"`
+	Latency time.Duration
+}
+
+// AsStatus returns r as the ipnstate type that tailscaled reports it as
+// in its LocalAPI.
+func (r CheckResult) AsStatus() ipnstate.HealthCheckResult {
+	return ipnstate.HealthCheckResult{
+		Name:         r.Name,
+		WarnableCode: string(r.WarnableCode),
+		OK:           r.OK,
+		Skipped:      r.Skipped,
+		Error:        r.Error,
+		Latency:      r.Latency,
+	}
+}
+
+// RunChecks runs checks concurrently, each limited to timeout, sets
+// their Warnables according to their results, and returns the results
+// in the same order as checks.
+func RunChecks(ctx context.Context, checks []Check, timeout time.Duration) []CheckResult {
+	res := make([]CheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		i, c := i, c
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			t0 := time.Now()
+			err := c.Run(ctx)
+			r := CheckResult{
+				Name:         c.Name,
+				WarnableCode: c.Warnable.Code,
+				OK:           err == nil,
+				Latency:      time.Since(t0),
+			}
+			switch {
+			case errors.Is(err, ErrCheckSkipped):
+				r.Skipped = true
+				r.Error = err.Error()
+				c.Warnable.Set(nil)
+			case err != nil:
+				r.Error = err.Error()
+				c.Warnable.Set(err)
+			default:
+				c.Warnable.Set(nil)
+			}
+			res[i] = r
+		}()
+	}
+	wg.Wait()
+	return res
+}
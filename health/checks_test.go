@@ -0,0 +1,58 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRunChecks(t *testing.T) {
+	newWarnable := func(code string) *Warnable {
+		return Register(&Warnable{Code: WarnableCode(code), Subsystem: "test", Severity: SeverityLow})
+	}
+	okW, failW, skipW, slowW := newWarnable("check-ok"), newWarnable("check-fail"), newWarnable("check-skip"), newWarnable("check-slow")
+	skipW.Set(errors.New("stale failure"))
+
+	res := RunChecks(context.Background(), []Check{
+		{Name: "ok", Warnable: okW, Run: func(context.Context) error { return nil }},
+		{Name: "fail", Warnable: failW, Run: func(context.Context) error { return errors.New("broken") }},
+		{Name: "skip", Warnable: skipW, Run: func(context.Context) error { return fmt.Errorf("%w: n/a", ErrCheckSkipped) }},
+		{Name: "slow", Warnable: slowW, Run: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}},
+	}, 50*time.Millisecond)
+	defer failW.Set(nil)
+	defer slowW.Set(nil)
+
+	if len(res) != 4 {
+		t.Fatalf("got %d results; want 4", len(res))
+	}
+	if r := res[0]; r.Name != "ok" || !r.OK || r.Skipped || r.WarnableCode != "check-ok" {
+		t.Errorf("ok result = %+v", r)
+	}
+	if r := res[1]; r.OK || r.Error != "broken" {
+		t.Errorf("fail result = %+v", r)
+	}
+	if r := res[2]; r.OK || !r.Skipped || r.Error != "skipped: n/a" {
+		t.Errorf("skip result = %+v", r)
+	}
+	if r := res[3]; r.OK || r.Error != context.DeadlineExceeded.Error() {
+		t.Errorf("slow result = %+v", r)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if warnableErr[okW] != nil || warnableErr[skipW] != nil {
+		t.Errorf("passing or skipped checks left warnings set")
+	}
+	if warnableErr[failW] == nil || warnableErr[slowW] == nil {
+		t.Errorf("failing checks didn't set warnings")
+	}
+}
@@ -25,9 +25,11 @@
 	// mu guards everything in this var block.
 	mu sync.Mutex
 
-	sysErr   = map[Subsystem]error{}                     // error key => err (or nil for no error)
-	watchers = map[*watchHandle]func(Subsystem, error){} // opt func to run if error state changes
-	timer    *time.Timer
+	sysErr      = map[Subsystem]error{}                     // error key => err (or nil for no error)
+	warnableErr = map[*Warnable]error{}                     // Warnable => its problem, for those set with Warnable.Set
+	brokenSince = map[string]time.Time{}                    // warning.id() => when first seen
+	watchers    = map[*watchHandle]func(Subsystem, error){} // opt func to run if error state changes
+	timer       *time.Timer
 
 	debugHandler = map[string]http.Handler{}
 
@@ -72,6 +74,19 @@
 	// the Windows network adapter's "category" (public, private, domain).
 	// If it's unhealthy, the Windows firewall rules won't match.
 	SysNetworkCategory = Subsystem("network-category")
+
+	// SysNetwork is the name of the host's network connectivity.
+	SysNetwork = Subsystem("network")
+
+	// SysControl is the name of the connection to the coordination
+	// server.
+	SysControl = Subsystem("control")
+
+	// SysDERP is the name of the connections to DERP relay servers.
+	SysDERP = Subsystem("derp")
+
+	// SysMagicsock is the name of the wgengine/magicsock subsystem.
+	SysMagicsock = Subsystem("magicsock")
 )
 
 type watchHandle byte
@@ -312,7 +327,9 @@ func selfCheckLocked() {
 		// Don't check yet.
 		return
 	}
-	setLocked(SysOverall, overallErrorLocked())
+	ws := unhealthyLocked()
+	updateBrokenSinceLocked(ws)
+	setLocked(SysOverall, overallErrorOf(ws))
 }
 
 // OverallError returns a summary of the health state.
@@ -322,41 +339,59 @@ func selfCheckLocked() {
 func OverallError() error {
 	mu.Lock()
 	defer mu.Unlock()
-	return overallErrorLocked()
+	return overallErrorOf(unhealthyLocked())
+}
+
+func overallErrorOf(ws []warning) error {
+	errs := make([]error, len(ws))
+	for i, wn := range ws {
+		errs[i] = wn.overallErr()
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		// Not super efficient (stringifying these in a sort), but probably max 2 or 3 items.
+		return errs[i].Error() < errs[j].Error()
+	})
+	return multierr.New(errs...)
 }
 
 var fakeErrForTesting = envknob.String("TS_DEBUG_FAKE_HEALTH_ERROR")
 
-func overallErrorLocked() error {
+// unhealthyLocked returns the current health problems. Some problems
+// (such as the network being down) make others moot, in which case
+// only that problem is returned.
+func unhealthyLocked() []warning {
+	one := func(w *Warnable, err error) []warning {
+		return []warning{{w: w, err: err}}
+	}
 	if !anyInterfaceUp {
-		return errors.New("network down")
+		return one(networkDownWarnable, errors.New("network down"))
 	}
 	if !ipnWantRunning {
-		return fmt.Errorf("state=%v, wantRunning=%v", ipnState, ipnWantRunning)
+		return one(notRunningWarnable, fmt.Errorf("state=%v, wantRunning=%v", ipnState, ipnWantRunning))
 	}
 	if lastLoginErr != nil {
-		return fmt.Errorf("not logged in, last login error=%v", lastLoginErr)
+		return one(loginErrorWarnable, fmt.Errorf("not logged in, last login error=%v", lastLoginErr))
 	}
 	now := time.Now()
 	if !inMapPoll && (lastMapPollEndedAt.IsZero() || now.Sub(lastMapPollEndedAt) > 10*time.Second) {
-		return errors.New("not in map poll")
+		return one(notInMapPollWarnable, errors.New("not in map poll"))
 	}
 	const tooIdle = 2*time.Minute + 5*time.Second
 	if d := now.Sub(lastStreamedMapResponse).Round(time.Second); d > tooIdle {
-		return fmt.Errorf("no map response in %v", d)
+		return one(mapResponseTimeoutWarnable, fmt.Errorf("no map response in %v", d))
 	}
 	rid := derpHomeRegion
 	if rid == 0 {
-		return errors.New("no DERP home")
+		return one(noDERPHomeWarnable, errors.New("no DERP home"))
 	}
 	if !derpRegionConnected[rid] {
-		return fmt.Errorf("not connected to home DERP region %v", rid)
+		return one(derpHomeDisconnectedWarnable, fmt.Errorf("not connected to home DERP region %v", rid))
 	}
 	if d := now.Sub(derpRegionLastFrame[rid]).Round(time.Second); d > tooIdle {
-		return fmt.Errorf("haven't heard from home DERP region %v in %v", rid, d)
+		return one(derpHomeTimeoutWarnable, fmt.Errorf("haven't heard from home DERP region %v in %v", rid, d))
 	}
 	if udp4Unbound {
-		return errors.New("no udp4 bind")
+		return one(udp4UnboundWarnable, errors.New("no udp4 bind"))
 	}
 
 	// TODO: use
@@ -365,32 +400,31 @@ func overallErrorLocked() error {
 	_ = lastStreamedMapResponse
 	_ = lastMapRequestHeard
 
-	var errs []error
+	var ws []warning
 	for _, recv := range receiveFuncs {
 		if recv.missing {
-			errs = append(errs, fmt.Errorf("%s is not running", recv.name))
+			ws = append(ws, warning{w: receiveFuncStoppedWarnable, key: recv.name, err: fmt.Errorf("%s is not running", recv.name)})
 		}
 	}
 	for sys, err := range sysErr {
 		if err == nil || sys == SysOverall {
 			continue
 		}
-		errs = append(errs, fmt.Errorf("%v: %w", sys, err))
+		ws = append(ws, warning{w: subsystemWarnable(sys), err: err, prefix: string(sys)})
+	}
+	for w, err := range warnableErr {
+		ws = append(ws, warning{w: w, err: err, prefix: string(w.Subsystem)})
 	}
 	for regionID, problem := range derpRegionHealthProblem {
-		errs = append(errs, fmt.Errorf("derp%d: %v", regionID, problem))
+		ws = append(ws, warning{w: derpRegionProblemWarnable, key: fmt.Sprint(regionID), err: errors.New(problem), prefix: fmt.Sprintf("derp%d", regionID)})
 	}
 	for _, s := range controlHealth {
-		errs = append(errs, errors.New(s))
+		ws = append(ws, warning{w: controlHealthWarnable, key: s, err: errors.New(s)})
 	}
-	if e := fakeErrForTesting; len(errs) == 0 && e != "" {
-		return errors.New(e)
+	if e := fakeErrForTesting; len(ws) == 0 && e != "" {
+		return one(fakeErrorWarnable, errors.New(e))
 	}
-	sort.Slice(errs, func(i, j int) bool {
-		// Not super efficient (stringifying these in a sort), but probably max 2 or 3 items.
-		return errs[i].Error() < errs[j].Error()
-	})
-	return multierr.New(errs...)
+	return ws
 }
 
 var (
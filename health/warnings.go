@@ -0,0 +1,402 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package health
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+)
+
+// Severity is how much a health warning impairs Tailscale.
+type Severity string
+
+const (
+	// SeverityHigh means Tailscale can't connect, or its connectivity
+	// is badly impaired.
+	SeverityHigh = Severity("high")
+
+	// SeverityMedium means some Tailscale functionality is degraded
+	// or unavailable.
+	SeverityMedium = Severity("medium")
+
+	// SeverityLow means something is worth knowing about but isn't
+	// likely to affect connectivity.
+	SeverityLow = Severity("low")
+)
+
+// rank orders severities, most severe first.
+func (s Severity) rank() int {
+	switch s {
+	case SeverityHigh:
+		return 0
+	case SeverityMedium:
+		return 1
+	case SeverityLow:
+		return 2
+	}
+	return 3
+}
+
+// WarnableCode is a stable identifier of a kind of health warning, for
+// programs to match on. A code is never reused for a different
+// problem.
+type WarnableCode string
+
+// A Warnable is a kind of health problem that can be reported. The
+// Warnables that exist are registered with Register.
+type Warnable struct {
+	// Code uniquely identifies the Warnable.
+	Code WarnableCode
+
+	// Subsystem is the subsystem that the problem affects.
+	Subsystem Subsystem
+
+	// Severity is how much the problem impairs Tailscale.
+	Severity Severity
+
+	// Title is a short human-readable summary of the problem.
+	Title string
+
+	// Remediation, if non-empty, is a suggestion to the user of how
+	// to fix the problem.
+	Remediation string
+
+	// sysErr is whether the Warnable's state is sysErr[Subsystem],
+	// as set by SetRouterHealth etc, rather than set by Set.
+	sysErr bool
+}
+
+var (
+	// registry holds all registered Warnables. It's guarded by mu.
+	registry = map[WarnableCode]*Warnable{}
+
+	// sysWarnables maps the subsystems whose state is set with
+	// SetRouterHealth etc to their Warnables.
+	sysWarnables = map[Subsystem]*Warnable{}
+)
+
+// Register adds w to the registry of known Warnables and returns it,
+// for use in package-level variable declarations. It panics if w is
+// missing its Code or Severity, or if a Warnable with the same Code is
+// already registered.
+func Register(w *Warnable) *Warnable {
+	if w.Code == "" || w.Severity.rank() > SeverityLow.rank() {
+		panic(fmt.Sprintf("health: invalid Warnable %+v", w))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if _, dup := registry[w.Code]; dup {
+		panic(fmt.Sprintf("health: duplicate Warnable code %q", w.Code))
+	}
+	registry[w.Code] = w
+	if w.sysErr {
+		sysWarnables[w.Subsystem] = w
+	}
+	return w
+}
+
+// Warnables returns all registered Warnables, sorted by code.
+func Warnables() []*Warnable {
+	mu.Lock()
+	defer mu.Unlock()
+	ret := make([]*Warnable, 0, len(registry))
+	for _, w := range registry {
+		ret = append(ret, w)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Code < ret[j].Code })
+	return ret
+}
+
+// Set sets the problem that w is warning about, or clears it if err is
+// nil. Watchers registered with RegisterWatcher are called with w's
+// Subsystem when it changes between healthy and unhealthy.
+func (w *Warnable) Set(err error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if w.sysErr {
+		setLocked(w.Subsystem, err)
+		return
+	}
+	old := warnableErr[w]
+	if err == nil {
+		delete(warnableErr, w)
+	} else {
+		warnableErr[w] = err
+	}
+	if (old == nil) == (err == nil) {
+		return
+	}
+	selfCheckLocked()
+	for _, cb := range watchers {
+		go cb(w.Subsystem, err)
+	}
+}
+
+// Built-in Warnables.
+var (
+	networkDownWarnable = Register(&Warnable{
+		Code:        "network-down",
+		Subsystem:   SysNetwork,
+		Severity:    SeverityHigh,
+		Title:       "Network down",
+		Remediation: "Check that this device is connected to a network.",
+	})
+	notRunningWarnable = Register(&Warnable{
+		Code:        "not-running",
+		Subsystem:   SysOverall,
+		Severity:    SeverityLow,
+		Title:       "Tailscale is stopped",
+		Remediation: "Run 'tailscale up' to connect.",
+	})
+	loginErrorWarnable = Register(&Warnable{
+		Code:        "login-error",
+		Subsystem:   SysControl,
+		Severity:    SeverityHigh,
+		Title:       "Unable to log in",
+		Remediation: "Run 'tailscale up' to log in again.",
+	})
+	notInMapPollWarnable = Register(&Warnable{
+		Code:        "not-in-map-poll",
+		Subsystem:   SysControl,
+		Severity:    SeverityMedium,
+		Title:       "Not connected to the coordination server",
+		Remediation: "Check that this device can reach the coordination server. Existing connections keep working, but changes to the network aren't received.",
+	})
+	mapResponseTimeoutWarnable = Register(&Warnable{
+		Code:        "map-response-timeout",
+		Subsystem:   SysControl,
+		Severity:    SeverityMedium,
+		Title:       "Coordination server not responding",
+		Remediation: "Check that this device can reach the coordination server and that no firewall or proxy is closing long-lived connections.",
+	})
+	noDERPHomeWarnable = Register(&Warnable{
+		Code:        "no-derp-home",
+		Subsystem:   SysDERP,
+		Severity:    SeverityHigh,
+		Title:       "No home relay server",
+		Remediation: "Check that this device can reach Tailscale's DERP relay servers; 'tailscale netcheck' shows which are reachable.",
+	})
+	derpHomeDisconnectedWarnable = Register(&Warnable{
+		Code:        "derp-home-disconnected",
+		Subsystem:   SysDERP,
+		Severity:    SeverityHigh,
+		Title:       "Not connected to home relay server",
+		Remediation: "Check that this device can reach Tailscale's DERP relay servers; 'tailscale netcheck' shows which are reachable.",
+	})
+	derpHomeTimeoutWarnable = Register(&Warnable{
+		Code:        "derp-home-timeout",
+		Subsystem:   SysDERP,
+		Severity:    SeverityMedium,
+		Title:       "Home relay server not responding",
+		Remediation: "Check that no firewall or proxy is closing long-lived connections to Tailscale's DERP relay servers.",
+	})
+	udp4UnboundWarnable = Register(&Warnable{
+		Code:        "udp4-unbound",
+		Subsystem:   SysMagicsock,
+		Severity:    SeverityMedium,
+		Title:       "Unable to bind a UDP socket",
+		Remediation: "Direct connections to peers aren't possible; traffic is relayed. Check the host's firewall and socket permissions.",
+	})
+	receiveFuncStoppedWarnable = Register(&Warnable{
+		Code:      "receive-func-stopped",
+		Subsystem: SysMagicsock,
+		Severity:  SeverityHigh,
+		Title:     "Packet receiver not running",
+	})
+	derpRegionProblemWarnable = Register(&Warnable{
+		Code:      "derp-region-problem",
+		Subsystem: SysDERP,
+		Severity:  SeverityLow,
+		Title:     "Relay server reported a problem",
+	})
+	controlHealthWarnable = Register(&Warnable{
+		Code:      "control-health",
+		Subsystem: SysControl,
+		Severity:  SeverityMedium,
+		Title:     "Coordination server reported a problem",
+	})
+	fakeErrorWarnable = Register(&Warnable{
+		Code:      "debug-fake-error",
+		Subsystem: SysOverall,
+		Severity:  SeverityLow,
+		Title:     "Fake error for testing (TS_DEBUG_FAKE_HEALTH_ERROR)",
+	})
+
+	// Warnables whose state is set by SetRouterHealth etc.
+	_ = Register(&Warnable{
+		Code:        "router-error",
+		Subsystem:   SysRouter,
+		Severity:    SeverityHigh,
+		Title:       "Unable to configure routes",
+		Remediation: "Traffic to the tailnet may not be routed through Tailscale. Check tailscaled's logs for details.",
+		sysErr:      true,
+	})
+	_ = Register(&Warnable{
+		Code:        "dns-error",
+		Subsystem:   SysDNS,
+		Severity:    SeverityHigh,
+		Title:       "Unable to configure DNS",
+		Remediation: "MagicDNS names may not resolve. Check tailscaled's logs for details.",
+		sysErr:      true,
+	})
+	_ = Register(&Warnable{
+		Code:        "dns-os-error",
+		Subsystem:   SysDNSOS,
+		Severity:    SeverityMedium,
+		Title:       "Unable to apply DNS settings to the OS",
+		Remediation: "MagicDNS names may not resolve. Check how this OS's DNS is managed.",
+		sysErr:      true,
+	})
+	_ = Register(&Warnable{
+		Code:      "dns-manager-error",
+		Subsystem: SysDNSManager,
+		Severity:  SeverityMedium,
+		Title:     "OS DNS configuration is misconfigured",
+		sysErr:    true,
+	})
+	_ = Register(&Warnable{
+		Code:        "network-category-error",
+		Subsystem:   SysNetworkCategory,
+		Severity:    SeverityMedium,
+		Title:       "Unable to set the network category",
+		Remediation: "Windows Firewall rules for Tailscale may not apply.",
+		sysErr:      true,
+	})
+)
+
+// subsystemWarnable returns the Warnable for errors set on sys with
+// set, such as by SetRouterHealth.
+func subsystemWarnable(sys Subsystem) *Warnable {
+	if w, ok := sysWarnables[sys]; ok {
+		return w
+	}
+	// Not expected, but don't drop the error.
+	return &Warnable{
+		Code:      WarnableCode(sys) + "-error",
+		Subsystem: sys,
+		Severity:  SeverityMedium,
+		Title:     string(sys) + " error",
+	}
+}
+
+// UnhealthyState is a health problem that's currently occurring, as
+// returned by Warnings.
+type UnhealthyState struct {
+	WarnableCode WarnableCode
+	Subsystem    Subsystem `json:",omitempty"`
+	Severity     Severity
+	Title        string
+	// Text is the detail of this occurrence of the problem.
+	Text        string
+	Remediation string `json:",omitempty"`
+	// BrokenSince is when the problem was first noticed, if known.
+	BrokenSince *time.Time `json:",omitempty"`
+}
+
+// warning is one occurrence of a Warnable's problem.
+type warning struct {
+	w *Warnable
+	// key distinguishes the warnings of Warnables that can have more
+	// than one at once, such as one per DERP region.
+	key string
+	err error
+	// prefix, if non-empty, is prepended to err in OverallError.
+	prefix string
+}
+
+func (wn warning) id() string { return string(wn.w.Code) + "/" + wn.key }
+
+// overallErr returns the warning as one of the errors that make up
+// OverallError.
+func (wn warning) overallErr() error {
+	if wn.prefix != "" {
+		return fmt.Errorf("%s: %w", wn.prefix, wn.err)
+	}
+	return wn.err
+}
+
+// updateBrokenSinceLocked records when each of ws was first seen, and
+// forgets the warnings that are no longer occurring.
+func updateBrokenSinceLocked(ws []warning) {
+	now := time.Now()
+	seen := make(map[string]bool, len(ws))
+	for _, wn := range ws {
+		id := wn.id()
+		seen[id] = true
+		if _, ok := brokenSince[id]; !ok {
+			brokenSince[id] = now
+		}
+	}
+	for id := range brokenSince {
+		if !seen[id] {
+			delete(brokenSince, id)
+		}
+	}
+}
+
+// Warnings returns the health problems currently occurring, most
+// severe first.
+func Warnings() []UnhealthyState {
+	mu.Lock()
+	defer mu.Unlock()
+	ws := unhealthyLocked()
+	updateBrokenSinceLocked(ws)
+	ret := make([]UnhealthyState, 0, len(ws))
+	for _, wn := range ws {
+		us := wn.w.UnhealthyState(wn.err)
+		if t, ok := brokenSince[wn.id()]; ok {
+			us.BrokenSince = &t
+		}
+		ret = append(ret, us)
+	}
+	SortUnhealthyStates(ret)
+	return ret
+}
+
+// UnhealthyState returns the UnhealthyState of w occurring with
+// details err, for problems that are detected on demand rather than
+// tracked with Set.
+func (w *Warnable) UnhealthyState(err error) UnhealthyState {
+	return UnhealthyState{
+		WarnableCode: w.Code,
+		Subsystem:    w.Subsystem,
+		Severity:     w.Severity,
+		Title:        w.Title,
+		Text:         err.Error(),
+		Remediation:  w.Remediation,
+	}
+}
+
+// AsStatus returns us as the ipnstate type that tailscaled reports it
+// as in its status and LocalAPI.
+func (us UnhealthyState) AsStatus() ipnstate.UnhealthyState {
+	return ipnstate.UnhealthyState{
+		WarnableCode: string(us.WarnableCode),
+		Subsystem:    string(us.Subsystem),
+		Severity:     string(us.Severity),
+		Title:        us.Title,
+		Text:         us.Text,
+		Remediation:  us.Remediation,
+		BrokenSince:  us.BrokenSince,
+	}
+}
+
+// SortUnhealthyStates sorts s by severity, most severe first, then by
+// code and text.
+func SortUnhealthyStates(s []UnhealthyState) {
+	sort.Slice(s, func(i, j int) bool {
+		a, b := s[i], s[j]
+		if ra, rb := a.Severity.rank(), b.Severity.rank(); ra != rb {
+			return ra < rb
+		}
+		if a.WarnableCode != b.WarnableCode {
+			return a.WarnableCode < b.WarnableCode
+		}
+		return a.Text < b.Text
+	})
+}
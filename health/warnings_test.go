@@ -0,0 +1,80 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package health
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWarnings(t *testing.T) {
+	SetAnyInterfaceUp(true)
+	SetIPNState("Running", true)
+	SetInPollNetMap(true)
+	GotStreamedMapResponse()
+	SetMagicSockDERPHome(1)
+	SetDERPRegionConnectedState(1, true)
+	NoteDERPRegionReceivedFrame(1)
+	t.Cleanup(func() { SetIPNState("", false) })
+
+	if ws := Warnings(); len(ws) != 0 {
+		t.Fatalf("got warnings %+v; want none", ws)
+	}
+
+	testWarnable := Register(&Warnable{
+		Code:        "test-warnable",
+		Subsystem:   "test",
+		Severity:    SeverityLow,
+		Title:       "Test problem",
+		Remediation: "Fix the test.",
+	})
+	testWarnable.Set(errors.New("low problem"))
+	SetRouterHealth(errors.New("no routes"))
+	defer SetRouterHealth(nil)
+
+	ws := Warnings()
+	if len(ws) != 2 {
+		t.Fatalf("got %d warnings; want 2: %+v", len(ws), ws)
+	}
+	if ws[0].WarnableCode != "router-error" || ws[0].Severity != SeverityHigh || ws[0].Text != "no routes" || ws[0].Subsystem != SysRouter {
+		t.Errorf("ws[0] = %+v", ws[0])
+	}
+	if ws[1].WarnableCode != "test-warnable" || ws[1].Text != "low problem" || ws[1].Remediation != "Fix the test." {
+		t.Errorf("ws[1] = %+v", ws[1])
+	}
+	if ws[0].BrokenSince == nil {
+		t.Error("BrokenSince not set")
+	}
+	since := *ws[0].BrokenSince
+	if ws := Warnings(); !ws[0].BrokenSince.Equal(since) {
+		t.Errorf("BrokenSince changed from %v to %v", since, ws[0].BrokenSince)
+	}
+
+	if got, want := OverallError().Error(), "multiple errors:\n\trouter: no routes\n\ttest: low problem"; got != want {
+		t.Errorf("OverallError = %q; want %q", got, want)
+	}
+
+	testWarnable.Set(nil)
+	if ws := Warnings(); len(ws) != 1 {
+		t.Errorf("after clearing, got warnings %+v", ws)
+	}
+
+	// The network being down makes every other problem moot.
+	SetAnyInterfaceUp(false)
+	defer SetAnyInterfaceUp(true)
+	ws = Warnings()
+	if len(ws) != 1 || ws[0].WarnableCode != "network-down" {
+		t.Errorf("with network down, got %+v", ws)
+	}
+}
+
+func TestRegisterDuplicate(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Register of duplicate code didn't panic")
+		}
+	}()
+	Register(&Warnable{Code: "network-down", Severity: SeverityLow})
+}
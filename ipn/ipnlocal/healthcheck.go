@@ -0,0 +1,252 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/netip"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/envknob"
+	"tailscale.com/health"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/types/logger"
+	"tailscale.com/wgengine"
+)
+
+// healthCheckTimeout is how long each active health check may take.
+const healthCheckTimeout = 10 * time.Second
+
+var (
+	dnsCheckWarnable = health.Register(&health.Warnable{
+		Code:        "dns-check-failed",
+		Subsystem:   health.SysDNS,
+		Severity:    health.SeverityMedium,
+		Title:       "MagicDNS self-check failed",
+		Remediation: "This device's own MagicDNS name didn't resolve through Tailscale's DNS resolver; MagicDNS names may not work.",
+	})
+	derpCheckWarnable = health.Register(&health.Warnable{
+		Code:        "derp-check-failed",
+		Subsystem:   health.SysDERP,
+		Severity:    health.SeverityMedium,
+		Title:       "Home relay server check failed",
+		Remediation: "Relayed connections to peers may not work. Check that no firewall or proxy is interfering with connections to Tailscale's DERP relay servers.",
+	})
+	controlCheckWarnable = health.Register(&health.Warnable{
+		Code:        "control-check-failed",
+		Subsystem:   health.SysControl,
+		Severity:    health.SeverityMedium,
+		Title:       "Coordination server unreachable",
+		Remediation: "Check that this device can reach the coordination server over HTTPS.",
+	})
+)
+
+// healthCheckInterval returns how often the active health checks
+// should run in the background, or zero if they shouldn't. They're
+// off unless enabled with TS_HEALTH_CHECK_INTERVAL.
+func healthCheckInterval(logf logger.Logf) time.Duration {
+	v := envknob.String("TS_HEALTH_CHECK_INTERVAL")
+	if v == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		logf("invalid TS_HEALTH_CHECK_INTERVAL %q; active health checks disabled", v)
+		return 0
+	}
+	if min := 2 * healthCheckTimeout; d < min {
+		d = min
+	}
+	return d
+}
+
+// runHealthChecksEvery runs the active health checks every d until the
+// backend is shut down.
+func (b *LocalBackend) runHealthChecksEvery(d time.Duration) {
+	t := time.NewTicker(d)
+	defer t.Stop()
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-t.C:
+		}
+		for _, r := range b.RunHealthChecks(b.ctx) {
+			if !r.OK && !r.Skipped {
+				b.logf("health check %q failed: %v", r.Name, r.Error)
+			}
+		}
+	}
+}
+
+// RunHealthChecks runs the active health checks now, updating the
+// health warnings with their results.
+func (b *LocalBackend) RunHealthChecks(ctx context.Context) []ipnstate.HealthCheckResult {
+	res := health.RunChecks(ctx, []health.Check{
+		{Name: "dns", Warnable: dnsCheckWarnable, Run: b.checkMagicDNS},
+		{Name: "derp", Warnable: derpCheckWarnable, Run: b.checkHomeDERP},
+		{Name: "control", Warnable: controlCheckWarnable, Run: b.checkControl},
+	}, healthCheckTimeout)
+	ret := make([]ipnstate.HealthCheckResult, len(res))
+	for i, r := range res {
+		ret[i] = r.AsStatus()
+	}
+	return ret
+}
+
+var errCheckNotRunning = fmt.Errorf("%w: not running", health.ErrCheckSkipped)
+
+// checkMagicDNS checks that the node's own MagicDNS name resolves to its
+// Tailscale IP through the local resolver.
+func (b *LocalBackend) checkMagicDNS(ctx context.Context) error {
+	b.mu.Lock()
+	state := b.state
+	prefs := b.prefs
+	nm := b.netMap
+	b.mu.Unlock()
+	if state != ipn.Running || nm == nil {
+		return errCheckNotRunning
+	}
+	if prefs == nil || !prefs.CorpDNS {
+		return fmt.Errorf("%w: DNS configuration not accepted", health.ErrCheckSkipped)
+	}
+	if nm.Name == "" || len(nm.Addresses) == 0 {
+		return fmt.Errorf("%w: no MagicDNS name", health.ErrCheckSkipped)
+	}
+	re, ok := b.e.(wgengine.ResolvingEngine)
+	if !ok {
+		return errors.New("engine has no resolver")
+	}
+	r, ok := re.GetResolver()
+	if !ok {
+		return errors.New("engine has no resolver")
+	}
+
+	self := nm.Addresses[0].Addr()
+	qtype := dnsmessage.TypeA
+	if self.Is6() {
+		qtype = dnsmessage.TypeAAAA
+	}
+	name := nm.Name
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	q, err := dnsQuery(name, qtype)
+	if err != nil {
+		return err
+	}
+	resp, err := r.Query(ctx, q, netip.AddrPortFrom(self, 0))
+	if err != nil {
+		return fmt.Errorf("resolving %s: %w", name, err)
+	}
+	addrs, err := dnsAnswerAddrs(resp)
+	if err != nil {
+		return fmt.Errorf("resolving %s: %w", name, err)
+	}
+	for _, a := range addrs {
+		if a == self {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s resolved to %v; want %v", name, addrs, self)
+}
+
+// dnsQuery returns a DNS query packet for name.
+func dnsQuery(name string, qtype dnsmessage.Type) ([]byte, error) {
+	n, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+	bld := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:               uint16(rand.Intn(1 << 16)),
+		RecursionDesired: true,
+	})
+	if err := bld.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := bld.Question(dnsmessage.Question{Name: n, Type: qtype, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	return bld.Finish()
+}
+
+// dnsAnswerAddrs returns the addresses in the A and AAAA answers of the
+// DNS response packet resp, or an error if it isn't a successful
+// response.
+func dnsAnswerAddrs(resp []byte) ([]netip.Addr, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(resp)
+	if err != nil {
+		return nil, err
+	}
+	if h.RCode != dnsmessage.RCodeSuccess {
+		return nil, fmt.Errorf("response code %v", h.RCode)
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, err
+	}
+	var addrs []netip.Addr
+	for {
+		rh, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			return addrs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch rh.Type {
+		case dnsmessage.TypeA:
+			r, err := p.AResource()
+			if err != nil {
+				return nil, err
+			}
+			addrs = append(addrs, netip.AddrFrom4(r.A))
+		case dnsmessage.TypeAAAA:
+			r, err := p.AAAAResource()
+			if err != nil {
+				return nil, err
+			}
+			addrs = append(addrs, netip.AddrFrom16(r.AAAA))
+		default:
+			if err := p.SkipAnswer(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// checkHomeDERP checks that the home DERP server answers a ping.
+func (b *LocalBackend) checkHomeDERP(ctx context.Context) error {
+	b.mu.Lock()
+	state := b.state
+	b.mu.Unlock()
+	if state != ipn.Running {
+		return errCheckNotRunning
+	}
+	mc, err := b.magicConn()
+	if err != nil {
+		return err
+	}
+	_, _, err = mc.PingHomeDERP(ctx)
+	return err
+}
+
+// checkControl checks that the coordination server can be reached.
+func (b *LocalBackend) checkControl(ctx context.Context) error {
+	b.mu.Lock()
+	cc := b.ccAuto
+	prefs := b.prefs
+	b.mu.Unlock()
+	if cc == nil || prefs == nil || !prefs.WantRunning {
+		return errCheckNotRunning
+	}
+	return cc.CheckReachable(ctx)
+}
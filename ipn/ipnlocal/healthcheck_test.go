@@ -0,0 +1,64 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestDNSAnswerAddrs(t *testing.T) {
+	q, err := dnsQuery("foo.tail-scale.ts.net.", dnsmessage.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p dnsmessage.Parser
+	h, err := p.Start(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	question, err := p.Question()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	respond := func(rcode dnsmessage.RCode, answers ...netip.Addr) []byte {
+		h.Response = true
+		h.RCode = rcode
+		b := dnsmessage.NewBuilder(nil, h)
+		b.StartQuestions()
+		b.Question(question)
+		b.StartAnswers()
+		rh := dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: 600}
+		for _, a := range answers {
+			if a.Is4() {
+				b.AResource(rh, dnsmessage.AResource{A: a.As4()})
+			} else {
+				b.AAAAResource(rh, dnsmessage.AAAAResource{AAAA: a.As16()})
+			}
+		}
+		resp, err := b.Finish()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	want := []netip.Addr{netip.MustParseAddr("100.64.0.1"), netip.MustParseAddr("fd7a:115c:a1e0::1")}
+	got, err := dnsAnswerAddrs(respond(dnsmessage.RCodeSuccess, want...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+
+	if _, err := dnsAnswerAddrs(respond(dnsmessage.RCodeNameError)); err == nil {
+		t.Error("NXDOMAIN response didn't return an error")
+	}
+}
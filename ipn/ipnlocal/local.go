@@ -12,6 +12,7 @@
 	"net"
 	"net/http"
 	"net/netip"
+	"net/url"
 	"os"
 	"os/user"
 	"path/filepath"
@@ -33,9 +34,12 @@
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/ipn/policy"
+	"tailscale.com/logtail"
 	"tailscale.com/net/dns"
 	"tailscale.com/net/interfaces"
+	"tailscale.com/net/netcheck"
 	"tailscale.com/net/netutil"
+	"tailscale.com/net/speedtest"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/net/tsdial"
 	"tailscale.com/paths"
@@ -126,7 +130,8 @@ type LocalBackend struct {
 	gotPortPollRes        chan struct{}    // closed upon first readPoller result
 	serverURL             string           // tailcontrol URL
 	newDecompressor       func() (controlclient.Decompressor, error)
-	varRoot               string // or empty if SetVarRoot never called
+	varRoot               string          // or empty if SetVarRoot never called
+	logtail               *logtail.Logger // or nil if SetLogtailLogger never called
 	sshAtomicBool         atomic.Bool
 	shutdownCalled        bool // if Shutdown has been called
 
@@ -249,6 +254,9 @@ func NewLocalBackend(logf logger.Logf, logid string, store ipn.StateStore, diale
 	b.unregisterLinkMon = linkMon.RegisterChangeCallback(b.linkChange)
 
 	b.unregisterHealthWatch = health.RegisterWatcher(b.onHealthChange)
+	if d := healthCheckInterval(logf); d > 0 {
+		go b.runHealthChecksEvery(d)
+	}
 
 	wiredPeerAPIPort := false
 	if ig, ok := e.(wgengine.InternalsGetter); ok {
@@ -437,6 +445,7 @@ func (b *LocalBackend) updateStatus(sb *ipnstate.StatusBuilder, extraLocked func
 		if m := b.sshOnButUnusableHealthCheckMessageLocked(); m != "" {
 			s.Health = append(s.Health, m)
 		}
+		s.HealthWarnings = b.healthWarningsLocked()
 		if b.netMap != nil {
 			s.CertDomains = append([]string(nil), b.netMap.DNS.CertDomains...)
 			s.MagicDNSSuffix = b.netMap.MagicDNSSuffix()
@@ -1859,6 +1868,35 @@ func (b *LocalBackend) pingPeerAPI(ctx context.Context, ip netip.Addr) (peer *ta
 	return peer, base, nil
 }
 
+// Speedtest runs a speed test described by opts against the speedtest
+// server in the peerapi of the peer with Tailscale IP ip.
+func (b *LocalBackend) Speedtest(ctx context.Context, ip netip.Addr, opts speedtest.Options) (*speedtest.Report, error) {
+	nm := b.NetMap()
+	if nm == nil {
+		return nil, errors.New("no netmap")
+	}
+	peer, ok := nm.PeerByTailscaleIP(ip)
+	if !ok {
+		return nil, fmt.Errorf("no peer found with Tailscale IP %v", ip)
+	}
+	base := peerAPIBase(nm, peer)
+	if base == "" {
+		return nil, fmt.Errorf("no peer API base found for peer %v (%v)", peer.ID, ip)
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	opts.HTTPPath = "/v0/speedtest"
+	opts.Dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if strings.HasPrefix(network, "udp") {
+			return b.dialer.UserDial(ctx, network, addr)
+		}
+		return b.dialer.DialPeerAPI(ctx, network, addr)
+	}
+	return speedtest.Run(ctx, u.Host, opts)
+}
+
 // parseWgStatusLocked returns an EngineStatus based on s.
 //
 // b.mu must be held; mostly because the caller is about to anyway, and doing so
@@ -1969,6 +2007,34 @@ func (b *LocalBackend) checkSSHPrefsLocked(p *ipn.Prefs) error {
 	return nil
 }
 
+var sshUnusableWarnable = health.Register(&health.Warnable{
+	Code:      "ssh-unusable",
+	Subsystem: "ssh",
+	Severity:  health.SeverityLow,
+	Title:     "Tailscale SSH can't be used",
+})
+
+// HealthWarnings returns the node's current health problems, most
+// severe first.
+func (b *LocalBackend) HealthWarnings() []ipnstate.UnhealthyState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.healthWarningsLocked()
+}
+
+func (b *LocalBackend) healthWarningsLocked() []ipnstate.UnhealthyState {
+	ws := health.Warnings()
+	if m := b.sshOnButUnusableHealthCheckMessageLocked(); m != "" {
+		ws = append(ws, sshUnusableWarnable.UnhealthyState(errors.New(m)))
+		health.SortUnhealthyStates(ws)
+	}
+	ret := make([]ipnstate.UnhealthyState, len(ws))
+	for i, us := range ws {
+		ret[i] = us.AsStatus()
+	}
+	return ret
+}
+
 func (b *LocalBackend) sshOnButUnusableHealthCheckMessageLocked() (healthMessage string) {
 	if b.prefs == nil || !b.prefs.RunSSH {
 		return ""
@@ -2511,6 +2577,51 @@ func (b *LocalBackend) SetVarRoot(dir string) {
 	b.varRoot = dir
 }
 
+// SetLogtailLogger sets the logtail Logger that the process's logs are
+// written to, whose per-subsystem verbosity can then be adjusted with
+// SetSubsystemLogLevel.
+//
+// It should only be called before the LocalBackend is used.
+func (b *LocalBackend) SetLogtailLogger(lt *logtail.Logger) {
+	b.logtail = lt
+}
+
+// LogLevels returns the verbosity levels of the logs that the process
+// writes to stderr.
+func (b *LocalBackend) LogLevels() (*apitype.LogLevels, error) {
+	if b.logtail == nil {
+		return nil, errors.New("log levels not available on this platform")
+	}
+	return &apitype.LogLevels{
+		Level:      b.logtail.VerbosityLevel(),
+		Subsystems: b.logtail.SubsystemVerbosityLevels(),
+		Known:      logtail.Subsystems(),
+	}, nil
+}
+
+// SetSubsystemLogLevel sets the verbosity level of the logs from subsys
+// that the process writes to stderr. A negative level reverts subsys to
+// the process's overall verbosity level.
+func (b *LocalBackend) SetSubsystemLogLevel(subsys string, level int) error {
+	if b.logtail == nil {
+		return errors.New("log levels not available on this platform")
+	}
+	if err := b.logtail.SetSubsystemVerbosityLevel(subsys, level); err != nil {
+		return err
+	}
+	b.logf("log level of %q set to %d", subsys, level)
+	return nil
+}
+
+// RecentLogs returns the process's most recently written log entries,
+// one JSON object per line, or nil if they're not kept.
+func (b *LocalBackend) RecentLogs() []byte {
+	if b.logtail == nil {
+		return nil
+	}
+	return b.logtail.RecentLogs()
+}
+
 // TailscaleVarRoot returns the root directory of Tailscale's writable
 // storage area. (e.g. "/var/lib/tailscale")
 //
@@ -3530,6 +3641,43 @@ func (b *LocalBackend) DebugReSTUN() error {
 	return nil
 }
 
+// NetcheckReport returns magicsock's most recent netcheck report, or
+// nil if none has completed yet.
+func (b *LocalBackend) NetcheckReport() (*netcheck.Report, error) {
+	mc, err := b.magicConn()
+	if err != nil {
+		return nil, err
+	}
+	return mc.LastNetcheckReport(), nil
+}
+
+// DNSConfig returns the DNS configuration derived from the current
+// network map and prefs, or nil if there's no network map yet.
+func (b *LocalBackend) DNSConfig() *dns.Config {
+	b.mu.Lock()
+	nm := b.netMap
+	prefs := b.prefs
+	b.mu.Unlock()
+	if nm == nil || prefs == nil {
+		return nil
+	}
+	return dnsConfigForNetmap(nm, prefs, logger.Discard, version.OS())
+}
+
+// OSDNSBaseConfig returns the OS's DNS configuration without any
+// changes made by Tailscale.
+func (b *LocalBackend) OSDNSBaseConfig() (dns.OSConfig, error) {
+	ig, ok := b.e.(wgengine.InternalsGetter)
+	if !ok {
+		return dns.OSConfig{}, errors.New("engine isn't InternalsGetter")
+	}
+	_, _, dm, ok := ig.GetInternals()
+	if !ok || dm == nil {
+		return dns.OSConfig{}, errors.New("failed to get internals")
+	}
+	return dm.OSBaseConfig()
+}
+
 func (b *LocalBackend) magicConn() (*magicsock.Conn, error) {
 	ig, ok := b.e.(wgengine.InternalsGetter)
 	if !ok {
@@ -3563,7 +3711,7 @@ func (b *LocalBackend) sshServerOrInit() (_ SSHServer, err error) {
 	if newSSHServer == nil {
 		return nil, errors.New("no SSH server support")
 	}
-	b.sshServer, err = newSSHServer(b.logf, b)
+	b.sshServer, err = newSSHServer(logger.WithPrefix(b.logf, "ssh: "), b)
 	if err != nil {
 		return nil, fmt.Errorf("newSSHServer: %w", err)
 	}
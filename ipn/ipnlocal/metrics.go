@@ -0,0 +1,72 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+)
+
+// WritePeerMetrics writes per-peer metrics to w in the Prometheus text
+// exposition format: the bytes sent to and received from each peer,
+// the age of its last WireGuard handshake, and whether it's currently
+// reached directly or via DERP.
+func (b *LocalBackend) WritePeerMetrics(w io.Writer) {
+	writePeerMetrics(w, b.Status(), time.Now())
+}
+
+var promLabelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writePeerMetrics(w io.Writer, st *ipnstate.Status, now time.Time) {
+	peers := st.Peers()
+	if len(peers) == 0 {
+		return
+	}
+	labels := make([]string, len(peers))
+	for i, pk := range peers {
+		ps := st.Peer[pk]
+		name := strings.TrimSuffix(ps.DNSName, ".")
+		if name == "" {
+			name = ps.HostName
+		}
+		var ip string
+		if len(ps.TailscaleIPs) > 0 {
+			ip = ps.TailscaleIPs[0].String()
+		}
+		labels[i] = fmt.Sprintf(`peer="%s",ip="%s"`, promLabelValueEscaper.Replace(name), ip)
+	}
+
+	metric := func(name, typ, help string, value func(*ipnstate.PeerStatus) (v float64, ok bool)) {
+		fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+		for i, pk := range peers {
+			if v, ok := value(st.Peer[pk]); ok {
+				fmt.Fprintf(w, "%s{%s} %v\n", name, labels[i], v)
+			}
+		}
+	}
+	metric("tailscaled_peer_rx_bytes", "counter", "Bytes received from the peer over WireGuard.",
+		func(ps *ipnstate.PeerStatus) (float64, bool) { return float64(ps.RxBytes), true })
+	metric("tailscaled_peer_tx_bytes", "counter", "Bytes sent to the peer over WireGuard.",
+		func(ps *ipnstate.PeerStatus) (float64, bool) { return float64(ps.TxBytes), true })
+	metric("tailscaled_peer_last_handshake_age_seconds", "gauge", "Seconds since the last WireGuard handshake with the peer.",
+		func(ps *ipnstate.PeerStatus) (float64, bool) {
+			if ps.LastHandshake.IsZero() {
+				return 0, false
+			}
+			return now.Sub(ps.LastHandshake).Truncate(time.Millisecond).Seconds(), true
+		})
+	metric("tailscaled_peer_direct", "gauge", "Whether the peer is reached directly (1) rather than via DERP (0).",
+		func(ps *ipnstate.PeerStatus) (float64, bool) {
+			if ps.CurAddr != "" {
+				return 1, true
+			}
+			return 0, true
+		})
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnlocal
+
+import (
+	"bytes"
+	"net/netip"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/types/key"
+)
+
+func TestWritePeerMetrics(t *testing.T) {
+	now := time.Unix(1660000000, 0)
+	var sb ipnstate.StatusBuilder
+	sb.AddPeer(key.NewNode().Public(), &ipnstate.PeerStatus{
+		DNSName:       "direct.tailnet.ts.net.",
+		TailscaleIPs:  []netip.Addr{netip.MustParseAddr("100.64.0.1")},
+		CurAddr:       "1.2.3.4:41641",
+		RxBytes:       100,
+		TxBytes:       200,
+		LastHandshake: now.Add(-90 * time.Second),
+	})
+	sb.AddPeer(key.NewNode().Public(), &ipnstate.PeerStatus{
+		HostName:     `my "laptop"`,
+		TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.2")},
+		Relay:        "nyc",
+	})
+	st := sb.Status()
+
+	var buf bytes.Buffer
+	writePeerMetrics(&buf, st, now)
+	got := buf.String()
+
+	// Peers are sorted by key, so check each line's presence rather than
+	// the exact output.
+	for _, want := range []string{
+		"# TYPE tailscaled_peer_rx_bytes counter\n",
+		`tailscaled_peer_rx_bytes{peer="direct.tailnet.ts.net",ip="100.64.0.1"} 100` + "\n",
+		`tailscaled_peer_tx_bytes{peer="direct.tailnet.ts.net",ip="100.64.0.1"} 200` + "\n",
+		`tailscaled_peer_rx_bytes{peer="my \"laptop\"",ip="100.64.0.2"} 0` + "\n",
+		"# TYPE tailscaled_peer_last_handshake_age_seconds gauge\n",
+		`tailscaled_peer_last_handshake_age_seconds{peer="direct.tailnet.ts.net",ip="100.64.0.1"} 90` + "\n",
+		`tailscaled_peer_direct{peer="direct.tailnet.ts.net",ip="100.64.0.1"} 1` + "\n",
+		`tailscaled_peer_direct{peer="my \"laptop\"",ip="100.64.0.2"} 0` + "\n",
+	} {
+		if !bytes.Contains(buf.Bytes(), []byte(want)) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+	if bytes.Contains(buf.Bytes(), []byte(`tailscaled_peer_last_handshake_age_seconds{peer="my`)) {
+		t.Errorf("handshake age reported for peer without handshake:\n%s", got)
+	}
+
+	buf.Reset()
+	writePeerMetrics(&buf, new(ipnstate.Status), now)
+	if buf.Len() != 0 {
+		t.Errorf("with no peers, got:\n%s", buf.Bytes())
+	}
+}
@@ -42,6 +42,7 @@
 	"tailscale.com/net/interfaces"
 	"tailscale.com/net/netaddr"
 	"tailscale.com/net/netutil"
+	"tailscale.com/net/speedtest"
 	"tailscale.com/tailcfg"
 	"tailscale.com/util/clientmetric"
 	"tailscale.com/wgengine"
@@ -449,6 +450,34 @@ func (s *peerAPIServer) listen(ip netip.Addr, ifState *interfaces.State) (ln net
 	return ln, err
 }
 
+// listenPacket creates a UDP socket on an ephemeral port that peers can
+// reach at the Tailscale IP ip, for UDP speed tests.
+func (s *peerAPIServer) listenPacket(ip netip.Addr) (net.PacketConn, error) {
+	ipStr := ip.String()
+	var lc net.ListenConfig
+	if initListenConfig != nil {
+		s.b.mu.Lock()
+		ifState := s.b.prevIfState
+		s.b.mu.Unlock()
+		if err := initListenConfig(&lc, ip, ifState, s.b.dialer.TUNName()); err != nil {
+			return nil, err
+		}
+		if runtime.GOOS == "darwin" || runtime.GOOS == "ios" {
+			ipStr = ""
+		}
+	}
+	// In netstack mode, netstack forwards UDP to our own Tailscale IPs
+	// on to localhost.
+	if wgengine.IsNetstack(s.b.e) {
+		ipStr = "127.0.0.1"
+	}
+	udp4or6 := "udp4"
+	if ip.Is6() && ipStr != "127.0.0.1" {
+		udp4or6 = "udp6"
+	}
+	return lc.ListenPacket(context.Background(), udp4or6, net.JoinHostPort(ipStr, "0"))
+}
+
 type peerAPIListener struct {
 	ps *peerAPIServer
 	ip netip.Addr
@@ -571,6 +600,9 @@ func (h *peerAPIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case "/v0/interfaces":
 		h.handleServeInterfaces(w, r)
 		return
+	case "/v0/speedtest":
+		h.handleServeSpeedtest(w, r)
+		return
 	}
 	who := h.peerUser.DisplayName
 	fmt.Fprintf(w, `<html>
@@ -880,6 +912,7 @@ func (h *peerAPIHandler) handleServeMetrics(w http.ResponseWriter, r *http.Reque
 	}
 	w.Header().Set("Content-Type", "text/plain")
 	clientmetric.WritePrometheusExpositionFormat(w)
+	h.ps.b.WritePeerMetrics(w)
 }
 
 func (h *peerAPIHandler) handleServeDNSFwd(w http.ResponseWriter, r *http.Request) {
@@ -895,6 +928,18 @@ func (h *peerAPIHandler) handleServeDNSFwd(w http.ResponseWriter, r *http.Reques
 	dh.ServeHTTP(w, r)
 }
 
+func (h *peerAPIHandler) handleServeSpeedtest(w http.ResponseWriter, r *http.Request) {
+	if !h.canDebug() {
+		http.Error(w, "denied; no debug access", http.StatusForbidden)
+		return
+	}
+	st := &speedtest.Server{
+		Logf:         h.logf,
+		ListenPacket: h.ps.listenPacket,
+	}
+	st.ServeHTTP(w, r)
+}
+
 func (h *peerAPIHandler) handleWakeOnLAN(w http.ResponseWriter, r *http.Request) {
 	if !h.canWakeOnLAN() {
 		http.Error(w, "no WoL access", http.StatusForbidden)
@@ -47,6 +47,10 @@ type Status struct {
 	// problems are detected)
 	Health []string
 
+	// HealthWarnings contains the same problems as Health, in
+	// machine-readable form, most severe first.
+	HealthWarnings []UnhealthyState `json:",omitempty"`
+
 	// This field is the legacy name of CurrentTailnet.MagicDNSSuffix.
 	//
 	// Deprecated: use CurrentTailnet.MagicDNSSuffix instead.
@@ -63,6 +67,10 @@ type Status struct {
 	// trailing periods, and without any "_acme-challenge." prefix.
 	CertDomains []string
 
+	// BIRDProtocols is the state of the protocols of the BIRD routing
+	// daemon, when tailscaled was started with --bird-socket.
+	BIRDProtocols []*BIRDProtocol `json:",omitempty"`
+
 	Peer map[key.NodePublic]*PeerStatus
 	User map[tailcfg.UserID]tailcfg.UserProfile
 }
@@ -97,6 +105,46 @@ type ExitNodeStatus struct {
 	TailscaleIPs []netip.Prefix
 }
 
+// BIRDProtocol is the state of a protocol instance in BIRD, as listed
+// by its "show protocols" command.
+type BIRDProtocol struct {
+	Name  string
+	Proto string // type of protocol, such as "BGP" or "Static"
+	State string // "up", "down", "start" or "stop"
+	Since string // when State last changed, as formatted by BIRD
+	Info  string `json:",omitempty"` // such as "Established" for a BGP session
+
+	// Routes is the number of routes from the protocol in BIRD's
+	// tables. It's only populated for BGP protocols.
+	Routes int `json:",omitempty"`
+}
+
+// UnhealthyState is a health problem that's currently occurring on the
+// node, as reported by the health package.
+type UnhealthyState struct {
+	WarnableCode string // such as "router-error"
+	Subsystem    string `json:",omitempty"`
+	Severity     string // "high", "medium" or "low"
+	Title        string
+	// Text is the detail of this occurrence of the problem.
+	Text        string
+	Remediation string `json:",omitempty"`
+	// BrokenSince is when the problem was first noticed, if known.
+	BrokenSince *time.Time `json:",omitempty"`
+}
+
+// HealthCheckResult is the result of one of tailscaled's active health
+// checks, as run by the "tailscale health check" subcommand.
+type HealthCheckResult struct {
+	Name         string // such as "dns"
+	WarnableCode string // of the UnhealthyState reported when the check fails
+	OK           bool
+	Skipped      bool `json:",omitempty"`
+	// Error is why the check failed or was skipped.
+	Error   string `json:",omitempty"`
+	Latency time.Duration
+}
+
 func (s *Status) Peers() []key.NodePublic {
 	kk := make([]key.NodePublic, 0, len(s.Peer))
 	for k := range s.Peer {
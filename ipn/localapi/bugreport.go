@@ -0,0 +1,251 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package localapi
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"runtime"
+	"time"
+
+	"tailscale.com/net/dns"
+	"tailscale.com/net/interfaces"
+	"tailscale.com/util/clientmetric"
+	"tailscale.com/version"
+)
+
+// routeTableFunc, if non-nil, writes the OS's routing tables to w.
+// It's nil on platforms where the bundle doesn't include them.
+var routeTableFunc func(ctx context.Context, w io.Writer) error
+
+// serveBugReportBundle logs a bug report marker, like serveBugReport,
+// and responds with a gzipped tar archive of diagnostics that the user
+// can share along with it: status, prefs with secrets redacted, the
+// last netcheck report, interface, route and DNS configuration, health
+// state, recent logs, a goroutine dump and metrics.
+//
+// Parts that fail to be collected are included with their error
+// rather than failing the whole bundle.
+func (h *Handler) serveBugReportBundle(w http.ResponseWriter, r *http.Request) {
+	// Require write access because of the goroutine dump and metrics;
+	// see serveGoroutines.
+	if !h.PermitWrite {
+		http.Error(w, "bugreport bundle access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	now := time.Now().UTC()
+	note := r.FormValue("note")
+	logMarker := h.logBugReport(now, note)
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Tailscale-Bugreport-Marker", logMarker)
+	bw := newBundleWriter(w, logMarker, now)
+
+	bw.addFunc("bugreport.txt", func(w io.Writer) error {
+		fmt.Fprintf(w, "Marker: %s\n", logMarker)
+		fmt.Fprintf(w, "Time: %s\n", now.Format(time.RFC3339))
+		fmt.Fprintf(w, "Version: %s\n", version.Long)
+		fmt.Fprintf(w, "OS: %s/%s\n", runtime.GOOS, runtime.GOARCH)
+		if note != "" {
+			fmt.Fprintf(w, "Note: %s\n", note)
+		}
+		return nil
+	})
+	st := h.b.Status()
+	bw.addJSON("status.json", st)
+	bw.addJSON("prefs.json", h.b.Prefs())
+	bw.addFunc("health.txt", func(w io.Writer) error {
+		if len(st.Health) == 0 {
+			fmt.Fprintln(w, "no problems")
+		}
+		for _, m := range st.Health {
+			fmt.Fprintln(w, m)
+		}
+		return nil
+	})
+	bw.addFunc("netcheck.json", func(w io.Writer) error {
+		rep, err := h.b.NetcheckReport()
+		if err != nil {
+			return err
+		}
+		if rep == nil {
+			return errors.New("no netcheck report yet")
+		}
+		return writeJSON(w, rep)
+	})
+	bw.addFunc("interfaces.txt", writeInterfaces)
+	bw.addFunc("routes.txt", func(w io.Writer) error {
+		if routeTableFunc == nil {
+			return fmt.Errorf("not supported on %v", runtime.GOOS)
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		return routeTableFunc(ctx, w)
+	})
+	bw.addFunc("dns.txt", h.writeDNS)
+	bw.addFunc("tailscaled.log.jsonl", func(w io.Writer) error {
+		logs := h.b.RecentLogs()
+		if logs == nil {
+			return errors.New("recent logs not available")
+		}
+		_, err := w.Write(logs)
+		return err
+	})
+	bw.addFunc("goroutines.txt", func(w io.Writer) error {
+		buf := make([]byte, 2<<20)
+		_, err := w.Write(buf[:runtime.Stack(buf, true)])
+		return err
+	})
+	bw.addFunc("metrics.txt", func(w io.Writer) error {
+		clientmetric.WritePrometheusExpositionFormat(w)
+		h.b.WritePeerMetrics(w)
+		return nil
+	})
+
+	if err := bw.Close(); err != nil {
+		h.logf("bugreport bundle: %v", err)
+	}
+}
+
+// logBugReport logs and returns a new bug report marker, along with
+// note if non-empty.
+func (h *Handler) logBugReport(now time.Time, note string) (logMarker string) {
+	logMarker = fmt.Sprintf("BUG-%v-%v-%v", h.backendLogID, now.Format("20060102150405Z"), randHex(8))
+	h.logf("user bugreport: %s", logMarker)
+	if note != "" {
+		h.logf("user bugreport note: %s", note)
+	}
+	return logMarker
+}
+
+func (h *Handler) writeDNS(w io.Writer) error {
+	fmt.Fprintln(w, "# Tailscale DNS configuration")
+	if cfg := h.b.DNSConfig(); cfg != nil {
+		bw := bufio.NewWriter(w)
+		cfg.WriteToBufioWriter(bw)
+		bw.WriteString("\n")
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintln(w, "none (no network map)")
+	}
+	fmt.Fprintln(w, "\n# OS base DNS configuration")
+	ocfg, err := h.b.OSDNSBaseConfig()
+	if errors.Is(err, dns.ErrGetBaseConfigNotSupported) {
+		fmt.Fprintln(w, "not supported by this OS configurator")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%+v\n", ocfg)
+	return nil
+}
+
+func writeInterfaces(w io.Writer) error {
+	st, err := interfaces.GetState()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%v\n\n", st)
+	return interfaces.ForeachInterface(func(i interfaces.Interface, pfxs []netip.Prefix) {
+		fmt.Fprintf(w, "%d: %s: mtu=%d flags=%v", i.Index, i.Name, i.MTU, i.Flags)
+		if i.Desc != "" {
+			fmt.Fprintf(w, " desc=%q", i.Desc)
+		}
+		fmt.Fprintln(w)
+		for _, pfx := range pfxs {
+			fmt.Fprintf(w, "\t%v\n", pfx)
+		}
+	})
+}
+
+func writeJSON(w io.Writer, v any) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	return e.Encode(v)
+}
+
+// bundleWriter writes the files of a bug report bundle to a gzipped
+// tar archive, all under a top-level directory named after the bug
+// report marker.
+type bundleWriter struct {
+	dir string
+	now time.Time
+	gz  *gzip.Writer
+	tw  *tar.Writer
+	err error // first write error, after which writes are skipped
+}
+
+func newBundleWriter(w io.Writer, logMarker string, now time.Time) *bundleWriter {
+	gz := gzip.NewWriter(w)
+	return &bundleWriter{
+		dir: logMarker,
+		now: now,
+		gz:  gz,
+		tw:  tar.NewWriter(gz),
+	}
+}
+
+// add adds a file named name with contents b to the bundle.
+func (bw *bundleWriter) add(name string, b []byte) {
+	if bw.err != nil {
+		return
+	}
+	bw.err = bw.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     bw.dir + "/" + name,
+		Size:     int64(len(b)),
+		Mode:     0644,
+		ModTime:  bw.now,
+	})
+	if bw.err == nil {
+		_, bw.err = bw.tw.Write(b)
+	}
+}
+
+// addFunc adds a file named name whose contents are written by fn.
+// If fn fails, its error is appended to whatever it wrote.
+func (bw *bundleWriter) addFunc(name string, fn func(io.Writer) error) {
+	var buf bytes.Buffer
+	if err := fn(&buf); err != nil {
+		if buf.Len() > 0 && !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+			buf.WriteByte('\n')
+		}
+		fmt.Fprintf(&buf, "error: %v\n", err)
+	}
+	bw.add(name, buf.Bytes())
+}
+
+// addJSON adds a file named name containing v as indented JSON.
+func (bw *bundleWriter) addJSON(name string, v any) {
+	bw.addFunc(name, func(w io.Writer) error { return writeJSON(w, v) })
+}
+
+// Close finishes the archive, returning the first error encountered
+// writing it.
+func (bw *bundleWriter) Close() error {
+	if bw.err != nil {
+		return bw.err
+	}
+	if err := bw.tw.Close(); err != nil {
+		return err
+	}
+	return bw.gz.Close()
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !ios && !android && !js
+// +build !ios,!android,!js
+
+// We don't include it on mobile and js, where there are no route
+// commands to run.
+
+package localapi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+func init() {
+	routeTableFunc = writeRouteTables
+}
+
+// routeTableCommands returns the commands that print the OS's routing
+// tables (and, on Linux, its policy routing rules).
+func routeTableCommands() [][]string {
+	switch runtime.GOOS {
+	case "linux":
+		return [][]string{
+			{"ip", "rule", "show"},
+			{"ip", "-6", "rule", "show"},
+			{"ip", "route", "show", "table", "all"},
+		}
+	case "windows":
+		return [][]string{{"route", "print"}}
+	default:
+		return [][]string{{"netstat", "-rn"}}
+	}
+}
+
+// writeRouteTables writes the output of each of routeTableCommands to
+// w, along with any error running it.
+func writeRouteTables(ctx context.Context, w io.Writer) error {
+	for _, args := range routeTableCommands() {
+		fmt.Fprintf(w, "$ %s\n", strings.Join(args, " "))
+		out, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+		w.Write(out)
+		if err != nil {
+			fmt.Fprintf(w, "error: %v\n", err)
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
@@ -30,6 +30,7 @@
 	"tailscale.com/ipn/ipnlocal"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/net/netutil"
+	"tailscale.com/net/speedtest"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/logger"
 	"tailscale.com/util/clientmetric"
@@ -116,6 +117,10 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.serveGoroutines(w, r)
 	case "/localapi/v0/profile":
 		h.serveProfile(w, r)
+	case "/localapi/v0/health":
+		h.serveHealth(w, r)
+	case "/localapi/v0/health-check":
+		h.serveHealthCheck(w, r)
 	case "/localapi/v0/status":
 		h.serveStatus(w, r)
 	case "/localapi/v0/logout":
@@ -132,6 +137,8 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.serveCheckIPForwarding(w, r)
 	case "/localapi/v0/bugreport":
 		h.serveBugReport(w, r)
+	case "/localapi/v0/bugreport-bundle":
+		h.serveBugReportBundle(w, r)
 	case "/localapi/v0/file-targets":
 		h.serveFileTargets(w, r)
 	case "/localapi/v0/set-dns":
@@ -142,10 +149,14 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.serveMetrics(w, r)
 	case "/localapi/v0/debug":
 		h.serveDebug(w, r)
+	case "/localapi/v0/log-level":
+		h.serveLogLevel(w, r)
 	case "/localapi/v0/set-expiry-sooner":
 		h.serveSetExpirySooner(w, r)
 	case "/localapi/v0/dial":
 		h.serveDial(w, r)
+	case "/localapi/v0/speedtest":
+		h.serveSpeedtest(w, r)
 	case "/localapi/v0/id-token":
 		h.serveIDToken(w, r)
 	case "/localapi/v0/upload-client-metrics":
@@ -207,11 +218,7 @@ func (h *Handler) serveBugReport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	logMarker := fmt.Sprintf("BUG-%v-%v-%v", h.backendLogID, time.Now().UTC().Format("20060102150405Z"), randHex(8))
-	h.logf("user bugreport: %s", logMarker)
-	if note := r.FormValue("note"); len(note) > 0 {
-		h.logf("user bugreport note: %s", note)
-	}
+	logMarker := h.logBugReport(time.Now().UTC(), r.FormValue("note"))
 	w.Header().Set("Content-Type", "text/plain")
 	fmt.Fprintln(w, logMarker)
 }
@@ -275,6 +282,7 @@ func (h *Handler) serveMetrics(w http.ResponseWriter, r *http.Request) {
 	}
 	w.Header().Set("Content-Type", "text/plain")
 	clientmetric.WritePrometheusExpositionFormat(w)
+	h.b.WritePeerMetrics(w)
 }
 
 func (h *Handler) serveDebug(w http.ResponseWriter, r *http.Request) {
@@ -306,6 +314,43 @@ func (h *Handler) serveDebug(w http.ResponseWriter, r *http.Request) {
 	io.WriteString(w, "done\n")
 }
 
+// serveLogLevel returns (on GET) or sets (on POST, with "subsys" and
+// "level" parameters) the verbosity levels of tailscaled's logs.
+func (h *Handler) serveLogLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		if !h.PermitRead {
+			http.Error(w, "log-level access denied", http.StatusForbidden)
+			return
+		}
+	case "POST":
+		if !h.PermitWrite {
+			http.Error(w, "log-level access denied", http.StatusForbidden)
+			return
+		}
+		subsys := r.FormValue("subsys")
+		level, err := strconv.Atoi(r.FormValue("level"))
+		if err != nil {
+			http.Error(w, "invalid 'level' parameter", http.StatusBadRequest)
+			return
+		}
+		if err := h.b.SetSubsystemLogLevel(subsys, level); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "want GET or POST", http.StatusMethodNotAllowed)
+		return
+	}
+	levels, err := h.b.LogLevels()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(levels)
+}
+
 // serveProfileFunc is the implementation of Handler.serveProfile, after auth,
 // for platforms where we want to link it in.
 var serveProfileFunc func(http.ResponseWriter, *http.Request)
@@ -341,6 +386,34 @@ func (h *Handler) serveCheckIPForwarding(w http.ResponseWriter, r *http.Request)
 	})
 }
 
+func (h *Handler) serveHealth(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "health access denied", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(h.b.HealthWarnings())
+}
+
+func (h *Handler) serveHealthCheck(w http.ResponseWriter, r *http.Request) {
+	// Require write access because the checks make network requests
+	// and their failures change the health state that others see.
+	if !h.PermitWrite {
+		http.Error(w, "health access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(h.b.RunHealthChecks(r.Context()))
+}
+
 func (h *Handler) serveStatus(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitRead {
 		http.Error(w, "status access denied", http.StatusForbidden)
@@ -686,6 +759,34 @@ func (h *Handler) servePing(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(res)
 }
 
+func (h *Handler) serveSpeedtest(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "speedtest access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "want POST", 400)
+		return
+	}
+	ip, err := netip.ParseAddr(r.FormValue("ip"))
+	if err != nil {
+		http.Error(w, "invalid or missing 'ip' parameter", 400)
+		return
+	}
+	var opts speedtest.Options
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+		http.Error(w, "invalid options: "+err.Error(), 400)
+		return
+	}
+	rep, err := h.b.Speedtest(r.Context(), ip, opts)
+	if err != nil {
+		writeErrorJSON(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rep)
+}
+
 func (h *Handler) serveDial(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "POST required", http.StatusMethodNotAllowed)
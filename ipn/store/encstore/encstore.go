@@ -0,0 +1,210 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package encstore contains an ipn.StateStore implementation that keeps
+// its state in a file encrypted with a key from a pluggable KeyProvider.
+package encstore
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"tailscale.com/atomicfile"
+	"tailscale.com/ipn"
+	"tailscale.com/paths"
+	"tailscale.com/types/logger"
+)
+
+// ErrCorrupt is returned (wrapped) when a state file can't be decrypted,
+// either because it was modified or because it was encrypted with a
+// different key.
+var ErrCorrupt = errors.New("state file is corrupted or was encrypted with a different key")
+
+// fileVersion is the current version of the encrypted file format.
+const fileVersion = 1
+
+// encFile is the format of the encrypted state file.
+type encFile struct {
+	// Version is fileVersion. It being non-zero is also how encrypted
+	// files are told apart from the plaintext ones of ipn/store.FileStore.
+	Version int `json:"TailscaleEncryptedState"`
+
+	// Nonce is the XChaCha20-Poly1305 nonce of Ciphertext.
+	Nonce []byte
+
+	// Ciphertext is the encrypted JSON of the state, as stored in the
+	// plaintext files of ipn/store.FileStore.
+	Ciphertext []byte
+}
+
+// additionalData is authenticated along with the state.
+var additionalData = []byte("tailscale.com/ipn/store/encstore v1")
+
+// Store is an ipn.StateStore that persists to an encrypted file.
+type Store struct {
+	path string
+	kp   KeyProvider
+	aead cipher.AEAD
+
+	mu    sync.RWMutex
+	cache map[ipn.StateKey][]byte
+}
+
+// New returns a new Store for arg, which is of the form
+// "enc:PATH?key=SCHEME:ARG". PATH is the state file, and SCHEME:ARG
+// selects the KeyProvider, as for NewKeyProvider.
+//
+// New is registered with ipn/store for the "enc:" prefix.
+func New(logf logger.Logf, arg string) (ipn.StateStore, error) {
+	path, query, _ := strings.Cut(strings.TrimPrefix(arg, "enc:"), "?")
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("encstore: invalid options %q: %w", query, err)
+	}
+	spec := q.Get("key")
+	if path == "" || spec == "" {
+		return nil, fmt.Errorf("encstore: %q is not of the form enc:PATH?key=SCHEME:ARG", arg)
+	}
+	kp, err := NewKeyProvider(spec)
+	if err != nil {
+		return nil, err
+	}
+	return NewFileStore(logf, path, kp)
+}
+
+// NewFileStore returns a new Store that persists to path, encrypted with
+// the key from kp.
+//
+// If path is a plaintext state file, as written by ipn/store.FileStore, it
+// is encrypted in place.
+func NewFileStore(logf logger.Logf, path string, kp KeyProvider) (*Store, error) {
+	key, err := kp.Key()
+	if err != nil {
+		return nil, fmt.Errorf("encstore: getting key from %v: %w", kp, err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("encstore: key from %v is %d bytes; want %d", kp, len(key), KeySize)
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	// We unconditionally call this to ensure that our perms are correct
+	if err := paths.MkStateDir(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("creating state directory: %w", err)
+	}
+	s := &Store{
+		path:  path,
+		kp:    kp,
+		aead:  aead,
+		cache: map[ipn.StateKey][]byte{},
+	}
+
+	bs, err := os.ReadFile(path)
+	// Treat an empty file as a missing file, like FileStore does.
+	if err == nil && len(bs) == 0 {
+		logf("encstore.NewFileStore(%q): file empty; treating it like a missing file [warning]", path)
+		err = os.ErrNotExist
+	}
+	if os.IsNotExist(err) {
+		// Write out an initial file, to verify that we can write to
+		// the path.
+		if err := s.writeLocked(); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ef encFile
+	if err := json.Unmarshal(bs, &ef); err != nil {
+		return nil, fmt.Errorf("encstore: %s: %w: %v", path, ErrCorrupt, err)
+	}
+	switch ef.Version {
+	case 0:
+		if err := json.Unmarshal(bs, &s.cache); err != nil {
+			return nil, fmt.Errorf("encstore: reading plaintext state %s: %w", path, err)
+		}
+		logf("encstore: encrypting plaintext state file %s with key from %v", path, kp)
+		if err := s.writeLocked(); err != nil {
+			return nil, fmt.Errorf("encstore: encrypting plaintext state: %w", err)
+		}
+		return s, nil
+	case fileVersion:
+	default:
+		return nil, fmt.Errorf("encstore: %s has unsupported version %d", path, ef.Version)
+	}
+	if len(ef.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("encstore: %s: %w: bad nonce", path, ErrCorrupt)
+	}
+	plain, err := aead.Open(nil, ef.Nonce, ef.Ciphertext, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("encstore: %s: %w", path, ErrCorrupt)
+	}
+	if err := json.Unmarshal(plain, &s.cache); err != nil {
+		return nil, fmt.Errorf("encstore: %s: %w: %v", path, ErrCorrupt, err)
+	}
+	return s, nil
+}
+
+// Path returns the path that NewFileStore was called with.
+func (s *Store) Path() string { return s.path }
+
+func (s *Store) String() string { return fmt.Sprintf("encstore.Store(%q, %v)", s.path, s.kp) }
+
+// ReadState implements the StateStore interface.
+func (s *Store) ReadState(id ipn.StateKey) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	bs, ok := s.cache[id]
+	if !ok {
+		return nil, ipn.ErrStateNotExist
+	}
+	return bs, nil
+}
+
+// WriteState implements the StateStore interface.
+func (s *Store) WriteState(id ipn.StateKey, bs []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if bytes.Equal(s.cache[id], bs) {
+		return nil
+	}
+	s.cache[id] = append([]byte(nil), bs...)
+	return s.writeLocked()
+}
+
+// writeLocked encrypts s.cache to s.path. s.mu must be held, or s must
+// not yet be shared.
+func (s *Store) writeLocked() error {
+	plain, err := json.Marshal(s.cache)
+	if err != nil {
+		return err
+	}
+	ef := encFile{
+		Version: fileVersion,
+		Nonce:   make([]byte, s.aead.NonceSize()),
+	}
+	if _, err := rand.Read(ef.Nonce); err != nil {
+		return err
+	}
+	ef.Ciphertext = s.aead.Seal(nil, ef.Nonce, plain, additionalData)
+	bs, err := json.MarshalIndent(ef, "", "  ")
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(s.path, bs, 0600)
+}
@@ -0,0 +1,266 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package encstore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tailscale.com/ipn"
+)
+
+// staticKey is a KeyProvider for tests.
+type staticKey []byte
+
+func (k staticKey) Key() ([]byte, error) { return k, nil }
+func (k staticKey) String() string       { return "static" }
+
+func testKey(b byte) staticKey {
+	return bytes.Repeat([]byte{b}, KeySize)
+}
+
+func TestRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tailscaled.state")
+	s, err := NewFileStore(t.Logf, path, testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ReadState("foo"); err != ipn.ErrStateNotExist {
+		t.Fatalf("ReadState of missing key: %v", err)
+	}
+	if err := s.WriteState("foo", []byte("secret-node-key")); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("secret-node-key")) || bytes.Contains(raw, []byte(base64.StdEncoding.EncodeToString([]byte("secret-node-key")))) {
+		t.Fatalf("state file contains plaintext: %s", raw)
+	}
+
+	s2, err := NewFileStore(t.Logf, path, testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s2.ReadState("foo"); err != nil || string(got) != "secret-node-key" {
+		t.Errorf("ReadState after reopen = %q, %v", got, err)
+	}
+}
+
+func TestMigratePlaintext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tailscaled.state")
+	plain, err := json.Marshal(map[ipn.StateKey][]byte{
+		"_machinekey": []byte("mkey"),
+		"_daemon":     []byte("prefs"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, plain, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewFileStore(t.Logf, path, testKey(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.ReadState("_machinekey"); err != nil || string(got) != "mkey" {
+		t.Errorf("ReadState after migration = %q, %v", got, err)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(raw, plain) || !bytes.Contains(raw, []byte("TailscaleEncryptedState")) {
+		t.Fatalf("state file not encrypted after migration: %s", raw)
+	}
+
+	// And it can be reopened as an encrypted file.
+	s2, err := NewFileStore(t.Logf, path, testKey(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s2.ReadState("_daemon"); err != nil || string(got) != "prefs" {
+		t.Errorf("ReadState after reopen = %q, %v", got, err)
+	}
+}
+
+func TestCorruption(t *testing.T) {
+	newState := func(t *testing.T) (path string, raw []byte) {
+		path = filepath.Join(t.TempDir(), "tailscaled.state")
+		s, err := NewFileStore(t.Logf, path, testKey(3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.WriteState("foo", []byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+		raw, err = os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path, raw
+	}
+	modifyFile := func(t *testing.T, path string, raw []byte, f func(*encFile)) {
+		var ef encFile
+		if err := json.Unmarshal(raw, &ef); err != nil {
+			t.Fatal(err)
+		}
+		f(&ef)
+		b, err := json.Marshal(ef)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		key    staticKey
+		modify func(t *testing.T, path string, raw []byte)
+	}{
+		{
+			name:   "wrong_key",
+			key:    testKey(4),
+			modify: func(*testing.T, string, []byte) {},
+		},
+		{
+			name: "flipped_ciphertext_bit",
+			modify: func(t *testing.T, path string, raw []byte) {
+				modifyFile(t, path, raw, func(ef *encFile) { ef.Ciphertext[0] ^= 1 })
+			},
+		},
+		{
+			name: "flipped_nonce_bit",
+			modify: func(t *testing.T, path string, raw []byte) {
+				modifyFile(t, path, raw, func(ef *encFile) { ef.Nonce[0] ^= 1 })
+			},
+		},
+		{
+			name: "truncated_ciphertext",
+			modify: func(t *testing.T, path string, raw []byte) {
+				modifyFile(t, path, raw, func(ef *encFile) { ef.Ciphertext = ef.Ciphertext[:len(ef.Ciphertext)-1] })
+			},
+		},
+		{
+			name: "short_nonce",
+			modify: func(t *testing.T, path string, raw []byte) {
+				modifyFile(t, path, raw, func(ef *encFile) { ef.Nonce = ef.Nonce[:12] })
+			},
+		},
+		{
+			name: "truncated_file",
+			modify: func(t *testing.T, path string, raw []byte) {
+				if err := os.WriteFile(path, raw[:len(raw)/2], 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, raw := newState(t)
+			tt.modify(t, path, raw)
+			key := tt.key
+			if key == nil {
+				key = testKey(3)
+			}
+			_, err := NewFileStore(t.Logf, path, key)
+			if !errors.Is(err, ErrCorrupt) {
+				t.Fatalf("NewFileStore = %v; want ErrCorrupt", err)
+			}
+			// The file must be left alone for recovery.
+			if got, _ := os.ReadFile(path); len(got) == 0 {
+				t.Error("state file was truncated")
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	statePath := filepath.Join(dir, "tailscaled.state")
+	arg := "enc:" + statePath + "?key=file:" + keyFile
+
+	s, err := New(t.Logf, arg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteState("foo", []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(keyFile); err != nil {
+		t.Fatalf("key file not generated: %v", err)
+	} else if fi.Mode().Perm() != 0600 {
+		t.Errorf("key file mode = %v; want 0600", fi.Mode().Perm())
+	}
+
+	s2, err := New(t.Logf, arg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s2.ReadState("foo"); err != nil || string(got) != "bar" {
+		t.Errorf("ReadState after reopen = %q, %v", got, err)
+	}
+
+	for _, bad := range []string{
+		"enc:" + statePath,
+		"enc:?key=file:" + keyFile,
+		"enc:" + statePath + "?key=nope:x",
+		"enc:" + statePath + "?key=file",
+		"enc:" + statePath + "?key=env:",
+	} {
+		if _, err := New(t.Logf, bad); err == nil {
+			t.Errorf("New(%q) succeeded; want error", bad)
+		}
+	}
+}
+
+func TestEnvKey(t *testing.T) {
+	key := testKey(5)
+	for name, v := range map[string]string{
+		"hex":    hex.EncodeToString(key),
+		"base64": base64.StdEncoding.EncodeToString(key) + "\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("TS_TEST_STATE_KEY", v)
+			kp, err := NewKeyProvider("env:TS_TEST_STATE_KEY")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := kp.Key()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, key) {
+				t.Errorf("key = %x; want %x", got, key)
+			}
+			if strings.Contains(kp.String(), v) {
+				t.Errorf("String() includes the key: %q", kp.String())
+			}
+		})
+	}
+
+	t.Setenv("TS_TEST_STATE_KEY", "too short")
+	kp, err := NewKeyProvider("env:TS_TEST_STATE_KEY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kp.Key(); err == nil {
+		t.Error("short key accepted")
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package encstore
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"tailscale.com/atomicfile"
+	"tailscale.com/util/mak"
+)
+
+// KeySize is the size in bytes of the keys that Stores are encrypted with.
+const KeySize = chacha20poly1305.KeySize
+
+// A KeyProvider provides the key that a Store is encrypted with.
+type KeyProvider interface {
+	// Key returns the key, which must be KeySize bytes.
+	Key() ([]byte, error)
+
+	// String describes the provider for logs and errors. It must not
+	// include the key.
+	String() string
+}
+
+var keyProviders map[string]func(arg string) (KeyProvider, error)
+
+// RegisterKeyProvider registers newProvider to create the KeyProviders
+// for specs of the form "scheme:arg" (see NewKeyProvider). It panics if
+// scheme is empty or already registered.
+//
+// The "file" and "env" schemes are always registered; on Linux, "keyring"
+// and "sealed" are too.
+func RegisterKeyProvider(scheme string, newProvider func(arg string) (KeyProvider, error)) {
+	if scheme == "" {
+		panic("scheme is empty")
+	}
+	if _, ok := keyProviders[scheme]; ok {
+		panic(fmt.Sprintf("%q already registered", scheme))
+	}
+	mak.Set(&keyProviders, scheme, newProvider)
+}
+
+func init() {
+	RegisterKeyProvider("file", func(path string) (KeyProvider, error) {
+		if path == "" {
+			return nil, errors.New("file key provider needs a path")
+		}
+		return fileKey(path), nil
+	})
+	RegisterKeyProvider("env", func(name string) (KeyProvider, error) {
+		if name == "" {
+			return nil, errors.New("env key provider needs a variable name")
+		}
+		return envKey(name), nil
+	})
+}
+
+// NewKeyProvider returns the KeyProvider described by spec, which is of
+// the form "scheme:arg" for a scheme registered with
+// RegisterKeyProvider. The built-in schemes are:
+//
+//   - "file:PATH" reads the key from the file PATH, generating it if the
+//     file doesn't exist.
+//   - "env:NAME" reads the key from the environment variable NAME.
+//   - (Linux-only) "keyring:DESC" reads the key from the "user" key DESC
+//     in the kernel's session or user keyring, where it must have been
+//     added beforehand (such as with "keyctl padd user DESC @u").
+//   - (Linux-only) "sealed:PATH" reads the key from the file PATH, where
+//     it is encrypted with a key derived from the machine ID, generating it
+//     if the file doesn't exist. This binds the state to the machine
+//     without needing a TPM, but doesn't protect it from anyone who can
+//     read both the state and /etc/machine-id.
+//
+// Keys read from files, the environment or keyrings are either KeySize
+// raw bytes, or their hex or standard base64 encoding, optionally with
+// surrounding whitespace.
+func NewKeyProvider(spec string) (KeyProvider, error) {
+	scheme, arg, ok := strings.Cut(spec, ":")
+	if !ok {
+		return nil, fmt.Errorf("encstore: key provider %q is not of the form SCHEME:ARG", spec)
+	}
+	newProvider, ok := keyProviders[scheme]
+	if !ok {
+		return nil, fmt.Errorf("encstore: unknown key provider %q", scheme)
+	}
+	kp, err := newProvider(arg)
+	if err != nil {
+		return nil, fmt.Errorf("encstore: %w", err)
+	}
+	return kp, nil
+}
+
+// parseKey parses a key in one of the formats accepted by NewKeyProvider.
+func parseKey(b []byte) ([]byte, error) {
+	if len(b) == KeySize {
+		return b, nil
+	}
+	s := string(bytes.TrimSpace(b))
+	if k, err := hex.DecodeString(s); err == nil && len(k) == KeySize {
+		return k, nil
+	}
+	if k, err := base64.StdEncoding.DecodeString(s); err == nil && len(k) == KeySize {
+		return k, nil
+	}
+	return nil, fmt.Errorf("key is not %d bytes, in raw, hex or base64 form", KeySize)
+}
+
+// newKey returns a new random key.
+func newKey() ([]byte, error) {
+	k := make([]byte, KeySize)
+	if _, err := rand.Read(k); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// fileKey is a KeyProvider that reads the key from a file, generating it
+// if needed.
+type fileKey string
+
+func (p fileKey) String() string { return "file:" + string(p) }
+
+func (p fileKey) Key() ([]byte, error) {
+	b, err := os.ReadFile(string(p))
+	if os.IsNotExist(err) {
+		k, err := newKey()
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(string(p)), 0700); err != nil {
+			return nil, err
+		}
+		if err := atomicfile.WriteFile(string(p), []byte(hex.EncodeToString(k)+"\n"), 0600); err != nil {
+			return nil, err
+		}
+		return k, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseKey(b)
+}
+
+// envKey is a KeyProvider that reads the key from an environment
+// variable.
+type envKey string
+
+func (p envKey) String() string { return "env:" + string(p) }
+
+func (p envKey) Key() ([]byte, error) {
+	v := os.Getenv(string(p))
+	if v == "" {
+		return nil, fmt.Errorf("environment variable %s is not set", string(p))
+	}
+	return parseKey([]byte(v))
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package encstore
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/sys/unix"
+	"tailscale.com/atomicfile"
+)
+
+func init() {
+	RegisterKeyProvider("keyring", func(desc string) (KeyProvider, error) {
+		if desc == "" {
+			return nil, errors.New("keyring key provider needs a key description")
+		}
+		return keyringKey(desc), nil
+	})
+	RegisterKeyProvider("sealed", func(path string) (KeyProvider, error) {
+		if path == "" {
+			return nil, errors.New("sealed key provider needs a path")
+		}
+		return sealedKey(path), nil
+	})
+}
+
+// keyringKey is a KeyProvider that reads the key from a "user" key in the
+// kernel keyring.
+type keyringKey string
+
+func (p keyringKey) String() string { return "keyring:" + string(p) }
+
+func (p keyringKey) Key() ([]byte, error) {
+	for _, ring := range []int{unix.KEY_SPEC_SESSION_KEYRING, unix.KEY_SPEC_USER_KEYRING} {
+		id, err := unix.KeyctlSearch(ring, "user", string(p), 0)
+		if err != nil {
+			continue
+		}
+		buf := make([]byte, 512)
+		n, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, buf, 0)
+		if err != nil {
+			return nil, fmt.Errorf("reading key %q from keyring: %w", string(p), err)
+		}
+		if n > len(buf) {
+			return nil, fmt.Errorf("key %q in keyring is too large", string(p))
+		}
+		return parseKey(buf[:n])
+	}
+	return nil, fmt.Errorf("no user key %q in the session or user keyring", string(p))
+}
+
+// machineIDFiles are the files that sealedKey reads the machine ID from,
+// in order of preference.
+var machineIDFiles = []string{"/etc/machine-id", "/var/lib/dbus/machine-id"}
+
+// sealingKey returns the key that sealedKey encrypts keys with, derived
+// from the machine ID.
+func sealingKey() ([]byte, error) {
+	for _, f := range machineIDFiles {
+		id, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		if id = bytes.TrimSpace(id); len(id) == 0 {
+			continue
+		}
+		h := sha256.New()
+		h.Write([]byte("tailscale.com/ipn/store/encstore sealing key\x00"))
+		h.Write(id)
+		return h.Sum(nil), nil
+	}
+	return nil, errors.New("no machine ID found to seal the key with")
+}
+
+// sealedKey is a KeyProvider that reads the key from a file, in which it
+// is encrypted with a key derived from the machine ID. If the file
+// doesn't exist, a new key is generated and sealed to it.
+type sealedKey string
+
+func (p sealedKey) String() string { return "sealed:" + string(p) }
+
+func (p sealedKey) Key() ([]byte, error) {
+	sk, err := sealingKey()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(sk)
+	if err != nil {
+		return nil, err
+	}
+	ad := []byte("tailscale.com/ipn/store/encstore sealed key")
+
+	b, err := os.ReadFile(string(p))
+	if os.IsNotExist(err) {
+		k, err := newKey()
+		if err != nil {
+			return nil, err
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(string(p)), 0700); err != nil {
+			return nil, err
+		}
+		if err := atomicfile.WriteFile(string(p), aead.Seal(nonce, nonce, k, ad), 0600); err != nil {
+			return nil, err
+		}
+		return k, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < aead.NonceSize() {
+		return nil, fmt.Errorf("sealed key file %s is truncated", string(p))
+	}
+	k, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], ad)
+	if err != nil {
+		return nil, fmt.Errorf("sealed key file %s is corrupted or was sealed on another machine", string(p))
+	}
+	return k, nil
+}
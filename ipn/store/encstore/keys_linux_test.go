@@ -0,0 +1,75 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package encstore
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestSealedKey(t *testing.T) {
+	dir := t.TempDir()
+	idFile := filepath.Join(dir, "machine-id")
+	if err := os.WriteFile(idFile, []byte("0123456789abcdef\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := machineIDFiles
+	machineIDFiles = []string{idFile}
+	t.Cleanup(func() { machineIDFiles = old })
+
+	kp := sealedKey(filepath.Join(dir, "state.key"))
+	k1, err := kp.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	k2, err := kp.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(k1, k2) {
+		t.Fatal("sealed key changed between calls")
+	}
+	raw, err := os.ReadFile(string(kp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, k1) {
+		t.Fatal("sealed key file contains the key in the clear")
+	}
+
+	// On another machine, the key can't be unsealed.
+	if err := os.WriteFile(idFile, []byte("fedcba9876543210\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kp.Key(); err == nil {
+		t.Error("sealed key unsealed with a different machine ID")
+	}
+}
+
+func TestKeyringKey(t *testing.T) {
+	key := testKey(6)
+	desc := "tailscale-encstore-test"
+	id, err := unix.AddKey("user", desc, []byte(hex.EncodeToString(key)), unix.KEY_SPEC_SESSION_KEYRING)
+	if err != nil {
+		t.Skipf("can't add key to session keyring: %v", err)
+	}
+	defer unix.KeyctlInt(unix.KEYCTL_UNLINK, id, unix.KEY_SPEC_SESSION_KEYRING, 0, 0)
+
+	got, err := keyringKey(desc).Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, key) {
+		t.Errorf("key = %x; want %x", got, key)
+	}
+	if _, err := keyringKey("tailscale-encstore-test-missing").Key(); err == nil {
+		t.Error("missing keyring key found")
+	}
+}
@@ -8,6 +8,7 @@
 
 import (
 	"context"
+	"net/http"
 	"time"
 
 	"tailscale.com/ipn"
@@ -55,31 +56,46 @@ func (s *Store) ReadState(id ipn.StateKey) ([]byte, error) {
 }
 
 // WriteState implements the StateStore interface.
+//
+// Only the key id of the secret is written, so concurrent writes of other
+// keys, by this or other processes, aren't lost.
 func (s *Store) WriteState(id ipn.StateKey, bs []byte) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	secret, err := s.client.GetSecret(ctx, s.secretName)
-	if err != nil {
-		if st, ok := err.(*kube.Status); ok && st.Code == 404 {
-			return s.client.CreateSecret(ctx, &kube.Secret{
-				TypeMeta: kube.TypeMeta{
-					APIVersion: "v1",
-					Kind:       "Secret",
-				},
-				ObjectMeta: kube.ObjectMeta{
-					Name: s.secretName,
-				},
-				Data: map[string][]byte{
-					string(id): bs,
-				},
+	patch := map[string]any{
+		"data": map[string][]byte{string(id): bs},
+	}
+	ns := s.client.Namespace()
+	return kube.RetryOnConflict(ctx, func() error {
+		err := s.client.Patch(ctx, kube.Secrets, ns, s.secretName, kube.StrategicMergePatch, patch, nil)
+		if st, ok := err.(*kube.Status); ok && st.Code == http.StatusForbidden {
+			// Roles set up for older versions only grant "update" on
+			// the secret, so fall back to replacing it.
+			_, err = kube.Modify(ctx, s.client, kube.Secrets, ns, s.secretName, func(secret *kube.Secret) error {
+				if secret.Data == nil {
+					secret.Data = map[string][]byte{}
+				}
+				secret.Data[string(id)] = bs
+				return nil
 			})
 		}
-		return err
-	}
-	secret.Data[string(id)] = bs
-	if err := s.client.UpdateSecret(ctx, secret); err != nil {
-		return err
-	}
-	return err
+		if !kube.IsNotFound(err) {
+			return err
+		}
+		// If the secret is created concurrently, this fails with a
+		// conflict and the patch is retried.
+		return s.client.CreateSecret(ctx, &kube.Secret{
+			TypeMeta: kube.TypeMeta{
+				APIVersion: "v1",
+				Kind:       "Secret",
+			},
+			ObjectMeta: kube.ObjectMeta{
+				Name: s.secretName,
+			},
+			Data: map[string][]byte{
+				string(id): bs,
+			},
+		})
+	})
 }
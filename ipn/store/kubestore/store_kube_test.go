@@ -0,0 +1,123 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kubestore
+
+import (
+	"context"
+	"testing"
+
+	"tailscale.com/ipn"
+	"tailscale.com/kube"
+	"tailscale.com/kube/kubetest"
+)
+
+func newTestStore(t *testing.T) (*Store, *kubetest.Server) {
+	srv := kubetest.New(t)
+	return &Store{client: srv.Client("default"), secretName: "ts-state"}, srv
+}
+
+func TestKubeStore(t *testing.T) {
+	store, _ := newTestStore(t)
+	testStoreSemantics(t, store)
+}
+
+func TestWriteStateConcurrentChange(t *testing.T) {
+	store, srv := newTestStore(t)
+	if err := store.WriteState("foo", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another writer changes the secret while WriteState is in flight.
+	other := srv.Client("default")
+	var injected bool
+	srv.OnWrite = func(method, path string) {
+		if injected {
+			return
+		}
+		injected = true
+		_, err := kube.Modify(context.Background(), other, kube.Secrets, "default", "ts-state", func(s *kube.Secret) error {
+			s.Data["other"] = []byte("x")
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if err := store.WriteState("bar", []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	for k, want := range map[ipn.StateKey]string{"foo": "1", "bar": "2", "other": "x"} {
+		got, err := store.ReadState(k)
+		if err != nil || string(got) != want {
+			t.Errorf("ReadState(%q) = %q, %v; want %q", k, got, err, want)
+		}
+	}
+}
+
+func testStoreSemantics(t *testing.T, store ipn.StateStore) {
+	t.Helper()
+
+	tests := []struct {
+		// if true, data is data to write. If false, data is expected
+		// output of read.
+		write bool
+		id    ipn.StateKey
+		data  string
+		// If write=false, true if we expect a not-exist error.
+		notExists bool
+	}{
+		{
+			id:        "foo",
+			notExists: true,
+		},
+		{
+			write: true,
+			id:    "foo",
+			data:  "bar",
+		},
+		{
+			id:   "foo",
+			data: "bar",
+		},
+		{
+			id:        "baz",
+			notExists: true,
+		},
+		{
+			write: true,
+			id:    "baz",
+			data:  "quux",
+		},
+		{
+			id:   "foo",
+			data: "bar",
+		},
+		{
+			id:   "baz",
+			data: "quux",
+		},
+	}
+
+	for _, test := range tests {
+		if test.write {
+			if err := store.WriteState(test.id, []byte(test.data)); err != nil {
+				t.Errorf("writing %q to %q: %v", test.data, test.id, err)
+			}
+		} else {
+			bs, err := store.ReadState(test.id)
+			if err != nil {
+				if test.notExists && err == ipn.ErrStateNotExist {
+					continue
+				}
+				t.Errorf("reading %q: %v", test.id, err)
+				continue
+			}
+			if string(bs) != test.data {
+				t.Errorf("reading %q: got %q, want %q", test.id, string(bs), test.data)
+			}
+		}
+	}
+}
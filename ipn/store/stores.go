@@ -18,6 +18,7 @@
 
 	"tailscale.com/atomicfile"
 	"tailscale.com/ipn"
+	"tailscale.com/ipn/store/encstore"
 	"tailscale.com/ipn/store/mem"
 	"tailscale.com/paths"
 	"tailscale.com/types/logger"
@@ -34,6 +35,7 @@
 
 func registerDefaultStores() {
 	Register("mem:", mem.New)
+	Register("enc:", encstore.New)
 
 	if registerAvailableExternalStores != nil {
 		registerAvailableExternalStores()
@@ -51,6 +53,10 @@ func registerDefaultStores() {
 //
 //   - if the string begins with "mem:", the suffix
 //     is ignored and an in-memory store is used.
+//   - if the string begins with "enc:", it is of the form
+//     "enc:PATH?key=SCHEME:ARG" and the state is kept in the
+//     file PATH, encrypted with the key from the key provider
+//     SCHEME:ARG (see encstore.NewKeyProvider).
 //   - (Linux-only) if the string begins with "arn:",
 //     the suffix an AWS ARN for an SSM.
 //   - (Linux-only) if the string begins with "kube:",
@@ -186,3 +186,320 @@ type Status struct {
 func (s *Status) Error() string {
 	return s.Message
 }
+
+// ListMeta describes metadata that synthetic resources must have, including
+// lists and various status objects.
+type ListMeta struct {
+	// String that identifies the server's internal version of this object that
+	// can be used by clients to determine when objects have changed.
+	// Value must be treated as opaque by clients and passed unmodified back to the server.
+	// Populated by the system.
+	// Read-only.
+	// More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#concurrency-control-and-consistency
+	// +optional
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+// SecretList is a list of Secrets.
+type SecretList struct {
+	TypeMeta `json:",inline"`
+	ListMeta `json:"metadata"`
+
+	Items []Secret `json:"items"`
+}
+
+// Service is a named abstraction of software service (for example, mysql)
+// consisting of local port (for example 3306) that the proxy listens on, and
+// the selector that determines which pods will answer requests sent through
+// the proxy.
+type Service struct {
+	TypeMeta   `json:",inline"`
+	ObjectMeta `json:"metadata"`
+
+	// Spec defines the behavior of a service.
+	// +optional
+	Spec ServiceSpec `json:"spec,omitempty"`
+}
+
+// ServiceSpec describes the attributes that a user creates on a service.
+type ServiceSpec struct {
+	// Ports is the list of ports that are exposed by this service.
+	// +optional
+	Ports []ServicePort `json:"ports,omitempty"`
+
+	// Route service traffic to pods with label keys and values matching this
+	// selector.
+	// +optional
+	Selector map[string]string `json:"selector,omitempty"`
+
+	// ClusterIP is the IP address of the service and is usually assigned
+	// randomly. If it is "None", the service is headless.
+	// +optional
+	ClusterIP string `json:"clusterIP,omitempty"`
+
+	// Type determines how the Service is exposed. Defaults to ClusterIP.
+	// Valid options are ExternalName, ClusterIP, NodePort, and LoadBalancer.
+	// +optional
+	Type string `json:"type,omitempty"`
+}
+
+// ServicePort contains information on service's port.
+type ServicePort struct {
+	// The name of this port within the service.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// The IP protocol for this port. Supports "TCP", "UDP", and "SCTP".
+	// Default is TCP.
+	// +optional
+	Protocol string `json:"protocol,omitempty"`
+
+	// The port that will be exposed by this service.
+	Port int32 `json:"port"`
+}
+
+// ServiceList holds a list of services.
+type ServiceList struct {
+	TypeMeta `json:",inline"`
+	ListMeta `json:"metadata"`
+
+	Items []Service `json:"items"`
+}
+
+// StatefulSet represents a set of pods with consistent identities.
+type StatefulSet struct {
+	TypeMeta   `json:",inline"`
+	ObjectMeta `json:"metadata"`
+
+	// Spec defines the desired identities of pods in this set.
+	// +optional
+	Spec StatefulSetSpec `json:"spec,omitempty"`
+}
+
+// StatefulSetSpec is the specification of a StatefulSet.
+type StatefulSetSpec struct {
+	// Replicas is the desired number of replicas of the given Template.
+	// If unspecified, defaults to 1.
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// Selector is a label query over pods that should match the replica count.
+	// It must match the pod template's labels.
+	Selector *LabelSelector `json:"selector"`
+
+	// Template is the object that describes the pod that will be created if
+	// insufficient replicas are detected.
+	Template PodTemplateSpec `json:"template"`
+
+	// ServiceName is the name of the service that governs this StatefulSet.
+	ServiceName string `json:"serviceName"`
+}
+
+// StatefulSetList is a collection of StatefulSets.
+type StatefulSetList struct {
+	TypeMeta `json:",inline"`
+	ListMeta `json:"metadata"`
+
+	Items []StatefulSet `json:"items"`
+}
+
+// LabelSelector is a label query over a set of resources.
+type LabelSelector struct {
+	// MatchLabels is a map of {key,value} pairs.
+	// +optional
+	MatchLabels map[string]string `json:"matchLabels,omitempty"`
+}
+
+// PodTemplateSpec describes the data a pod should have when created from a
+// template.
+type PodTemplateSpec struct {
+	// Standard object's metadata.
+	// +optional
+	ObjectMeta `json:"metadata,omitempty"`
+
+	// Specification of the desired behavior of the pod.
+	// +optional
+	Spec PodSpec `json:"spec,omitempty"`
+}
+
+// PodSpec is a description of a pod.
+type PodSpec struct {
+	// List of initialization containers belonging to the pod.
+	// +optional
+	InitContainers []Container `json:"initContainers,omitempty"`
+
+	// List of containers belonging to the pod.
+	Containers []Container `json:"containers"`
+
+	// ServiceAccountName is the name of the ServiceAccount to use to run
+	// this pod.
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+}
+
+// Container is a single application container that you want to run within
+// a pod.
+type Container struct {
+	// Name of the container specified as a DNS_LABEL.
+	Name string `json:"name"`
+
+	// Container image name.
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// Entrypoint array. Not executed within a shell.
+	// +optional
+	Command []string `json:"command,omitempty"`
+
+	// Arguments to the entrypoint.
+	// +optional
+	Args []string `json:"args,omitempty"`
+
+	// List of environment variables to set in the container.
+	// +optional
+	Env []EnvVar `json:"env,omitempty"`
+
+	// Image pull policy. One of Always, Never, IfNotPresent.
+	// +optional
+	ImagePullPolicy string `json:"imagePullPolicy,omitempty"`
+
+	// SecurityContext defines the security options the container should be
+	// run with.
+	// +optional
+	SecurityContext *SecurityContext `json:"securityContext,omitempty"`
+}
+
+// EnvVar represents an environment variable present in a Container.
+type EnvVar struct {
+	// Name of the environment variable. Must be a C_IDENTIFIER.
+	Name string `json:"name"`
+
+	// Value of the environment variable.
+	// +optional
+	Value string `json:"value,omitempty"`
+
+	// Source for the environment variable's value. Cannot be used if value
+	// is not empty.
+	// +optional
+	ValueFrom *EnvVarSource `json:"valueFrom,omitempty"`
+}
+
+// EnvVarSource represents a source for the value of an EnvVar.
+type EnvVarSource struct {
+	// Selects a key of a secret in the pod's namespace.
+	// +optional
+	SecretKeyRef *SecretKeySelector `json:"secretKeyRef,omitempty"`
+}
+
+// SecretKeySelector selects a key of a Secret.
+type SecretKeySelector struct {
+	// Name of the referent.
+	Name string `json:"name"`
+
+	// The key of the secret to select from. Must be a valid secret key.
+	Key string `json:"key"`
+
+	// Specify whether the Secret or its key must be defined.
+	// +optional
+	Optional *bool `json:"optional,omitempty"`
+}
+
+// SecurityContext holds security configuration that will be applied to a
+// container.
+type SecurityContext struct {
+	// The capabilities to add/drop when running containers.
+	// +optional
+	Capabilities *Capabilities `json:"capabilities,omitempty"`
+
+	// Run container in privileged mode.
+	// +optional
+	Privileged *bool `json:"privileged,omitempty"`
+}
+
+// Capabilities adds and removes POSIX capabilities from running containers.
+type Capabilities struct {
+	// Added capabilities.
+	// +optional
+	Add []string `json:"add,omitempty"`
+
+	// Removed capabilities.
+	// +optional
+	Drop []string `json:"drop,omitempty"`
+}
+
+// ServiceAccount provides an identity for processes that run in a pod.
+type ServiceAccount struct {
+	TypeMeta   `json:",inline"`
+	ObjectMeta `json:"metadata"`
+}
+
+// Role is a namespaced set of PolicyRules that can be granted with a
+// RoleBinding.
+type Role struct {
+	TypeMeta   `json:",inline"`
+	ObjectMeta `json:"metadata"`
+
+	// Rules holds all the PolicyRules for this Role.
+	// +optional
+	Rules []PolicyRule `json:"rules"`
+}
+
+// PolicyRule holds information that describes a policy rule.
+type PolicyRule struct {
+	// Verbs is a list of verbs that apply to all the resources of the
+	// rule, such as "get" or "update".
+	Verbs []string `json:"verbs"`
+
+	// APIGroups is the name of the API groups that contain the
+	// resources. "" is the core API group.
+	// +optional
+	APIGroups []string `json:"apiGroups,omitempty"`
+
+	// Resources is a list of resources this rule applies to.
+	// +optional
+	Resources []string `json:"resources,omitempty"`
+
+	// ResourceNames is an optional allowlist of names that the rule
+	// applies to. An empty set means that everything is allowed.
+	// +optional
+	ResourceNames []string `json:"resourceNames,omitempty"`
+}
+
+// RoleBinding grants the permissions of a Role to Subjects.
+type RoleBinding struct {
+	TypeMeta   `json:",inline"`
+	ObjectMeta `json:"metadata"`
+
+	// Subjects holds references to the objects the role applies to.
+	// +optional
+	Subjects []Subject `json:"subjects,omitempty"`
+
+	// RoleRef references the Role that is granted.
+	RoleRef RoleRef `json:"roleRef"`
+}
+
+// Subject is a user, group or service account that a RoleBinding applies
+// to.
+type Subject struct {
+	// Kind of object being referenced, such as "ServiceAccount".
+	Kind string `json:"kind"`
+
+	// Name of the object being referenced.
+	Name string `json:"name"`
+
+	// Namespace of the referenced object, for service accounts.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// RoleRef contains information that points to the role being used.
+type RoleRef struct {
+	// APIGroup is the group of the referenced role.
+	APIGroup string `json:"apiGroup"`
+
+	// Kind is the type of the referenced role, such as "Role".
+	Kind string `json:"kind"`
+
+	// Name is the name of the referenced role.
+	Name string `json:"name"`
+}
@@ -32,7 +32,7 @@ func readFile(n string) ([]byte, error) {
 }
 
 // Client handles connections to Kubernetes.
-// It expects to be run inside a cluster.
+// It expects to be run inside a cluster, unless created with NewStatic.
 type Client struct {
 	mu          sync.Mutex
 	url         string
@@ -40,6 +40,7 @@ type Client struct {
 	client      *http.Client
 	token       string
 	tokenExpiry time.Time
+	staticToken bool // token is fixed and not read from saPath
 }
 
 // New returns a new client
@@ -69,6 +70,30 @@ func New() (*Client, error) {
 	}, nil
 }
 
+// NewStatic returns a client for the API server at baseURL that uses the
+// namespace ns and the fixed bearer token, instead of the service account
+// of the pod it runs in. If hc is nil, http.DefaultClient is used.
+//
+// It is intended for tests and for running outside of a cluster.
+func NewStatic(baseURL, ns, token string, hc *http.Client) *Client {
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	return &Client{
+		url:         baseURL,
+		ns:          ns,
+		client:      hc,
+		token:       token,
+		staticToken: true,
+	}
+}
+
+// Namespace returns the namespace that the client's namespaced methods
+// operate in.
+func (c *Client) Namespace() string {
+	return c.ns
+}
+
 func (c *Client) expireToken() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -79,7 +104,7 @@ func (c *Client) getOrRenewToken() (string, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	tk, te := c.token, c.tokenExpiry
-	if time.Now().Before(te) {
+	if c.staticToken || time.Now().Before(te) {
 		return tk, nil
 	}
 
@@ -92,15 +117,26 @@ func (c *Client) getOrRenewToken() (string, error) {
 	return c.token, nil
 }
 
-func (c *Client) secretURL(name string) string {
-	if name == "" {
-		return fmt.Sprintf("%s/api/v1/namespaces/%s/secrets", c.url, c.ns)
+// resourceURL returns the URL of the object name of type r in namespace
+// ns. If name is empty, it returns the URL of the collection of such
+// objects instead, across all namespaces if ns is also empty.
+func (c *Client) resourceURL(r Resource, ns, name string) string {
+	u := c.url + "/api/" + r.Version
+	if r.Group != "" {
+		u = c.url + "/apis/" + r.Group + "/" + r.Version
 	}
-	return fmt.Sprintf("%s/api/v1/namespaces/%s/secrets/%s", c.url, c.ns, name)
+	if ns != "" {
+		u += "/namespaces/" + ns
+	}
+	u += "/" + r.Name
+	if name != "" {
+		u += "/" + name
+	}
+	return u
 }
 
 func getError(resp *http.Response) error {
-	if resp.StatusCode == 200 {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
 		return nil
 	}
 	st := &Status{}
@@ -110,39 +146,55 @@ func getError(resp *http.Response) error {
 	return st
 }
 
-func (c *Client) doRequest(ctx context.Context, method, url string, in, out any) error {
+// send sends a request with the JSON encoding of in, if non-nil, as its
+// body with the content type ct. It returns the response if its status is
+// successful, and the Status error from the API otherwise.
+func (c *Client) send(ctx context.Context, method, url, ct string, in any) (*http.Response, error) {
 	tk, err := c.getOrRenewToken()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	var body io.Reader
 	if in != nil {
 		var b bytes.Buffer
 		if err := json.NewEncoder(&b).Encode(in); err != nil {
-			return err
+			return nil, err
 		}
 		body = &b
 	}
 	req, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if body != nil {
-		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("Content-Type", ct)
 	}
 	req.Header.Add("Accept", "application/json")
 	req.Header.Add("Authorization", "Bearer "+tk)
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
 	if err := getError(resp); err != nil {
+		resp.Body.Close()
 		if st, ok := err.(*Status); ok && st.Code == 401 {
 			c.expireToken()
 		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *Client) doRequest(ctx context.Context, method, url string, in, out any) error {
+	return c.doRequestWithContentType(ctx, method, url, "application/json", in, out)
+}
+
+func (c *Client) doRequestWithContentType(ctx context.Context, method, url, ct string, in, out any) error {
+	resp, err := c.send(ctx, method, url, ct, in)
+	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 	if out != nil {
 		return json.NewDecoder(resp.Body).Decode(out)
 	}
@@ -151,20 +203,90 @@ func (c *Client) doRequest(ctx context.Context, method, url string, in, out any)
 
 // GetSecret fetches the secret from the Kubernetes API.
 func (c *Client) GetSecret(ctx context.Context, name string) (*Secret, error) {
-	s := &Secret{Data: make(map[string][]byte)}
-	if err := c.doRequest(ctx, "GET", c.secretURL(name), nil, s); err != nil {
+	s, err := Get[Secret](ctx, c, Secrets, c.ns, name)
+	if err != nil {
 		return nil, err
 	}
+	if s.Data == nil {
+		s.Data = make(map[string][]byte)
+	}
 	return s, nil
 }
 
 // CreateSecret creates a secret in the Kubernetes API.
 func (c *Client) CreateSecret(ctx context.Context, s *Secret) error {
 	s.Namespace = c.ns
-	return c.doRequest(ctx, "POST", c.secretURL(""), s, nil)
+	_, err := Create(ctx, c, Secrets, c.ns, s)
+	return err
 }
 
 // UpdateSecret updates a secret in the Kubernetes API.
 func (c *Client) UpdateSecret(ctx context.Context, s *Secret) error {
-	return c.doRequest(ctx, "PUT", c.secretURL(s.Name), s, nil)
+	_, err := Update(ctx, c, Secrets, c.ns, s.Name, s)
+	return err
+}
+
+// DeleteSecret deletes the named secret from the Kubernetes API.
+func (c *Client) DeleteSecret(ctx context.Context, name string) error {
+	return c.Delete(ctx, Secrets, c.ns, name)
+}
+
+// ListSecrets lists the secrets in the client's namespace that match the
+// label selector sel. An empty sel matches all secrets.
+func (c *Client) ListSecrets(ctx context.Context, sel string) ([]Secret, error) {
+	items, _, err := List[Secret](ctx, c, Secrets, c.ns, ListOptions{LabelSelector: sel})
+	return items, err
+}
+
+// ListServices lists the services in namespace ns, or in all namespaces if
+// ns is empty.
+func (c *Client) ListServices(ctx context.Context, ns string) ([]Service, error) {
+	items, _, err := List[Service](ctx, c, Services, ns, ListOptions{})
+	return items, err
+}
+
+// GetStatefulSet fetches the named StatefulSet from the Kubernetes API.
+func (c *Client) GetStatefulSet(ctx context.Context, name string) (*StatefulSet, error) {
+	return Get[StatefulSet](ctx, c, StatefulSets, c.ns, name)
+}
+
+// ListStatefulSets lists the StatefulSets in the client's namespace that
+// match the label selector sel. An empty sel matches all StatefulSets.
+func (c *Client) ListStatefulSets(ctx context.Context, sel string) ([]StatefulSet, error) {
+	items, _, err := List[StatefulSet](ctx, c, StatefulSets, c.ns, ListOptions{LabelSelector: sel})
+	return items, err
+}
+
+// CreateStatefulSet creates a StatefulSet in the Kubernetes API.
+func (c *Client) CreateStatefulSet(ctx context.Context, s *StatefulSet) error {
+	s.Namespace = c.ns
+	_, err := Create(ctx, c, StatefulSets, c.ns, s)
+	return err
+}
+
+// UpdateStatefulSet updates a StatefulSet in the Kubernetes API.
+func (c *Client) UpdateStatefulSet(ctx context.Context, s *StatefulSet) error {
+	_, err := Update(ctx, c, StatefulSets, c.ns, s.Name, s)
+	return err
+}
+
+// DeleteStatefulSet deletes the named StatefulSet from the Kubernetes API.
+func (c *Client) DeleteStatefulSet(ctx context.Context, name string) error {
+	return c.Delete(ctx, StatefulSets, c.ns, name)
+}
+
+// IsNotFound reports whether err is a Status error from the Kubernetes API
+// with code 404.
+func IsNotFound(err error) bool {
+	st, ok := err.(*Status)
+	return ok && st.Code == http.StatusNotFound
+}
+
+// IsConflict reports whether err is a Status error from the Kubernetes API
+// with code 409. That's the case when an update was based on an outdated
+// resourceVersion of the object, or when creating an object that already
+// exists.
+func IsConflict(err error) bool {
+	st, ok := err.(*Status)
+	return ok && st.Code == http.StatusConflict
 }
@@ -0,0 +1,208 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kube_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"tailscale.com/kube"
+	"tailscale.com/kube/kubetest"
+)
+
+func createSecret(t *testing.T, c *kube.Client, name string, data map[string]string) *kube.Secret {
+	t.Helper()
+	s := &kube.Secret{
+		ObjectMeta: kube.ObjectMeta{Name: name, Labels: map[string]string{"app": "test"}},
+		Data:       map[string][]byte{},
+	}
+	for k, v := range data {
+		s.Data[k] = []byte(v)
+	}
+	out, err := kube.Create(context.Background(), c, kube.Secrets, c.Namespace(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func secretData(t *testing.T, c *kube.Client, name string) map[string]string {
+	t.Helper()
+	s, err := c.GetSecret(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := map[string]string{}
+	for k, v := range s.Data {
+		m[k] = string(v)
+	}
+	return m
+}
+
+func TestGetList(t *testing.T) {
+	srv := kubetest.New(t)
+	c := srv.Client("ns1")
+	ctx := context.Background()
+	createSecret(t, c, "a", nil)
+	createSecret(t, c, "b", nil)
+	createSecret(t, srv.Client("ns2"), "c", nil)
+
+	items, rv, err := kube.List[kube.Secret](ctx, c, kube.Secrets, "", kube.ListOptions{LabelSelector: "app=test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, s := range items {
+		names = append(names, s.Namespace+"/"+s.Name)
+	}
+	if want := []string{"ns1/a", "ns1/b", "ns2/c"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("listed %v; want %v", names, want)
+	}
+	if rv == "" {
+		t.Error("list has no resourceVersion")
+	}
+
+	if _, err := kube.Get[kube.Secret](ctx, c, kube.Secrets, "ns1", "c"); !kube.IsNotFound(err) {
+		t.Errorf("Get of secret in other namespace: %v; want not found", err)
+	}
+	if _, err := kube.Create(ctx, c, kube.Secrets, "ns1", &kube.Secret{ObjectMeta: kube.ObjectMeta{Name: "a"}}); !kube.IsConflict(err) {
+		t.Errorf("Create of existing secret: %v; want conflict", err)
+	}
+}
+
+func TestPatch(t *testing.T) {
+	srv := kubetest.New(t)
+	c := srv.Client("default")
+	ctx := context.Background()
+	old := createSecret(t, c, "s", map[string]string{"a": "1", "b": "2"})
+
+	err := c.Patch(ctx, kube.Secrets, "default", "s", kube.StrategicMergePatch, map[string]any{
+		"data": map[string]any{"a": []byte("3"), "b": nil},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := secretData(t, c, "s"), map[string]string{"a": "3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after merge patch: %v; want %v", got, want)
+	}
+
+	var out kube.Secret
+	err = c.Patch(ctx, kube.Secrets, "default", "s", kube.JSONPatch, []kube.JSONPatchOp{
+		{Op: "add", Path: "/data/c", Value: []byte("4")},
+		{Op: "remove", Path: "/data/a"},
+	}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(out.Data["c"]); got != "4" || len(out.Data) != 1 {
+		t.Errorf("after JSON patch: %v", out.Data)
+	}
+
+	// Patches with a stale resourceVersion conflict.
+	err = c.Patch(ctx, kube.Secrets, "default", "s", kube.MergePatch, map[string]any{
+		"metadata": map[string]any{"resourceVersion": old.ResourceVersion},
+		"data":     map[string]any{"d": []byte("5")},
+	}, nil)
+	if !kube.IsConflict(err) {
+		t.Errorf("patch with stale resourceVersion: %v; want conflict", err)
+	}
+}
+
+func TestModifyRetriesOnConflict(t *testing.T) {
+	srv := kubetest.New(t)
+	c := srv.Client("default")
+	ctx := context.Background()
+	createSecret(t, c, "s", map[string]string{"n": "0"})
+
+	calls := 0
+	out, err := kube.Modify(ctx, c, kube.Secrets, "default", "s", func(s *kube.Secret) error {
+		calls++
+		if calls == 1 {
+			// Change the secret behind Modify's back.
+			_, err := kube.Update(ctx, c, kube.Secrets, "default", "s", &kube.Secret{
+				ObjectMeta: kube.ObjectMeta{Name: "s"},
+				Data:       map[string][]byte{"n": []byte("1"), "other": []byte("x")},
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}
+		s.Data["n"] = append(s.Data["n"], '!')
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("mutate called %d times; want 2", calls)
+	}
+	if got, want := string(out.Data["n"]), "1!"; got != want {
+		t.Errorf("n = %q; want %q", got, want)
+	}
+	if got := string(out.Data["other"]); got != "x" {
+		t.Errorf("concurrent change lost: other = %q", got)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	srv := kubetest.New(t)
+	c := srv.Client("default")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	createSecret(t, c, "existing", nil)
+	_, rv, err := kube.List[kube.Secret](ctx, c, kube.Secrets, "default", kube.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := kube.Watch[kube.Secret](ctx, c, kube.Secrets, "default", kube.ListOptions{ResourceVersion: rv})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	createSecret(t, c, "new", map[string]string{"k": "v"})
+	if err := c.Patch(ctx, kube.Secrets, "default", "new", kube.MergePatch, map[string]any{"data": map[string]any{"k": []byte("w")}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteSecret(ctx, "new"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		typ  kube.EventType
+		data string
+	}{
+		{kube.EventAdded, "v"},
+		{kube.EventModified, "w"},
+		{kube.EventDeleted, "w"},
+	}
+	for _, want := range want {
+		ev, err := w.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev.Type != want.typ || ev.Object.Name != "new" || string(ev.Object.Data["k"]) != want.data {
+			t.Errorf("got %s event for %q with data %q; want %s event with %q", ev.Type, ev.Object.Name, ev.Object.Data["k"], want.typ, want.data)
+		}
+	}
+
+	// Without a resourceVersion, the watch starts with the existing
+	// objects.
+	w2, err := kube.Watch[kube.Secret](ctx, c, kube.Secrets, "default", kube.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w2.Close()
+	ev, err := w2.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != kube.EventAdded || ev.Object.Name != "existing" {
+		t.Errorf("got %s event for %q; want ADDED for existing", ev.Type, ev.Object.Name)
+	}
+}
@@ -0,0 +1,475 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package kubetest provides an in-memory fake of the Kubernetes API server
+// for testing code that uses kube.Client.
+package kubetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tailscale.com/kube"
+)
+
+// token is the bearer token that the Server requires.
+const token = "kubetest-token"
+
+// Server is a fake Kubernetes API server. It stores objects of any
+// resource type as JSON and supports creating, getting, listing and
+// watching (with equality-based label selectors), replacing, patching and
+// deleting them. Writes that carry a stale resourceVersion are rejected
+// with a conflict, as the real API server does.
+//
+// Strategic merge patches are applied like JSON merge patches, so lists
+// are replaced rather than merged.
+type Server struct {
+	srv *httptest.Server
+
+	mu      sync.Mutex
+	objs    map[string]map[string]any // path of object => object
+	events  []event
+	changed chan struct{} // closed and replaced when events grows
+	lastRV  int
+	lastUID int
+
+	// OnWrite, if non-nil, is called before each create, update, patch
+	// or delete request is applied, with the request's method and path.
+	// Tests can use it to inject concurrent changes.
+	OnWrite func(method, path string)
+}
+
+// event is a change to an object, for watches.
+type event struct {
+	rv   int
+	typ  kube.EventType
+	path string
+	obj  map[string]any
+}
+
+// New returns a new Server that is shut down when tb's test completes.
+func New(tb testing.TB) *Server {
+	s := &Server{
+		objs:    make(map[string]map[string]any),
+		changed: make(chan struct{}),
+	}
+	s.srv = httptest.NewServer(s)
+	tb.Cleanup(s.srv.Close)
+	return s
+}
+
+// URL returns the base URL of the server.
+func (s *Server) URL() string { return s.srv.URL }
+
+// Client returns a kube.Client for the server that operates in namespace
+// ns.
+func (s *Server) Client(ns string) *kube.Client {
+	return kube.NewStatic(s.srv.URL, ns, token, s.srv.Client())
+}
+
+// Set creates or replaces the object at path (such as
+// "/api/v1/namespaces/default/services/foo") with obj, bypassing the
+// checks that the API applies.
+func (s *Server) Set(path string, obj any) error {
+	m, err := toMap(obj)
+	if err != nil {
+		return err
+	}
+	r, ok := parsePath(path)
+	if !ok || r.name == "" {
+		return fmt.Errorf("kubetest: invalid object path %q", path)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store(r, m)
+	return nil
+}
+
+// Get unmarshals the object at path into obj. It reports whether the
+// object exists.
+func (s *Server) Get(path string, obj any) (bool, error) {
+	s.mu.Lock()
+	m, ok := s.objs[path]
+	s.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(b, obj)
+}
+
+// Delete removes the object at path, if any.
+func (s *Server) Delete(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(path)
+}
+
+// resource is a parsed API request path.
+type resource struct {
+	prefix   string // "/api/v1" or "/apis/<group>/<version>"
+	ns       string // empty for cluster-wide lists
+	resource string // "secrets", "statefulsets", etc
+	name     string // empty for collections
+}
+
+func (r resource) collectionPath() string {
+	return fmt.Sprintf("%s/namespaces/%s/%s", r.prefix, r.ns, r.resource)
+}
+
+func (r resource) objectPath() string {
+	return r.collectionPath() + "/" + r.name
+}
+
+// parsePath parses the API request path p. Like the real API server, it
+// rejects paths with a trailing slash or empty segments.
+func parsePath(p string) (r resource, ok bool) {
+	if !strings.HasPrefix(p, "/") || strings.HasSuffix(p, "/") || strings.Contains(p, "//") {
+		return r, false
+	}
+	f := strings.Split(p[1:], "/")
+	switch {
+	case len(f) >= 2 && f[0] == "api":
+		r.prefix, f = "/"+strings.Join(f[:2], "/"), f[2:]
+	case len(f) >= 3 && f[0] == "apis":
+		r.prefix, f = "/"+strings.Join(f[:3], "/"), f[3:]
+	default:
+		return r, false
+	}
+	switch len(f) {
+	case 1:
+		r.resource = f[0]
+	case 3, 4:
+		if f[0] != "namespaces" {
+			return r, false
+		}
+		r.ns, r.resource = f[1], f[2]
+		if len(f) == 4 {
+			r.name = f[3]
+		}
+	default:
+		return r, false
+	}
+	return r, true
+}
+
+// store stores m as the object r, setting its server-populated metadata.
+// s.mu must be held.
+func (s *Server) store(r resource, m map[string]any) {
+	md, _ := m["metadata"].(map[string]any)
+	if md == nil {
+		md = map[string]any{}
+		m["metadata"] = md
+	}
+	md["name"] = r.name
+	md["namespace"] = r.ns
+	if old, ok := s.objs[r.objectPath()]; ok {
+		omd := old["metadata"].(map[string]any)
+		md["uid"] = omd["uid"]
+		md["creationTimestamp"] = omd["creationTimestamp"]
+	} else {
+		s.lastUID++
+		md["uid"] = fmt.Sprintf("uid-%d", s.lastUID)
+		md["creationTimestamp"] = time.Now().UTC().Format(time.RFC3339)
+	}
+	typ := kube.EventModified
+	if _, ok := s.objs[r.objectPath()]; !ok {
+		typ = kube.EventAdded
+	}
+	s.lastRV++
+	md["resourceVersion"] = strconv.Itoa(s.lastRV)
+	s.objs[r.objectPath()] = m
+	s.addEvent(typ, r.objectPath(), m)
+}
+
+// remove deletes the object at path, if any. s.mu must be held.
+func (s *Server) remove(path string) {
+	obj, ok := s.objs[path]
+	if !ok {
+		return
+	}
+	delete(s.objs, path)
+	s.lastRV++
+	obj["metadata"].(map[string]any)["resourceVersion"] = strconv.Itoa(s.lastRV)
+	s.addEvent(kube.EventDeleted, path, obj)
+}
+
+// addEvent records a change at s.lastRV and wakes up watchers. s.mu must
+// be held.
+func (s *Server) addEvent(typ kube.EventType, path string, obj map[string]any) {
+	s.events = append(s.events, event{rv: s.lastRV, typ: typ, path: path, obj: obj})
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Authorization") != "Bearer "+token {
+		writeStatus(w, http.StatusUnauthorized, "Unauthorized", "missing or invalid bearer token")
+		return
+	}
+	r, ok := parsePath(req.URL.Path)
+	if !ok {
+		writeStatus(w, http.StatusNotFound, "NotFound", "the server could not find the requested resource")
+		return
+	}
+	if req.Method == "GET" && r.name == "" && req.URL.Query().Get("watch") == "true" {
+		s.serveWatch(w, req, r)
+		return
+	}
+	var body any
+	if req.Method == "POST" || req.Method == "PUT" || req.Method == "PATCH" {
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			writeStatus(w, http.StatusBadRequest, "BadRequest", err.Error())
+			return
+		}
+	}
+	if req.Method != "GET" && s.OnWrite != nil {
+		s.OnWrite(req.Method, req.URL.Path)
+	}
+	obj, _ := body.(map[string]any)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case req.Method == "GET" && r.name == "":
+		s.serveList(w, r, req.URL.Query().Get("labelSelector"))
+	case req.Method == "GET":
+		obj, ok := s.objs[r.objectPath()]
+		if !ok {
+			writeNotFound(w, r)
+			return
+		}
+		writeJSON(w, http.StatusOK, obj)
+	case req.Method == "POST" && r.name == "" && r.ns != "":
+		r.name = objectName(obj)
+		if r.name == "" {
+			writeStatus(w, http.StatusUnprocessableEntity, "Invalid", "metadata.name: Required value")
+			return
+		}
+		if _, ok := s.objs[r.objectPath()]; ok {
+			writeStatus(w, http.StatusConflict, "AlreadyExists", fmt.Sprintf("%s %q already exists", r.resource, r.name))
+			return
+		}
+		s.store(r, obj)
+		writeJSON(w, http.StatusCreated, obj)
+	case req.Method == "PUT" && r.name != "":
+		old, ok := s.objs[r.objectPath()]
+		if !ok {
+			writeNotFound(w, r)
+			return
+		}
+		if rv := resourceVersion(obj); rv != "" && rv != resourceVersion(old) {
+			writeConflict(w, r)
+			return
+		}
+		s.store(r, obj)
+		writeJSON(w, http.StatusOK, obj)
+	case req.Method == "PATCH" && r.name != "":
+		old, ok := s.objs[r.objectPath()]
+		if !ok {
+			writeNotFound(w, r)
+			return
+		}
+		patched, err := applyPatch(kube.PatchType(req.Header.Get("Content-Type")), old, body)
+		if err != nil {
+			writeStatus(w, http.StatusUnprocessableEntity, "Invalid", err.Error())
+			return
+		}
+		if rv := resourceVersion(patched); rv != resourceVersion(old) {
+			writeConflict(w, r)
+			return
+		}
+		s.store(r, patched)
+		writeJSON(w, http.StatusOK, patched)
+	case req.Method == "DELETE" && r.name != "":
+		if _, ok := s.objs[r.objectPath()]; !ok {
+			writeNotFound(w, r)
+			return
+		}
+		s.remove(r.objectPath())
+		writeStatus(w, http.StatusOK, "", "")
+	default:
+		writeStatus(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "the server does not allow this method on the requested resource")
+	}
+}
+
+// serveWatch streams the changes to objects in the collection r, as
+// newline-delimited JSON events, until the request is canceled.
+func (s *Server) serveWatch(w http.ResponseWriter, req *http.Request, r resource) {
+	sel := req.URL.Query().Get("labelSelector")
+	s.mu.Lock()
+	since, err := strconv.Atoi(req.URL.Query().Get("resourceVersion"))
+	var initial []event
+	if err != nil || since == 0 {
+		// Start with the existing objects.
+		for _, p := range s.matchingPaths(r, sel) {
+			initial = append(initial, event{typ: kube.EventAdded, obj: s.objs[p]})
+		}
+		since = s.lastRV
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	send := func(evs []event) bool {
+		for _, ev := range evs {
+			if err := enc.Encode(map[string]any{"type": ev.typ, "object": ev.obj}); err != nil {
+				return false
+			}
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		return true
+	}
+	if !send(initial) {
+		return
+	}
+	for {
+		s.mu.Lock()
+		var evs []event
+		for _, ev := range s.events {
+			if ev.rv > since && s.inCollection(ev.path, r) && matchesSelector(ev.obj, sel) {
+				evs = append(evs, ev)
+			}
+		}
+		since = s.lastRV
+		changed := s.changed
+		s.mu.Unlock()
+		if !send(evs) {
+			return
+		}
+		select {
+		case <-changed:
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// inCollection reports whether the object at path is in the collection
+// r.
+func (s *Server) inCollection(path string, r resource) bool {
+	prefix := r.collectionPath() + "/"
+	if r.ns == "" {
+		prefix = r.prefix + "/namespaces/"
+	}
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	pr, _ := parsePath(path)
+	return pr.resource == r.resource
+}
+
+// matchingPaths returns the sorted paths of the objects in the collection
+// r that match the label selector sel. s.mu must be held.
+func (s *Server) matchingPaths(r resource, sel string) []string {
+	var paths []string
+	for p := range s.objs {
+		if s.inCollection(p, r) && matchesSelector(s.objs[p], sel) {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// serveList writes the objects in the collection r that match the label
+// selector sel. s.mu must be held.
+func (s *Server) serveList(w http.ResponseWriter, r resource, sel string) {
+	paths := s.matchingPaths(r, sel)
+	items := make([]any, 0, len(paths))
+	for _, p := range paths {
+		items = append(items, s.objs[p])
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"kind":       "List",
+		"apiVersion": "v1",
+		"metadata":   map[string]any{"resourceVersion": strconv.Itoa(s.lastRV)},
+		"items":      items,
+	})
+}
+
+// matchesSelector reports whether obj's labels match the label selector
+// sel, which is a comma-separated list of "key=value" and "key"
+// requirements.
+func matchesSelector(obj map[string]any, sel string) bool {
+	if sel == "" {
+		return true
+	}
+	md, _ := obj["metadata"].(map[string]any)
+	labels, _ := md["labels"].(map[string]any)
+	for _, req := range strings.Split(sel, ",") {
+		k, v, hasValue := strings.Cut(req, "=")
+		got, ok := labels[k]
+		if !ok || (hasValue && got != strings.TrimPrefix(v, "=")) {
+			return false
+		}
+	}
+	return true
+}
+
+func objectName(obj map[string]any) string {
+	md, _ := obj["metadata"].(map[string]any)
+	name, _ := md["name"].(string)
+	return name
+}
+
+func resourceVersion(obj map[string]any) string {
+	md, _ := obj["metadata"].(map[string]any)
+	rv, _ := md["resourceVersion"].(string)
+	return rv
+}
+
+func toMap(obj any) (map[string]any, error) {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]any
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeConflict(w http.ResponseWriter, r resource) {
+	writeStatus(w, http.StatusConflict, "Conflict", fmt.Sprintf("Operation cannot be fulfilled on %s %q: the object has been modified; please apply your changes to the latest version and try again", r.resource, r.name))
+}
+
+func writeNotFound(w http.ResponseWriter, r resource) {
+	writeStatus(w, http.StatusNotFound, "NotFound", fmt.Sprintf("%s %q not found", r.resource, r.name))
+}
+
+func writeStatus(w http.ResponseWriter, code int, reason, msg string) {
+	st := &kube.Status{
+		TypeMeta: kube.TypeMeta{Kind: "Status", APIVersion: "v1"},
+		Status:   "Success",
+		Reason:   reason,
+		Message:  msg,
+		Code:     code,
+	}
+	if code >= 300 {
+		st.Status = "Failure"
+	}
+	writeJSON(w, code, st)
+}
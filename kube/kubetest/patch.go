@@ -0,0 +1,192 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kubetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"tailscale.com/kube"
+)
+
+// applyPatch returns the result of applying patch, of type pt, to obj.
+// obj is not modified.
+func applyPatch(pt kube.PatchType, obj map[string]any, patch any) (map[string]any, error) {
+	obj, err := toMap(obj) // deep copy
+	if err != nil {
+		return nil, err
+	}
+	switch pt {
+	case kube.MergePatch, kube.StrategicMergePatch:
+		pm, ok := patch.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("merge patch is not an object")
+		}
+		return mergePatch(obj, pm), nil
+	case kube.JSONPatch:
+		b, err := json.Marshal(patch)
+		if err != nil {
+			return nil, err
+		}
+		var ops []kube.JSONPatchOp
+		if err := json.Unmarshal(b, &ops); err != nil {
+			return nil, fmt.Errorf("invalid JSON patch: %w", err)
+		}
+		var doc any = obj
+		for _, op := range ops {
+			if doc, err = applyJSONPatchOp(doc, op); err != nil {
+				return nil, err
+			}
+		}
+		m, ok := doc.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("JSON patch replaced the object with a non-object")
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("unsupported patch type %q", pt)
+	}
+}
+
+// mergePatch applies the JSON merge patch (RFC 7386) patch to obj.
+func mergePatch(obj, patch map[string]any) map[string]any {
+	for k, pv := range patch {
+		if pv == nil {
+			delete(obj, k)
+			continue
+		}
+		pm, ok := pv.(map[string]any)
+		if !ok {
+			obj[k] = pv
+			continue
+		}
+		om, ok := obj[k].(map[string]any)
+		if !ok {
+			om = map[string]any{}
+		}
+		obj[k] = mergePatch(om, pm)
+	}
+	return obj
+}
+
+// applyJSONPatchOp applies op to doc and returns the resulting document.
+func applyJSONPatchOp(doc any, op kube.JSONPatchOp) (any, error) {
+	if op.Path == "" {
+		switch op.Op {
+		case "add", "replace":
+			return op.Value, nil
+		case "test":
+			if !jsonEqual(doc, op.Value) {
+				return nil, fmt.Errorf("test of %q failed", op.Path)
+			}
+			return doc, nil
+		}
+		return nil, fmt.Errorf("invalid %q operation on the whole document", op.Op)
+	}
+	if !strings.HasPrefix(op.Path, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", op.Path)
+	}
+	toks := strings.Split(op.Path[1:], "/")
+	for i, t := range toks {
+		toks[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(t)
+	}
+	parent := doc
+	for _, t := range toks[:len(toks)-1] {
+		var ok bool
+		if parent, ok = child(parent, t); !ok {
+			return nil, fmt.Errorf("path %q does not exist", op.Path)
+		}
+	}
+	last := toks[len(toks)-1]
+	switch p := parent.(type) {
+	case map[string]any:
+		cur, exists := p[last]
+		switch op.Op {
+		case "add":
+			p[last] = op.Value
+		case "replace", "remove", "test":
+			if !exists {
+				return nil, fmt.Errorf("path %q does not exist", op.Path)
+			}
+			switch op.Op {
+			case "replace":
+				p[last] = op.Value
+			case "remove":
+				delete(p, last)
+			case "test":
+				if !jsonEqual(cur, op.Value) {
+					return nil, fmt.Errorf("test of %q failed", op.Path)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("unsupported JSON patch operation %q", op.Op)
+		}
+		return doc, nil
+	case []any:
+		// Lists can't be grown in place, so modify a copy and set it
+		// back on the parent with a replace.
+		var i int
+		if last == "-" && op.Op == "add" {
+			i = len(p)
+		} else {
+			var err error
+			i, err = strconv.Atoi(last)
+			if err != nil || i < 0 || i > len(p) || (i == len(p) && op.Op != "add") {
+				return nil, fmt.Errorf("invalid list index in %q", op.Path)
+			}
+		}
+		var l []any
+		switch op.Op {
+		case "add":
+			l = append(append(append([]any{}, p[:i]...), op.Value), p[i:]...)
+		case "replace":
+			l = append([]any{}, p...)
+			l[i] = op.Value
+		case "remove":
+			l = append(append([]any{}, p[:i]...), p[i+1:]...)
+		case "test":
+			if !jsonEqual(p[i], op.Value) {
+				return nil, fmt.Errorf("test of %q failed", op.Path)
+			}
+			return doc, nil
+		default:
+			return nil, fmt.Errorf("unsupported JSON patch operation %q", op.Op)
+		}
+		parentPath := op.Path[:strings.LastIndex(op.Path, "/")]
+		return applyJSONPatchOp(doc, kube.JSONPatchOp{Op: "replace", Path: parentPath, Value: l})
+	default:
+		return nil, fmt.Errorf("path %q does not exist", op.Path)
+	}
+}
+
+// child returns the element t of the JSON object or list v.
+func child(v any, t string) (any, bool) {
+	switch v := v.(type) {
+	case map[string]any:
+		c, ok := v[t]
+		return c, ok
+	case []any:
+		i, err := strconv.Atoi(t)
+		if err != nil || i < 0 || i >= len(v) {
+			return nil, false
+		}
+		return v[i], true
+	}
+	return nil, false
+}
+
+// jsonEqual reports whether a and b have the same JSON encoding, ignoring
+// differences in Go types such as int vs float64.
+func jsonEqual(a, b any) bool {
+	var na, nb any
+	ab, _ := json.Marshal(a)
+	bb, _ := json.Marshal(b)
+	json.Unmarshal(ab, &na)
+	json.Unmarshal(bb, &nb)
+	return reflect.DeepEqual(na, nb)
+}
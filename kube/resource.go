@@ -0,0 +1,207 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"context"
+	"net/url"
+	"time"
+)
+
+// Resource identifies a type of object in the Kubernetes API.
+type Resource struct {
+	// Group is the API group of the resource, such as "apps". It is
+	// empty for the core group.
+	Group string
+
+	// Version is the API version of the resource, such as "v1".
+	Version string
+
+	// Name is the plural, lower-case name of the resource, such as
+	// "secrets", as used in its URLs.
+	Name string
+}
+
+// Resources that Tailscale uses.
+var (
+	Secrets      = Resource{Version: "v1", Name: "secrets"}
+	Services     = Resource{Version: "v1", Name: "services"}
+	StatefulSets = Resource{Group: "apps", Version: "v1", Name: "statefulsets"}
+
+	ServiceAccounts = Resource{Version: "v1", Name: "serviceaccounts"}
+	Roles           = Resource{Group: "rbac.authorization.k8s.io", Version: "v1", Name: "roles"}
+	RoleBindings    = Resource{Group: "rbac.authorization.k8s.io", Version: "v1", Name: "rolebindings"}
+)
+
+// ListOptions are the options for List and Watch.
+type ListOptions struct {
+	// LabelSelector, if non-empty, limits the objects to those whose
+	// labels match it, such as "app=foo,tier".
+	LabelSelector string
+
+	// ResourceVersion, if non-empty, is the resource version (as returned
+	// by List) after which Watch reports changes. If empty, Watch first
+	// reports an EventAdded for every existing object. It is ignored by
+	// List.
+	ResourceVersion string
+}
+
+func (o ListOptions) query(watch bool) string {
+	q := url.Values{}
+	if o.LabelSelector != "" {
+		q.Set("labelSelector", o.LabelSelector)
+	}
+	if watch {
+		q.Set("watch", "true")
+		if o.ResourceVersion != "" {
+			q.Set("resourceVersion", o.ResourceVersion)
+		}
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
+
+// Get fetches the object name of type r in namespace ns.
+func Get[T any](ctx context.Context, c *Client, r Resource, ns, name string) (*T, error) {
+	obj := new(T)
+	if err := c.doRequest(ctx, "GET", c.resourceURL(r, ns, name), nil, obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// List lists the objects of type r in namespace ns, or in all namespaces
+// if ns is empty. It also returns the resource version of the list, from
+// which Watch can report subsequent changes.
+func List[T any](ctx context.Context, c *Client, r Resource, ns string, opts ListOptions) (items []T, resourceVersion string, err error) {
+	var l struct {
+		ListMeta `json:"metadata"`
+		Items    []T `json:"items"`
+	}
+	if err := c.doRequest(ctx, "GET", c.resourceURL(r, ns, "")+opts.query(false), nil, &l); err != nil {
+		return nil, "", err
+	}
+	return l.Items, l.ResourceVersion, nil
+}
+
+// Create creates obj, of type r, in namespace ns and returns the object
+// as stored by the API server.
+func Create[T any](ctx context.Context, c *Client, r Resource, ns string, obj *T) (*T, error) {
+	out := new(T)
+	if err := c.doRequest(ctx, "POST", c.resourceURL(r, ns, ""), obj, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Update replaces the object name of type r in namespace ns with obj and
+// returns the object as stored by the API server.
+//
+// If obj has a resourceVersion, the update fails with a conflict (see
+// IsConflict) if the object has been changed since that version.
+func Update[T any](ctx context.Context, c *Client, r Resource, ns, name string, obj *T) (*T, error) {
+	out := new(T)
+	if err := c.doRequest(ctx, "PUT", c.resourceURL(r, ns, name), obj, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Delete deletes the object name of type r in namespace ns.
+func (c *Client) Delete(ctx context.Context, r Resource, ns, name string) error {
+	return c.doRequest(ctx, "DELETE", c.resourceURL(r, ns, name), nil, nil)
+}
+
+// PatchType is the format of a patch sent with Client.Patch.
+type PatchType string
+
+const (
+	// JSONPatch is a JSON patch (RFC 6902), given as a []JSONPatchOp.
+	JSONPatch PatchType = "application/json-patch+json"
+
+	// MergePatch is a JSON merge patch (RFC 7386): a partial object
+	// whose fields replace those of the object, and where null removes
+	// a field.
+	MergePatch PatchType = "application/merge-patch+json"
+
+	// StrategicMergePatch is like MergePatch, except that lists of
+	// objects are merged by their key (such as the name of a container)
+	// rather than replaced.
+	StrategicMergePatch PatchType = "application/strategic-merge-patch+json"
+)
+
+// JSONPatchOp is an operation of a JSONPatch.
+type JSONPatchOp struct {
+	// Op is the operation: "add", "remove", "replace" or "test".
+	Op string `json:"op"`
+
+	// Path is the JSON pointer (RFC 6901) to the value that the
+	// operation applies to, such as "/data/foo".
+	Path string `json:"path"`
+
+	// Value is the value to add, replace or test against.
+	Value any `json:"value,omitempty"`
+}
+
+// Patch applies patch, of format pt, to the object name of type r in
+// namespace ns. If out is non-nil, the patched object is decoded into it.
+//
+// Patches that set the object's resourceVersion (such as a merge patch
+// that includes metadata.resourceVersion) fail with a conflict (see
+// IsConflict) if the object has been changed since that version. Other
+// patches are applied to whatever the latest version is.
+func (c *Client) Patch(ctx context.Context, r Resource, ns, name string, pt PatchType, patch, out any) error {
+	return c.doRequestWithContentType(ctx, "PATCH", c.resourceURL(r, ns, name), string(pt), patch, out)
+}
+
+// maxConflictRetries is the number of times that RetryOnConflict retries.
+const maxConflictRetries = 5
+
+// RetryOnConflict calls fn and, while it fails with a conflict (see
+// IsConflict), calls it again up to a few times with a short delay in
+// between. fn typically reads the object it's about to write, so that
+// the write is based on the latest version.
+func RetryOnConflict(ctx context.Context, fn func() error) error {
+	delay := 10 * time.Millisecond
+	for i := 0; ; i++ {
+		err := fn()
+		if !IsConflict(err) || i == maxConflictRetries {
+			return err
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+		delay *= 2
+	}
+}
+
+// Modify fetches the object name of type r in namespace ns, calls mutate
+// to change it, and writes it back, retrying from the start if the object
+// was changed concurrently. It returns the object as stored by the API
+// server. If mutate returns an error, the object isn't written.
+func Modify[T any](ctx context.Context, c *Client, r Resource, ns, name string, mutate func(*T) error) (*T, error) {
+	var out *T
+	err := RetryOnConflict(ctx, func() error {
+		obj, err := Get[T](ctx, c, r, ns, name)
+		if err != nil {
+			return err
+		}
+		if err := mutate(obj); err != nil {
+			return err
+		}
+		out, err = Update(ctx, c, r, ns, name, obj)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// EventType is the type of a watch Event.
+type EventType string
+
+const (
+	EventAdded    EventType = "ADDED"
+	EventModified EventType = "MODIFIED"
+	EventDeleted  EventType = "DELETED"
+	EventBookmark EventType = "BOOKMARK"
+
+	// eventError is the type of events that report a watch error. They
+	// are returned as errors by Watcher.Next.
+	eventError EventType = "ERROR"
+)
+
+// Event is a change to an object of type T, as reported by a Watcher.
+type Event[T any] struct {
+	Type EventType `json:"type"`
+
+	// Object is the object after the change or, for EventDeleted, its
+	// last state.
+	Object T `json:"object"`
+}
+
+// Watcher reports changes to objects. It is created by Watch.
+type Watcher[T any] struct {
+	resp *http.Response
+	dec  *json.Decoder
+}
+
+// Watch starts watching for changes to objects of type r in namespace ns,
+// or in all namespaces if ns is empty. The caller must Close the returned
+// Watcher when done. The watch ends when ctx is done, or when the API
+// server closes it, which it does periodically.
+func Watch[T any](ctx context.Context, c *Client, r Resource, ns string, opts ListOptions) (*Watcher[T], error) {
+	resp, err := c.send(ctx, "GET", c.resourceURL(r, ns, "")+opts.query(true), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Watcher[T]{resp: resp, dec: json.NewDecoder(resp.Body)}, nil
+}
+
+// Next blocks until the next change and returns it.
+//
+// Errors reported by the API server are returned as *Status. Those with
+// code 410 (Gone) mean that the resource version the watch started from
+// is too old, and the caller should List the objects again.
+func (w *Watcher[T]) Next() (Event[T], error) {
+	var raw struct {
+		Type   EventType       `json:"type"`
+		Object json.RawMessage `json:"object"`
+	}
+	if err := w.dec.Decode(&raw); err != nil {
+		return Event[T]{}, err
+	}
+	if raw.Type == eventError {
+		st := &Status{}
+		if err := json.Unmarshal(raw.Object, st); err != nil {
+			return Event[T]{}, fmt.Errorf("decoding watch error: %w", err)
+		}
+		return Event[T]{}, st
+	}
+	ev := Event[T]{Type: raw.Type}
+	if err := json.Unmarshal(raw.Object, &ev.Object); err != nil {
+		return Event[T]{}, fmt.Errorf("decoding %s event: %w", raw.Type, err)
+	}
+	return ev, nil
+}
+
+// Close stops the watch.
+func (w *Watcher[T]) Close() error {
+	return w.resp.Body.Close()
+}
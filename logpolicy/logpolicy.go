@@ -546,8 +546,19 @@ func New(collection string) *Policy {
 		conf.HTTPC = &http.Client{Transport: NewLogtailTransport(u.Host)}
 	}
 
+	if val := envknob.String("TS_LOG_DESTINATIONS"); val != "" {
+		ups, useCatalog, err := parseLogDestinations(val, newc.PublicID)
+		if err != nil {
+			earlyLogf("logpolicy: TS_LOG_DESTINATIONS: %v", err)
+		} else {
+			conf.Uploaders = ups
+			conf.SkipCatalog = !useCatalog
+		}
+	}
+
 	filchOptions := filch.Options{
 		ReplaceStderr: redirectStderrToLogPanics(),
+		RecentSize:    recentLogsSize,
 	}
 	filchPrefix := filepath.Join(dir, cmdName)
 
@@ -605,6 +616,10 @@ func New(collection string) *Policy {
 	}
 }
 
+// recentLogsSize is how many bytes of the most recent logs are kept in
+// memory for bug report bundles.
+const recentLogsSize = 512 << 10
+
 // dialLog is used by NewLogtailTransport to log the happy path of its
 // own dialing.
 //
@@ -728,6 +743,91 @@ func NewLogtailTransport(host string) *http.Transport {
 	return tr
 }
 
+// parseLogDestinations parses a comma-separated list of log upload
+// destinations, as given in the TS_LOG_DESTINATIONS environment variable.
+//
+// Each destination is either "catalog", meaning the log catalog at the
+// configured log target (log.tailscale.io by default), or a URL with one
+// of the following schemes:
+//
+//   - otlp+http, otlp+https: OpenTelemetry logs over OTLP/HTTP
+//   - syslog+tcp, syslog+tls: RFC 5424 syslog over TCP or TLS
+//   - jsonl+http, jsonl+https: JSON lines POSTed to an HTTP endpoint
+//
+// Userinfo in the HTTP-based URLs is sent as basic auth.
+//
+// It reports whether the log catalog was listed.
+func parseLogDestinations(list string, publicID logtail.PublicID) (ups []logtail.Uploader, useCatalog bool, err error) {
+	hostname, _ := os.Hostname()
+	for _, dst := range strings.Split(list, ",") {
+		dst = strings.TrimSpace(dst)
+		if dst == "" {
+			continue
+		}
+		if dst == "catalog" {
+			useCatalog = true
+			continue
+		}
+		u, err := url.Parse(dst)
+		if err != nil {
+			return nil, false, err
+		}
+		kind, scheme, ok := strings.Cut(u.Scheme, "+")
+		if !ok {
+			return nil, false, fmt.Errorf("unknown log destination %q", u.Redacted())
+		}
+		var header http.Header
+		if u.User != nil {
+			req := &http.Request{Header: http.Header{}}
+			pass, _ := u.User.Password()
+			req.SetBasicAuth(u.User.Username(), pass)
+			header = req.Header
+			u.User = nil
+		}
+		u.Scheme = scheme
+		httpc := &http.Client{Transport: NewLogtailTransport(u.Hostname())}
+
+		var up logtail.Uploader
+		switch {
+		case kind == "otlp" && (scheme == "http" || scheme == "https"):
+			up, err = logtail.NewOTLPUploader(logtail.OTLPOptions{
+				URL:    u.String(),
+				HTTPC:  httpc,
+				Header: header,
+				Resource: map[string]string{
+					"service.name":     version.CmdName(),
+					"service.version":  version.Long,
+					"host.name":        hostname,
+					"tailscale.log_id": publicID.String(),
+				},
+			})
+		case kind == "jsonl" && (scheme == "http" || scheme == "https"):
+			up, err = logtail.NewJSONLinesUploader(logtail.JSONLinesOptions{
+				URL:    u.String(),
+				HTTPC:  httpc,
+				Header: header,
+			})
+		case kind == "syslog" && (scheme == "tcp" || scheme == "tls"):
+			opts := logtail.SyslogOptions{
+				Addr:     u.Host,
+				Hostname: hostname,
+				AppName:  version.CmdName(),
+			}
+			if scheme == "tls" {
+				opts.TLSConfig = tlsdial.Config(u.Hostname(), nil)
+			}
+			up, err = logtail.NewSyslogUploader(opts)
+		default:
+			return nil, false, fmt.Errorf("unknown log destination scheme %q", kind+"+"+scheme)
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		ups = append(ups, up)
+	}
+	return ups, useCatalog, nil
+}
+
 func goVersion() string {
 	v := strings.TrimPrefix(runtime.Version(), "go")
 	if racebuild.On {
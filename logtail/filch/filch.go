@@ -22,6 +22,11 @@
 type Options struct {
 	ReplaceStderr bool // dup over fd 2 so everything written to stderr comes here
 	MaxFileSize   int
+
+	// RecentSize, if non-zero, is the number of most recently
+	// written bytes to also keep in memory for Recent, whether or
+	// not they've since been read out.
+	RecentSize int
 }
 
 // A Filch uses two alternating files as a simplistic ring buffer.
@@ -36,6 +41,7 @@ type Filch struct {
 
 	maxFileSize  int64
 	writeCounter int
+	recent       *ring // or nil if Options.RecentSize is zero
 
 	// buf is an initial buffer for altscan.
 	// As of August 2021, 99.96% of all log lines
@@ -45,7 +51,19 @@ type Filch struct {
 	// so that the whole struct takes 4096 bytes
 	// (less on 32 bit platforms).
 	// This reduces allocation waste.
-	buf [4096 - 64]byte
+	buf [4096 - 72]byte
+}
+
+// Recent returns the most recently written whole lines, up to
+// Options.RecentSize bytes of them. It returns nil if RecentSize was
+// zero.
+func (f *Filch) Recent() []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.recent == nil {
+		return nil
+	}
+	return f.recent.bytes()
 }
 
 // TryReadline implements the logtail.Buffer interface.
@@ -119,7 +137,10 @@ func (f *Filch) Write(b []byte) (int, error) {
 		bnl := make([]byte, len(b)+1)
 		copy(bnl, b)
 		bnl[len(bnl)-1] = '\n'
-		return f.cur.Write(bnl)
+		b = bnl
+	}
+	if f.recent != nil {
+		f.recent.write(b)
 	}
 	return f.cur.Write(b)
 }
@@ -190,6 +211,9 @@ func New(filePrefix string, opts Options) (f *Filch, err error) {
 		OrigStderr:  os.Stderr, // temporary, for past logs recovery
 		maxFileSize: int64(mfs),
 	}
+	if opts.RecentSize > 0 {
+		f.recent = &ring{buf: make([]byte, opts.RecentSize)}
+	}
 
 	// Neither, either, or both files may exist and contain logs from
 	// the last time the process ran. The three cases are:
@@ -244,6 +268,49 @@ func New(filePrefix string, opts Options) (f *Filch, err error) {
 	return f, nil
 }
 
+// ring is a fixed-size byte ring buffer holding the most recent
+// bytes written to it.
+type ring struct {
+	buf     []byte
+	off     int  // index in buf of the next byte to write
+	wrapped bool // whether buf has been filled at least once
+}
+
+func (r *ring) write(b []byte) {
+	if len(b) >= len(r.buf) {
+		copy(r.buf, b[len(b)-len(r.buf):])
+		r.off = 0
+		r.wrapped = true
+		return
+	}
+	n := copy(r.buf[r.off:], b)
+	if n < len(b) {
+		r.off = copy(r.buf, b[n:])
+		r.wrapped = true
+	} else {
+		r.off += n
+	}
+	if r.off == len(r.buf) {
+		r.off = 0
+		r.wrapped = true
+	}
+}
+
+// bytes returns a copy of the ring's contents, oldest first, without
+// any partial line that's been overwritten at its start.
+func (r *ring) bytes() []byte {
+	if !r.wrapped {
+		return append([]byte(nil), r.buf[:r.off]...)
+	}
+	b := make([]byte, 0, len(r.buf))
+	b = append(b, r.buf[r.off:]...)
+	b = append(b, r.buf[:r.off]...)
+	if i := bytes.IndexByte(b, '\n'); i != -1 {
+		b = b[i+1:]
+	}
+	return b
+}
+
 func moveContents(dst, src *os.File) (err error) {
 	defer func() {
 		_, err2 := src.Seek(0, io.SeekStart)
@@ -111,6 +111,42 @@ func TestQueue(t *testing.T) {
 	f.close(t)
 }
 
+func TestRecent(t *testing.T) {
+	filePrefix := t.TempDir()
+	f := newFilchTest(t, filePrefix, Options{RecentSize: 16})
+	defer f.close(t)
+
+	check := func(want string) {
+		t.Helper()
+		if got := string(f.Recent()); got != want {
+			t.Errorf("Recent() = %q; want %q", got, want)
+		}
+	}
+	check("")
+	f.write(t, "one")
+	f.write(t, "two\n")
+	check("one\ntwo\n")
+
+	// Reading lines out doesn't remove them from Recent.
+	f.read(t, "one")
+	f.read(t, "two")
+	check("one\ntwo\n")
+
+	// Once the ring wraps, the partially overwritten oldest line is
+	// dropped.
+	f.write(t, "three")
+	f.write(t, "four")
+	check("two\nthree\nfour\n")
+	f.write(t, "a line longer than the ring")
+	check("")
+	f.write(t, "x")
+	check("x\n")
+
+	if b := newFilchTest(t, t.TempDir(), Options{}).Recent(); b != nil {
+		t.Errorf("Recent() without RecentSize = %q; want nil", b)
+	}
+}
+
 func TestRecover(t *testing.T) {
 	t.Run("empty", func(t *testing.T) {
 		filePrefix := t.TempDir()
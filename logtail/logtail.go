@@ -75,6 +75,15 @@ type Config struct {
 	// being included in the logs. The sequence number is incremented for each
 	// log message sent, but is not peristed across process restarts.
 	IncludeProcSequence bool
+
+	// Uploaders are additional destinations that logs are uploaded
+	// to, beyond the log catalog at BaseURL. See NewOTLPUploader,
+	// NewSyslogUploader, and NewJSONLinesUploader.
+	Uploaders []Uploader
+
+	// SkipCatalog, if true, disables uploading logs to the log catalog
+	// at BaseURL, so logs are only sent to Uploaders.
+	SkipCatalog bool
 }
 
 func NewLogger(cfg Config, logf tslogger.Logf) *Logger {
@@ -112,8 +121,6 @@ func NewLogger(cfg Config, logf tslogger.Logf) *Logger {
 		privateID:      cfg.PrivateID,
 		stderr:         cfg.Stderr,
 		stderrLevel:    int64(cfg.StderrLevel),
-		httpc:          cfg.HTTPC,
-		url:            cfg.BaseURL + "/c/" + cfg.Collection + "/" + cfg.PrivateID.String(),
 		lowMem:         cfg.LowMemory,
 		buffer:         cfg.Buffer,
 		skipClientTime: cfg.SkipClientTime,
@@ -130,13 +137,33 @@ func NewLogger(cfg Config, logf tslogger.Logf) *Logger {
 		shutdownStart: make(chan struct{}),
 		shutdownDone:  make(chan struct{}),
 	}
-	if cfg.NewZstdEncoder != nil {
-		l.zstdEncoder = cfg.NewZstdEncoder()
+	for i := range l.subsysLevel {
+		l.subsysLevel[i] = subsysLevelUnset
+	}
+	if !cfg.SkipCatalog {
+		cu := &catalogUploader{
+			httpc: cfg.HTTPC,
+			url:   cfg.BaseURL + "/c/" + cfg.Collection + "/" + cfg.PrivateID.String(),
+		}
+		if cfg.NewZstdEncoder != nil {
+			cu.zstdEncoder = cfg.NewZstdEncoder()
+			l.zstdEncoder = cu.zstdEncoder
+		}
+		l.uploaders = append(l.uploaders, cu)
 	}
+	l.uploaders = append(l.uploaders, cfg.Uploaders...)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	l.uploadCancel = cancel
 
+	if len(l.uploaders) > 0 {
+		l.primary = l.uploaders[0]
+		for _, u := range l.uploaders[1:] {
+			q := newUploadQueue(u, l.stderr, backoff.NewBackoff("logtail", logf, 30*time.Second))
+			l.queues = append(l.queues, q)
+			go q.run(ctx)
+		}
+	}
 	go l.uploading(ctx)
 	l.Write([]byte("logtail started"))
 	return l
@@ -146,9 +173,8 @@ func NewLogger(cfg Config, logf tslogger.Logf) *Logger {
 // logging facilities and uploading to a log server.
 type Logger struct {
 	stderr         io.Writer
-	stderrLevel    int64 // accessed atomically
-	httpc          *http.Client
-	url            string
+	stderrLevel    int64                  // accessed atomically
+	subsysLevel    [len(subsystems)]int64 // stderrLevel overrides, or subsysLevelUnset; accessed atomically
 	lowMem         bool
 	skipClientTime bool
 	linkMonitor    *monitor.Mon
@@ -158,7 +184,10 @@ type Logger struct {
 	sentinel       chan int32
 	timeNow        func() time.Time
 	bo             *backoff.Backoff
-	zstdEncoder    Encoder
+	zstdEncoder    Encoder        // or nil; owned by the catalog uploader, closed on shutdown
+	uploaders      []Uploader     // destinations for each drained batch
+	primary        Uploader       // uploaders[0], or nil if none
+	queues         []*uploadQueue // feeding uploaders[1:]
 	uploadCancel   func()
 	explainedRaw   bool
 	metricsDelta   func() string // or nil
@@ -194,6 +223,16 @@ func (l *Logger) SetLinkMonitor(lm *monitor.Mon) {
 // It exists for internal use only.
 func (l *Logger) PrivateID() PrivateID { return l.privateID }
 
+// RecentLogs returns the most recently written log entries, one JSON
+// object per line, if the Logger's Buffer keeps them (as a filch.Filch
+// with a RecentSize does). Otherwise it returns nil.
+func (l *Logger) RecentLogs() []byte {
+	if rb, ok := l.buffer.(interface{ Recent() []byte }); ok {
+		return rb.Recent()
+	}
+	return nil
+}
+
 // Shutdown gracefully shuts down the logger while completing any
 // remaining uploads.
 //
@@ -201,13 +240,21 @@ func (l *Logger) PrivateID() PrivateID { return l.privateID }
 // context object interrupts it by being done.
 // If the shutdown is interrupted, an error is returned.
 func (l *Logger) Shutdown(ctx context.Context) error {
+	uploadsDone := make(chan struct{})
+	go func() {
+		<-l.shutdownDone
+		for _, q := range l.queues {
+			q.close()
+		}
+		close(uploadsDone)
+	}()
 	done := make(chan struct{})
 	go func() {
 		select {
 		case <-ctx.Done():
 			l.uploadCancel()
-			<-l.shutdownDone
-		case <-l.shutdownDone:
+			<-uploadsDone
+		case <-uploadsDone:
 		}
 		close(done)
 	}()
@@ -216,10 +263,20 @@ func (l *Logger) Shutdown(ctx context.Context) error {
 	io.WriteString(l, "logger closing down\n")
 	<-done
 
+	var firstErr error
+	for _, u := range l.uploaders {
+		if c, ok := u.(io.Closer); ok {
+			if err := c.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
 	if l.zstdEncoder != nil {
-		return l.zstdEncoder.Close()
+		if err := l.zstdEncoder.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
-	return nil
+	return firstErr
 }
 
 // Close shuts down this logger object, the background log uploader
@@ -321,26 +378,22 @@ func (l *Logger) uploading(ctx context.Context) {
 	scratch := make([]byte, 4096) // reusable buffer to write into
 	for {
 		body := l.drainPending(scratch)
-		origlen := -1 // sentinel value: uncompressed
-		// Don't attempt to compress tiny bodies; not worth the CPU cycles.
-		if l.zstdEncoder != nil && len(body) > 256 {
-			zbody := l.zstdEncoder.EncodeAll(body, nil)
-			// Only send it compressed if the bandwidth savings are sufficient.
-			// Just the extra headers associated with enabling compression
-			// are 50 bytes by themselves.
-			if len(body)-len(zbody) > 64 {
-				origlen = len(body)
-				body = zbody
+
+		if len(body) > 0 {
+			for _, q := range l.queues {
+				q.enqueue(body)
 			}
 		}
 
-		for len(body) > 0 {
+		// The first uploader is fed straight from the buffer, which
+		// holds logs for it while it's unreachable.
+		for len(body) > 0 && l.primary != nil {
 			select {
 			case <-ctx.Done():
 				return
 			default:
 			}
-			uploaded, err := l.upload(ctx, body, origlen)
+			uploaded, err := l.primary.Upload(ctx, body)
 			if err != nil {
 				if !l.internetUp() {
 					fmt.Fprintf(l.stderr, "logtail: internet down; waiting\n")
@@ -391,55 +444,6 @@ func (l *Logger) awaitInternetUp(ctx context.Context) {
 	}
 }
 
-// upload uploads body to the log server.
-// origlen indicates the pre-compression body length.
-// origlen of -1 indicates that the body is not compressed.
-func (l *Logger) upload(ctx context.Context, body []byte, origlen int) (uploaded bool, err error) {
-	const maxUploadTime = 45 * time.Second
-	ctx, cancel := context.WithTimeout(ctx, maxUploadTime)
-	defer cancel()
-
-	req, err := http.NewRequestWithContext(ctx, "POST", l.url, bytes.NewReader(body))
-	if err != nil {
-		// I know of no conditions under which this could fail.
-		// Report it very loudly.
-		// TODO record logs to disk
-		panic("logtail: cannot build http request: " + err.Error())
-	}
-	if origlen != -1 {
-		req.Header.Add("Content-Encoding", "zstd")
-		req.Header.Add("Orig-Content-Length", strconv.Itoa(origlen))
-	}
-	req.Header["User-Agent"] = nil // not worth writing one; save some bytes
-
-	compressedNote := "not-compressed"
-	if origlen != -1 {
-		compressedNote = "compressed"
-	}
-
-	resp, err := l.httpc.Do(req)
-	if err != nil {
-		return false, fmt.Errorf("log upload of %d bytes %s failed: %v", len(body), compressedNote, err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		uploaded = resp.StatusCode == 400 // the server saved the logs anyway
-		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
-		return uploaded, fmt.Errorf("log upload of %d bytes %s failed %d: %q", len(body), compressedNote, resp.StatusCode, b)
-	}
-
-	// Try to read to EOF, in case server's response is
-	// chunked. We want to reuse the TCP connection if it's
-	// HTTP/1. On success, we expect 0 bytes.
-	// TODO(bradfitz): can remove a few days after 2020-04-04 once
-	// server is fixed.
-	if resp.ContentLength == -1 {
-		resp.Body.Read(make([]byte, 1))
-	}
-	return true, nil
-}
-
 // Flush uploads all logs to the server.
 // It blocks until complete or there is an unrecoverable error.
 func (l *Logger) Flush() error {
@@ -649,7 +653,7 @@ func (l *Logger) Write(buf []byte) (int, error) {
 		return 0, nil
 	}
 	level, buf := parseAndRemoveLogLevel(buf)
-	if l.stderr != nil && l.stderr != ioutil.Discard && int64(level) <= atomic.LoadInt64(&l.stderrLevel) {
+	if l.stderr != nil && l.stderr != ioutil.Discard && int64(level) <= l.stderrLevelFor(buf) {
 		if buf[len(buf)-1] == '\n' {
 			l.stderr.Write(buf)
 		} else {
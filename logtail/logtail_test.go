@@ -12,6 +12,7 @@
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -402,3 +403,49 @@ func TestEncode(t *testing.T) {
 		}
 	}
 }
+
+func TestSubsystemVerbosity(t *testing.T) {
+	var stderr bytes.Buffer
+	l := NewLogger(Config{SkipCatalog: true, Stderr: &stderr}, t.Logf)
+	defer l.Shutdown(context.Background())
+
+	if err := l.SetSubsystemVerbosityLevel("bogus", 1); err == nil {
+		t.Error("SetSubsystemVerbosityLevel of unknown subsystem succeeded")
+	}
+	if err := l.SetSubsystemVerbosityLevel(SubsystemDNS, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.SetSubsystemVerbosityLevel(SubsystemSSH, 0); err != nil {
+		t.Fatal(err)
+	}
+	l.SetVerbosityLevel(1)
+
+	stderr.Reset()
+	for _, line := range []string{
+		"[v1] magicsock: shown by global level\n",
+		"[v2] magicsock: hidden by global level\n",
+		"dns: [v2] shown by dns level\n",
+		"[v1] dns: resolver: shown by dns level\n",
+		"[v1] ssh: hidden by ssh level\n",
+		"ssh: shown at level 0\n",
+	} {
+		l.Write([]byte(line))
+	}
+	want := "magicsock: shown by global level\n" +
+		"dns: shown by dns level\n" +
+		"dns: resolver: shown by dns level\n" +
+		"ssh: shown at level 0\n"
+	if got := stderr.String(); got != want {
+		t.Errorf("stderr got:\n%s\nwant:\n%s", got, want)
+	}
+
+	wantLevels := map[string]int{SubsystemDNS: 2, SubsystemSSH: 0}
+	if got := l.SubsystemVerbosityLevels(); !reflect.DeepEqual(got, wantLevels) {
+		t.Errorf("SubsystemVerbosityLevels = %v; want %v", got, wantLevels)
+	}
+	l.SetSubsystemVerbosityLevel(SubsystemSSH, -1)
+	delete(wantLevels, SubsystemSSH)
+	if got := l.SubsystemVerbosityLevels(); !reflect.DeepEqual(got, wantLevels) {
+		t.Errorf("after reset, SubsystemVerbosityLevels = %v; want %v", got, wantLevels)
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package logtail
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// OTLPOptions configures an Uploader created by NewOTLPUploader.
+type OTLPOptions struct {
+	// URL is the OTLP/HTTP logs endpoint, such as
+	// "https://otel-collector:4318/v1/logs". If the URL has no path,
+	// "/v1/logs" is used.
+	URL string
+
+	// HTTPC is the HTTP client to use. If nil, http.DefaultClient is used.
+	HTTPC *http.Client
+
+	// Header contains optional extra request headers, such as
+	// authentication tokens required by the collector.
+	Header http.Header
+
+	// Resource contains the OpenTelemetry resource attributes
+	// describing the process producing the logs, such as
+	// "service.name".
+	Resource map[string]string
+}
+
+// NewOTLPUploader returns an Uploader that sends logs as OpenTelemetry
+// log records using the OTLP/HTTP JSON encoding.
+func NewOTLPUploader(opts OTLPOptions) (Uploader, error) {
+	u, err := url.Parse(opts.URL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("logtail: invalid OTLP URL %q", opts.URL)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/logs"
+	}
+	opts.URL = u.String()
+	if opts.HTTPC == nil {
+		opts.HTTPC = http.DefaultClient
+	}
+	ou := &otlpUploader{opts: opts}
+	for _, k := range sortedKeys(opts.Resource) {
+		ou.resource = append(ou.resource, otlpStringAttr(k, opts.Resource[k]))
+	}
+	return ou, nil
+}
+
+type otlpUploader struct {
+	opts     OTLPOptions
+	resource []otlpKeyValue
+}
+
+// The following types are the subset of the OTLP JSON encoding of
+// ExportLogsServiceRequest that we produce. See
+// https://github.com/open-telemetry/opentelemetry-proto/blob/main/opentelemetry/proto/logs/v1/logs.proto
+type (
+	otlpLogsRequest struct {
+		ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+	}
+	otlpResourceLogs struct {
+		Resource  otlpResource    `json:"resource"`
+		ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes,omitempty"`
+	}
+	otlpScopeLogs struct {
+		Scope      otlpScope       `json:"scope"`
+		LogRecords []otlpLogRecord `json:"logRecords"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpLogRecord struct {
+		// TimeUnixNano and ObservedTimeUnixNano are fixed64 fields,
+		// which the protobuf JSON mapping encodes as decimal strings.
+		TimeUnixNano         string         `json:"timeUnixNano"`
+		ObservedTimeUnixNano string         `json:"observedTimeUnixNano"`
+		SeverityNumber       int            `json:"severityNumber"`
+		SeverityText         string         `json:"severityText"`
+		Body                 otlpAnyValue   `json:"body"`
+		Attributes           []otlpKeyValue `json:"attributes,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string       `json:"key"`
+		Value otlpAnyValue `json:"value"`
+	}
+	otlpAnyValue struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    *string `json:"intValue,omitempty"` // int64 as a decimal string
+	}
+)
+
+// OpenTelemetry severity numbers.
+const (
+	otlpSeverityDebug = 5
+	otlpSeverityInfo  = 9
+)
+
+func otlpStringAttr(k, v string) otlpKeyValue {
+	return otlpKeyValue{Key: k, Value: otlpAnyValue{StringValue: &v}}
+}
+
+func otlpIntAttr(k string, v uint64) otlpKeyValue {
+	s := strconv.FormatUint(v, 10)
+	return otlpKeyValue{Key: k, Value: otlpAnyValue{IntValue: &s}}
+}
+
+func (u *otlpUploader) Upload(ctx context.Context, batch []byte) (uploaded bool, err error) {
+	now := time.Now()
+	ents, err := decodeBatch(batch, now)
+	if err != nil {
+		// An undecodable batch will never become decodable.
+		return true, err
+	}
+	body, err := json.Marshal(u.encode(ents, now))
+	if err != nil {
+		return true, err
+	}
+	return doPost(ctx, u.opts.HTTPC, u.opts.URL, "application/json", u.opts.Header, body)
+}
+
+func (u *otlpUploader) encode(ents []uploadEntry, now time.Time) *otlpLogsRequest {
+	observed := strconv.FormatInt(now.UnixNano(), 10)
+	recs := make([]otlpLogRecord, 0, len(ents))
+	for _, e := range ents {
+		text := e.Text
+		rec := otlpLogRecord{
+			TimeUnixNano:         strconv.FormatInt(e.Time.UnixNano(), 10),
+			ObservedTimeUnixNano: observed,
+			SeverityNumber:       otlpSeverityInfo,
+			SeverityText:         "INFO",
+			Body:                 otlpAnyValue{StringValue: &text},
+		}
+		if e.Level > 0 {
+			rec.SeverityNumber = otlpSeverityDebug
+			rec.SeverityText = "DEBUG"
+			rec.Attributes = append(rec.Attributes, otlpIntAttr("tailscale.verbosity", uint64(e.Level)))
+		}
+		if e.ProcID != 0 {
+			rec.Attributes = append(rec.Attributes, otlpIntAttr("tailscale.proc_id", uint64(e.ProcID)))
+		}
+		if e.ProcSeq != 0 {
+			rec.Attributes = append(rec.Attributes, otlpIntAttr("tailscale.proc_seq", e.ProcSeq))
+		}
+		recs = append(recs, rec)
+	}
+	return &otlpLogsRequest{
+		ResourceLogs: []otlpResourceLogs{{
+			Resource: otlpResource{Attributes: u.resource},
+			ScopeLogs: []otlpScopeLogs{{
+				Scope:      otlpScope{Name: "tailscale.com/logtail"},
+				LogRecords: recs,
+			}},
+		}},
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
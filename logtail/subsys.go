@@ -0,0 +1,126 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package logtail
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+)
+
+// Subsystems whose verbosity can be adjusted independently of the
+// Logger's overall verbosity level, with SetSubsystemVerbosityLevel.
+const (
+	SubsystemMagicsock     = "magicsock"
+	SubsystemDNS           = "dns"
+	SubsystemControlClient = "controlclient"
+	SubsystemNetstack      = "netstack"
+	SubsystemSSH           = "ssh"
+)
+
+// subsystems are the known subsystems, in the order of their indexes
+// in Logger.subsysLevel.
+var subsystems = [...]string{
+	SubsystemMagicsock,
+	SubsystemDNS,
+	SubsystemControlClient,
+	SubsystemNetstack,
+	SubsystemSSH,
+}
+
+// subsystemPrefixes maps the prefixes that log lines start with (as
+// added by logger.WithPrefix or written at the start of format
+// strings) to their subsystem.
+var subsystemPrefixes = []struct {
+	prefix []byte
+	subsys string
+}{
+	{[]byte("magicsock: "), SubsystemMagicsock},
+	{[]byte("netcheck: "), SubsystemMagicsock},
+	{[]byte("portmapper: "), SubsystemMagicsock},
+	{[]byte("derphttp.Client"), SubsystemMagicsock},
+	{[]byte("dns: "), SubsystemDNS},
+	{[]byte("control: "), SubsystemControlClient},
+	{[]byte("controlclient: "), SubsystemControlClient},
+	{[]byte("netstack: "), SubsystemNetstack},
+	{[]byte("ssh: "), SubsystemSSH},
+}
+
+// Subsystems returns the names of the subsystems whose verbosity can be
+// adjusted with SetSubsystemVerbosityLevel.
+func Subsystems() []string {
+	return append([]string(nil), subsystems[:]...)
+}
+
+func subsystemIndex(subsys string) int {
+	for i, s := range subsystems {
+		if s == subsys {
+			return i
+		}
+	}
+	return -1
+}
+
+// subsystemOf returns the index of the subsystem that the log line buf
+// (with any log level already removed) belongs to, or -1 if unknown.
+func subsystemOf(buf []byte) int {
+	for _, sp := range subsystemPrefixes {
+		if bytes.HasPrefix(buf, sp.prefix) {
+			return subsystemIndex(sp.subsys)
+		}
+	}
+	return -1
+}
+
+// subsysLevelUnset is the value of a Logger.subsysLevel element whose
+// subsystem uses the Logger's overall verbosity level.
+const subsysLevelUnset = -1
+
+// SetSubsystemVerbosityLevel sets the verbosity level of log lines from
+// subsys that should be written to stderr, overriding the level set by
+// SetVerbosityLevel for that subsystem only. A negative level removes
+// the override.
+//
+// It returns an error if subsys isn't one of Subsystems.
+func (l *Logger) SetSubsystemVerbosityLevel(subsys string, level int) error {
+	i := subsystemIndex(subsys)
+	if i == -1 {
+		return fmt.Errorf("unknown log subsystem %q", subsys)
+	}
+	if level < 0 {
+		level = subsysLevelUnset
+	}
+	atomic.StoreInt64(&l.subsysLevel[i], int64(level))
+	return nil
+}
+
+// SubsystemVerbosityLevels returns the subsystems whose verbosity level
+// has been overridden by SetSubsystemVerbosityLevel, and their levels.
+func (l *Logger) SubsystemVerbosityLevels() map[string]int {
+	m := map[string]int{}
+	for i, s := range subsystems {
+		if v := atomic.LoadInt64(&l.subsysLevel[i]); v != subsysLevelUnset {
+			m[s] = int(v)
+		}
+	}
+	return m
+}
+
+// VerbosityLevel returns the overall verbosity level set by
+// SetVerbosityLevel.
+func (l *Logger) VerbosityLevel() int {
+	return int(atomic.LoadInt64(&l.stderrLevel))
+}
+
+// stderrLevelFor returns the maximum verbosity level of the log line
+// buf that should be written to stderr.
+func (l *Logger) stderrLevelFor(buf []byte) int64 {
+	if i := subsystemOf(buf); i != -1 {
+		if v := atomic.LoadInt64(&l.subsysLevel[i]); v != subsysLevelUnset {
+			return v
+		}
+	}
+	return atomic.LoadInt64(&l.stderrLevel)
+}
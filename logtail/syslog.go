@@ -0,0 +1,207 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package logtail
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyslogOptions configures an Uploader created by NewSyslogUploader.
+type SyslogOptions struct {
+	// Addr is the "host:port" of the syslog server.
+	Addr string
+
+	// TLSConfig, if non-nil, enables RFC 5425 syslog over TLS.
+	// Otherwise logs are sent over plain TCP (RFC 6587).
+	TLSConfig *tls.Config
+
+	// Hostname is the HOSTNAME field of each message.
+	// If empty, os.Hostname is used.
+	Hostname string
+
+	// AppName is the APP-NAME field of each message.
+	// If empty, "tailscale" is used.
+	AppName string
+
+	// Facility is the syslog facility code. If zero, "daemon" (3)
+	// is used; "kern" (0) can't be selected.
+	Facility int
+
+	// Dial, if non-nil, is used instead of a net.Dialer to
+	// establish the TCP connection.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// NewSyslogUploader returns an Uploader that sends each log entry as
+// an RFC 5424 syslog message over TCP, or TLS if configured, using
+// octet-counting framing.
+func NewSyslogUploader(opts SyslogOptions) (Uploader, error) {
+	if _, _, err := net.SplitHostPort(opts.Addr); err != nil {
+		return nil, fmt.Errorf("logtail: invalid syslog address %q: %w", opts.Addr, err)
+	}
+	if opts.Hostname == "" {
+		opts.Hostname, _ = os.Hostname()
+	}
+	if opts.AppName == "" {
+		opts.AppName = "tailscale"
+	}
+	if opts.Facility == 0 {
+		opts.Facility = syslogFacilityDaemon
+	}
+	if opts.Dial == nil {
+		var d net.Dialer
+		opts.Dial = d.DialContext
+	}
+	return &syslogUploader{
+		opts:   opts,
+		procID: strconv.Itoa(os.Getpid()),
+	}, nil
+}
+
+const syslogFacilityDaemon = 3
+
+// Syslog severities used for log levels.
+const (
+	syslogSeverityInfo  = 6
+	syslogSeverityDebug = 7
+)
+
+type syslogUploader struct {
+	opts   SyslogOptions
+	procID string
+
+	mu     sync.Mutex
+	conn   net.Conn // or nil if not connected
+	closed bool
+}
+
+func (u *syslogUploader) Upload(ctx context.Context, batch []byte) (uploaded bool, err error) {
+	ents, err := decodeBatch(batch, time.Now())
+	if err != nil {
+		// An undecodable batch will never become decodable.
+		return true, err
+	}
+	var buf bytes.Buffer
+	for _, e := range ents {
+		msg := u.appendMessage(nil, e)
+		buf.WriteString(strconv.Itoa(len(msg)))
+		buf.WriteByte(' ')
+		buf.Write(msg)
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.closed {
+		return false, errors.New("syslog uploader closed")
+	}
+	if u.conn == nil {
+		c, err := u.dialLocked(ctx)
+		if err != nil {
+			return false, fmt.Errorf("syslog dial %v: %w", u.opts.Addr, err)
+		}
+		u.conn = c
+	}
+	deadline := time.Now().Add(maxUploadTime)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	u.conn.SetWriteDeadline(deadline)
+	if _, err := u.conn.Write(buf.Bytes()); err != nil {
+		// The server may have received some of the batch, but we
+		// can't tell how much; resend it all on a new connection.
+		u.conn.Close()
+		u.conn = nil
+		return false, fmt.Errorf("syslog write to %v: %w", u.opts.Addr, err)
+	}
+	return true, nil
+}
+
+func (u *syslogUploader) dialLocked(ctx context.Context) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, maxUploadTime)
+	defer cancel()
+	c, err := u.opts.Dial(ctx, "tcp", u.opts.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.opts.TLSConfig == nil {
+		return c, nil
+	}
+	conf := u.opts.TLSConfig.Clone()
+	if conf.ServerName == "" {
+		conf.ServerName, _, _ = net.SplitHostPort(u.opts.Addr)
+	}
+	tc := tls.Client(c, conf)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+// Close closes the connection to the syslog server, if any.
+func (u *syslogUploader) Close() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.closed = true
+	if u.conn == nil {
+		return nil
+	}
+	err := u.conn.Close()
+	u.conn = nil
+	return err
+}
+
+// appendMessage appends the RFC 5424 encoding of e to b.
+func (u *syslogUploader) appendMessage(b []byte, e uploadEntry) []byte {
+	sev := syslogSeverityInfo
+	if e.Level > 0 {
+		sev = syslogSeverityDebug
+	}
+	b = append(b, '<')
+	b = strconv.AppendInt(b, int64(u.opts.Facility*8+sev), 10)
+	b = append(b, ">1 "...)
+	b = e.Time.UTC().AppendFormat(b, "2006-01-02T15:04:05.000000Z07:00")
+	b = append(b, ' ')
+	b = appendSyslogField(b, u.opts.Hostname, 255)
+	b = append(b, ' ')
+	b = appendSyslogField(b, u.opts.AppName, 48)
+	b = append(b, ' ')
+	b = appendSyslogField(b, u.procID, 128)
+	b = append(b, " - -"...) // MSGID and STRUCTURED-DATA
+	if e.Text != "" {
+		b = append(b, ' ')
+		b = append(b, e.Text...)
+	}
+	return b
+}
+
+// appendSyslogField appends a syslog header field to b, which must be
+// printable US-ASCII with no spaces and at most max bytes long. An
+// empty value is encoded as the NILVALUE "-".
+func appendSyslogField(b []byte, v string, max int) []byte {
+	v = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, v)
+	if len(v) > max {
+		v = v[:max]
+	}
+	if v == "" {
+		v = "-"
+	}
+	return append(b, v...)
+}
@@ -0,0 +1,319 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package logtail
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"tailscale.com/logtail/backoff"
+)
+
+// An Uploader sends batches of log entries to a log destination.
+//
+// Implementations that hold resources (such as network connections)
+// may also implement io.Closer, in which case they are closed when
+// the Logger shuts down.
+type Uploader interface {
+	// Upload sends batch, a JSON array of log entries as encoded by
+	// the Logger, to the destination.
+	//
+	// It reports whether the destination accepted the batch. If
+	// uploaded is false, the batch is retried (with backoff) until
+	// it succeeds or the Logger shuts down, except that Uploaders
+	// other than a Logger's first drop the oldest batches once they
+	// fall maxQueuedBatches behind. An Uploader may return
+	// uploaded as true alongside a non-nil error to report a problem
+	// that retrying would not fix.
+	Upload(ctx context.Context, batch []byte) (uploaded bool, err error)
+}
+
+// maxQueuedBatches is the number of batches that an Uploader other than
+// a Logger's first may fall behind by before its oldest batches are
+// dropped.
+const maxQueuedBatches = 64
+
+// An uploadQueue feeds batches to one of the Uploaders after a Logger's
+// first, so that a slow or unreachable destination doesn't hold up the
+// others. Batches that the Uploader falls too far behind on are dropped.
+type uploadQueue struct {
+	u       Uploader
+	stderr  io.Writer
+	bo      *backoff.Backoff
+	batches chan []byte
+	done    chan struct{} // closed when run returns
+}
+
+func newUploadQueue(u Uploader, stderr io.Writer, bo *backoff.Backoff) *uploadQueue {
+	return &uploadQueue{
+		u:       u,
+		stderr:  stderr,
+		bo:      bo,
+		batches: make(chan []byte, maxQueuedBatches),
+		done:    make(chan struct{}),
+	}
+}
+
+// enqueue queues a copy of batch for upload, dropping the oldest queued
+// batch if the queue is full.
+func (q *uploadQueue) enqueue(batch []byte) {
+	batch = append([]byte(nil), batch...)
+	for {
+		select {
+		case q.batches <- batch:
+			return
+		default:
+		}
+		select {
+		case <-q.batches:
+			fmt.Fprintf(q.stderr, "logtail: %T is behind; dropped a batch\n", q.u)
+		default:
+		}
+	}
+}
+
+// run uploads queued batches, retrying each until it's accepted or ctx
+// is done, until close is called and the queue is empty.
+func (q *uploadQueue) run(ctx context.Context) {
+	defer close(q.done)
+	for batch := range q.batches {
+		for ctx.Err() == nil {
+			uploaded, err := q.u.Upload(ctx, batch)
+			if err != nil {
+				fmt.Fprintf(q.stderr, "logtail: upload: %v\n", err)
+			}
+			if uploaded {
+				break
+			}
+			q.bo.BackOff(ctx, err)
+		}
+	}
+}
+
+// close stops q from accepting batches and waits for run to finish
+// uploading the queued ones.
+func (q *uploadQueue) close() {
+	close(q.batches)
+	<-q.done
+}
+
+// maxUploadTime is the maximum amount of time a single upload
+// attempt may take.
+const maxUploadTime = 45 * time.Second
+
+// catalogUploader uploads logs to a Tailscale log catalog server
+// (such as log.tailscale.io) in its native format.
+type catalogUploader struct {
+	httpc       *http.Client
+	url         string
+	zstdEncoder Encoder // or nil
+}
+
+func (u *catalogUploader) Upload(ctx context.Context, body []byte) (uploaded bool, err error) {
+	origlen := -1 // sentinel value: uncompressed
+	// Don't attempt to compress tiny bodies; not worth the CPU cycles.
+	if u.zstdEncoder != nil && len(body) > 256 {
+		zbody := u.zstdEncoder.EncodeAll(body, nil)
+		// Only send it compressed if the bandwidth savings are sufficient.
+		// Just the extra headers associated with enabling compression
+		// are 50 bytes by themselves.
+		if len(body)-len(zbody) > 64 {
+			origlen = len(body)
+			body = zbody
+		}
+	}
+	return u.upload(ctx, body, origlen)
+}
+
+// upload uploads body to the log server.
+// origlen indicates the pre-compression body length.
+// origlen of -1 indicates that the body is not compressed.
+func (u *catalogUploader) upload(ctx context.Context, body []byte, origlen int) (uploaded bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, maxUploadTime)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", u.url, bytes.NewReader(body))
+	if err != nil {
+		// I know of no conditions under which this could fail.
+		// Report it very loudly.
+		// TODO record logs to disk
+		panic("logtail: cannot build http request: " + err.Error())
+	}
+	if origlen != -1 {
+		req.Header.Add("Content-Encoding", "zstd")
+		req.Header.Add("Orig-Content-Length", strconv.Itoa(origlen))
+	}
+	req.Header["User-Agent"] = nil // not worth writing one; save some bytes
+
+	compressedNote := "not-compressed"
+	if origlen != -1 {
+		compressedNote = "compressed"
+	}
+
+	resp, err := u.httpc.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("log upload of %d bytes %s failed: %v", len(body), compressedNote, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		uploaded = resp.StatusCode == 400 // the server saved the logs anyway
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		return uploaded, fmt.Errorf("log upload of %d bytes %s failed %d: %q", len(body), compressedNote, resp.StatusCode, b)
+	}
+
+	// Try to read to EOF, in case server's response is
+	// chunked. We want to reuse the TCP connection if it's
+	// HTTP/1. On success, we expect 0 bytes.
+	// TODO(bradfitz): can remove a few days after 2020-04-04 once
+	// server is fixed.
+	if resp.ContentLength == -1 {
+		resp.Body.Read(make([]byte, 1))
+	}
+	return true, nil
+}
+
+// uploadEntry is a log entry decoded from a batch, for the benefit of
+// Uploaders that re-encode entries into another format.
+type uploadEntry struct {
+	Time    time.Time       // client time, or the time of decoding if absent
+	Level   int             // verbosity level; 0 is normal, 1+ are increasingly verbose
+	Text    string          // log text, or the JSON encoding of a structured entry
+	ProcID  uint32          // or zero
+	ProcSeq uint64          // or zero
+	Raw     json.RawMessage // the entry as encoded by the Logger
+}
+
+// decodeBatch decodes a batch, as passed to Uploader.Upload, into its
+// entries. Entries without a client time are stamped with now.
+func decodeBatch(batch []byte, now time.Time) ([]uploadEntry, error) {
+	var raws []json.RawMessage
+	if err := json.Unmarshal(batch, &raws); err != nil {
+		return nil, fmt.Errorf("decoding log batch: %w", err)
+	}
+	ents := make([]uploadEntry, 0, len(raws))
+	for _, raw := range raws {
+		var e struct {
+			Logtail struct {
+				ClientTime time.Time `json:"client_time"`
+				ProcID     uint32    `json:"proc_id"`
+				ProcSeq    uint64    `json:"proc_seq"`
+			} `json:"logtail"`
+			V    int     `json:"v"`
+			Text *string `json:"text"`
+		}
+		// Structured entries may contain arbitrary fields that
+		// don't fit the struct above; that's fine, as we fall back
+		// to the raw JSON below.
+		json.Unmarshal(raw, &e)
+
+		ent := uploadEntry{
+			Time:    e.Logtail.ClientTime,
+			Level:   e.V,
+			ProcID:  e.Logtail.ProcID,
+			ProcSeq: e.Logtail.ProcSeq,
+			Raw:     raw,
+		}
+		if ent.Time.IsZero() {
+			ent.Time = now
+		}
+		if e.Text != nil {
+			ent.Text = strings.TrimSuffix(*e.Text, "\n")
+		} else {
+			ent.Text = string(raw)
+		}
+		ents = append(ents, ent)
+	}
+	return ents, nil
+}
+
+// doPost POSTs body to urlStr with the given content type and extra
+// headers, and classifies the result the same way for all the generic
+// HTTP Uploaders.
+func doPost(ctx context.Context, httpc *http.Client, urlStr, contentType string, header http.Header, body []byte) (uploaded bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, maxUploadTime)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", urlStr, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for k, vv := range header {
+		req.Header[k] = vv
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := httpc.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("log upload of %d bytes to %s failed: %v", len(body), redactURL(urlStr), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+		return true, nil
+	}
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	// Client errors other than rate limiting won't get better with
+	// retries, so drop the batch rather than wedging the uploader.
+	uploaded = resp.StatusCode >= 400 && resp.StatusCode <= 499 && resp.StatusCode != http.StatusTooManyRequests
+	return uploaded, fmt.Errorf("log upload of %d bytes to %s failed %d: %q", len(body), redactURL(urlStr), resp.StatusCode, b)
+}
+
+// redactURL returns urlStr without any userinfo, for use in errors.
+func redactURL(urlStr string) string {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return "<invalid URL>"
+	}
+	return u.Redacted()
+}
+
+// JSONLinesOptions configures an Uploader created by NewJSONLinesUploader.
+type JSONLinesOptions struct {
+	URL    string       // HTTP(S) endpoint to POST batches to
+	HTTPC  *http.Client // if nil, http.DefaultClient
+	Header http.Header  // optional extra request headers (e.g. Authorization)
+}
+
+// NewJSONLinesUploader returns an Uploader that POSTs each batch to an
+// HTTP endpoint as newline-delimited JSON, one log entry per line.
+func NewJSONLinesUploader(opts JSONLinesOptions) (Uploader, error) {
+	if _, err := url.Parse(opts.URL); err != nil || opts.URL == "" {
+		return nil, fmt.Errorf("logtail: invalid JSON lines URL %q", opts.URL)
+	}
+	if opts.HTTPC == nil {
+		opts.HTTPC = http.DefaultClient
+	}
+	return &jsonLinesUploader{opts: opts}, nil
+}
+
+type jsonLinesUploader struct {
+	opts JSONLinesOptions
+}
+
+func (u *jsonLinesUploader) Upload(ctx context.Context, batch []byte) (uploaded bool, err error) {
+	ents, err := decodeBatch(batch, time.Now())
+	if err != nil {
+		// An undecodable batch will never become decodable.
+		return true, err
+	}
+	var buf bytes.Buffer
+	for _, e := range ents {
+		if err := json.Compact(&buf, e.Raw); err != nil {
+			continue
+		}
+		buf.WriteByte('\n')
+	}
+	return doPost(ctx, u.opts.HTTPC, u.opts.URL, "application/x-ndjson", u.opts.Header, buf.Bytes())
+}
@@ -0,0 +1,287 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package logtail
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newUploadTestServer returns an httptest server that sends the bodies
+// of requests it receives to the returned channel, along with the
+// request's Content-Type.
+func newUploadTestServer(t *testing.T) (*httptest.Server, chan [2]string) {
+	got := make(chan [2]string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		got <- [2]string{r.Header.Get("Content-Type"), string(body)}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, got
+}
+
+func recvUpload(t *testing.T, c chan [2]string) (contentType, body string) {
+	t.Helper()
+	select {
+	case v := <-c:
+		return v[0], v[1]
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for upload")
+	}
+	panic("unreachable")
+}
+
+func TestDecodeBatch(t *testing.T) {
+	now := time.Unix(1600000000, 0).UTC()
+	batch := `[
+		{"logtail": {"client_time": "2022-08-01T10:00:00Z", "proc_id": 7, "proc_seq": 3}, "v": 2, "text": "hello\n"},
+		{"text": "no time"},
+		{"foo": "bar"}
+	]`
+	ents, err := decodeBatch([]byte(batch), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ents) != 3 {
+		t.Fatalf("got %d entries; want 3", len(ents))
+	}
+	if want := time.Date(2022, 8, 1, 10, 0, 0, 0, time.UTC); !ents[0].Time.Equal(want) {
+		t.Errorf("ents[0].Time = %v; want %v", ents[0].Time, want)
+	}
+	if ents[0].Text != "hello" || ents[0].Level != 2 || ents[0].ProcID != 7 || ents[0].ProcSeq != 3 {
+		t.Errorf("ents[0] = %+v", ents[0])
+	}
+	if !ents[1].Time.Equal(now) || ents[1].Text != "no time" {
+		t.Errorf("ents[1] = %+v", ents[1])
+	}
+	if ents[2].Text != `{"foo": "bar"}` {
+		t.Errorf("ents[2].Text = %q", ents[2].Text)
+	}
+
+	if _, err := decodeBatch([]byte("not json"), now); err == nil {
+		t.Error("decodeBatch of invalid JSON succeeded")
+	}
+}
+
+func TestOTLPUploader(t *testing.T) {
+	srv, got := newUploadTestServer(t)
+	u, err := NewOTLPUploader(OTLPOptions{
+		URL:      srv.URL,
+		Resource: map[string]string{"service.name": "tailscaled"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewLogger(Config{SkipCatalog: true, Uploaders: []Uploader{u}}, t.Logf)
+	defer l.Shutdown(context.Background())
+
+	ct, body := recvUpload(t, got)
+	if ct != "application/json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	var req otlpLogsRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatalf("%v; body: %s", err, body)
+	}
+	if len(req.ResourceLogs) != 1 || len(req.ResourceLogs[0].ScopeLogs) != 1 {
+		t.Fatalf("unexpected request shape: %s", body)
+	}
+	attrs := req.ResourceLogs[0].Resource.Attributes
+	if len(attrs) != 1 || attrs[0].Key != "service.name" || *attrs[0].Value.StringValue != "tailscaled" {
+		t.Errorf("resource attributes = %s", body)
+	}
+	recs := req.ResourceLogs[0].ScopeLogs[0].LogRecords
+	if len(recs) != 1 || *recs[0].Body.StringValue != "logtail started" {
+		t.Fatalf("log records = %s", body)
+	}
+	if recs[0].SeverityText != "INFO" {
+		t.Errorf("severity = %q; want INFO", recs[0].SeverityText)
+	}
+	if _, err := strconv.ParseInt(recs[0].TimeUnixNano, 10, 64); err != nil {
+		t.Errorf("bad timeUnixNano: %v", err)
+	}
+
+	l.Write([]byte("[v1] verbose\n"))
+	_, body = recvUpload(t, got)
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+	rec := req.ResourceLogs[0].ScopeLogs[0].LogRecords[0]
+	if rec.SeverityText != "DEBUG" || *rec.Body.StringValue != "verbose" {
+		t.Errorf("got record %+v", rec)
+	}
+}
+
+func TestJSONLinesUploaderAlongsideCatalog(t *testing.T) {
+	catalog, catalogGot := newUploadTestServer(t)
+	jl, jlGot := newUploadTestServer(t)
+
+	u, err := NewJSONLinesUploader(JSONLinesOptions{URL: jl.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewLogger(Config{BaseURL: catalog.URL, Uploaders: []Uploader{u}}, t.Logf)
+	defer l.Shutdown(context.Background())
+
+	if _, body := recvUpload(t, catalogGot); !strings.Contains(body, "logtail started") {
+		t.Errorf("catalog got %q", body)
+	}
+	ct, body := recvUpload(t, jlGot)
+	if ct != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	lines := strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("got %d lines; want 1: %q", len(lines), body)
+	}
+	var ent struct{ Text string }
+	if err := json.Unmarshal([]byte(lines[0]), &ent); err != nil {
+		t.Fatal(err)
+	}
+	if ent.Text != "logtail started" {
+		t.Errorf("text = %q", ent.Text)
+	}
+}
+
+// deadUploader is an Uploader whose destination never accepts logs.
+type deadUploader struct{}
+
+func (deadUploader) Upload(ctx context.Context, batch []byte) (bool, error) {
+	return false, errors.New("unreachable")
+}
+
+func TestDeadUploaderDoesNotStallOthers(t *testing.T) {
+	catalog, catalogGot := newUploadTestServer(t)
+	l := NewLogger(Config{BaseURL: catalog.URL, Uploaders: []Uploader{deadUploader{}}, Stderr: io.Discard}, t.Logf)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	defer l.Shutdown(ctx)
+
+	if _, body := recvUpload(t, catalogGot); !strings.Contains(body, "logtail started") {
+		t.Errorf("catalog got %q", body)
+	}
+	l.Write([]byte("second\n"))
+	if _, body := recvUpload(t, catalogGot); !strings.Contains(body, "second") {
+		t.Errorf("catalog got %q", body)
+	}
+}
+
+func TestUploaderRetries(t *testing.T) {
+	var calls atomic.Int32
+	got := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		got <- string(b)
+	}))
+	defer srv.Close()
+
+	u, err := NewJSONLinesUploader(JSONLinesOptions{URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewLogger(Config{SkipCatalog: true, Uploaders: []Uploader{u}, Stderr: io.Discard}, t.Logf)
+	defer l.Shutdown(context.Background())
+
+	select {
+	case body := <-got:
+		if !strings.Contains(body, "logtail started") {
+			t.Errorf("got %q", body)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("timeout waiting for retried upload")
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("got %d calls; want 2", n)
+	}
+}
+
+func TestSyslogUploader(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	msgs := make(chan string, 10)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		br := bufio.NewReader(c)
+		for {
+			lenStr, err := br.ReadString(' ')
+			if err != nil {
+				return
+			}
+			n, err := strconv.Atoi(strings.TrimSpace(lenStr))
+			if err != nil {
+				t.Errorf("bad frame length %q", lenStr)
+				return
+			}
+			msg := make([]byte, n)
+			if _, err := io.ReadFull(br, msg); err != nil {
+				return
+			}
+			msgs <- string(msg)
+		}
+	}()
+
+	u, err := NewSyslogUploader(SyslogOptions{
+		Addr:     ln.Addr().String(),
+		Hostname: "my host",
+		AppName:  "tailscaled",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewLogger(Config{SkipCatalog: true, Uploaders: []Uploader{u}}, t.Logf)
+	defer l.Shutdown(context.Background())
+
+	var msg string
+	select {
+	case msg = <-msgs:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for syslog message")
+	}
+	// <daemon*8+info>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD MSG
+	f := strings.SplitN(msg, " ", 8)
+	if len(f) != 8 {
+		t.Fatalf("malformed message %q", msg)
+	}
+	if f[0] != "<30>1" {
+		t.Errorf("PRI/VERSION = %q; want <30>1", f[0])
+	}
+	if _, err := time.Parse(time.RFC3339Nano, f[1]); err != nil {
+		t.Errorf("bad timestamp: %v", err)
+	}
+	if f[2] != "my_host" || f[3] != "tailscaled" {
+		t.Errorf("HOSTNAME, APP-NAME = %q, %q", f[2], f[3])
+	}
+	if f[5] != "-" || f[6] != "-" {
+		t.Errorf("MSGID, SD = %q, %q", f[5], f[6])
+	}
+	if f[7] != "logtail started" {
+		t.Errorf("MSG = %q", f[7])
+	}
+}
@@ -0,0 +1,485 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package bgp implements a minimal BGP-4 speaker, for subnet routers
+// to announce their routes to a single external BGP neighbor without
+// running a separate routing daemon.
+//
+// It only speaks eBGP to one neighbor at a time, announces unicast
+// routes of the address family of the session (IPv4 routes over IPv4,
+// IPv6 routes over IPv6) with itself as the next hop, and keeps the
+// routes the neighbor announces without using them.
+package bgp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"tailscale.com/types/logger"
+)
+
+// DefaultPort is the TCP port BGP neighbors listen on.
+const DefaultPort = 179
+
+const (
+	defaultHoldTime   = 90 * time.Second
+	defaultRetryDelay = 10 * time.Second
+
+	// maxRoutesPerUpdate bounds the prefixes per UPDATE message to
+	// keep messages under maxMsgLen even for IPv6.
+	maxRoutesPerUpdate = 200
+)
+
+// Config is the configuration of a Speaker.
+type Config struct {
+	// LocalAS is this speaker's autonomous system number.
+	LocalAS uint32
+
+	// PeerAS is the neighbor's autonomous system number. Sessions
+	// with a neighbor claiming a different AS are rejected.
+	PeerAS uint32
+
+	// Neighbor is the address of the neighbor that Run connects to.
+	// If it has no port, DefaultPort is used.
+	Neighbor string
+
+	// RouterID is the speaker's BGP identifier. If invalid, the local
+	// IPv4 address of the session is used, in which case sessions
+	// over IPv6 fail.
+	RouterID netip.Addr
+
+	// HoldTime is the hold time to propose to the neighbor. If zero,
+	// 90 seconds is used.
+	HoldTime time.Duration
+
+	// RetryDelay is how long Run waits before reconnecting after a
+	// session ends or fails to start. If zero, 10 seconds is used.
+	RetryDelay time.Duration
+
+	// Logf, if non-nil, is where the speaker logs.
+	Logf logger.Logf
+}
+
+// State is the state of a Speaker's BGP session.
+type State string
+
+const (
+	StateIdle        State = "Idle"
+	StateConnect     State = "Connect"
+	StateOpenSent    State = "OpenSent"
+	StateEstablished State = "Established"
+)
+
+// Speaker is a BGP speaker. Its methods are safe for concurrent use.
+type Speaker struct {
+	cfg     Config
+	logf    logger.Logf
+	closed  chan struct{}
+	changed chan struct{} // buffered 1; signals the session that routes changed
+
+	mu        sync.Mutex
+	closeOnce sync.Once
+	state     State
+	routes    map[netip.Prefix]bool // to announce
+	received  map[netip.Prefix]bool // announced by the neighbor
+}
+
+// New returns a new Speaker. It doesn't do anything until Run or
+// Serve is called.
+func New(cfg Config) (*Speaker, error) {
+	if cfg.LocalAS == 0 || cfg.PeerAS == 0 {
+		return nil, errors.New("bgp: LocalAS and PeerAS are required")
+	}
+	if cfg.LocalAS == cfg.PeerAS {
+		return nil, errors.New("bgp: only eBGP is supported; LocalAS and PeerAS must differ")
+	}
+	if cfg.RouterID.IsValid() && !cfg.RouterID.Is4() {
+		return nil, fmt.Errorf("bgp: RouterID %v is not an IPv4 address", cfg.RouterID)
+	}
+	if cfg.HoldTime == 0 {
+		cfg.HoldTime = defaultHoldTime
+	}
+	if cfg.RetryDelay == 0 {
+		cfg.RetryDelay = defaultRetryDelay
+	}
+	logf := cfg.Logf
+	if logf == nil {
+		logf = logger.Discard
+	}
+	return &Speaker{
+		cfg:      cfg,
+		logf:     logger.WithPrefix(logf, "bgp: "),
+		closed:   make(chan struct{}),
+		changed:  make(chan struct{}, 1),
+		state:    StateIdle,
+		routes:   map[netip.Prefix]bool{},
+		received: map[netip.Prefix]bool{},
+	}, nil
+}
+
+// SetRoutes sets the routes to announce to the neighbor, withdrawing
+// any previously announced ones not in routes.
+func (s *Speaker) SetRoutes(routes []netip.Prefix) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := make(map[netip.Prefix]bool, len(routes))
+	for _, r := range routes {
+		m[r.Masked()] = true
+	}
+	if mapsEqual(m, s.routes) {
+		return
+	}
+	s.routes = m
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// ReceivedRoutes returns the routes the neighbor currently announces,
+// in sorted order.
+func (s *Speaker) ReceivedRoutes() []netip.Prefix {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sortedPrefixes(s.received)
+}
+
+// State returns the state of the speaker's session.
+func (s *Speaker) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+func (s *Speaker) setState(st State) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = st
+	if st != StateEstablished {
+		s.received = map[netip.Prefix]bool{}
+	}
+}
+
+// Close ends the speaker's session, if any, and makes Run and Serve
+// return.
+func (s *Speaker) Close() error {
+	s.closeOnce.Do(func() { close(s.closed) })
+	return nil
+}
+
+// Run connects to the configured neighbor and maintains a session
+// with it, reconnecting as needed, until ctx is done or the speaker
+// is closed.
+func (s *Speaker) Run(ctx context.Context) error {
+	ctx, cancel := s.withClose(ctx)
+	defer cancel()
+	addr := s.cfg.Neighbor
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, fmt.Sprint(DefaultPort))
+	}
+	var d net.Dialer
+	for {
+		s.setState(StateConnect)
+		c, err := d.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			err = s.runSession(ctx, c)
+		}
+		s.setState(StateIdle)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		s.logf("session with %v ended: %v; retrying in %v", addr, err, s.cfg.RetryDelay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(s.cfg.RetryDelay):
+		}
+	}
+}
+
+// Serve accepts sessions from neighbors connecting to ln, one at a
+// time, until ln fails or the speaker is closed.
+func (s *Speaker) Serve(ln net.Listener) error {
+	ctx, cancel := s.withClose(context.Background())
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		err = s.runSession(ctx, c)
+		s.setState(StateIdle)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		s.logf("session with %v ended: %v", c.RemoteAddr(), err)
+	}
+}
+
+// withClose returns a context that's done when ctx is or when s is
+// closed.
+func (s *Speaker) withClose(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-s.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// runSession runs a BGP session over c until it fails or ctx is done.
+// It closes c.
+func (s *Speaker) runSession(ctx context.Context, c net.Conn) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		select {
+		case <-s.closed:
+			// Tell the neighbor we're going away on purpose.
+			c.SetWriteDeadline(time.Now().Add(time.Second))
+			c.Write(marshalNotification(errCodeCease, 0))
+		default:
+		}
+		c.Close()
+	}()
+
+	local, err := netip.ParseAddrPort(c.LocalAddr().String())
+	if err != nil {
+		return err
+	}
+	localIP := local.Addr().Unmap()
+	routerID := s.cfg.RouterID
+	if !routerID.IsValid() {
+		if !localIP.Is4() {
+			return errors.New("RouterID is required for sessions over IPv6")
+		}
+		routerID = localIP
+	}
+
+	afi := uint32(afiIPv4)
+	if localIP.Is6() {
+		afi = afiIPv6
+	}
+	s.setState(StateOpenSent)
+	open := &openMsg{
+		as:       s.cfg.LocalAS,
+		holdTime: uint16(s.cfg.HoldTime / time.Second),
+		routerID: routerID,
+		families: []uint32{afi<<16 | safiUnicast},
+	}
+	if _, err := c.Write(open.marshal()); err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(c)
+	c.SetReadDeadline(time.Now().Add(s.cfg.HoldTime))
+	typ, body, err := readMessage(br)
+	if err != nil {
+		return err
+	}
+	if typ == msgNotification {
+		return parseNotification(body)
+	}
+	if typ != msgOpen {
+		return fmt.Errorf("got message type %d; want OPEN", typ)
+	}
+	peerOpen, err := parseOpen(body)
+	if err != nil {
+		return err
+	}
+	if peerOpen.as != s.cfg.PeerAS {
+		c.Write(marshalNotification(errCodeOpen, errSubBadPeerAS))
+		return fmt.Errorf("neighbor has AS %d; want %d", peerOpen.as, s.cfg.PeerAS)
+	}
+	hold := s.cfg.HoldTime
+	if ph := time.Duration(peerOpen.holdTime) * time.Second; ph < hold {
+		hold = ph
+	}
+	if _, err := c.Write(marshalKeepalive()); err != nil {
+		return err
+	}
+	typ, body, err = readMessage(br)
+	if err != nil {
+		return err
+	}
+	if typ == msgNotification {
+		return parseNotification(body)
+	}
+	if typ != msgKeepalive {
+		return fmt.Errorf("got message type %d; want KEEPALIVE", typ)
+	}
+	s.setState(StateEstablished)
+	s.logf("session with %v (AS %d, ID %v) established", c.RemoteAddr(), peerOpen.as, peerOpen.routerID)
+
+	errc := make(chan error, 2)
+	go func() { errc <- s.readLoop(c, br, hold) }()
+	go func() { errc <- s.writeLoop(ctx, c, hold, peerOpen.fourAS, localIP) }()
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return err
+}
+
+// readLoop reads messages from an established session until it fails.
+func (s *Speaker) readLoop(c net.Conn, br *bufio.Reader, hold time.Duration) error {
+	for {
+		if hold > 0 {
+			c.SetReadDeadline(time.Now().Add(hold))
+		} else {
+			c.SetReadDeadline(time.Time{})
+		}
+		typ, body, err := readMessage(br)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				c.Write(marshalNotification(errCodeHoldTimer, 0))
+				return errors.New("hold timer expired")
+			}
+			return err
+		}
+		switch typ {
+		case msgKeepalive:
+		case msgNotification:
+			return parseNotification(body)
+		case msgUpdate:
+			u, err := parseUpdate(body)
+			if err != nil {
+				return err
+			}
+			s.mu.Lock()
+			for _, p := range u.withdraw {
+				delete(s.received, p)
+			}
+			for _, p := range u.announce {
+				s.received[p] = true
+			}
+			s.mu.Unlock()
+		default:
+			return fmt.Errorf("unexpected message type %d", typ)
+		}
+	}
+}
+
+// writeLoop sends keepalives and route changes on an established
+// session until it fails or ctx is done.
+func (s *Speaker) writeLoop(ctx context.Context, c net.Conn, hold time.Duration, fourAS bool, localIP netip.Addr) error {
+	var keepalive <-chan time.Time
+	if hold > 0 {
+		t := time.NewTicker(hold / 3)
+		defer t.Stop()
+		keepalive = t.C
+	}
+	announced := map[netip.Prefix]bool{}
+	for {
+		s.mu.Lock()
+		var add, del []netip.Prefix
+		for r := range s.routes {
+			if r.Addr().Is4() == localIP.Is4() && !announced[r] {
+				add = append(add, r)
+			}
+		}
+		for r := range announced {
+			if !s.routes[r] {
+				del = append(del, r)
+			}
+		}
+		s.mu.Unlock()
+
+		for _, batch := range batches(del) {
+			if _, err := c.Write(marshalUpdate(batch, true, s.cfg.LocalAS, fourAS, localIP)); err != nil {
+				return err
+			}
+		}
+		for _, batch := range batches(add) {
+			if _, err := c.Write(marshalUpdate(batch, false, s.cfg.LocalAS, fourAS, localIP)); err != nil {
+				return err
+			}
+		}
+		for _, r := range del {
+			delete(announced, r)
+		}
+		for _, r := range add {
+			announced[r] = true
+		}
+		if len(add)+len(del) > 0 {
+			s.logf("announced %d routes, withdrew %d", len(add), len(del))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.changed:
+		case <-keepalive:
+			if _, err := c.Write(marshalKeepalive()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// batches splits routes into sorted batches of at most
+// maxRoutesPerUpdate.
+func batches(routes []netip.Prefix) [][]netip.Prefix {
+	sortPrefixes(routes)
+	var ret [][]netip.Prefix
+	for len(routes) > 0 {
+		n := len(routes)
+		if n > maxRoutesPerUpdate {
+			n = maxRoutesPerUpdate
+		}
+		ret = append(ret, routes[:n])
+		routes = routes[n:]
+	}
+	return ret
+}
+
+func sortedPrefixes(m map[netip.Prefix]bool) []netip.Prefix {
+	ret := make([]netip.Prefix, 0, len(m))
+	for p := range m {
+		ret = append(ret, p)
+	}
+	sortPrefixes(ret)
+	return ret
+}
+
+func sortPrefixes(ps []netip.Prefix) {
+	sort.Slice(ps, func(i, j int) bool {
+		if c := ps[i].Addr().Compare(ps[j].Addr()); c != 0 {
+			return c < 0
+		}
+		return ps[i].Bits() < ps[j].Bits()
+	})
+}
+
+func mapsEqual(a, b map[netip.Prefix]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if !b[k] {
+			return false
+		}
+	}
+	return true
+}
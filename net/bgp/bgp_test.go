@@ -0,0 +1,180 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bgp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func mustPrefixes(ss ...string) []netip.Prefix {
+	ret := make([]netip.Prefix, 0, len(ss))
+	for _, s := range ss {
+		ret = append(ret, netip.MustParsePrefix(s))
+	}
+	return ret
+}
+
+func TestUpdateRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		routes   []netip.Prefix
+		withdraw bool
+		nextHop  netip.Addr
+	}{
+		{"announce4", mustPrefixes("10.0.0.0/8", "192.168.1.0/24", "100.100.1.1/32"), false, netip.MustParseAddr("10.1.1.1")},
+		{"withdraw4", mustPrefixes("10.0.0.0/8"), true, netip.Addr{}},
+		{"announce6", mustPrefixes("fd7a:115c:a1e0::/48", "2001:db8::/32"), false, netip.MustParseAddr("fd00::1")},
+		{"withdraw6", mustPrefixes("2001:db8::/32"), true, netip.Addr{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := marshalUpdate(tt.routes, tt.withdraw, 4200000000, true, tt.nextHop)
+			typ, body, err := readMessage(bufio.NewReader(bytes.NewReader(msg)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if typ != msgUpdate {
+				t.Fatalf("type = %d; want UPDATE", typ)
+			}
+			u, err := parseUpdate(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, other := u.announce, u.withdraw
+			if tt.withdraw {
+				got, other = u.withdraw, u.announce
+			}
+			if !reflect.DeepEqual(got, tt.routes) || len(other) != 0 {
+				t.Errorf("got %+v; want %v", u, tt.routes)
+			}
+		})
+	}
+}
+
+func TestOpenRoundTrip(t *testing.T) {
+	m := &openMsg{
+		as:       4200000000,
+		holdTime: 90,
+		routerID: netip.MustParseAddr("10.1.2.3"),
+		families: []uint32{afiIPv6<<16 | safiUnicast},
+	}
+	typ, body, err := readMessage(bufio.NewReader(bytes.NewReader(m.marshal())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != msgOpen {
+		t.Fatalf("type = %d; want OPEN", typ)
+	}
+	got, err := parseOpen(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.fourAS = true
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("got %+v; want %+v", got, m)
+	}
+}
+
+// waitFor polls cond until it's true or a few seconds pass.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestSpeakers(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := New(Config{LocalAS: 65001, PeerAS: 65002, Logf: t.Logf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := New(Config{LocalAS: 65002, PeerAS: 65001, Neighbor: ln.Addr().String(), RetryDelay: 50 * time.Millisecond, Logf: t.Logf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	a.SetRoutes(mustPrefixes("10.1.0.0/16", "10.2.0.0/16", "fd00::/64"))
+	go a.Serve(ln)
+	go b.Run(context.Background())
+
+	waitFor(t, "session", func() bool {
+		return a.State() == StateEstablished && b.State() == StateEstablished
+	})
+	// The IPv6 route isn't announced over an IPv4 session.
+	want := mustPrefixes("10.1.0.0/16", "10.2.0.0/16")
+	waitFor(t, "initial routes", func() bool { return reflect.DeepEqual(b.ReceivedRoutes(), want) })
+
+	a.SetRoutes(mustPrefixes("10.2.0.0/16", "10.3.0.0/24"))
+	want = mustPrefixes("10.2.0.0/16", "10.3.0.0/24")
+	waitFor(t, "changed routes", func() bool { return reflect.DeepEqual(b.ReceivedRoutes(), want) })
+
+	b.SetRoutes(mustPrefixes("192.168.0.0/24"))
+	want = mustPrefixes("192.168.0.0/24")
+	waitFor(t, "routes from b", func() bool { return reflect.DeepEqual(a.ReceivedRoutes(), want) })
+
+	a.SetRoutes(nil)
+	waitFor(t, "withdrawal", func() bool { return len(b.ReceivedRoutes()) == 0 })
+
+	// Once a is closed, b loses the session and its routes.
+	a.SetRoutes(mustPrefixes("10.1.0.0/16"))
+	waitFor(t, "re-announcement", func() bool { return len(b.ReceivedRoutes()) == 1 })
+	a.Close()
+	waitFor(t, "session end", func() bool { return b.State() != StateEstablished && len(b.ReceivedRoutes()) == 0 })
+}
+
+func TestSpeakerBadPeerAS(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := New(Config{LocalAS: 65001, PeerAS: 65099})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	go a.Serve(ln)
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	open := &openMsg{as: 65002, holdTime: 90, routerID: netip.MustParseAddr("10.0.0.2")}
+	if _, err := c.Write(open.marshal()); err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	br := bufio.NewReader(c)
+	for {
+		typ, body, err := readMessage(br)
+		if err != nil {
+			t.Fatalf("reading until NOTIFICATION: %v", err)
+		}
+		if typ != msgNotification {
+			continue
+		}
+		err = parseNotification(body)
+		if ne, ok := err.(notificationError); !ok || ne.code != errCodeOpen || ne.subcode != errSubBadPeerAS {
+			t.Errorf("got %v; want bad peer AS notification", err)
+		}
+		return
+	}
+}
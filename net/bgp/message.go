@@ -0,0 +1,369 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bgp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+)
+
+// Message types, from RFC 4271 section 4.1.
+const (
+	msgOpen         = 1
+	msgUpdate       = 2
+	msgNotification = 3
+	msgKeepalive    = 4
+)
+
+const (
+	headerLen  = 19
+	maxMsgLen  = 4096
+	bgpVersion = 4
+
+	// asTrans is the 2-octet AS number sent in place of a 4-octet one
+	// to peers that don't support the latter (RFC 6793).
+	asTrans = 23456
+)
+
+// Capability codes, sent in OPEN messages (RFC 5492).
+const (
+	capMultiprotocol = 1
+	capFourOctetAS   = 65
+)
+
+// Address family identifiers (RFC 4760).
+const (
+	afiIPv4     = 1
+	afiIPv6     = 2
+	safiUnicast = 1
+)
+
+// Path attribute flags and type codes (RFC 4271 section 4.3 and RFC 4760).
+const (
+	attrFlagOptional   = 0x80
+	attrFlagTransitive = 0x40
+	attrFlagExtLen     = 0x10
+
+	attrOrigin        = 1
+	attrASPath        = 2
+	attrNextHop       = 3
+	attrMPReachNLRI   = 14
+	attrMPUnreachNLRI = 15
+
+	originIGP      = 0
+	asPathSequence = 2
+)
+
+// NOTIFICATION error codes and subcodes (RFC 4271 section 4.5).
+const (
+	errCodeOpen      = 2
+	errCodeHoldTimer = 4
+	errCodeCease     = 6
+
+	errSubBadPeerAS = 2
+)
+
+// appendHeader appends a message header for a message of type typ
+// with a body of bodyLen bytes to b.
+func appendHeader(b []byte, typ byte, bodyLen int) []byte {
+	for i := 0; i < 16; i++ {
+		b = append(b, 0xff)
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(headerLen+bodyLen))
+	return append(b, typ)
+}
+
+// readMessage reads a BGP message from br and returns its type and body.
+func readMessage(br *bufio.Reader) (typ byte, body []byte, err error) {
+	var hdr [headerLen]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	for _, b := range hdr[:16] {
+		if b != 0xff {
+			return 0, nil, errors.New("bgp: bad message marker")
+		}
+	}
+	n := int(binary.BigEndian.Uint16(hdr[16:18]))
+	if n < headerLen || n > maxMsgLen {
+		return 0, nil, fmt.Errorf("bgp: bad message length %d", n)
+	}
+	body = make([]byte, n-headerLen)
+	if _, err := io.ReadFull(br, body); err != nil {
+		return 0, nil, err
+	}
+	return hdr[18], body, nil
+}
+
+// openMsg is an OPEN message.
+type openMsg struct {
+	as       uint32 // the 4-octet AS, if advertised; else the 2-octet one
+	holdTime uint16 // seconds
+	routerID netip.Addr
+	fourAS   bool     // whether the 4-octet AS number capability was advertised
+	families []uint32 // AFI<<16 | SAFI of the multiprotocol capabilities
+}
+
+func (m *openMsg) marshal() []byte {
+	var caps []byte
+	for _, f := range m.families {
+		caps = append(caps, capMultiprotocol, 4)
+		caps = binary.BigEndian.AppendUint16(caps, uint16(f>>16))
+		caps = append(caps, 0, byte(f))
+	}
+	caps = append(caps, capFourOctetAS, 4)
+	caps = binary.BigEndian.AppendUint32(caps, m.as)
+
+	as2 := uint16(asTrans)
+	if m.as <= 0xffff {
+		as2 = uint16(m.as)
+	}
+	body := []byte{bgpVersion}
+	body = binary.BigEndian.AppendUint16(body, as2)
+	body = binary.BigEndian.AppendUint16(body, m.holdTime)
+	id := m.routerID.As4()
+	body = append(body, id[:]...)
+	body = append(body, byte(2+len(caps)), 2, byte(len(caps)))
+	body = append(body, caps...)
+	return append(appendHeader(nil, msgOpen, len(body)), body...)
+}
+
+func parseOpen(b []byte) (*openMsg, error) {
+	if len(b) < 10 {
+		return nil, errors.New("bgp: short OPEN message")
+	}
+	if b[0] != bgpVersion {
+		return nil, fmt.Errorf("bgp: unsupported version %d", b[0])
+	}
+	m := &openMsg{
+		as:       uint32(binary.BigEndian.Uint16(b[1:3])),
+		holdTime: binary.BigEndian.Uint16(b[3:5]),
+		routerID: netip.AddrFrom4(*(*[4]byte)(b[5:9])),
+	}
+	params := b[10:]
+	if len(params) != int(b[9]) {
+		return nil, errors.New("bgp: bad OPEN optional parameters length")
+	}
+	for len(params) > 0 {
+		if len(params) < 2 || len(params) < 2+int(params[1]) {
+			return nil, errors.New("bgp: truncated OPEN optional parameter")
+		}
+		typ, val := params[0], params[2:2+int(params[1])]
+		params = params[2+int(params[1]):]
+		if typ != 2 { // capabilities
+			continue
+		}
+		for len(val) > 0 {
+			if len(val) < 2 || len(val) < 2+int(val[1]) {
+				return nil, errors.New("bgp: truncated capability")
+			}
+			code, cv := val[0], val[2:2+int(val[1])]
+			val = val[2+int(val[1]):]
+			switch {
+			case code == capFourOctetAS && len(cv) == 4:
+				m.fourAS = true
+				m.as = binary.BigEndian.Uint32(cv)
+			case code == capMultiprotocol && len(cv) == 4:
+				m.families = append(m.families, uint32(binary.BigEndian.Uint16(cv))<<16|uint32(cv[3]))
+			}
+		}
+	}
+	return m, nil
+}
+
+func marshalKeepalive() []byte {
+	return appendHeader(nil, msgKeepalive, 0)
+}
+
+func marshalNotification(code, subcode byte) []byte {
+	return append(appendHeader(nil, msgNotification, 2), code, subcode)
+}
+
+// notificationError is a NOTIFICATION received from a peer.
+type notificationError struct {
+	code, subcode byte
+}
+
+func (e notificationError) Error() string {
+	return fmt.Sprintf("bgp: peer sent NOTIFICATION code %d subcode %d", e.code, e.subcode)
+}
+
+func parseNotification(b []byte) error {
+	if len(b) < 2 {
+		return errors.New("bgp: short NOTIFICATION message")
+	}
+	return notificationError{b[0], b[1]}
+}
+
+// appendPrefix appends p in NLRI encoding to b.
+func appendPrefix(b []byte, p netip.Prefix) []byte {
+	b = append(b, byte(p.Bits()))
+	return append(b, p.Addr().AsSlice()[:(p.Bits()+7)/8]...)
+}
+
+// parsePrefixes parses the NLRI-encoded prefixes of the family of
+// addresses of size addrLen in b.
+func parsePrefixes(b []byte, addrLen int) ([]netip.Prefix, error) {
+	var ret []netip.Prefix
+	for len(b) > 0 {
+		bits := int(b[0])
+		n := (bits + 7) / 8
+		if bits > addrLen*8 || len(b) < 1+n {
+			return nil, errors.New("bgp: bad NLRI prefix")
+		}
+		var a [16]byte
+		copy(a[:], b[1:1+n])
+		ip, _ := netip.AddrFromSlice(a[:addrLen])
+		ret = append(ret, netip.PrefixFrom(ip, bits).Masked())
+		b = b[1+n:]
+	}
+	return ret, nil
+}
+
+// appendAttr appends a path attribute to b.
+func appendAttr(b []byte, flags, typ byte, val []byte) []byte {
+	if len(val) > 0xff {
+		b = append(b, flags|attrFlagExtLen, typ)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(val)))
+	} else {
+		b = append(b, flags, typ, byte(len(val)))
+	}
+	return append(b, val...)
+}
+
+// updateMsg is the subset of an UPDATE message this package deals
+// with: the reachable and withdrawn unicast prefixes.
+type updateMsg struct {
+	announce []netip.Prefix
+	withdraw []netip.Prefix
+}
+
+// marshalUpdate returns an UPDATE message announcing or withdrawing
+// routes, which must all be of the same address family. Announced
+// routes get an AS_PATH of just localAS and the next hop nextHop.
+func marshalUpdate(routes []netip.Prefix, withdraw bool, localAS uint32, fourAS bool, nextHop netip.Addr) []byte {
+	var nlri []byte
+	for _, r := range routes {
+		nlri = appendPrefix(nlri, r)
+	}
+	is6 := len(routes) > 0 && routes[0].Addr().Is6()
+
+	var withdrawn, attrs []byte
+	switch {
+	case withdraw && !is6:
+		withdrawn, nlri = nlri, nil
+	case withdraw && is6:
+		val := binary.BigEndian.AppendUint16(nil, afiIPv6)
+		val = append(val, safiUnicast)
+		attrs = appendAttr(attrs, attrFlagOptional, attrMPUnreachNLRI, append(val, nlri...))
+		nlri = nil
+	default:
+		attrs = appendAttr(attrs, attrFlagTransitive, attrOrigin, []byte{originIGP})
+		asPath := []byte{asPathSequence, 1}
+		if fourAS {
+			asPath = binary.BigEndian.AppendUint32(asPath, localAS)
+		} else if localAS <= 0xffff {
+			asPath = binary.BigEndian.AppendUint16(asPath, uint16(localAS))
+		} else {
+			asPath = binary.BigEndian.AppendUint16(asPath, asTrans)
+		}
+		attrs = appendAttr(attrs, attrFlagTransitive, attrASPath, asPath)
+		if is6 {
+			val := binary.BigEndian.AppendUint16(nil, afiIPv6)
+			val = append(val, safiUnicast, 16)
+			val = append(val, nextHop.AsSlice()...)
+			val = append(val, 0) // reserved
+			attrs = appendAttr(attrs, attrFlagOptional, attrMPReachNLRI, append(val, nlri...))
+			nlri = nil
+		} else {
+			attrs = appendAttr(attrs, attrFlagTransitive, attrNextHop, nextHop.AsSlice())
+		}
+	}
+
+	body := binary.BigEndian.AppendUint16(nil, uint16(len(withdrawn)))
+	body = append(body, withdrawn...)
+	body = binary.BigEndian.AppendUint16(body, uint16(len(attrs)))
+	body = append(body, attrs...)
+	body = append(body, nlri...)
+	return append(appendHeader(nil, msgUpdate, len(body)), body...)
+}
+
+func parseUpdate(b []byte) (*updateMsg, error) {
+	errShort := errors.New("bgp: truncated UPDATE message")
+	m := new(updateMsg)
+	if len(b) < 2 {
+		return nil, errShort
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n+2 {
+		return nil, errShort
+	}
+	w, err := parsePrefixes(b[2:2+n], 4)
+	if err != nil {
+		return nil, err
+	}
+	m.withdraw = append(m.withdraw, w...)
+	b = b[2+n:]
+	n = int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return nil, errShort
+	}
+	attrs := b[2 : 2+n]
+	a, err := parsePrefixes(b[2+n:], 4)
+	if err != nil {
+		return nil, err
+	}
+	m.announce = append(m.announce, a...)
+
+	for len(attrs) > 0 {
+		if len(attrs) < 3 {
+			return nil, errShort
+		}
+		flags, typ := attrs[0], attrs[1]
+		hdr, vlen := 3, int(attrs[2])
+		if flags&attrFlagExtLen != 0 {
+			if len(attrs) < 4 {
+				return nil, errShort
+			}
+			hdr, vlen = 4, int(binary.BigEndian.Uint16(attrs[2:]))
+		}
+		if len(attrs) < hdr+vlen {
+			return nil, errShort
+		}
+		val := attrs[hdr : hdr+vlen]
+		attrs = attrs[hdr+vlen:]
+		switch typ {
+		case attrMPReachNLRI:
+			if len(val) < 5 || len(val) < 5+int(val[3]) {
+				return nil, errShort
+			}
+			if binary.BigEndian.Uint16(val) != afiIPv6 || val[2] != safiUnicast {
+				continue
+			}
+			p, err := parsePrefixes(val[5+int(val[3]):], 16)
+			if err != nil {
+				return nil, err
+			}
+			m.announce = append(m.announce, p...)
+		case attrMPUnreachNLRI:
+			if len(val) < 3 {
+				return nil, errShort
+			}
+			if binary.BigEndian.Uint16(val) != afiIPv6 || val[2] != safiUnicast {
+				continue
+			}
+			p, err := parsePrefixes(val[3:], 16)
+			if err != nil {
+				return nil, err
+			}
+			m.withdraw = append(m.withdraw, p...)
+		}
+	}
+	return m, nil
+}
@@ -110,6 +110,11 @@ func NewManager(logf logger.Logf, oscfg OSConfigurator, linkMon *monitor.Mon, di
 // Resolver returns the Manager's DNS Resolver.
 func (m *Manager) Resolver() *resolver.Resolver { return m.resolver }
 
+// OSBaseConfig returns the OS's DNS configuration without any changes
+// made by Tailscale. It returns ErrGetBaseConfigNotSupported if the
+// OS configurator can't read it.
+func (m *Manager) OSBaseConfig() (OSConfig, error) { return m.os.GetBaseConfig() }
+
 func (m *Manager) Set(cfg Config) error {
 	m.logf("Set: %v", logger.ArgWriter(func(w *bufio.Writer) {
 		cfg.WriteToBufioWriter(w)
@@ -452,6 +452,7 @@ func (f *forwarder) sendDoH(ctx context.Context, urlBase string, c *http.Client,
 	// empirically no provider cares about the Accept header's
 	// absence.
 
+	t0 := time.Now()
 	hres, err := c.Do(req)
 	if err != nil {
 		metricDNSFwdDoHErrorTransport.Add(1)
@@ -469,6 +470,8 @@ func (f *forwarder) sendDoH(ctx context.Context, urlBase string, c *http.Client,
 	res, err := ioutil.ReadAll(hres.Body)
 	if err != nil {
 		metricDNSFwdDoHErrorBody.Add(1)
+	} else {
+		metricDNSFwdLatencyDoH.Observe(time.Since(t0).Milliseconds())
 	}
 	if truncatedFlagSet(res) {
 		metricDNSFwdTruncated.Add(1)
@@ -543,6 +546,7 @@ func (f *forwarder) sendUDP(ctx context.Context, fq *forwardQuery, rr resolverAn
 	fq.closeOnCtxDone.Add(conn)
 	defer fq.closeOnCtxDone.Remove(conn)
 
+	t0 := time.Now()
 	if _, err := conn.WriteToUDPAddrPort(fq.packet, ipp); err != nil {
 		metricDNSFwdUDPErrorWrite.Add(1)
 		if err := ctx.Err(); err != nil {
@@ -606,6 +610,7 @@ func (f *forwarder) sendUDP(ctx context.Context, fq *forwardQuery, rr resolverAn
 
 	clampEDNSSize(out, maxResponseBytes)
 	metricDNSFwdUDPSuccess.Add(1)
+	metricDNSFwdLatencyUDP.Observe(time.Since(t0).Milliseconds())
 	return out, nil
 }
 
@@ -1269,6 +1269,12 @@ func unARPA(a string) (ipStr string, ok bool) {
 	metricDNSFwdDoHErrorTransport = clientmetric.NewCounter("dns_query_fwd_doh_error_transport")
 	metricDNSFwdDoHErrorBody      = clientmetric.NewCounter("dns_query_fwd_doh_error_body")
 
+	// Time taken by upstream resolvers to successfully answer forwarded
+	// queries, by protocol: "udp" or "doh".
+	metricDNSFwdLatency    = clientmetric.NewHistogramMap("dns_query_fwd_latency_ms", "proto", []int64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000})
+	metricDNSFwdLatencyUDP = metricDNSFwdLatency.Get("udp")
+	metricDNSFwdLatencyDoH = metricDNSFwdLatency.Get("doh")
+
 	metricDNSResolveLocal             = clientmetric.NewCounter("dns_resolve_local")
 	metricDNSResolveLocalErrorOnion   = clientmetric.NewCounter("dns_resolve_local_error_onion")
 	metricDNSResolveLocalErrorMissing = clientmetric.NewCounter("dns_resolve_local_error_missing")
@@ -56,11 +56,12 @@
 
 // Client is a port mapping client.
 type Client struct {
-	logf         logger.Logf
-	ipAndGateway func() (gw, ip netip.Addr, ok bool)
-	onChange     func() // or nil
-	testPxPPort  uint16 // if non-zero, pxpPort to use for tests
-	testUPnPPort uint16 // if non-zero, uPnPPort to use for tests
+	logf           logger.Logf
+	ipAndGateway   func() (gw, ip netip.Addr, ok bool)
+	onChange       func()                 // or nil
+	testPxPPort    uint16                 // if non-zero, pxpPort to use for tests
+	testUPnPPort   uint16                 // if non-zero, uPnPPort to use for tests
+	packetListener nettype.PacketListener // or nil to use netns
 
 	mu sync.Mutex // guards following, and all fields thereof
 
@@ -168,6 +169,12 @@ func (c *Client) SetGatewayLookupFunc(f func() (gw, myIP netip.Addr, ok bool)) {
 	c.ipAndGateway = f
 }
 
+// SetPacketListener sets how the client creates its UDP sockets, in
+// place of netns. It must be called before the client is used.
+func (c *Client) SetPacketListener(ln nettype.PacketListener) {
+	c.packetListener = ln
+}
+
 // NoteNetworkDown should be called when the network has transitioned to a down state.
 // It's too late to release port mappings at this point (the user might've just turned off
 // their wifi), but we can make sure we invalidate mappings for later when the network
@@ -259,6 +266,9 @@ func (c *Client) listenPacket(ctx context.Context, network, addr string) (nettyp
 		}
 		return pc.(*net.UDPConn), nil
 	}
+	if c.packetListener != nil {
+		return nettype.MakePacketListenerWithNetIP(c.packetListener).ListenPacket(ctx, network, addr)
+	}
 	pc, err := netns.Listener(c.logf).ListenPacket(ctx, network, addr)
 	if err != nil {
 		return nil, err
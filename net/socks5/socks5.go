@@ -15,6 +15,7 @@
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -26,10 +27,17 @@
 	"tailscale.com/types/logger"
 )
 
+// Authentication METHODs described in RFC 1928, section 3.
 const (
 	noAuthRequired   byte = 0
+	passwordAuth     byte = 2
 	noAcceptableAuth byte = 255
+)
+
+// passwordAuthVersion is the auth version byte described in RFC 1929.
+const passwordAuthVersion = 1
 
+const (
 	// socks5Version is the byte that represents the SOCKS version
 	// in requests.
 	socks5Version byte = 5
@@ -83,7 +91,15 @@ type Server struct {
 
 	// Dialer optionally specifies the dialer to use for outgoing connections.
 	// If nil, the net package's standard dialer is used.
+	// It is called with network "tcp" for CONNECT requests and "udp"
+	// for the destinations of UDP ASSOCIATE requests.
 	Dialer func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// Username and Password, if either is non-empty, are the credentials
+	// that clients must authenticate with, using the username/password
+	// method of RFC 1929. Otherwise, no authentication is required.
+	Username string
+	Password string
 }
 
 func (s *Server) dial(ctx context.Context, network, addr string) (net.Conn, error) {
@@ -135,12 +151,33 @@ type Conn struct {
 
 // Run starts the new connection.
 func (c *Conn) Run() error {
-	err := parseClientGreeting(c.clientConn)
+	needAuth := c.srv.Username != "" || c.srv.Password != ""
+	authMethod := noAuthRequired
+	if needAuth {
+		authMethod = passwordAuth
+	}
+	err := parseClientGreeting(c.clientConn, authMethod)
 	if err != nil {
 		c.clientConn.Write([]byte{socks5Version, noAcceptableAuth})
 		return err
 	}
-	c.clientConn.Write([]byte{socks5Version, noAuthRequired})
+	c.clientConn.Write([]byte{socks5Version, authMethod})
+	if needAuth {
+		user, pwd, err := parseClientAuth(c.clientConn)
+		if err != nil {
+			c.clientConn.Write([]byte{passwordAuthVersion, 1}) // auth error
+			return err
+		}
+		// Compare both, so that the time taken doesn't reveal
+		// which one was wrong.
+		userOK := subtle.ConstantTimeCompare([]byte(user), []byte(c.srv.Username))
+		pwdOK := subtle.ConstantTimeCompare([]byte(pwd), []byte(c.srv.Password))
+		if userOK&pwdOK != 1 {
+			c.clientConn.Write([]byte{passwordAuthVersion, 1}) // auth error
+			return fmt.Errorf("authentication failed for user %q", user)
+		}
+		c.clientConn.Write([]byte{passwordAuthVersion, 0}) // auth success
+	}
 	return c.handleRequest()
 }
 
@@ -152,36 +189,31 @@ func (c *Conn) handleRequest() error {
 		c.clientConn.Write(buf)
 		return err
 	}
-	if req.command != connect {
+	c.request = req
+	switch req.command {
+	case connect:
+		return c.handleTCP()
+	case udpAssociate:
+		return c.handleUDP()
+	default:
 		res := &response{reply: commandNotSupported}
 		buf, _ := res.marshal()
 		c.clientConn.Write(buf)
 		return fmt.Errorf("unsupported command %v", req.command)
 	}
-	c.request = req
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	srv, err := c.srv.dial(
-		ctx,
-		"tcp",
-		net.JoinHostPort(c.request.destination, strconv.Itoa(int(c.request.port))),
-	)
-	if err != nil {
-		res := &response{reply: generalFailure}
-		buf, _ := res.marshal()
-		c.clientConn.Write(buf)
-		return err
-	}
-	defer srv.Close()
-	serverAddr, serverPortStr, err := net.SplitHostPort(srv.LocalAddr().String())
+// writeSuccess writes a success response to the client with the bind
+// address addr, which is of the form "host:port".
+func (c *Conn) writeSuccess(addr string) error {
+	bindAddr, bindPortStr, err := net.SplitHostPort(addr)
 	if err != nil {
 		return err
 	}
-	serverPort, _ := strconv.Atoi(serverPortStr)
+	bindPort, _ := strconv.Atoi(bindPortStr)
 
 	var bindAddrType addrType
-	if ip := net.ParseIP(serverAddr); ip != nil {
+	if ip := net.ParseIP(bindAddr); ip != nil {
 		if ip.To4() != nil {
 			bindAddrType = ipv4
 		} else {
@@ -193,15 +225,36 @@ func (c *Conn) handleRequest() error {
 	res := &response{
 		reply:        success,
 		bindAddrType: bindAddrType,
-		bindAddr:     serverAddr,
-		bindPort:     uint16(serverPort),
+		bindAddr:     bindAddr,
+		bindPort:     uint16(bindPort),
 	}
 	buf, err := res.marshal()
 	if err != nil {
 		res = &response{reply: generalFailure}
 		buf, _ = res.marshal()
 	}
-	c.clientConn.Write(buf)
+	_, err = c.clientConn.Write(buf)
+	return err
+}
+
+func (c *Conn) handleTCP() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv, err := c.srv.dial(
+		ctx,
+		"tcp",
+		net.JoinHostPort(c.request.destination, strconv.Itoa(int(c.request.port))),
+	)
+	if err != nil {
+		res := &response{reply: generalFailure}
+		buf, _ := res.marshal()
+		c.clientConn.Write(buf)
+		return err
+	}
+	defer srv.Close()
+	if err := c.writeSuccess(srv.LocalAddr().String()); err != nil {
+		return err
+	}
 
 	errc := make(chan error, 2)
 	go func() {
@@ -222,9 +275,9 @@ func (c *Conn) handleRequest() error {
 }
 
 // parseClientGreeting parses a request initiation packet
-// and returns a slice that contains the acceptable auth methods
-// for the client.
-func parseClientGreeting(r io.Reader) error {
+// and returns an error if the client doesn't support the
+// auth method authMethod.
+func parseClientGreeting(r io.Reader, authMethod byte) error {
 	var hdr [2]byte
 	_, err := io.ReadFull(r, hdr[:])
 	if err != nil {
@@ -240,13 +293,38 @@ func parseClientGreeting(r io.Reader) error {
 		return fmt.Errorf("could not read methods")
 	}
 	for _, m := range methods {
-		if m == noAuthRequired {
+		if m == authMethod {
 			return nil
 		}
 	}
 	return fmt.Errorf("no acceptable auth methods")
 }
 
+// parseClientAuth parses the username/password authentication request
+// of RFC 1929 and returns the username and password.
+func parseClientAuth(r io.Reader) (usr, pwd string, err error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return "", "", fmt.Errorf("could not read auth packet header")
+	}
+	if hdr[0] != passwordAuthVersion {
+		return "", "", fmt.Errorf("bad auth version %d", hdr[0])
+	}
+	usrBytes := make([]byte, hdr[1])
+	if _, err := io.ReadFull(r, usrBytes); err != nil {
+		return "", "", fmt.Errorf("could not read username")
+	}
+	var pwdLen [1]byte
+	if _, err := io.ReadFull(r, pwdLen[:]); err != nil {
+		return "", "", fmt.Errorf("could not read password length")
+	}
+	pwdBytes := make([]byte, pwdLen[0])
+	if _, err := io.ReadFull(r, pwdBytes); err != nil {
+		return "", "", fmt.Errorf("could not read password")
+	}
+	return string(usrBytes), string(pwdBytes), nil
+}
+
 // request represents data contained within a SOCKS5
 // connection request packet.
 type request struct {
@@ -266,53 +344,52 @@ func parseClientRequest(r io.Reader) (*request, error) {
 	}
 	cmd := hdr[1]
 	destAddrType := addrType(hdr[3])
+	destination, port, err := parseAddr(r, destAddrType)
+	if err != nil {
+		return nil, err
+	}
+	return &request{
+		command:      commandType(cmd),
+		destination:  destination,
+		port:         port,
+		destAddrType: destAddrType,
+	}, nil
+}
 
-	var destination string
-	var port uint16
-
-	if destAddrType == ipv4 {
+// parseAddr reads an address of type typ and a port, as found in
+// requests and UDP datagram headers, from r.
+func parseAddr(r io.Reader, typ addrType) (host string, port uint16, err error) {
+	switch typ {
+	case ipv4:
 		var ip [4]byte
-		_, err = io.ReadFull(r, ip[:])
-		if err != nil {
-			return nil, fmt.Errorf("could not read IPv4 address")
+		if _, err := io.ReadFull(r, ip[:]); err != nil {
+			return "", 0, fmt.Errorf("could not read IPv4 address")
 		}
-		destination = net.IP(ip[:]).String()
-	} else if destAddrType == domainName {
+		host = net.IP(ip[:]).String()
+	case domainName:
 		var dstSizeByte [1]byte
-		_, err = io.ReadFull(r, dstSizeByte[:])
-		if err != nil {
-			return nil, fmt.Errorf("could not read domain name size")
+		if _, err := io.ReadFull(r, dstSizeByte[:]); err != nil {
+			return "", 0, fmt.Errorf("could not read domain name size")
 		}
-		dstSize := int(dstSizeByte[0])
-		domainName := make([]byte, dstSize)
-		_, err = io.ReadFull(r, domainName)
-		if err != nil {
-			return nil, fmt.Errorf("could not read domain name")
+		domainName := make([]byte, int(dstSizeByte[0]))
+		if _, err := io.ReadFull(r, domainName); err != nil {
+			return "", 0, fmt.Errorf("could not read domain name")
 		}
-		destination = string(domainName)
-	} else if destAddrType == ipv6 {
+		host = string(domainName)
+	case ipv6:
 		var ip [16]byte
-		_, err = io.ReadFull(r, ip[:])
-		if err != nil {
-			return nil, fmt.Errorf("could not read IPv6 address")
+		if _, err := io.ReadFull(r, ip[:]); err != nil {
+			return "", 0, fmt.Errorf("could not read IPv6 address")
 		}
-		destination = net.IP(ip[:]).String()
-	} else {
-		return nil, fmt.Errorf("unsupported address type")
+		host = net.IP(ip[:]).String()
+	default:
+		return "", 0, fmt.Errorf("unsupported address type")
 	}
 	var portBytes [2]byte
-	_, err = io.ReadFull(r, portBytes[:])
-	if err != nil {
-		return nil, fmt.Errorf("could not read port")
+	if _, err := io.ReadFull(r, portBytes[:]); err != nil {
+		return "", 0, fmt.Errorf("could not read port")
 	}
-	port = binary.BigEndian.Uint16(portBytes[:])
-
-	return &request{
-		command:      commandType(cmd),
-		destination:  destination,
-		port:         port,
-		destAddrType: destAddrType,
-	}, nil
+	return host, binary.BigEndian.Uint16(portBytes[:]), nil
 }
 
 // response contains the contents of
@@ -339,22 +416,28 @@ func (res *response) marshal() ([]byte, error) {
 		return pkt, nil
 	}
 
+	return appendAddr(pkt, res.bindAddrType, res.bindAddr, res.bindPort)
+}
+
+// appendAddr appends the address host of type typ and port, in the form
+// used in responses and UDP datagram headers, to b.
+func appendAddr(b []byte, typ addrType, host string, port uint16) ([]byte, error) {
 	var addr []byte
-	switch res.bindAddrType {
+	switch typ {
 	case ipv4:
-		addr = net.ParseIP(res.bindAddr).To4()
+		addr = net.ParseIP(host).To4()
 		if addr == nil {
 			return nil, fmt.Errorf("invalid IPv4 address for binding")
 		}
 	case domainName:
-		if len(res.bindAddr) > 255 {
+		if len(host) > 255 {
 			return nil, fmt.Errorf("invalid domain name for binding")
 		}
-		addr = make([]byte, 0, len(res.bindAddr)+1)
-		addr = append(addr, byte(len(res.bindAddr)))
-		addr = append(addr, []byte(res.bindAddr)...)
+		addr = make([]byte, 0, len(host)+1)
+		addr = append(addr, byte(len(host)))
+		addr = append(addr, []byte(host)...)
 	case ipv6:
-		addr = net.ParseIP(res.bindAddr).To16()
+		addr = net.ParseIP(host).To16()
 		if addr == nil {
 			return nil, fmt.Errorf("invalid IPv6 address for binding")
 		}
@@ -362,8 +445,7 @@ func (res *response) marshal() ([]byte, error) {
 		return nil, fmt.Errorf("unsupported address type")
 	}
 
-	pkt = append(pkt, addr...)
-	pkt = binary.BigEndian.AppendUint16(pkt, uint16(res.bindPort))
-
-	return pkt, nil
+	b = append(b, addr...)
+	b = binary.BigEndian.AppendUint16(b, port)
+	return b, nil
 }
@@ -5,10 +5,12 @@
 package socks5
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"net"
 	"testing"
+	"time"
 
 	"golang.org/x/net/proxy"
 )
@@ -75,3 +77,223 @@ func TestRead(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestReadPassword(t *testing.T) {
+	// backend server which we'll use SOCKS5 to connect to
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Write([]byte("Test"))
+			c.Close()
+		}
+	}()
+
+	socks5, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socks5.Close()
+	srv := &Server{
+		Logf:     t.Logf,
+		Username: "bilbo",
+		Password: "baggins",
+	}
+	go srv.Serve(socks5)
+
+	tests := []struct {
+		name    string
+		auth    *proxy.Auth
+		wantErr bool
+	}{
+		{"ok", &proxy.Auth{User: "bilbo", Password: "baggins"}, false},
+		{"bad_password", &proxy.Auth{User: "bilbo", Password: "sackville"}, true},
+		{"bad_user", &proxy.Auth{User: "frodo", Password: "baggins"}, true},
+		{"no_auth", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := proxy.SOCKS5("tcp", socks5.Addr().String(), tt.auth, proxy.Direct)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn, err := d.Dial("tcp", ln.Addr().String())
+			if tt.wantErr {
+				if err == nil {
+					conn.Close()
+					t.Fatal("dial succeeded; want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			buf := make([]byte, 4)
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				t.Fatal(err)
+			}
+			if string(buf) != "Test" {
+				t.Fatalf("got: %q want: Test", buf)
+			}
+		})
+	}
+}
+
+func TestUDP(t *testing.T) {
+	// UDP echo server which we'll use SOCKS5 to send to
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteTo(buf[:n], addr)
+		}
+	}()
+	echoAddr := echo.LocalAddr().(*net.UDPAddr)
+
+	socks5, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socks5.Close()
+	srv := &Server{Logf: t.Logf}
+	go srv.Serve(socks5)
+
+	ctl, err := net.Dial("tcp", socks5.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctl.Close()
+	ctl.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// Greeting, offering no auth.
+	if _, err := ctl.Write([]byte{socks5Version, 1, noAuthRequired}); err != nil {
+		t.Fatal(err)
+	}
+	var greeting [2]byte
+	if _, err := io.ReadFull(ctl, greeting[:]); err != nil {
+		t.Fatal(err)
+	}
+	if greeting != [2]byte{socks5Version, noAuthRequired} {
+		t.Fatalf("greeting reply = %v", greeting)
+	}
+
+	// UDP ASSOCIATE, without saying which port we'll send from.
+	if _, err := ctl.Write([]byte{socks5Version, byte(udpAssociate), 0, byte(ipv4), 0, 0, 0, 0, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+	var hdr [4]byte
+	if _, err := io.ReadFull(ctl, hdr[:]); err != nil {
+		t.Fatal(err)
+	}
+	if replyCode(hdr[1]) != success {
+		t.Fatalf("UDP ASSOCIATE reply = %v", hdr[1])
+	}
+	host, port, err := parseAddr(ctl, addrType(hdr[3]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, fmt.Sprint(port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	pc.SetDeadline(time.Now().Add(10 * time.Second))
+
+	wantHdr, err := udpHeader(echoAddr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"hello", "world"} {
+		pkt := append(append([]byte(nil), wantHdr...), msg...)
+		if _, err := pc.WriteToUDP(pkt, relay); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 1500)
+		n, _, err := pc.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := buf[:n]; !bytes.Equal(got, pkt) {
+			t.Errorf("got %q; want %q", got, pkt)
+		}
+	}
+}
+
+func TestParseUDPDatagram(t *testing.T) {
+	tests := []struct {
+		name     string
+		pkt      []byte
+		wantDst  string
+		wantData string
+		wantErr  bool
+	}{
+		{
+			name:     "ipv4",
+			pkt:      []byte{0, 0, 0, byte(ipv4), 1, 2, 3, 4, 0, 53, 'h', 'i'},
+			wantDst:  "1.2.3.4:53",
+			wantData: "hi",
+		},
+		{
+			name:     "domain",
+			pkt:      []byte{0, 0, 0, byte(domainName), 3, 'f', 'o', 'o', 1, 187, 'x'},
+			wantDst:  "foo:443",
+			wantData: "x",
+		},
+		{
+			name:     "ipv6",
+			pkt:      append([]byte{0, 0, 0, byte(ipv6), 0xfd, 0x7a, 0x11, 0x5c, 0xa1, 0xe0, 0xab, 0x12, 0x48, 0x43, 0xcd, 0x96, 0, 0, 0, 1, 0, 80}, "data"...),
+			wantDst:  "[fd7a:115c:a1e0:ab12:4843:cd96:0:1]:80",
+			wantData: "data",
+		},
+		{
+			name:    "fragmented",
+			pkt:     []byte{0, 0, 1, byte(ipv4), 1, 2, 3, 4, 0, 53, 'h', 'i'},
+			wantErr: true,
+		},
+		{
+			name:    "short",
+			pkt:     []byte{0, 0, 0, byte(ipv4), 1, 2},
+			wantErr: true,
+		},
+		{
+			name:    "bad_addr_type",
+			pkt:     []byte{0, 0, 0, 2, 1, 2, 3, 4, 0, 53},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst, data, err := parseUDPDatagram(tt.pkt)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v; wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if dst != tt.wantDst || string(data) != tt.wantData {
+				t.Errorf("got (%q, %q); want (%q, %q)", dst, data, tt.wantDst, tt.wantData)
+			}
+		})
+	}
+}
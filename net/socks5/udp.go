@@ -0,0 +1,232 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxUDPPacketSize is the largest UDP datagram that is relayed.
+const maxUDPPacketSize = 64 << 10
+
+// maxUDPTargets is the maximum number of destinations that a single UDP
+// association can send to.
+const maxUDPTargets = 256
+
+// handleUDP handles a UDP ASSOCIATE request, as described in RFC 1928,
+// section 7. It relays datagrams between the client and its destinations
+// until the client closes the TCP connection the request came in on.
+func (c *Conn) handleUDP() error {
+	clientIP, err := addrIP(c.clientConn.RemoteAddr())
+	if err != nil {
+		return err
+	}
+	localIP, err := addrIP(c.clientConn.LocalAddr())
+	if err != nil {
+		return err
+	}
+	// Listen on the address the client reached us on, which it can
+	// presumably also send UDP to.
+	pc, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip.AddrPortFrom(localIP, 0)))
+	if err != nil {
+		res := &response{reply: generalFailure}
+		buf, _ := res.marshal()
+		c.clientConn.Write(buf)
+		return err
+	}
+	a := &udpAssociation{
+		srv:      c.srv,
+		pc:       pc,
+		clientIP: clientIP,
+		targets:  make(map[string]net.Conn),
+	}
+	defer a.close()
+	// If the client said which port it will send from, only accept
+	// datagrams from there. Otherwise, the first datagram decides.
+	if ip, err := netip.ParseAddr(c.request.destination); err == nil && ip.Unmap() == clientIP && c.request.port != 0 {
+		a.client = netip.AddrPortFrom(clientIP, c.request.port)
+	}
+	if err := c.writeSuccess(pc.LocalAddr().String()); err != nil {
+		return err
+	}
+	go a.serveClient()
+
+	// The association lasts as long as the TCP connection.
+	_, err = io.Copy(io.Discard, c.clientConn)
+	return err
+}
+
+// addrIP returns the IP address of addr, which must be a TCP or UDP
+// address.
+func addrIP(addr net.Addr) (netip.Addr, error) {
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("unexpected address %v: %w", addr, err)
+	}
+	return ap.Addr().Unmap(), nil
+}
+
+// udpAssociation relays the datagrams of a UDP ASSOCIATE request.
+type udpAssociation struct {
+	srv      *Server
+	pc       *net.UDPConn // receives datagrams from the client
+	clientIP netip.Addr
+
+	mu      sync.Mutex
+	closed  bool
+	client  netip.AddrPort      // zero until the first datagram, unless given in the request
+	targets map[string]net.Conn // by destination "host:port"
+}
+
+func (a *udpAssociation) close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.closed = true
+	a.pc.Close()
+	for _, c := range a.targets {
+		c.Close()
+	}
+}
+
+// serveClient relays datagrams from the client to their destinations.
+func (a *udpAssociation) serveClient() {
+	buf := make([]byte, maxUDPPacketSize)
+	for {
+		n, src, err := a.pc.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return
+		}
+		src = netip.AddrPortFrom(src.Addr().Unmap(), src.Port())
+		if src.Addr() != a.clientIP {
+			continue
+		}
+		a.mu.Lock()
+		if !a.client.IsValid() {
+			a.client = src
+		}
+		client := a.client
+		a.mu.Unlock()
+		if src != client {
+			continue
+		}
+
+		dst, data, err := parseUDPDatagram(buf[:n])
+		if err != nil {
+			a.srv.logf("udp: dropping datagram from client: %v", err)
+			continue
+		}
+		tc, err := a.target(dst)
+		if err != nil {
+			a.srv.logf("udp: dialing %s: %v", dst, err)
+			continue
+		}
+		if _, err := tc.Write(data); err != nil {
+			a.srv.logf("udp: writing to %s: %v", dst, err)
+		}
+	}
+}
+
+// target returns the connection to dst, dialing it if needed.
+func (a *udpAssociation) target(dst string) (net.Conn, error) {
+	a.mu.Lock()
+	tc, ok := a.targets[dst]
+	n := len(a.targets)
+	a.mu.Unlock()
+	if ok {
+		return tc, nil
+	}
+	if n >= maxUDPTargets {
+		return nil, fmt.Errorf("too many destinations")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tc, err := a.srv.dial(ctx, "udp", dst)
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		tc.Close()
+		return nil, net.ErrClosed
+	}
+	a.targets[dst] = tc
+	go a.serveTarget(tc, dst)
+	return tc, nil
+}
+
+// serveTarget relays datagrams from the destination connection tc, which
+// was dialed for dst, back to the client.
+func (a *udpAssociation) serveTarget(tc net.Conn, dst string) {
+	// Replies are reported as coming from the address that was dialed,
+	// resolved if it was a domain name.
+	from := dst
+	if ap, err := netip.ParseAddrPort(tc.RemoteAddr().String()); err == nil {
+		from = netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port()).String()
+	}
+	hdr, err := udpHeader(from)
+	if err != nil {
+		a.srv.logf("udp: %v", err)
+		return
+	}
+	buf := make([]byte, len(hdr)+maxUDPPacketSize)
+	copy(buf, hdr)
+	for {
+		n, err := tc.Read(buf[len(hdr):])
+		if err != nil {
+			return
+		}
+		a.mu.Lock()
+		client := a.client
+		a.mu.Unlock()
+		if _, err := a.pc.WriteToUDPAddrPort(buf[:len(hdr)+n], client); err != nil {
+			return
+		}
+	}
+}
+
+// parseUDPDatagram parses a datagram from the client, as described in
+// RFC 1928, section 7, and returns its destination "host:port" and data.
+// Fragmented datagrams are not supported.
+func parseUDPDatagram(b []byte) (dst string, data []byte, err error) {
+	if len(b) < 4 {
+		return "", nil, fmt.Errorf("short datagram")
+	}
+	if b[0] != 0 || b[1] != 0 {
+		return "", nil, fmt.Errorf("non-zero reserved bytes")
+	}
+	if b[2] != 0 {
+		return "", nil, fmt.Errorf("fragmented datagrams not supported")
+	}
+	r := bytes.NewReader(b[4:])
+	host, port, err := parseAddr(r, addrType(b[3]))
+	if err != nil {
+		return "", nil, err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), b[len(b)-r.Len():], nil
+}
+
+// udpHeader returns the header of datagrams sent to the client that came
+// from addr, which is of the form "ip:port".
+func udpHeader(addr string) ([]byte, error) {
+	ap, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	typ := ipv6
+	if ap.Addr().Is4() {
+		typ = ipv4
+	}
+	return appendAddr([]byte{0, 0, 0, byte(typ)}, typ, ap.Addr().String(), ap.Port())
+}
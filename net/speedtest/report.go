@@ -0,0 +1,58 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package speedtest
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// WriteText writes a human-readable table of r to w.
+func (r *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 12, 0, 0, ' ', tabwriter.TabIndent)
+	for _, d := range []struct {
+		dir Direction
+		dr  *DirectionReport
+	}{{Download, r.Download}, {Upload, r.Upload}} {
+		if d.dr == nil {
+			continue
+		}
+		switch {
+		case r.Options.UDP:
+			fmt.Fprintf(tw, "%s (UDP at %.2f Mbits/sec):\n", d.dir, float64(r.Options.UDPBitrate)/1e6)
+		case d.dr.Streams > 1:
+			fmt.Fprintf(tw, "%s (%d streams):\n", d.dir, d.dr.Streams)
+		default:
+			fmt.Fprintf(tw, "%s:\n", d.dir)
+		}
+		fmt.Fprintln(tw, "Interval\t\tTransfer\t\tBandwidth\t\t")
+		for _, res := range d.dr.Results {
+			if res.Total {
+				fmt.Fprintln(tw, "-------------------------------------------------------------------------")
+			}
+			fmt.Fprintf(tw, "%.2f-%.2f\tsec\t%.4f\tMBits\t%.4f\tMbits/sec\t\n", res.IntervalStart.Seconds(), res.IntervalEnd.Seconds(), res.MegaBits(), res.MBitsPerSecond())
+		}
+		if r.Options.UDP {
+			fmt.Fprintf(tw, "Loss: %d/%d datagrams (%.2f%%), jitter: %v\n", d.dr.PacketsSent-d.dr.PacketsReceived, d.dr.PacketsSent, d.dr.LossPercent(), d.dr.Jitter.Round(time.Microsecond))
+		}
+		fmt.Fprintln(tw)
+	}
+	if l := r.Latency; l != nil {
+		fmt.Fprintln(tw, "Latency\t\tMin\t\tMedian\t\tMax\t\tSamples\t")
+		for _, s := range []struct {
+			name  string
+			stats LatencyStats
+		}{{"idle", l.Idle}, {"loaded", l.Loaded}} {
+			fmt.Fprintf(tw, "%s\t\t%v\t\t%v\t\t%v\t\t%d\t\n", s.name, roundRTT(s.stats.Min()), roundRTT(s.stats.Median()), roundRTT(s.stats.Max()), len(s.stats.Samples))
+		}
+	}
+	return tw.Flush()
+}
+
+func roundRTT(d time.Duration) time.Duration {
+	return d.Round(10 * time.Microsecond)
+}
@@ -7,18 +7,34 @@
 package speedtest
 
 import (
+	"context"
+	"net"
+	"sort"
 	"time"
 )
 
 const (
-	blockSize       = 32000                 // size of the block of data to send
-	MinDuration     = 5 * time.Second       // minimum duration for a test
-	DefaultDuration = MinDuration           // default duration for a test
-	MaxDuration     = 30 * time.Second      // maximum duration for a test
-	version         = 1                     // value used when comparing client and server versions
-	increment       = time.Second           // increment to display results for, in seconds
-	minInterval     = 10 * time.Millisecond // minimum interval length for a result to be included
-	DefaultPort     = 20333
+	blockSize         = 32000                 // size of the block of data to send
+	MinDuration       = 5 * time.Second       // minimum duration for a test
+	DefaultDuration   = MinDuration           // default duration for a test
+	MaxDuration       = 30 * time.Second      // maximum duration for a test
+	version           = 2                     // value used when comparing client and server versions
+	minVersion        = 1                     // oldest client version the server accepts
+	increment         = time.Second           // increment to display results for, in seconds
+	minInterval       = 10 * time.Millisecond // minimum interval length for a result to be included
+	DefaultPort       = 20333
+	MaxStreams        = 16             // maximum number of parallel TCP streams per direction
+	DefaultUDPBitrate = 10_000_000     // default sending rate of UDP tests, in bits per second
+	MaxUDPBitrate     = 10_000_000_000 // maximum sending rate of UDP tests, in bits per second
+	latencyInterval   = 100 * time.Millisecond
+	idleLatencyProbes = 5 // number of RTT samples taken before the load starts
+)
+
+// Test modes, sent in config.Mode.
+const (
+	modeTCP  = ""     // throughput over the test's TCP connection
+	modeUDP  = "udp"  // throughput, loss and jitter over UDP, controlled by the TCP connection
+	modeEcho = "echo" // echo everything back, for measuring RTT
 )
 
 // config is the initial message sent to the server, that contains information on how to
@@ -27,12 +43,19 @@ type config struct {
 	Version      int           `json:"version"`
 	TestDuration time.Duration `json:"time"`
 	Direction    Direction     `json:"direction"`
+	Mode         string        `json:"mode,omitempty"`
+	UDPBitrate   int64         `json:"udpBitrate,omitempty"` // for modeUDP, in bits per second
 }
 
 // configResponse is the response to the testConfig message. If the server has an
 // error with the config, the Error variable will hold that error value.
 type configResponse struct {
 	Error string `json:"error,omitempty"`
+
+	// For modeUDP, the UDP port that the server is sending from or
+	// receiving on, and the token that starts each datagram of the test.
+	UDPPort  uint16 `json:"udpPort,omitempty"`
+	UDPToken uint64 `json:"udpToken,omitempty"`
 }
 
 // This represents the Result of a speedtest within a specific interval
@@ -64,6 +87,10 @@ func (r Result) Interval() time.Duration {
 const (
 	Download Direction = iota
 	Upload
+
+	// Bidirectional runs a download and an upload test at the same
+	// time. It's only valid in Options.
+	Bidirectional
 )
 
 func (d Direction) String() string {
@@ -72,6 +99,8 @@ func (d Direction) String() string {
 		return "upload"
 	case Download:
 		return "download"
+	case Bidirectional:
+		return "bidirectional"
 	default:
 		return ""
 	}
@@ -86,3 +115,131 @@ func (d *Direction) Reverse() {
 	default:
 	}
 }
+
+// Options are the parameters of a test run by Run.
+type Options struct {
+	// Direction is the direction of the test, from the client's point
+	// of view.
+	Direction Direction
+
+	// Duration is how long to run the test for. It must be between
+	// MinDuration and MaxDuration.
+	Duration time.Duration
+
+	// Streams is the number of parallel TCP connections to use for each
+	// direction. Zero means 1. It's ignored for UDP tests.
+	Streams int `json:",omitempty"`
+
+	// UDP, if true, tests with a stream of UDP datagrams sent at
+	// UDPBitrate instead of TCP, and reports packet loss and jitter.
+	UDP bool `json:",omitempty"`
+
+	// UDPBitrate is the rate at which to send UDP datagrams, in bits per
+	// second. Zero means DefaultUDPBitrate.
+	UDPBitrate int64 `json:",omitempty"`
+
+	// Latency, if true, samples the round-trip time over a separate
+	// connection before and during the test.
+	Latency bool `json:",omitempty"`
+
+	// HTTPPath, if non-empty, is the path of an HTTP server on the
+	// test's address that hands connections to a speedtest server with
+	// an HTTP upgrade. See Server.ServeHTTP.
+	HTTPPath string `json:",omitempty"`
+
+	// Dial, if non-nil, is used to dial the server, both "tcp" for
+	// the test's connections and "udp" for UDP tests. If nil, a
+	// net.Dialer is used.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error) `json:"-"`
+}
+
+// Report is the outcome of a test run by Run.
+type Report struct {
+	Options Options
+
+	// Download and Upload are the results of each direction tested, or
+	// nil if that direction wasn't tested.
+	Download *DirectionReport `json:",omitempty"`
+	Upload   *DirectionReport `json:",omitempty"`
+
+	// Latency is the round-trip time measured before and during the
+	// test, if requested.
+	Latency *LatencyReport `json:",omitempty"`
+}
+
+// DirectionReport is the outcome of one direction of a test.
+type DirectionReport struct {
+	// Results is the throughput of each interval of the test, summed
+	// over all streams, followed by a Result for the whole test with
+	// Total set. For UDP tests it's measured by the receiver.
+	Results []Result
+
+	// Streams is the number of TCP streams used, or zero for UDP tests.
+	Streams int `json:",omitempty"`
+
+	// The following fields are only set by UDP tests.
+	PacketsSent     int64         `json:",omitempty"`
+	PacketsReceived int64         `json:",omitempty"` // not counting duplicates
+	Jitter          time.Duration `json:",omitempty"` // as defined in RFC 3550, section 6.4.1
+}
+
+// Total returns the Result for the whole test, or the zero Result if
+// there wasn't one.
+func (r *DirectionReport) Total() Result {
+	for _, res := range r.Results {
+		if res.Total {
+			return res
+		}
+	}
+	return Result{}
+}
+
+// LossPercent returns the percentage of UDP datagrams sent that weren't
+// received.
+func (r *DirectionReport) LossPercent() float64 {
+	if r.PacketsSent == 0 {
+		return 0
+	}
+	return 100 * float64(r.PacketsSent-r.PacketsReceived) / float64(r.PacketsSent)
+}
+
+// LatencyReport is the round-trip time measured while running a test.
+type LatencyReport struct {
+	Idle   LatencyStats // before the test started
+	Loaded LatencyStats // while the test was running
+}
+
+// LatencyStats are round-trip time samples.
+type LatencyStats struct {
+	Samples []time.Duration
+}
+
+func (s LatencyStats) sorted() []time.Duration {
+	v := append([]time.Duration(nil), s.Samples...)
+	sort.Slice(v, func(i, j int) bool { return v[i] < v[j] })
+	return v
+}
+
+// Min returns the smallest sample, or zero if there are none.
+func (s LatencyStats) Min() time.Duration {
+	if len(s.Samples) == 0 {
+		return 0
+	}
+	return s.sorted()[0]
+}
+
+// Median returns the median sample, or zero if there are none.
+func (s LatencyStats) Median() time.Duration {
+	if len(s.Samples) == 0 {
+		return 0
+	}
+	return s.sorted()[len(s.Samples)/2]
+}
+
+// Max returns the largest sample, or zero if there are none.
+func (s LatencyStats) Max() time.Duration {
+	if len(s.Samples) == 0 {
+		return 0
+	}
+	return s.sorted()[len(s.Samples)-1]
+}
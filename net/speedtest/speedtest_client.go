@@ -5,9 +5,16 @@
 package speedtest
 
 import (
+	"bufio"
+	"context"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"sync"
 	"time"
 )
 
@@ -40,3 +47,317 @@ func RunClient(direction Direction, duration time.Duration, host string) ([]Resu
 
 	return doTest(conn, conf)
 }
+
+// Run runs the test described by opts against the speedtest server at
+// addr, an "ip:port" or "host:port".
+func Run(ctx context.Context, addr string, opts Options) (*Report, error) {
+	if opts.Duration < MinDuration || opts.Duration > MaxDuration {
+		return nil, fmt.Errorf("test duration must be within %v and %v", MinDuration, MaxDuration)
+	}
+	if opts.Streams == 0 {
+		opts.Streams = 1
+	}
+	if opts.Streams < 0 || opts.Streams > MaxStreams {
+		return nil, fmt.Errorf("number of streams must be within 1 and %d", MaxStreams)
+	}
+	if opts.UDP && opts.UDPBitrate == 0 {
+		opts.UDPBitrate = DefaultUDPBitrate
+	}
+	if opts.UDPBitrate < 0 || opts.UDPBitrate > MaxUDPBitrate {
+		return nil, fmt.Errorf("UDP bitrate must be within 0 and %d", int64(MaxUDPBitrate))
+	}
+	var dirs []Direction
+	switch opts.Direction {
+	case Download, Upload:
+		dirs = []Direction{opts.Direction}
+	case Bidirectional:
+		dirs = []Direction{Download, Upload}
+	default:
+		return nil, fmt.Errorf("invalid direction %d", opts.Direction)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	c := &client{addr: addr, opts: opts}
+	rep := &Report{Options: opts}
+	rep.Options.Dial = nil
+
+	var lat *latencyProbe
+	if opts.Latency {
+		var err error
+		lat, err = c.startLatency(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("latency probe: %w", err)
+		}
+		defer lat.conn.Close()
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for _, dir := range dirs {
+		dir := dir
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var dr *DirectionReport
+			var err error
+			if opts.UDP {
+				dr, err = c.runUDPDirection(ctx, dir)
+			} else {
+				dr, err = c.runTCPDirection(ctx, dir)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%v: %w", dir, err))
+				cancel()
+				return
+			}
+			if dir == Download {
+				rep.Download = dr
+			} else {
+				rep.Upload = dr
+			}
+		}()
+	}
+	wg.Wait()
+	if lat != nil {
+		rep.Latency = lat.stop()
+	}
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return rep, nil
+}
+
+// client is the state of a test run by Run.
+type client struct {
+	addr string
+	opts Options
+}
+
+func (c *client) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if c.opts.Dial != nil {
+		return c.opts.Dial(ctx, network, addr)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, addr)
+}
+
+// connect opens a connection to the server and starts the test in conf.
+// The returned decoder should be used to read any further JSON messages
+// from the server.
+func (c *client) connect(ctx context.Context, conf config) (net.Conn, *json.Decoder, configResponse, error) {
+	var resp configResponse
+	conn, err := c.dial(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, nil, resp, err
+	}
+	if err := c.upgrade(conn); err != nil {
+		conn.Close()
+		return nil, nil, resp, err
+	}
+	conf.Version = version
+	if err := json.NewEncoder(conn).Encode(conf); err != nil {
+		conn.Close()
+		return nil, nil, resp, err
+	}
+	dec := json.NewDecoder(conn)
+	if err := dec.Decode(&resp); err != nil {
+		conn.Close()
+		return nil, nil, resp, err
+	}
+	if resp.Error != "" {
+		conn.Close()
+		return nil, nil, resp, errors.New(resp.Error)
+	}
+	return conn, dec, resp, nil
+}
+
+// upgrade switches conn to the speedtest protocol with an HTTP upgrade
+// request, if Options.HTTPPath is set.
+func (c *client) upgrade(conn net.Conn) error {
+	if c.opts.HTTPPath == "" {
+		return nil
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	defer conn.SetDeadline(time.Time{})
+	req := fmt.Sprintf("GET %s HTTP/1.1\r\nHost: %s\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", c.opts.HTTPPath, c.addr, upgradeProto)
+	if _, err := io.WriteString(conn, req); err != nil {
+		return err
+	}
+	// The server doesn't send anything after the response until it
+	// gets the config, so it's safe to discard the bufio.Reader.
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+		return fmt.Errorf("speedtest upgrade: %v: %s", res.Status, body)
+	}
+	return nil
+}
+
+// closeOnDone closes conn when ctx is done, until the returned func is
+// called.
+func closeOnDone(ctx context.Context, conn net.Conn) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// runTCPDirection runs Options.Streams parallel TCP streams in the
+// direction dir.
+func (c *client) runTCPDirection(ctx context.Context, dir Direction) (*DirectionReport, error) {
+	conf := config{TestDuration: c.opts.Duration, Direction: dir, Mode: modeTCP}
+	// The server starts its clock for a stream as soon as it has replied
+	// to the config, so start ours before connecting the first stream
+	// for the meter to cover all of every stream's transfer.
+	m := newMeter()
+	var conns []net.Conn
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	for i := 0; i < c.opts.Streams; i++ {
+		conn, _, _, err := c.connect(ctx, conf)
+		if err != nil {
+			return nil, err
+		}
+		conns = append(conns, conn)
+	}
+
+	errc := make(chan error, len(conns))
+	for _, conn := range conns {
+		conn := conn
+		go func() {
+			defer closeOnDone(ctx, conn)()
+			err := transfer(conn, conf, m)
+			if dir == Upload {
+				// Tell the server we're done.
+				conn.Close()
+			}
+			errc <- err
+		}()
+	}
+	var firstErr error
+	for range conns {
+		if err := <-errc; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return &DirectionReport{Results: m.finish(), Streams: len(conns)}, nil
+}
+
+// runUDPDirection runs a UDP test in the direction dir.
+func (c *client) runUDPDirection(ctx context.Context, dir Direction) (*DirectionReport, error) {
+	conf := config{TestDuration: c.opts.Duration, Direction: dir, Mode: modeUDP, UDPBitrate: c.opts.UDPBitrate}
+	conn, dec, resp, err := c.connect(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	defer closeOnDone(ctx, conn)()
+	return c.runUDP(ctx, conn, dec, conf, resp)
+}
+
+// latencyProbe samples the round-trip time over an echo connection.
+type latencyProbe struct {
+	conn net.Conn
+	idle LatencyStats
+
+	mu     sync.Mutex
+	loaded LatencyStats
+
+	stopc chan struct{}
+	done  chan struct{}
+}
+
+// startLatency opens an echo connection and takes the idle samples,
+// then keeps sampling in the background until stop is called.
+func (c *client) startLatency(ctx context.Context) (*latencyProbe, error) {
+	conn, _, _, err := c.connect(ctx, config{Mode: modeEcho, Direction: Download})
+	if err != nil {
+		return nil, err
+	}
+	p := &latencyProbe{
+		conn:  conn,
+		stopc: make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	var seq uint64
+	for i := 0; i < idleLatencyProbes; i++ {
+		seq++
+		d, err := p.probe(seq)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		p.idle.Samples = append(p.idle.Samples, d)
+		time.Sleep(latencyInterval)
+	}
+	go func() {
+		defer close(p.done)
+		t := time.NewTicker(latencyInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-p.stopc:
+				return
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			seq++
+			d, err := p.probe(seq)
+			if err != nil {
+				return
+			}
+			p.mu.Lock()
+			p.loaded.Samples = append(p.loaded.Samples, d)
+			p.mu.Unlock()
+		}
+	}()
+	return p, nil
+}
+
+// probe sends seq and waits for it to be echoed back, returning how long
+// that took.
+func (p *latencyProbe) probe(seq uint64) (time.Duration, error) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], seq)
+	p.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	t0 := time.Now()
+	if _, err := p.conn.Write(b[:]); err != nil {
+		return 0, err
+	}
+	if _, err := io.ReadFull(p.conn, b[:]); err != nil {
+		return 0, err
+	}
+	if binary.BigEndian.Uint64(b[:]) != seq {
+		return 0, errors.New("latency probe: out of sequence echo")
+	}
+	return time.Since(t0), nil
+}
+
+func (p *latencyProbe) stop() *LatencyReport {
+	close(p.stopc)
+	<-p.done
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return &LatencyReport{Idle: p.idle, Loaded: p.loaded}
+}
@@ -11,14 +11,42 @@
 	"fmt"
 	"io"
 	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
 	"time"
+
+	"tailscale.com/types/logger"
 )
 
+// upgradeProto is the protocol name used in the Upgrade header to start a
+// speedtest over an HTTP connection.
+const upgradeProto = "tailscale-speedtest"
+
+// Server is a speedtest server. The zero value is ready to use.
+type Server struct {
+	// Logf, if non-nil, logs errors from tests. Serve and ServeHTTP
+	// otherwise ignore them.
+	Logf logger.Logf
+
+	// ListenPacket, if non-nil, creates the UDP socket for a UDP test
+	// whose control connection arrived on the local address local. If
+	// nil, a UDP socket is bound to local with an ephemeral port.
+	ListenPacket func(local netip.Addr) (net.PacketConn, error)
+}
+
 // Serve starts up the server on a given host and port pair. It starts to listen for
 // connections and handles each one in a goroutine. Because it runs in an infinite loop,
-// this function only returns if any of the speedtests return with errors, or if the
-// listener is closed.
+// this function only returns if the listener fails or is closed.
 func Serve(l net.Listener) error {
+	return new(Server).Serve(l)
+}
+
+// Serve accepts connections on l and handles each one in a goroutine. It
+// returns nil when l is closed, or the error if accepting fails
+// otherwise.
+func (s *Server) Serve(l net.Listener) error {
 	for {
 		conn, err := l.Accept()
 		if errors.Is(err, net.ErrClosed) {
@@ -27,25 +55,63 @@ func Serve(l net.Listener) error {
 		if err != nil {
 			return err
 		}
-		err = handleConnection(conn)
-		if err != nil {
-			return err
-		}
+		go func() {
+			s.logError(s.ServeConn(conn))
+		}()
 	}
 }
 
-// handleConnection handles the initial exchange between the server and the client.
+// ServeHTTP hands the connection of the request to ServeConn, if it asks
+// to upgrade to the speedtest protocol.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), upgradeProto) {
+		http.Error(w, "speedtest requires Upgrade: "+upgradeProto, http.StatusUpgradeRequired)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "can't hijack connection", http.StatusInternalServerError)
+		return
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		s.logError(err)
+		return
+	}
+	fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", upgradeProto)
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return
+	}
+	if brw.Reader.Buffered() > 0 {
+		// The client must wait for the response before starting.
+		conn.Close()
+		return
+	}
+	s.logError(s.ServeConn(conn))
+}
+
+func (s *Server) logError(err error) {
+	if err != nil && s.Logf != nil {
+		s.Logf("speedtest: %v", err)
+	}
+}
+
+// ServeConn runs the test requested by the client on conn and closes it.
+//
 // It reads the testconfig message into a config struct. If any errors occur with
 // the testconfig (specifically, if there is a version mismatch), it will return those
 // errors to the client with a configResponse. After the exchange, it will start
 // the speed test.
-func handleConnection(conn net.Conn) error {
+func (s *Server) ServeConn(conn net.Conn) error {
 	defer conn.Close()
 	var conf config
 
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
 	decoder := json.NewDecoder(conn)
 	err := decoder.Decode(&conf)
 	encoder := json.NewEncoder(conn)
+	conn.SetReadDeadline(time.Time{})
 
 	// Both return and encode errors that occurred before the test started.
 	if err != nil {
@@ -56,45 +122,142 @@ func handleConnection(conn net.Conn) error {
 	// The server should always be doing the opposite of what the client is doing.
 	conf.Direction.Reverse()
 
-	if conf.Version != version {
-		err = fmt.Errorf("version mismatch! Server is version %d, client is version %d", version, conf.Version)
+	if err := checkConfig(conf); err != nil {
 		encoder.Encode(configResponse{Error: err.Error()})
 		return err
 	}
 
+	switch conf.Mode {
+	case modeEcho:
+		encoder.Encode(configResponse{})
+		_, err := io.Copy(conn, io.MultiReader(decoder.Buffered(), conn))
+		return err
+	case modeUDP:
+		return s.serveUDP(conn, conf, decoder, encoder)
+	}
+
 	// Start the test
 	encoder.Encode(configResponse{})
 	_, err = doTest(conn, conf)
 	return err
 }
 
+// checkConfig returns an error if the server shouldn't run the test in
+// conf.
+func checkConfig(conf config) error {
+	if conf.Version < minVersion || conf.Version > version {
+		return fmt.Errorf("version mismatch! Server is version %d, client is version %d", version, conf.Version)
+	}
+	if conf.Version < 2 && conf.Mode != modeTCP {
+		return fmt.Errorf("mode %q requires version 2", conf.Mode)
+	}
+	switch conf.Mode {
+	case modeTCP, modeUDP, modeEcho:
+	default:
+		return fmt.Errorf("unknown test mode %q", conf.Mode)
+	}
+	if conf.Direction != Upload && conf.Direction != Download {
+		return fmt.Errorf("invalid direction %d", conf.Direction)
+	}
+	if conf.Mode != modeEcho && (conf.TestDuration <= 0 || conf.TestDuration > MaxDuration) {
+		return fmt.Errorf("test duration must be within 0 and %v", MaxDuration)
+	}
+	if conf.Mode == modeUDP && (conf.UDPBitrate <= 0 || conf.UDPBitrate > MaxUDPBitrate) {
+		return fmt.Errorf("UDP bitrate must be within 0 and %d", int64(MaxUDPBitrate))
+	}
+	return nil
+}
+
+// meter counts the bytes transferred by one or more streams of a test
+// and divides them into Results.
+type meter struct {
+	start time.Time
+
+	mu             sync.Mutex
+	results        []Result
+	lastCalculated time.Time
+	currentTime    time.Time
+	intervalBytes  int
+	totalBytes     int
+}
+
+func newMeter() *meter {
+	now := time.Now()
+	return &meter{start: now, lastCalculated: now, currentTime: now}
+}
+
+// add records that n bytes were transferred.
+func (m *meter) add(n int) {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.currentTime = now
+	m.intervalBytes += n
+
+	// checks if the current time is more or equal to the lastCalculated time plus the increment
+	if now.After(m.lastCalculated.Add(increment)) {
+		intervalStart := m.lastCalculated.Sub(m.start)
+		intervalEnd := now.Sub(m.start)
+		if (intervalEnd - intervalStart) > minInterval {
+			m.results = append(m.results, Result{Bytes: m.intervalBytes, IntervalStart: intervalStart, IntervalEnd: intervalEnd, Total: false})
+		}
+		m.lastCalculated = now
+		m.totalBytes += m.intervalBytes
+		m.intervalBytes = 0
+	}
+}
+
+// finish returns the results, ending with the last partial interval and
+// the total.
+func (m *meter) finish() []Result {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	results := append([]Result(nil), m.results...)
+
+	// get last segment
+	intervalStart := m.lastCalculated.Sub(m.start)
+	intervalEnd := m.currentTime.Sub(m.start)
+	if (intervalEnd - intervalStart) > minInterval {
+		results = append(results, Result{Bytes: m.intervalBytes, IntervalStart: intervalStart, IntervalEnd: intervalEnd, Total: false})
+	}
+
+	// get total
+	totalBytes := m.totalBytes + m.intervalBytes
+	if intervalEnd > minInterval {
+		results = append(results, Result{Bytes: totalBytes, IntervalStart: 0, IntervalEnd: intervalEnd, Total: true})
+	}
+	return results
+}
+
 // TODO include code to detect whether the code is direct vs DERP
 
 // doTest contains the code to run both the upload and download speedtest.
 // the direction value in the config parameter determines which test to run.
 func doTest(conn net.Conn, conf config) ([]Result, error) {
-	bufferData := make([]byte, blockSize)
-
-	intervalBytes := 0
-	totalBytes := 0
-
-	var currentTime time.Time
-	var results []Result
+	m := newMeter()
+	if err := transfer(conn, conf, m); err != nil {
+		return nil, err
+	}
+	return m.finish(), nil
+}
 
+// transfer sends data on conn for the test's duration, or receives it
+// until the sender closes the connection, depending on conf.Direction,
+// and records the bytes transferred in m.
+func transfer(conn net.Conn, conf config, m *meter) error {
+	bufferData := make([]byte, blockSize)
 	startTime := time.Now()
-	lastCalculated := startTime
 
 	if conf.Direction == Download {
 		conn.SetReadDeadline(time.Now().Add(conf.TestDuration).Add(5 * time.Second))
 	} else {
 		_, err := rand.Read(bufferData)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 	}
 
-SpeedTestLoop:
 	for {
 		var n int
 		var err error
@@ -103,11 +266,12 @@ func doTest(conn net.Conn, conf config) ([]Result, error) {
 			n, err = io.ReadFull(conn, bufferData)
 			switch err {
 			case io.EOF, io.ErrUnexpectedEOF:
-				break SpeedTestLoop
+				m.add(n)
+				return nil
 			case nil:
 				// successful read
 			default:
-				return nil, fmt.Errorf("unexpected error has occurred: %w", err)
+				return fmt.Errorf("unexpected error has occurred: %w", err)
 			}
 		} else {
 			// Need to change the data a little bit, to avoid any compression.
@@ -117,42 +281,13 @@ func doTest(conn net.Conn, conf config) ([]Result, error) {
 			n, err = conn.Write(bufferData)
 			if err != nil {
 				// If the write failed, there is most likely something wrong with the connection.
-				return nil, fmt.Errorf("upload failed: %w", err)
+				return fmt.Errorf("upload failed: %w", err)
 			}
 		}
-		currentTime = time.Now()
-		intervalBytes += n
-
-		// checks if the current time is more or equal to the lastCalculated time plus the increment
-		if currentTime.After(lastCalculated.Add(increment)) {
-			intervalStart := lastCalculated.Sub(startTime)
-			intervalEnd := currentTime.Sub(startTime)
-			if (intervalEnd - intervalStart) > minInterval {
-				results = append(results, Result{Bytes: intervalBytes, IntervalStart: intervalStart, IntervalEnd: intervalEnd, Total: false})
-			}
-			lastCalculated = currentTime
-			totalBytes += intervalBytes
-			intervalBytes = 0
-		}
+		m.add(n)
 
 		if conf.Direction == Upload && time.Since(startTime) > conf.TestDuration {
-			break SpeedTestLoop
+			return nil
 		}
 	}
-
-	// get last segment
-	intervalStart := lastCalculated.Sub(startTime)
-	intervalEnd := currentTime.Sub(startTime)
-	if (intervalEnd - intervalStart) > minInterval {
-		results = append(results, Result{Bytes: intervalBytes, IntervalStart: intervalStart, IntervalEnd: intervalEnd, Total: false})
-	}
-
-	// get total
-	totalBytes += intervalBytes
-	intervalEnd = currentTime.Sub(startTime)
-	if intervalEnd > minInterval {
-		results = append(results, Result{Bytes: totalBytes, IntervalStart: 0, IntervalEnd: intervalEnd, Total: true})
-	}
-
-	return results, nil
 }
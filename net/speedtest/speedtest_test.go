@@ -5,8 +5,15 @@
 package speedtest
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestDownload(t *testing.T) {
@@ -79,3 +86,155 @@ type state struct {
 		t.Error("server error:", err)
 	}
 }
+
+func startServer(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go (&Server{Logf: t.Logf}).Serve(l)
+	return l.Addr().String()
+}
+
+func TestRunBidirectionalStreams(t *testing.T) {
+	t.Parallel()
+	addr := startServer(t)
+	rep, err := Run(context.Background(), addr, Options{
+		Direction: Bidirectional,
+		Duration:  MinDuration,
+		Streams:   3,
+		Latency:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dr := range []*DirectionReport{rep.Download, rep.Upload} {
+		if dr == nil {
+			t.Fatal("missing direction in report")
+		}
+		if dr.Streams != 3 {
+			t.Errorf("Streams = %d; want 3", dr.Streams)
+		}
+		if total := dr.Total(); total.Bytes == 0 || total.IntervalEnd < MinDuration {
+			t.Errorf("total = %+v", total)
+		}
+	}
+	if rep.Latency == nil || len(rep.Latency.Idle.Samples) != idleLatencyProbes || len(rep.Latency.Loaded.Samples) == 0 {
+		t.Errorf("latency = %+v", rep.Latency)
+	}
+	var buf bytes.Buffer
+	if err := rep.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("report:\n%s", buf.Bytes())
+}
+
+func TestRunUDP(t *testing.T) {
+	t.Parallel()
+	addr := startServer(t)
+	rep, err := Run(context.Background(), addr, Options{
+		Direction:  Bidirectional,
+		Duration:   MinDuration,
+		UDP:        true,
+		UDPBitrate: 4_000_000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 4 Mbit/s of 1200 byte datagrams for 5s.
+	const wantSent = 4_000_000 / (8 * udpPacketSize) * 5
+	for _, dr := range []*DirectionReport{rep.Download, rep.Upload} {
+		if dr == nil {
+			t.Fatal("missing direction in report")
+		}
+		if dr.PacketsSent < wantSent*9/10 || dr.PacketsSent > wantSent*11/10 {
+			t.Errorf("PacketsSent = %d; want about %d", dr.PacketsSent, wantSent)
+		}
+		// Loopback shouldn't lose much.
+		if dr.LossPercent() > 10 {
+			t.Errorf("loss = %.2f%% (%d of %d received)", dr.LossPercent(), dr.PacketsReceived, dr.PacketsSent)
+		}
+		if dr.Total().Bytes == 0 {
+			t.Errorf("no bytes received: %+v", dr.Results)
+		}
+	}
+}
+
+func TestHTTPUpgrade(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v0/speedtest" {
+			http.NotFound(w, r)
+			return
+		}
+		new(Server).ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	c := &client{addr: addr, opts: Options{HTTPPath: "/v0/speedtest"}}
+	conn, _, _, err := c.connect(context.Background(), config{Mode: modeEcho})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	p := &latencyProbe{conn: conn}
+	if _, err := p.probe(42); err != nil {
+		t.Fatal(err)
+	}
+
+	c.opts.HTTPPath = "/elsewhere"
+	if _, _, _, err := c.connect(context.Background(), config{Mode: modeEcho}); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("connect to wrong path: got err %v", err)
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	tests := []struct {
+		conf    config
+		wantErr string
+	}{
+		{config{Version: 1, TestDuration: time.Second}, ""},
+		{config{Version: 1, Mode: modeUDP, TestDuration: time.Second, UDPBitrate: 1}, "requires version 2"},
+		{config{Version: 3, TestDuration: time.Second}, "version mismatch"},
+		{config{Version: 2, TestDuration: time.Hour}, "test duration"},
+		{config{Version: 2, Mode: modeEcho}, ""},
+		{config{Version: 2, Mode: modeUDP, TestDuration: time.Second}, "UDP bitrate"},
+		{config{Version: 2, Mode: "bogus", TestDuration: time.Second}, "unknown test mode"},
+		{config{Version: 2, Direction: Bidirectional, TestDuration: time.Second}, "invalid direction"},
+	}
+	for _, tt := range tests {
+		err := checkConfig(tt.conf)
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("checkConfig(%+v) = %v; want %q", tt.conf, err, tt.wantErr)
+		}
+	}
+}
+
+func TestUDPReceiver(t *testing.T) {
+	r := &udpReceiver{token: 7, m: newMeter()}
+	pkt := func(seq uint64, sentAt time.Time) []byte {
+		b := make([]byte, udpPacketSize)
+		binary.BigEndian.PutUint64(b, 7)
+		binary.BigEndian.PutUint64(b[8:], seq)
+		binary.BigEndian.PutUint64(b[16:], uint64(sentAt.UnixNano()))
+		return b
+	}
+	t0 := time.Unix(1600000000, 0)
+	ms := time.Millisecond
+	r.handle(pkt(0, t0), t0.Add(10*ms))
+	r.handle(pkt(1, t0.Add(ms)), t0.Add(15*ms)) // transit 14ms: D = 4ms
+	r.handle(pkt(1, t0.Add(ms)), t0.Add(16*ms)) // duplicate
+	r.handle(pkt(3, t0.Add(3*ms)), t0.Add(13*ms))
+	if r.handle([]byte("not a test packet at all, no no no"), t0) {
+		t.Error("handled packet with wrong token")
+	}
+	if r.received != 3 {
+		t.Errorf("received = %d; want 3", r.received)
+	}
+	// J = 4/16 = 0.25ms, then D = 4ms: J = 0.25 + (4-0.25)/16.
+	if want := 0.25e6 + (4e6-0.25e6)/16; r.jitter != want {
+		t.Errorf("jitter = %v; want %v", r.jitter, want)
+	}
+}
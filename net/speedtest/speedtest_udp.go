@@ -0,0 +1,355 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package speedtest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// UDP tests are controlled by the TCP connection that they were requested
+// on. The receiver learns that the sender is finished from a udpDone
+// message sent on it, and when the server is the receiver, it replies
+// with its udpStats. When the server is the sender, the client first
+// sends hello datagrams (just the test's token) so the server learns
+// where to send to.
+//
+// Test datagrams are udpPacketSize bytes, starting with a header of the
+// test's token, the datagram's sequence number and the time it was sent
+// in nanoseconds since the Unix epoch on the sender's clock, all as
+// big-endian uint64s.
+const (
+	udpPacketSize  = 1200 // fits in the tailnet MTU
+	udpHeaderSize  = 24
+	udpHelloSize   = 8
+	udpDrainTime   = 250 * time.Millisecond // how long to wait for stragglers after the sender's done
+	udpHelloEvery  = 100 * time.Millisecond
+	udpHelloWait   = 5 * time.Second
+	maxUDPSequence = 1 << 26 // larger sequence numbers are ignored
+)
+
+// udpDone is sent by the sender of a UDP test on the control connection
+// when it's finished.
+type udpDone struct {
+	Sent int64 `json:"sent"`
+}
+
+// udpStats is the outcome of a UDP test, as measured by the receiver.
+type udpStats struct {
+	Results  []Result      `json:"results"`
+	Received int64         `json:"received"` // unique datagrams received
+	Jitter   time.Duration `json:"jitter"`
+}
+
+func (s *Server) serveUDP(conn net.Conn, conf config, dec *json.Decoder, enc *json.Encoder) error {
+	listen := s.ListenPacket
+	if listen == nil {
+		listen = func(local netip.Addr) (net.PacketConn, error) {
+			return net.ListenPacket("udp", net.JoinHostPort(local.String(), "0"))
+		}
+	}
+	var local netip.Addr
+	if ta, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		local = ta.AddrPort().Addr().Unmap()
+	}
+	pc, err := listen(local)
+	if err != nil {
+		enc.Encode(configResponse{Error: err.Error()})
+		return err
+	}
+	defer pc.Close()
+	ua, ok := pc.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		err := fmt.Errorf("unexpected UDP socket address %v", pc.LocalAddr())
+		enc.Encode(configResponse{Error: err.Error()})
+		return err
+	}
+	token := newUDPToken()
+	if err := enc.Encode(configResponse{UDPPort: uint16(ua.Port), UDPToken: token}); err != nil {
+		return err
+	}
+
+	if conf.Direction == Upload {
+		// The client is downloading. Wait for its hello to know where
+		// to send to.
+		dst, err := waitUDPHello(pc, token)
+		if err != nil {
+			return err
+		}
+		sent, err := sendUDP(func(b []byte) error {
+			_, err := pc.WriteTo(b, dst)
+			return err
+		}, token, conf)
+		if err != nil {
+			return err
+		}
+		if err := enc.Encode(udpDone{Sent: sent}); err != nil {
+			return err
+		}
+		// Wait for the client to finish receiving and hang up.
+		conn.SetReadDeadline(time.Now().Add(udpDrainTime + 5*time.Second))
+		io.Copy(io.Discard, conn)
+		return nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		var d udpDone
+		dec.Decode(&d)
+		close(done)
+	}()
+	stats, err := receiveUDP(func(b []byte) (int, error) {
+		n, _, err := pc.ReadFrom(b)
+		return n, err
+	}, pc.SetReadDeadline, token, conf, done)
+	if err != nil {
+		return err
+	}
+	return enc.Encode(stats)
+}
+
+func newUDPToken() uint64 {
+	var b [8]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint64(b[:])
+}
+
+// waitUDPHello waits for a hello datagram with token and returns the
+// address it came from.
+func waitUDPHello(pc net.PacketConn, token uint64) (net.Addr, error) {
+	pc.SetReadDeadline(time.Now().Add(udpHelloWait))
+	defer pc.SetReadDeadline(time.Time{})
+	buf := make([]byte, udpPacketSize)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return nil, fmt.Errorf("waiting for UDP hello: %w", err)
+		}
+		if n == udpHelloSize && binary.BigEndian.Uint64(buf) == token {
+			return addr, nil
+		}
+	}
+}
+
+// sendUDP sends test datagrams with write, paced at conf.UDPBitrate, for
+// conf.TestDuration, and returns how many it sent.
+func sendUDP(write func([]byte) error, token uint64, conf config) (sent int64, err error) {
+	pkt := make([]byte, udpPacketSize)
+	if _, err := rand.Read(pkt); err != nil {
+		return 0, err
+	}
+	binary.BigEndian.PutUint64(pkt, token)
+	perSecond := float64(conf.UDPBitrate) / (8 * udpPacketSize)
+
+	var failures int // consecutive write errors
+	start := time.Now()
+	for {
+		elapsed := time.Since(start)
+		if elapsed >= conf.TestDuration {
+			return sent, nil
+		}
+		for want := int64(elapsed.Seconds()*perSecond) + 1; sent < want; sent++ {
+			binary.BigEndian.PutUint64(pkt[8:], uint64(sent))
+			binary.BigEndian.PutUint64(pkt[16:], uint64(time.Now().UnixNano()))
+			if err := write(pkt); err != nil {
+				// Sending faster than the local network stack can
+				// handle shows up as loss, but give up if nothing's
+				// getting through at all.
+				if failures++; failures > 100 {
+					return sent, fmt.Errorf("UDP send failed: %w", err)
+				}
+				continue
+			}
+			failures = 0
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// udpReceiver accumulates the statistics of received test datagrams.
+type udpReceiver struct {
+	token    uint64
+	m        *meter
+	seen     []uint64 // bitset of sequence numbers received
+	received int64
+
+	// For jitter, as in RFC 3550.
+	haveTransit bool
+	lastTransit time.Duration
+	jitter      float64
+}
+
+// handle records the datagram b, received at now. It reports whether b
+// was a test datagram.
+func (r *udpReceiver) handle(b []byte, now time.Time) bool {
+	if len(b) < udpHeaderSize || binary.BigEndian.Uint64(b) != r.token {
+		return false
+	}
+	r.m.add(len(b))
+	seq := binary.BigEndian.Uint64(b[8:])
+	sentAt := int64(binary.BigEndian.Uint64(b[16:]))
+	if seq >= maxUDPSequence {
+		return true
+	}
+	word, bit := seq/64, uint64(1)<<(seq%64)
+	for uint64(len(r.seen)) <= word {
+		r.seen = append(r.seen, 0)
+	}
+	if r.seen[word]&bit != 0 {
+		return true // duplicate
+	}
+	r.seen[word] |= bit
+	r.received++
+
+	transit := time.Duration(now.UnixNano() - sentAt)
+	if r.haveTransit {
+		d := transit - r.lastTransit
+		if d < 0 {
+			d = -d
+		}
+		r.jitter += (float64(d) - r.jitter) / 16
+	}
+	r.haveTransit = true
+	r.lastTransit = transit
+	return true
+}
+
+// receiveUDP receives the test datagrams of a UDP test with read, until
+// udpDrainTime after done is closed.
+func receiveUDP(read func([]byte) (int, error), setDeadline func(time.Time) error, token uint64, conf config, done <-chan struct{}) (*udpStats, error) {
+	r := &udpReceiver{token: token, m: newMeter()}
+	buf := make([]byte, udpPacketSize+1)
+	stop := time.Now().Add(conf.TestDuration + udpHelloWait + 5*time.Second)
+	for {
+		select {
+		case <-done:
+			if s := time.Now().Add(udpDrainTime); s.Before(stop) {
+				stop = s
+			}
+			done = nil
+		default:
+		}
+		if !time.Now().Before(stop) {
+			break
+		}
+		// Wake up regularly to check whether the sender's done.
+		setDeadline(time.Now().Add(50 * time.Millisecond))
+		n, err := read(buf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return nil, err
+		}
+		r.handle(buf[:n], time.Now())
+	}
+	return &udpStats{
+		Results:  r.m.finish(),
+		Received: r.received,
+		Jitter:   time.Duration(r.jitter),
+	}, nil
+}
+
+// runUDP runs one direction of a UDP test, whose control connection conn
+// has been set up with conf and got the response resp.
+func (c *client) runUDP(ctx context.Context, conn net.Conn, dec *json.Decoder, conf config, resp configResponse) (*DirectionReport, error) {
+	host, _, err := net.SplitHostPort(c.addr)
+	if err != nil {
+		return nil, err
+	}
+	uc, err := c.dial(ctx, "udp", net.JoinHostPort(host, strconv.Itoa(int(resp.UDPPort))))
+	if err != nil {
+		return nil, err
+	}
+	defer uc.Close()
+	enc := json.NewEncoder(conn)
+
+	if conf.Direction == Upload {
+		sent, err := sendUDP(func(b []byte) error {
+			_, err := uc.Write(b)
+			return err
+		}, resp.UDPToken, conf)
+		if err != nil {
+			return nil, err
+		}
+		if err := enc.Encode(udpDone{Sent: sent}); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(udpDrainTime + 10*time.Second))
+		var stats udpStats
+		if err := dec.Decode(&stats); err != nil {
+			return nil, fmt.Errorf("reading UDP results: %w", err)
+		}
+		return &DirectionReport{
+			Results:         stats.Results,
+			PacketsSent:     sent,
+			PacketsReceived: stats.Received,
+			Jitter:          stats.Jitter,
+		}, nil
+	}
+
+	// Downloading: say hello until the first datagram arrives.
+	var gotData atomic.Bool
+	helloDone := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		hello := make([]byte, udpHelloSize)
+		binary.BigEndian.PutUint64(hello, resp.UDPToken)
+		t := time.NewTicker(udpHelloEvery)
+		defer t.Stop()
+		for !gotData.Load() {
+			uc.Write(hello)
+			select {
+			case <-t.C:
+			case <-helloDone:
+				return
+			}
+		}
+	}()
+	defer wg.Wait()
+	defer close(helloDone)
+
+	var sent int64
+	done := make(chan struct{})
+	go func() {
+		var d udpDone
+		if dec.Decode(&d) == nil {
+			sent = d.Sent
+		}
+		close(done)
+	}()
+	stats, err := receiveUDP(func(b []byte) (int, error) {
+		n, err := uc.Read(b)
+		if n >= udpHeaderSize {
+			gotData.Store(true)
+		}
+		return n, err
+	}, uc.SetReadDeadline, resp.UDPToken, conf, done)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	<-done
+	return &DirectionReport{
+		Results:         stats.Results,
+		PacketsSent:     sent,
+		PacketsReceived: stats.Received,
+		Jitter:          stats.Jitter,
+	}, nil
+}
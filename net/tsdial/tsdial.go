@@ -13,6 +13,7 @@
 	"net/http"
 	"net/netip"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
@@ -34,14 +35,19 @@
 // Extension, none), user-selected route acceptance prefs, etc.
 type Dialer struct {
 	Logf logger.Logf
-	// UseNetstackForIP if non-nil is whether NetstackDialTCP (if
-	// it's non-nil) should be used to dial the provided IP.
+	// UseNetstackForIP if non-nil is whether NetstackDialTCP and
+	// NetstackDialUDP (if they're non-nil) should be used to dial the
+	// provided IP.
 	UseNetstackForIP func(netip.Addr) bool
 
 	// NetstackDialTCP dials the provided IPPort using netstack.
 	// If nil, it's not used.
 	NetstackDialTCP func(context.Context, netip.AddrPort) (net.Conn, error)
 
+	// NetstackDialUDP dials the provided IPPort over UDP using netstack.
+	// If nil, it's not used.
+	NetstackDialUDP func(context.Context, netip.AddrPort) (net.Conn, error)
+
 	peerClientOnce sync.Once
 	peerClient     *http.Client
 
@@ -54,7 +60,8 @@ type Dialer struct {
 	mu                sync.Mutex
 	closed            bool
 	dns               dnsMap
-	tunName           string // tun device name
+	tailnetRoutes     []netip.Prefix // from the netmap; see TailnetRoutes
+	tunName           string         // tun device name
 	linkMon           *monitor.Mon
 	linkMonUnregister func()
 	exitDNSDoHBase    string                 // non-empty if DoH-proxying exit node in use; base URL+path (without '?')
@@ -198,10 +205,73 @@ func (d *Dialer) PeerDialControlFunc() func(network, address string, c syscall.R
 // in its DNS configuration.
 func (d *Dialer) SetNetMap(nm *netmap.NetworkMap) {
 	m := dnsMapFromNetworkMap(nm)
+	routes := tailnetRoutesFromNetworkMap(nm)
 
 	d.mu.Lock()
 	defer d.mu.Unlock()
 	d.dns = m
+	d.tailnetRoutes = routes
+}
+
+// tailnetRoutesFromNetworkMap returns the addresses of the nodes in nm and
+// the subnet routes served by its peers, excluding exit node routes.
+func tailnetRoutesFromNetworkMap(nm *netmap.NetworkMap) []netip.Prefix {
+	if nm == nil {
+		return nil
+	}
+	ret := append([]netip.Prefix(nil), nm.Addresses...)
+	for _, p := range nm.Peers {
+		ret = append(ret, p.Addresses...)
+		for _, r := range p.PrimaryRoutes {
+			if r.Bits() != 0 {
+				ret = append(ret, r)
+			}
+		}
+	}
+	return ret
+}
+
+// TailnetRoutes returns the prefixes that are reachable over the tailnet
+// according to the current network map: the addresses of its nodes and
+// the subnets routed by its peers. Exit node routes are not included.
+func (d *Dialer) TailnetRoutes() []netip.Prefix {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]netip.Prefix(nil), d.tailnetRoutes...)
+}
+
+// TailnetNames returns the sorted MagicDNS names (both base names and
+// FQDNs, without trailing dots) in the current network map.
+func (d *Dialer) TailnetNames() []string {
+	d.mu.Lock()
+	m := d.dns
+	d.mu.Unlock()
+	ret := make([]string, 0, len(m))
+	for name := range m {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// IsTailnetDestination reports whether host, an IP address or a name,
+// is reachable over the tailnet without using an exit node: whether it's
+// a MagicDNS name or an address in TailnetRoutes.
+func (d *Dialer) IsTailnetDestination(host string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		_, ok := d.dns[canonMapKey(host)]
+		return ok
+	}
+	ip = ip.Unmap()
+	for _, r := range d.tailnetRoutes {
+		if r.Contains(ip) {
+			return true
+		}
+	}
+	return false
 }
 
 func (d *Dialer) userDialResolve(ctx context.Context, network, addr string) (netip.AddrPort, error) {
@@ -307,21 +377,25 @@ func (d *Dialer) UserDial(ctx context.Context, network, addr string) (net.Conn,
 		return nil, err
 	}
 	if d.UseNetstackForIP != nil && d.UseNetstackForIP(ipp.Addr()) {
-		if d.NetstackDialTCP == nil {
+		netstackDial := d.NetstackDialTCP
+		if strings.HasPrefix(network, "udp") {
+			netstackDial = d.NetstackDialUDP
+		}
+		if netstackDial == nil {
 			return nil, errors.New("Dialer not initialized correctly")
 		}
-		return d.NetstackDialTCP(ctx, ipp)
+		return netstackDial(ctx, ipp)
 	}
 	// TODO(bradfitz): netns, etc
 	var stdDialer net.Dialer
 	return stdDialer.DialContext(ctx, network, ipp.String())
 }
 
-// dialPeerAPI connects to a Tailscale peer's peerapi over TCP.
+// DialPeerAPI connects to a Tailscale peer's peerapi over TCP.
 //
 // network must a "tcp" type, and addr must be an ip:port. Name resolution
 // is not supported.
-func (d *Dialer) dialPeerAPI(ctx context.Context, network, addr string) (net.Conn, error) {
+func (d *Dialer) DialPeerAPI(ctx context.Context, network, addr string) (net.Conn, error) {
 	switch network {
 	case "tcp", "tcp6", "tcp4":
 	default:
@@ -367,7 +441,7 @@ func (d *Dialer) PeerAPIHTTPClient() *http.Client {
 	d.peerClientOnce.Do(func() {
 		t := http.DefaultTransport.(*http.Transport).Clone()
 		t.Dial = nil
-		t.DialContext = d.dialPeerAPI
+		t.DialContext = d.DialPeerAPI
 		d.peerClient = &http.Client{Transport: t}
 	})
 	return d.peerClient
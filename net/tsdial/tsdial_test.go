@@ -0,0 +1,67 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsdial
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+func TestIsTailnetDestination(t *testing.T) {
+	pfx := netip.MustParsePrefix
+	var d Dialer
+	d.SetNetMap(&netmap.NetworkMap{
+		Name:      "foo.tailnet.",
+		Addresses: []netip.Prefix{pfx("100.102.103.104/32")},
+		Peers: []*tailcfg.Node{
+			{
+				Name:          "router.tailnet.",
+				Addresses:     []netip.Prefix{pfx("100.102.103.105/32")},
+				PrimaryRoutes: []netip.Prefix{pfx("10.0.0.0/8")},
+			},
+			{
+				Name:          "exit.tailnet.",
+				Addresses:     []netip.Prefix{pfx("100.102.103.106/32")},
+				PrimaryRoutes: []netip.Prefix{pfx("0.0.0.0/0"), pfx("::/0")},
+			},
+		},
+	})
+
+	for host, want := range map[string]bool{
+		"100.102.103.104":    true,
+		"100.102.103.106":    true,
+		"10.1.2.3":           true,
+		"::ffff:10.1.2.3":    true,
+		"8.8.8.8":            false,
+		"2001:4860::8888":    false,
+		"router":             true,
+		"ROUTER.tailnet.":    true,
+		"exit.tailnet":       true,
+		"example.com":        false,
+		"router.example.com": false,
+	} {
+		if got := d.IsTailnetDestination(host); got != want {
+			t.Errorf("IsTailnetDestination(%q) = %v; want %v", host, got, want)
+		}
+	}
+
+	wantRoutes := []netip.Prefix{
+		pfx("100.102.103.104/32"),
+		pfx("100.102.103.105/32"),
+		pfx("10.0.0.0/8"),
+		pfx("100.102.103.106/32"),
+	}
+	if got := d.TailnetRoutes(); !reflect.DeepEqual(got, wantRoutes) {
+		t.Errorf("TailnetRoutes = %v; want %v", got, wantRoutes)
+	}
+	wantNames := []string{"exit", "exit.tailnet", "foo", "foo.tailnet", "router", "router.tailnet"}
+	if got := d.TailnetNames(); !reflect.DeepEqual(got, wantNames) {
+		t.Errorf("TailnetNames = %q; want %q", got, wantNames)
+	}
+}
@@ -563,7 +563,7 @@ func (c *conn) authorizeSession(s ssh.Session) (_ *contextReader, ok bool) {
 	if c.idH == "" {
 		c.idH = idH
 	} else if c.idH != idH {
-		c.logf("ssh: session ID mismatch: %q != %q", c.idH, idH)
+		c.logf("session ID mismatch: %q != %q", c.idH, idH)
 		s.Exit(1)
 		return nil, false
 	}
@@ -910,7 +910,7 @@ func (ss *sshSession) handleSSHAgentForwarding(s ssh.Session, lu *user.User) err
 	if !ssh.AgentRequested(ss) || !ss.conn.finalAction.AllowAgentForwarding {
 		return nil
 	}
-	ss.logf("ssh: agent forwarding requested")
+	ss.logf("agent forwarding requested")
 	ln, err := ssh.NewAgentListener()
 	if err != nil {
 		return err
@@ -0,0 +1,895 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package integration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/client/tailscale"
+	"tailscale.com/control/controlclient"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnlocal"
+	"tailscale.com/ipn/localapi"
+	"tailscale.com/ipn/store/mem"
+	"tailscale.com/logtail"
+	"tailscale.com/net/dns"
+	"tailscale.com/net/nettest"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/net/tsdial"
+	"tailscale.com/smallzstd"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstest"
+	"tailscale.com/tstest/integration/testcontrol"
+	"tailscale.com/types/logger"
+	"tailscale.com/wgengine"
+	"tailscale.com/wgengine/monitor"
+	"tailscale.com/wgengine/netstack"
+)
+
+// TailnetDomain is the MagicDNS domain of tailnets started by
+// StartTailnet. Nodes are named <name>.TailnetDomain.
+const TailnetDomain = "tailnet.test"
+
+// A TailnetSpec declares a tailnet for RunTailnet or StartTailnet: its
+// nodes, and what should be true of the connectivity between them.
+type TailnetSpec struct {
+	Nodes      []NodeSpec
+	Assertions []Assertion
+
+	// Logf, if non-nil, is where the nodes and servers log. By
+	// default, logs are kept in memory and written to the test's log
+	// if it fails.
+	Logf logger.Logf
+}
+
+// A NodeSpec declares a node of a tailnet, with the prefs that
+// "tailscale up" would set on it.
+type NodeSpec struct {
+	// Name is the node's hostname, by which the rest of the
+	// TailnetSpec refers to it. It must be unique in the tailnet.
+	Name string
+
+	// AdvertiseRoutes are the subnet routes that the node offers.
+	// Connections to them are forwarded off the tailnet, to listeners
+	// made with Tailnet.ListenExternal or else the host's network.
+	AdvertiseRoutes []netip.Prefix
+	// AdvertiseExitNode is whether the node offers to be an exit node.
+	AdvertiseExitNode bool
+	// ExitNode, if non-empty, is the name of the node to use as an
+	// exit node.
+	ExitNode string
+	// Tags are the ACL tags that the node advertises, such as
+	// "tag:server".
+	Tags []string
+	// SSH is whether the node runs the Tailscale SSH server.
+	SSH bool
+}
+
+// A Tailnet is a running tailnet of in-process nodes, with its own
+// control, DERP and STUN servers.
+//
+// The nodes run in userspace networking mode, as tailscaled does with
+// --tun=userspace-networking, so they need no privileges. Connections
+// to a node's Tailscale IP are handed to its Listen listeners, and
+// connections that nodes route off the tailnet are handed to
+// ListenExternal listeners or else made on the host's network.
+type Tailnet struct {
+	Control *testcontrol.Server
+
+	t     testing.TB
+	logf  logger.Logf
+	nodes []*Node
+
+	mu       sync.Mutex
+	external map[netip.AddrPort]*listener
+	echoes   map[string]bool // addresses of running echo servers
+}
+
+// RunTailnet starts the tailnet declared by spec and checks its
+// assertions. The tailnet is shut down when the test ends.
+func RunTailnet(t testing.TB, spec TailnetSpec) *Tailnet {
+	t.Helper()
+	tn := StartTailnet(t, spec)
+	tn.Check(spec.Assertions...)
+	return tn
+}
+
+// StartTailnet starts the nodes of the tailnet declared by spec, and
+// waits for them to be running and to see each other. It doesn't check
+// spec's assertions. The tailnet is shut down when the test ends.
+func StartTailnet(t testing.TB, spec TailnetSpec) *Tailnet {
+	t.Helper()
+	logf := spec.Logf
+	if logf == nil {
+		ml := new(tstest.MemLogger)
+		logf = ml.Logf
+		t.Cleanup(func() {
+			if t.Failed() {
+				t.Logf("tailnet logs:\n%s", ml.String())
+			}
+		})
+	}
+	if err := spec.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	control := &testcontrol.Server{
+		DERPMap:        RunDERPAndSTUN(t, logf, "127.0.0.1"),
+		Logf:           logger.WithPrefix(logf, "control: "),
+		MagicDNSDomain: TailnetDomain,
+		AutoApprove:    true,
+	}
+	for _, ns := range spec.Nodes {
+		if ns.SSH {
+			// An empty policy rejects all connections, but gives
+			// nodes the SSH capability, which running the server
+			// requires.
+			control.SSHPolicy = &tailcfg.SSHPolicy{}
+		}
+	}
+	control.HTTPTestServer = httptest.NewUnstartedServer(control)
+	control.HTTPTestServer.Start()
+	t.Cleanup(control.HTTPTestServer.Close)
+
+	tn := &Tailnet{
+		Control: control,
+		t:       t,
+		logf:    logf,
+	}
+	t.Cleanup(tn.close)
+	for _, ns := range spec.Nodes {
+		n, err := tn.startNode(ns)
+		if err != nil {
+			t.Fatalf("starting node %q: %v", ns.Name, err)
+		}
+		tn.nodes = append(tn.nodes, n)
+	}
+	if err := tstest.WaitFor(30*time.Second, tn.checkUp); err != nil {
+		t.Fatalf("waiting for tailnet to come up: %v", err)
+	}
+
+	// Exit nodes are chosen by IP, which nodes only have once up.
+	for i, ns := range spec.Nodes {
+		if ns.ExitNode == "" {
+			continue
+		}
+		_, err := tn.nodes[i].lb.EditPrefs(&ipn.MaskedPrefs{
+			Prefs:         ipn.Prefs{ExitNodeIP: tn.Node(ns.ExitNode).IP()},
+			ExitNodeIPSet: true,
+		})
+		if err != nil {
+			t.Fatalf("setting exit node of %q: %v", ns.Name, err)
+		}
+	}
+	return tn
+}
+
+func (spec *TailnetSpec) validate() error {
+	names := map[string]bool{}
+	for _, ns := range spec.Nodes {
+		if ns.Name == "" {
+			return errors.New("node with no name")
+		}
+		if names[ns.Name] {
+			return fmt.Errorf("duplicate node name %q", ns.Name)
+		}
+		names[ns.Name] = true
+	}
+	for _, ns := range spec.Nodes {
+		if ns.ExitNode != "" && !names[ns.ExitNode] {
+			return fmt.Errorf("node %q uses unknown exit node %q", ns.Name, ns.ExitNode)
+		}
+	}
+	return nil
+}
+
+// checkUp returns an error unless all of tn's nodes are running and
+// see all the others.
+func (tn *Tailnet) checkUp() error {
+	for _, n := range tn.nodes {
+		if st := n.lb.State(); st != ipn.Running {
+			return fmt.Errorf("node %q is in state %v", n.Name, st)
+		}
+		nm := n.lb.NetMap()
+		if nm == nil || len(nm.Addresses) == 0 {
+			return fmt.Errorf("node %q has no addresses", n.Name)
+		}
+		if len(nm.Peers) != len(tn.nodes)-1 {
+			return fmt.Errorf("node %q has %d peers; want %d", n.Name, len(nm.Peers), len(tn.nodes)-1)
+		}
+	}
+	return nil
+}
+
+// Node returns the node named name. It fails the test if there's no
+// such node.
+func (tn *Tailnet) Node(name string) *Node {
+	tn.t.Helper()
+	for _, n := range tn.nodes {
+		if n.Name == name {
+			return n
+		}
+	}
+	tn.t.Fatalf("no node named %q", name)
+	panic("unreachable")
+}
+
+// Nodes returns tn's nodes, in the order of its TailnetSpec.
+func (tn *Tailnet) Nodes() []*Node {
+	return append([]*Node(nil), tn.nodes...)
+}
+
+// ListenExternal listens on addr, an address off the tailnet that
+// nodes reach through subnet routers and exit nodes.
+func (tn *Tailnet) ListenExternal(addr netip.AddrPort) (net.Listener, error) {
+	tn.mu.Lock()
+	defer tn.mu.Unlock()
+	if tn.external[addr] != nil {
+		return nil, fmt.Errorf("listener already open for %v", addr)
+	}
+	if tn.external == nil {
+		tn.external = map[netip.AddrPort]*listener{}
+	}
+	ln := newListener(net.TCPAddrFromAddrPort(addr), func() {
+		tn.mu.Lock()
+		defer tn.mu.Unlock()
+		delete(tn.external, addr)
+	})
+	tn.external[addr] = ln
+	return ln, nil
+}
+
+// dialExternal connects c, which a node routed off the tailnet to dst,
+// to its destination.
+func (tn *Tailnet) dialExternal(n *Node, c net.Conn, dst netip.AddrPort) {
+	tn.mu.Lock()
+	ln := tn.external[dst]
+	tn.mu.Unlock()
+	if ln != nil {
+		// netstack stops handling packets to dst once forwardTCP
+		// returns, so wait for the listener's user to be done with c.
+		dc := &doneConn{Conn: c, done: make(chan struct{})}
+		if ln.hand(dc) {
+			<-dc.done
+		}
+		return
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	server, err := n.dialer.SystemDial(ctx, "tcp", dst.String())
+	if err != nil {
+		n.logf("dialing %v off the tailnet: %v", dst, err)
+		return
+	}
+	defer server.Close()
+	errc := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(server, c)
+		errc <- err
+	}()
+	go func() {
+		_, err := io.Copy(c, server)
+		errc <- err
+	}()
+	<-errc
+}
+
+// Check checks that assertions hold, retrying each for a while since
+// changes take time to propagate through the tailnet. It reports the
+// assertions that don't hold as test errors.
+func (tn *Tailnet) Check(assertions ...Assertion) {
+	tn.t.Helper()
+	for _, a := range assertions {
+		err := tstest.WaitFor(20*time.Second, func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			return a.check(ctx, tn)
+		})
+		if err != nil {
+			tn.t.Errorf("%v: %v", a, err)
+		}
+	}
+}
+
+// echo ensures that an echo server is running at addr, which is
+// "name:port" for a node or "ip:port" for an address off the tailnet.
+func (tn *Tailnet) echo(addr string) error {
+	tn.mu.Lock()
+	running := tn.echoes[addr]
+	tn.mu.Unlock()
+	if running {
+		return nil
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	var ln net.Listener
+	if isIP(host) {
+		ipp, err := netip.ParseAddrPort(addr)
+		if err != nil {
+			return err
+		}
+		if tsaddr.IsTailscaleIP(ipp.Addr()) {
+			return fmt.Errorf("address %v is in the tailnet; use a node name", ipp.Addr())
+		}
+		if ln, err = tn.ListenExternal(ipp); err != nil {
+			return err
+		}
+	} else {
+		n, ok := tn.node(host)
+		if !ok {
+			return fmt.Errorf("no node named %q", host)
+		}
+		if ln, err = n.Listen("tcp", ":"+port); err != nil {
+			return err
+		}
+	}
+
+	tn.mu.Lock()
+	if tn.echoes == nil {
+		tn.echoes = map[string]bool{}
+	}
+	tn.echoes[addr] = true
+	tn.mu.Unlock()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	return nil
+}
+
+func (tn *Tailnet) node(name string) (*Node, bool) {
+	for _, n := range tn.nodes {
+		if n.Name == name {
+			return n, true
+		}
+	}
+	return nil, false
+}
+
+func (tn *Tailnet) close() {
+	for _, n := range tn.nodes {
+		n.close()
+	}
+	tn.mu.Lock()
+	lns := make([]*listener, 0, len(tn.external))
+	for _, ln := range tn.external {
+		lns = append(lns, ln)
+	}
+	tn.mu.Unlock()
+	for _, ln := range lns {
+		ln.Close()
+	}
+}
+
+// A Node is a running in-process node of a Tailnet.
+type Node struct {
+	Name string
+
+	tn       *Tailnet
+	logf     logger.Logf
+	lb       *ipnlocal.LocalBackend
+	lc       *tailscale.LocalClient
+	dialer   *tsdial.Dialer
+	dns      *dns.Manager
+	linkMon  *monitor.Mon
+	localAPI *nettest.Listener
+
+	mu        sync.Mutex
+	listeners map[uint16]*listener
+}
+
+// startNode starts a node declared by ns, except for its exit node,
+// which can't be set until the exit node is up.
+func (tn *Tailnet) startNode(ns NodeSpec) (_ *Node, err error) {
+	logf := logger.WithPrefix(tn.logf, ns.Name+": ")
+	n := &Node{
+		Name: ns.Name,
+		tn:   tn,
+		logf: logf,
+	}
+	defer func() {
+		if err != nil {
+			n.close()
+		}
+	}()
+
+	n.linkMon, err = monitor.New(logf)
+	if err != nil {
+		return nil, err
+	}
+	n.dialer = new(tsdial.Dialer) // mutated below (before used)
+	eng, err := wgengine.NewUserspaceEngine(logf, wgengine.Config{
+		ListenPort:  0,
+		LinkMonitor: n.linkMon,
+		Dialer:      n.dialer,
+	})
+	if err != nil {
+		return nil, err
+	}
+	tunDev, magicConn, dnsMgr, ok := eng.(wgengine.InternalsGetter).GetInternals()
+	if !ok {
+		eng.Close()
+		return nil, fmt.Errorf("%T is not a wgengine.InternalsGetter", eng)
+	}
+	n.dns = dnsMgr
+	ns2, err := netstack.Create(logf, tunDev, eng, magicConn, n.dialer, dnsMgr)
+	if err != nil {
+		eng.Close()
+		return nil, fmt.Errorf("netstack.Create: %w", err)
+	}
+	ns2.ProcessLocalIPs = true
+	ns2.ProcessSubnets = true
+	ns2.ForwardTCPIn = n.forwardTCP
+	n.dialer.UseNetstackForIP = func(ip netip.Addr) bool {
+		_, ok := eng.PeerForIP(ip)
+		return ok
+	}
+	n.dialer.NetstackDialTCP = func(ctx context.Context, dst netip.AddrPort) (net.Conn, error) {
+		return ns2.DialContextTCP(ctx, dst)
+	}
+	n.dialer.NetstackDialUDP = func(ctx context.Context, dst netip.AddrPort) (net.Conn, error) {
+		return ns2.DialContextUDP(ctx, dst)
+	}
+
+	logid, err := logtail.NewPrivateID()
+	if err != nil {
+		eng.Close()
+		return nil, err
+	}
+	n.lb, err = ipnlocal.NewLocalBackend(logf, logid.Public().String(), new(mem.Store), n.dialer, eng, controlclient.LoginDefault)
+	if err != nil {
+		eng.Close()
+		return nil, fmt.Errorf("NewLocalBackend: %w", err)
+	}
+	n.lb.SetVarRoot(tn.t.TempDir()) // for SSH host keys
+	n.lb.SetDecompressor(func() (controlclient.Decompressor, error) {
+		return smallzstd.NewDecoder(nil)
+	})
+	ns2.SetLocalBackend(n.lb)
+	if err := ns2.Start(); err != nil {
+		return nil, fmt.Errorf("starting netstack: %w", err)
+	}
+
+	lah := localapi.NewHandler(n.lb, logf, logid.Public().String())
+	lah.PermitRead = true
+	lah.PermitWrite = true
+	n.localAPI = nettest.Listen("local-tailscaled.sock:80")
+	n.lc = &tailscale.LocalClient{Dial: n.localAPI.Dial}
+	go http.Serve(n.localAPI, lah)
+
+	prefs := ipn.NewPrefs()
+	prefs.ControlURL = tn.Control.BaseURL()
+	prefs.Hostname = ns.Name
+	prefs.WantRunning = true
+	// There's no OS resolver to configure, and quad-100 answers
+	// MagicDNS queries regardless.
+	prefs.CorpDNS = false
+	prefs.AdvertiseRoutes = append(prefs.AdvertiseRoutes, ns.AdvertiseRoutes...)
+	if ns.AdvertiseExitNode {
+		prefs.AdvertiseRoutes = append(prefs.AdvertiseRoutes, tsaddr.AllIPv4(), tsaddr.AllIPv6())
+	}
+	prefs.AdvertiseTags = ns.Tags
+	prefs.RunSSH = ns.SSH
+	if err := n.lb.Start(ipn.Options{
+		StateKey:    ipn.GlobalDaemonStateKey,
+		UpdatePrefs: prefs,
+	}); err != nil {
+		return nil, fmt.Errorf("starting backend: %w", err)
+	}
+	n.lb.StartLoginInteractive()
+	return n, nil
+}
+
+// IP returns n's Tailscale IPv4 address.
+func (n *Node) IP() netip.Addr {
+	if nm := n.lb.NetMap(); nm != nil {
+		for _, p := range nm.Addresses {
+			if p.Addr().Is4() {
+				return p.Addr()
+			}
+		}
+	}
+	return netip.Addr{}
+}
+
+// LocalClient returns a client of n's LocalAPI.
+func (n *Node) LocalClient() *tailscale.LocalClient {
+	return n.lc
+}
+
+// Dial connects to address from n, as n's SOCKS5 proxy would: through
+// the tailnet if the address is routed there, and otherwise on the
+// host's network. Addresses may name nodes by their MagicDNS names.
+func (n *Node) Dial(ctx context.Context, network, address string) (net.Conn, error) {
+	return n.dialer.UserDial(ctx, network, address)
+}
+
+// Listen listens for TCP connections to addr, ":port", on n's
+// Tailscale IPs.
+func (n *Node) Listen(network, addr string) (net.Listener, error) {
+	if network != "tcp" {
+		return nil, fmt.Errorf("unsupported network %q", network)
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if host != "" {
+		return nil, fmt.Errorf("listening on a specific address (%q) is not supported", addr)
+	}
+	port64, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	port := uint16(port64)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.listeners[port] != nil {
+		return nil, fmt.Errorf("listener already open for port %d", port)
+	}
+	if n.listeners == nil {
+		n.listeners = map[uint16]*listener{}
+	}
+	ln := newListener(net.TCPAddrFromAddrPort(netip.AddrPortFrom(n.IP(), port)), func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		delete(n.listeners, port)
+	})
+	n.listeners[port] = ln
+	return ln, nil
+}
+
+// forwardTCP handles a TCP connection that netstack accepted: to one of
+// n's IPs, or to an address that n routes off the tailnet.
+func (n *Node) forwardTCP(c net.Conn, port uint16) {
+	dst := c.LocalAddr().(*net.TCPAddr).AddrPort()
+	dst = netip.AddrPortFrom(dst.Addr().Unmap(), dst.Port())
+	if !n.isLocalIP(dst.Addr()) {
+		n.tn.dialExternal(n, c, dst)
+		return
+	}
+	n.mu.Lock()
+	ln := n.listeners[port]
+	n.mu.Unlock()
+	if ln == nil {
+		c.Close()
+		return
+	}
+	ln.hand(c)
+}
+
+func (n *Node) isLocalIP(ip netip.Addr) bool {
+	nm := n.lb.NetMap()
+	if nm == nil {
+		return false
+	}
+	for _, p := range nm.Addresses {
+		if p.Addr() == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve looks name up with n's MagicDNS resolver, returning its IPv4
+// addresses.
+func (n *Node) Resolve(ctx context.Context, name string) ([]netip.Addr, error) {
+	qname, err := dnsmessage.NewName(strings.TrimSuffix(name, ".") + ".")
+	if err != nil {
+		return nil, err
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 1, RecursionDesired: true})
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{Name: qname, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET})
+	q, err := b.Finish()
+	if err != nil {
+		return nil, err
+	}
+	res, err := n.dns.Query(ctx, q, netip.AddrPortFrom(n.IP(), 0))
+	if err != nil {
+		return nil, err
+	}
+
+	var p dnsmessage.Parser
+	h, err := p.Start(res)
+	if err != nil {
+		return nil, err
+	}
+	if h.RCode != dnsmessage.RCodeSuccess {
+		return nil, fmt.Errorf("resolving %q: %v", name, h.RCode)
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, err
+	}
+	var ips []netip.Addr
+	for {
+		ah, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ah.Type != dnsmessage.TypeA {
+			if err := p.SkipAnswer(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		a, err := p.AResource()
+		if err != nil {
+			return nil, err
+		}
+		ips = append(ips, netip.AddrFrom4(a.A))
+	}
+	return ips, nil
+}
+
+func (n *Node) close() {
+	if n.lb != nil {
+		n.lb.Shutdown()
+	}
+	if n.linkMon != nil {
+		n.linkMon.Close()
+	}
+	if n.dialer != nil {
+		n.dialer.Close()
+	}
+	if n.localAPI != nil {
+		n.localAPI.Close()
+	}
+	n.mu.Lock()
+	lns := make([]*listener, 0, len(n.listeners))
+	for _, ln := range n.listeners {
+		lns = append(lns, ln)
+	}
+	n.mu.Unlock()
+	for _, ln := range lns {
+		ln.Close()
+	}
+}
+
+// A listener is a net.Listener whose connections are handed to it by a
+// Node.
+type listener struct {
+	addr    net.Addr
+	conns   chan net.Conn
+	closed  chan struct{}
+	onClose func()
+	once    sync.Once
+}
+
+func newListener(addr net.Addr, onClose func()) *listener {
+	return &listener{
+		addr:    addr,
+		conns:   make(chan net.Conn),
+		closed:  make(chan struct{}),
+		onClose: onClose,
+	}
+}
+
+// hand gives c to ln's Accept, or closes c if ln isn't accepting. It
+// reports whether c was accepted.
+func (ln *listener) hand(c net.Conn) bool {
+	t := time.NewTimer(time.Second)
+	defer t.Stop()
+	select {
+	case ln.conns <- c:
+		return true
+	case <-ln.closed:
+	case <-t.C:
+	}
+	c.Close()
+	return false
+}
+
+func (ln *listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-ln.conns:
+		return c, nil
+	case <-ln.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (ln *listener) Addr() net.Addr { return ln.addr }
+
+func (ln *listener) Close() error {
+	ln.once.Do(func() {
+		close(ln.closed)
+		ln.onClose()
+	})
+	return nil
+}
+
+// A doneConn is a net.Conn that closes done when closed.
+type doneConn struct {
+	net.Conn
+	done chan struct{}
+	once sync.Once
+}
+
+func (c *doneConn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return c.Conn.Close()
+}
+
+// An Assertion is something that should be true of a running Tailnet.
+// See CanPing, CanConnect, Resolves and ServesSSH.
+type Assertion interface {
+	fmt.Stringer
+	check(context.Context, *Tailnet) error
+}
+
+// CanPing asserts that node From can disco ping node To.
+type CanPing struct {
+	From, To string
+}
+
+func (a CanPing) String() string { return fmt.Sprintf("%s can ping %s", a.From, a.To) }
+
+func (a CanPing) check(ctx context.Context, tn *Tailnet) error {
+	from, to, err := tn.pair(a.From, a.To)
+	if err != nil {
+		return err
+	}
+	pr, err := from.lb.Ping(ctx, to.IP(), tailcfg.PingDisco)
+	if err != nil {
+		return err
+	}
+	if pr.Err != "" {
+		return errors.New(pr.Err)
+	}
+	return nil
+}
+
+// CanConnect asserts that node From can make a TCP connection to To,
+// and exchange data over it.
+//
+// To is "name:port" for a port of a node, or "ip:port" for an address
+// off the tailnet, reached through a subnet router or exit node. An
+// echo server is started at To.
+type CanConnect struct {
+	From, To string
+}
+
+func (a CanConnect) String() string { return fmt.Sprintf("%s can connect to %s", a.From, a.To) }
+
+func (a CanConnect) check(ctx context.Context, tn *Tailnet) error {
+	from, ok := tn.node(a.From)
+	if !ok {
+		return fmt.Errorf("no node named %q", a.From)
+	}
+	if err := tn.echo(a.To); err != nil {
+		return err
+	}
+	dst := a.To
+	if host, port, _ := net.SplitHostPort(a.To); !isIP(host) {
+		to, _ := tn.node(host)
+		dst = net.JoinHostPort(to.IP().String(), port)
+	}
+	c, err := from.Dial(ctx, "tcp", dst)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if d, ok := ctx.Deadline(); ok {
+		c.SetDeadline(d)
+	}
+	const msg = "hello\n"
+	if _, err := io.WriteString(c, msg); err != nil {
+		return err
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(c, buf); err != nil {
+		return fmt.Errorf("reading echo: %w", err)
+	}
+	if string(buf) != msg {
+		return fmt.Errorf("echo = %q; want %q", buf, msg)
+	}
+	return nil
+}
+
+func isIP(s string) bool {
+	_, err := netip.ParseAddr(s)
+	return err == nil
+}
+
+// Resolves asserts that node From resolves the MagicDNS name of node
+// Name to Name's IP.
+type Resolves struct {
+	From, Name string
+}
+
+func (a Resolves) String() string { return fmt.Sprintf("%s resolves %s", a.From, a.Name) }
+
+func (a Resolves) check(ctx context.Context, tn *Tailnet) error {
+	from, to, err := tn.pair(a.From, a.Name)
+	if err != nil {
+		return err
+	}
+	fqdn := a.Name + "." + TailnetDomain
+	ips, err := from.Resolve(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+	for _, ip := range ips {
+		if ip == to.IP() {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s resolved to %v; want %v", fqdn, ips, to.IP())
+}
+
+// ServesSSH asserts that node From can reach the Tailscale SSH server
+// of node To.
+type ServesSSH struct {
+	From, To string
+}
+
+func (a ServesSSH) String() string { return fmt.Sprintf("%s reaches SSH on %s", a.From, a.To) }
+
+func (a ServesSSH) check(ctx context.Context, tn *Tailnet) error {
+	from, to, err := tn.pair(a.From, a.To)
+	if err != nil {
+		return err
+	}
+	c, err := from.Dial(ctx, "tcp", net.JoinHostPort(to.IP().String(), "22"))
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if d, ok := ctx.Deadline(); ok {
+		c.SetDeadline(d)
+	}
+	const banner = "SSH-2.0-"
+	buf := make([]byte, len(banner))
+	if _, err := io.ReadFull(c, buf); err != nil {
+		return fmt.Errorf("reading SSH banner: %w", err)
+	}
+	if string(buf) != banner {
+		return fmt.Errorf("got %q; want an SSH banner", buf)
+	}
+	return nil
+}
+
+// pair returns the nodes named from and to.
+func (tn *Tailnet) pair(from, to string) (_, _ *Node, err error) {
+	f, ok := tn.node(from)
+	if !ok {
+		return nil, nil, fmt.Errorf("no node named %q", from)
+	}
+	t, ok := tn.node(to)
+	if !ok {
+		return nil, nil, fmt.Errorf("no node named %q", to)
+	}
+	return f, t, nil
+}
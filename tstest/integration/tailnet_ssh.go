@@ -0,0 +1,12 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux || (darwin && !ios)
+// +build linux darwin,!ios
+
+package integration
+
+// Register the Tailscale SSH server, which Tailnet nodes run if their
+// NodeSpec asks for it.
+import _ "tailscale.com/ssh/tailssh"
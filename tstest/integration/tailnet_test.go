@@ -0,0 +1,85 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package integration
+
+import (
+	"context"
+	"net/netip"
+	"runtime"
+	"testing"
+)
+
+func TestTailnetBasic(t *testing.T) {
+	RunTailnet(t, TailnetSpec{
+		Nodes: []NodeSpec{{Name: "a"}, {Name: "b"}},
+		Assertions: []Assertion{
+			CanPing{From: "a", To: "b"},
+			CanPing{From: "b", To: "a"},
+			CanConnect{From: "a", To: "b:8080"},
+			CanConnect{From: "b", To: "a:8080"},
+			Resolves{From: "a", Name: "b"},
+			Resolves{From: "b", Name: "a"},
+		},
+	})
+}
+
+func TestTailnetSubnetRouter(t *testing.T) {
+	RunTailnet(t, TailnetSpec{
+		Nodes: []NodeSpec{
+			{Name: "client"},
+			{Name: "router", AdvertiseRoutes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")}},
+		},
+		Assertions: []Assertion{
+			CanConnect{From: "client", To: "10.0.0.5:80"},
+		},
+	})
+}
+
+func TestTailnetExitNode(t *testing.T) {
+	RunTailnet(t, TailnetSpec{
+		Nodes: []NodeSpec{
+			{Name: "client", ExitNode: "exit"},
+			{Name: "exit", AdvertiseExitNode: true},
+		},
+		Assertions: []Assertion{
+			CanConnect{From: "client", To: "203.0.113.1:443"},
+		},
+	})
+}
+
+func TestTailnetTags(t *testing.T) {
+	tn := StartTailnet(t, TailnetSpec{
+		Nodes: []NodeSpec{
+			{Name: "server", Tags: []string{"tag:server"}},
+			{Name: "client"},
+		},
+	})
+	st, err := tn.Node("server").LocalClient().Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := tn.Control.Node(st.Self.PublicKey)
+	if n == nil {
+		t.Fatal("control doesn't know the server")
+	}
+	if len(n.Tags) != 1 || n.Tags[0] != "tag:server" {
+		t.Errorf("server's tags = %q; want [tag:server]", n.Tags)
+	}
+}
+
+func TestTailnetSSH(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Tailscale SSH is only tested on Linux")
+	}
+	RunTailnet(t, TailnetSpec{
+		Nodes: []NodeSpec{
+			{Name: "client"},
+			{Name: "server", SSH: true},
+		},
+		Assertions: []Assertion{
+			ServesSSH{From: "client", To: "server"},
+		},
+	})
+}
@@ -47,6 +47,18 @@ type Server struct {
 	Verbose     bool
 	DNSConfig   *tailcfg.DNSConfig // nil means no DNS config
 
+	// MagicDNSDomain, if non-empty, is the domain under which nodes
+	// are named after their hostnames, for MagicDNS.
+	MagicDNSDomain string
+
+	// SSHPolicy, if non-nil, is sent to all nodes, which are then
+	// given the SSH capability.
+	SSHPolicy *tailcfg.SSHPolicy
+
+	// AutoApprove, if true, approves the routes and tags that nodes
+	// advertise in their Hostinfo. Otherwise they're ignored.
+	AutoApprove bool
+
 	// ExplicitBaseURL or HTTPTestServer must be set.
 	ExplicitBaseURL string           // e.g. "http://127.0.0.1:1234" with no trailing URL
 	HTTPTestServer  *httptest.Server // if non-nil, used to get BaseURL
@@ -494,6 +506,7 @@ func (s *Server) serveRegister(w http.ResponseWriter, r *http.Request, mkey key.
 		AllowedIPs:        allowedIPs,
 		Hostinfo:          req.Hostinfo.View(),
 	}
+	s.applyHostinfo(s.nodes[nk])
 	requireAuth := s.RequireAuth
 	if requireAuth && s.nodeKeyAuthed[nk] {
 		requireAuth = false
@@ -635,6 +648,7 @@ func (s *Server) serveMap(w http.ResponseWriter, r *http.Request, mkey key.Machi
 					node.DERP = fmt.Sprintf("127.3.3.40:%d", ni.PreferredDERP())
 				}
 			}
+			s.applyHostinfo(node)
 		}
 		peersToUpdate = s.UpdateNode(node)
 	}
@@ -722,6 +736,40 @@ func (s *Server) serveMap(w http.ResponseWriter, r *http.Request, mkey key.Machi
 	}
 }
 
+// applyHostinfo updates n's name from its Hostinfo and, if
+// s.AutoApprove is set, its tags and routes, approving all that it
+// asks for.
+func (s *Server) applyHostinfo(n *tailcfg.Node) {
+	hi := n.Hostinfo
+	if !hi.Valid() {
+		return
+	}
+	if s.MagicDNSDomain != "" && hi.Hostname() != "" {
+		n.Name = hi.Hostname() + "." + s.MagicDNSDomain + "."
+	}
+	if !s.AutoApprove {
+		return
+	}
+	n.Tags = hi.RequestTags().AsSlice()
+	routes := s.approvedRoutes(hi)
+	n.PrimaryRoutes = nil
+	for _, r := range routes {
+		if r.Bits() != 0 { // exit node routes aren't primary routes
+			n.PrimaryRoutes = append(n.PrimaryRoutes, r)
+		}
+	}
+	n.AllowedIPs = append(n.Addresses[:len(n.Addresses):len(n.Addresses)], routes...)
+}
+
+// approvedRoutes returns the routes of the node with hi that are
+// approved: those it advertises if s.AutoApprove is set, otherwise none.
+func (s *Server) approvedRoutes(hi tailcfg.HostinfoView) []netip.Prefix {
+	if !s.AutoApprove || !hi.Valid() {
+		return nil
+	}
+	return hi.RoutableIPs().AsSlice()
+}
+
 var keepAliveMsg = &struct {
 	KeepAlive bool
 }{
@@ -768,7 +816,11 @@ func (s *Server) MapResponse(req *tailcfg.MapRequest) (res *tailcfg.MapResponse,
 		v4Prefix,
 		v6Prefix,
 	}
-	res.Node.AllowedIPs = res.Node.Addresses
+	res.Node.AllowedIPs = append(res.Node.Addresses[:len(res.Node.Addresses):len(res.Node.Addresses)], s.approvedRoutes(res.Node.Hostinfo)...)
+	if s.SSHPolicy != nil {
+		res.SSHPolicy = s.SSHPolicy
+		res.Node.Capabilities = append(res.Node.Capabilities, tailcfg.CapabilitySSH)
+	}
 
 	// Consume the PingRequest while protected by mutex if it exists
 	s.mu.Lock()
@@ -0,0 +1,156 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package natlab
+
+import (
+	"crypto/ed25519"
+	crand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"tailscale.com/derp"
+	"tailscale.com/derp/derphttp"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/logger"
+)
+
+// derpHostName is the host name of DERPServers' nodes in their DERP
+// maps, and of their TLS certificates.
+const derpHostName = "derp.natlab.invalid"
+
+// A DERPServer is a DERP server with a STUN server alongside it, as
+// production DERP nodes have. Both run on a natlab Machine, so DERP
+// and STUN traffic crosses the simulated network's NATs and
+// firewalls.
+//
+// DERP is served over HTTPS with a self-signed certificate, which
+// clients accept because the node is marked InsecureForTests in
+// DERPMap. Clients must dial through the simulated network; NewClient
+// returns one that does.
+type DERPServer struct {
+	STUN *STUNServer
+
+	// Addr is the address that DERP is served on.
+	Addr netip.AddrPort
+
+	d  *derp.Server
+	hs *http.Server
+}
+
+// NewDERPServer starts a DERP server on port 443 of m's first IPv4
+// address, with a STUN server on its default port.
+func NewDERPServer(logf logger.Logf, m *Machine) (*DERPServer, error) {
+	ip := m.firstIPv4()
+	if !ip.IsValid() {
+		return nil, errors.New("machine has no IPv4 address")
+	}
+	cert, err := selfSignedCert(ip)
+	if err != nil {
+		return nil, err
+	}
+	addr := netip.AddrPortFrom(ip, 443)
+	ln, err := m.Listen("tcp4", addr.String())
+	if err != nil {
+		return nil, err
+	}
+	stun, err := NewSTUNServer(m, 0)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	d := derp.NewServer(key.NewNode(), logf)
+	hs := &http.Server{
+		Handler:  derphttp.Handler(d),
+		ErrorLog: logger.StdLogger(logf),
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		},
+		// Disable HTTP/2; DERP upgrades HTTP/1.1 connections.
+		TLSNextProto: make(map[string]func(*http.Server, *tls.Conn, http.Handler)),
+	}
+	go hs.Serve(tls.NewListener(ln, hs.TLSConfig))
+	return &DERPServer{
+		STUN: stun,
+		Addr: addr,
+		d:    d,
+		hs:   hs,
+	}, nil
+}
+
+// selfSignedCert returns a self-signed TLS certificate for
+// derpHostName and ip.
+func selfSignedCert(ip netip.Addr) (tls.Certificate, error) {
+	pub, priv, err := ed25519.GenerateKey(crand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: derpHostName},
+		DNSNames:     []string{derpHostName},
+		IPAddresses:  []net.IP{ip.AsSlice()},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(crand.Reader, tmpl, tmpl, pub, priv)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  priv,
+	}, nil
+}
+
+// DERPMap returns a DERP map with a single region, whose node is s.
+func (s *DERPServer) DERPMap() *tailcfg.DERPMap {
+	return &tailcfg.DERPMap{
+		Regions: map[int]*tailcfg.DERPRegion{
+			1: {
+				RegionID:   1,
+				RegionCode: "natlab",
+				Nodes: []*tailcfg.DERPNode{
+					{
+						Name:             "1a",
+						RegionID:         1,
+						HostName:         derpHostName,
+						IPv4:             s.Addr.Addr().String(),
+						IPv6:             "none",
+						STUNPort:         int(s.STUN.Addr.Port()),
+						DERPPort:         int(s.Addr.Port()),
+						InsecureForTests: true,
+						STUNTestIP:       s.STUN.Addr.Addr().String(),
+					},
+				},
+			},
+		},
+	}
+}
+
+// NewClient returns a DERP client with the given private key that
+// connects to s from m, through the simulated network.
+func (s *DERPServer) NewClient(privateKey key.NodePrivate, logf logger.Logf, m *Machine) *derphttp.Client {
+	reg := s.DERPMap().Regions[1]
+	c := derphttp.NewRegionClient(privateKey, logf, func() *tailcfg.DERPRegion { return reg })
+	c.SetURLDialer(m.Dial)
+	return c
+}
+
+// Close stops the DERP and STUN servers.
+func (s *DERPServer) Close() error {
+	s.hs.Close()
+	s.d.Close()
+	return s.STUN.Close()
+}
@@ -9,6 +9,8 @@
 	"net/netip"
 	"sync"
 	"time"
+
+	"tailscale.com/types/ipproto"
 )
 
 // FirewallType is the type of filtering a stateful firewall
@@ -36,18 +38,19 @@
 // some fields, so in practice the key is either a 2-tuple (src only),
 // 3-tuple (src ip+port and dst ip) or 4-tuple (src+dst ip+port).
 type fwKey struct {
-	src netip.AddrPort
-	dst netip.AddrPort
+	proto ipproto.Proto
+	src   netip.AddrPort
+	dst   netip.AddrPort
 }
 
-// key returns an fwKey for the given src and dst, trimmed according
-// to the FirewallType. fwKeys are always constructed from the
+// key returns an fwKey for the given proto, src and dst, trimmed
+// according to the FirewallType. fwKeys are always constructed from the
 // "outbound" point of view (i.e. src is the "trusted" side of the
 // world), it's the caller's responsibility to swap src and dst in the
 // call to key when processing packets inbound from the "untrusted"
 // world.
-func (s FirewallType) key(src, dst netip.AddrPort) fwKey {
-	k := fwKey{src: src}
+func (s FirewallType) key(proto ipproto.Proto, src, dst netip.AddrPort) fwKey {
+	k := fwKey{proto: proto, src: src}
 	switch s {
 	case EndpointIndependentFirewall:
 	case AddressDependentFirewall:
@@ -111,20 +114,24 @@ func (f *Firewall) HandleOut(p *Packet, oif *Interface) *Packet {
 	defer f.mu.Unlock()
 	f.init()
 
-	k := f.Type.key(p.Src, p.Dst)
+	k := f.Type.key(p.proto(), p.Src, p.Dst)
 	f.seen[k] = f.timeNow().Add(f.sessionTimeoutLocked())
 	p.Trace("firewall out ok")
 	return p
 }
 
 func (f *Firewall) HandleIn(p *Packet, iif *Interface) *Packet {
+	if iif == f.TrustedInterface {
+		p.Trace("firewall in ok, trusted interface")
+		return p
+	}
 	f.mu.Lock()
 	defer f.mu.Unlock()
 	f.init()
 
 	// reverse src and dst because the session table is from the POV
 	// of outbound packets.
-	k := f.Type.key(p.Dst, p.Src)
+	k := f.Type.key(p.proto(), p.Dst, p.Src)
 	now := f.timeNow()
 	if now.After(f.seen[k]) {
 		p.Trace("firewall drop")
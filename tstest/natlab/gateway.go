@@ -0,0 +1,413 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package natlab
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Well-known ports of the port mapping protocols.
+const (
+	PxPPort      = 5351 // NAT-PMP and PCP
+	SSDPPort     = 1900 // UPnP discovery
+	UPnPHTTPPort = 5000 // UPnP description and control
+)
+
+// defaultUPnPLease is the lifetime of UPnP port mappings requested
+// with a zero (that is, infinite) lease duration.
+const defaultUPnPLease = 7 * 24 * time.Hour
+
+// A Gateway is a home router's port mapping services: it answers
+// NAT-PMP, PCP and UPnP requests from its LAN by adding port mappings
+// to its NAT.
+//
+// The UPnP description and control endpoints, which UPnP discovery
+// replies point clients to, are served by Gateway's ServeHTTP over
+// natlab TCP. Tests can also serve ServeHTTP themselves, to reach it
+// from outside the simulated network.
+type Gateway struct {
+	// NAT is the NAT that port mappings are added to.
+	NAT *SNAT44
+	// LANInterface is the interface of NAT.Machine that the services
+	// listen on.
+	LANInterface *Interface
+
+	// PMP, PCP and UPnP are which protocols the gateway speaks.
+	PMP, PCP, UPnP bool
+
+	start time.Time
+	pcs   []net.PacketConn
+	srv   *http.Server // UPnP description and control
+	wg    sync.WaitGroup
+}
+
+// Start starts g's services.
+func (g *Gateway) Start() error {
+	g.start = time.Now()
+	ip := g.LANInterface.V4()
+	if g.PMP || g.PCP {
+		if err := g.listen(netip.AddrPortFrom(ip, PxPPort), g.servePxP); err != nil {
+			return err
+		}
+	}
+	if g.UPnP {
+		if err := g.listen(netip.AddrPortFrom(ip, SSDPPort), g.serveSSDP); err != nil {
+			g.Close()
+			return err
+		}
+		ln, err := g.NAT.Machine.Listen("tcp4", netip.AddrPortFrom(ip, UPnPHTTPPort).String())
+		if err != nil {
+			g.Close()
+			return err
+		}
+		srv := &http.Server{Handler: g}
+		g.srv = srv
+		g.wg.Add(1)
+		go func() {
+			defer g.wg.Done()
+			srv.Serve(ln)
+		}()
+	}
+	return nil
+}
+
+func (g *Gateway) listen(addr netip.AddrPort, serve func(net.PacketConn)) error {
+	pc, err := g.NAT.Machine.ListenPacket(context.Background(), "udp4", addr.String())
+	if err != nil {
+		return err
+	}
+	g.pcs = append(g.pcs, pc)
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		serve(pc)
+	}()
+	return nil
+}
+
+// Close stops g's services. Port mappings that were made stay until
+// they expire.
+func (g *Gateway) Close() error {
+	for _, pc := range g.pcs {
+		pc.Close()
+	}
+	if g.srv != nil {
+		g.srv.Close()
+	}
+	g.wg.Wait()
+	g.pcs = nil
+	g.srv = nil
+	return nil
+}
+
+// epoch returns the seconds since g started, which NAT-PMP and PCP
+// servers report so that clients can detect restarts.
+func (g *Gateway) epoch() uint32 {
+	return uint32(time.Since(g.start) / time.Second)
+}
+
+func (g *Gateway) servePxP(pc net.PacketConn) {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		src := addr.(*net.UDPAddr).AddrPort()
+		var res []byte
+		switch pkt := buf[:n]; {
+		case len(pkt) < 2:
+		case pkt[0] == 0 && g.PMP:
+			res = g.handlePMP(pkt, src)
+		case pkt[0] == 2 && g.PCP:
+			res = g.handlePCP(pkt, src)
+		}
+		if res != nil {
+			pc.WriteTo(res, addr)
+		}
+	}
+}
+
+// handlePMP returns the response to the NAT-PMP request pkt, or nil if
+// there is none. See RFC 6886.
+func (g *Gateway) handlePMP(pkt []byte, src netip.AddrPort) []byte {
+	const opReply = 0x80
+	switch op := pkt[1]; op {
+	case 0: // external address
+		res := make([]byte, 12)
+		res[1] = opReply | op
+		binary.BigEndian.PutUint32(res[4:], g.epoch())
+		ip := g.NAT.ExternalInterface.V4().As4()
+		copy(res[8:], ip[:])
+		return res
+	case 1: // map UDP
+		if len(pkt) != 12 {
+			return nil
+		}
+		lanSrc := netip.AddrPortFrom(src.Addr(), binary.BigEndian.Uint16(pkt[4:]))
+		wanPort := binary.BigEndian.Uint16(pkt[6:])
+		lifetime := binary.BigEndian.Uint32(pkt[8:])
+		res := make([]byte, 16)
+		res[1] = opReply | op
+		binary.BigEndian.PutUint32(res[4:], g.epoch())
+		binary.BigEndian.PutUint16(res[8:], lanSrc.Port())
+		if lifetime == 0 {
+			g.NAT.DeletePortMapping(lanSrc)
+			return res
+		}
+		wan, err := g.NAT.AddPortMapping(lanSrc, wanPort, time.Duration(lifetime)*time.Second)
+		if err != nil {
+			binary.BigEndian.PutUint16(res[2:], 4) // out of resources
+			return res
+		}
+		binary.BigEndian.PutUint16(res[10:], wan.Port())
+		binary.BigEndian.PutUint32(res[12:], lifetime)
+		return res
+	}
+	return nil
+}
+
+// handlePCP returns the response to the PCP request pkt, or nil if
+// there is none. See RFC 6887.
+func (g *Gateway) handlePCP(pkt []byte, src netip.AddrPort) []byte {
+	const (
+		opReply         = 0x80
+		opAnnounce      = 0
+		opMap           = 1
+		addressMismatch = 12
+		noResources     = 8
+	)
+	if len(pkt) < 24 {
+		return nil
+	}
+	op := pkt[1]
+	var res []byte
+	switch op {
+	case opAnnounce:
+		res = make([]byte, 24)
+	case opMap:
+		if len(pkt) < 60 {
+			return nil
+		}
+		res = make([]byte, 60)
+		req, mapRes := pkt[24:], res[24:]
+		copy(mapRes[:13], req[:13]) // nonce and protocol
+		copy(mapRes[16:18], req[16:18])
+
+		client := netip.AddrFrom16(*(*[16]byte)(pkt[8:24])).Unmap()
+		if client != src.Addr() {
+			res[3] = addressMismatch
+			break
+		}
+		lanSrc := netip.AddrPortFrom(client, binary.BigEndian.Uint16(req[16:]))
+		lifetime := binary.BigEndian.Uint32(pkt[4:])
+		if lifetime == 0 {
+			g.NAT.DeletePortMapping(lanSrc)
+			break
+		}
+		wan, err := g.NAT.AddPortMapping(lanSrc, binary.BigEndian.Uint16(req[18:]), time.Duration(lifetime)*time.Second)
+		if err != nil {
+			res[3] = noResources
+			break
+		}
+		binary.BigEndian.PutUint32(res[4:], lifetime)
+		binary.BigEndian.PutUint16(mapRes[18:], wan.Port())
+		ip := netip.AddrFrom4(wan.Addr().As4()).As16()
+		copy(mapRes[20:], ip[:])
+	default:
+		return nil
+	}
+	res[0] = 2
+	res[1] = opReply | op
+	binary.BigEndian.PutUint32(res[8:], g.epoch())
+	return res
+}
+
+func (g *Gateway) serveSSDP(pc net.PacketConn) {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if !bytes.HasPrefix(buf[:n], []byte("M-SEARCH ")) {
+			continue
+		}
+		res := fmt.Sprintf("HTTP/1.1 200 OK\r\n"+
+			"CACHE-CONTROL: max-age=120\r\n"+
+			"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n"+
+			"USN: uuid:natlab-gateway::urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n"+
+			"EXT:\r\n"+
+			"SERVER: natlab UPnP/1.1\r\n"+
+			"LOCATION: http://%s/rootDesc.xml\r\n\r\n",
+			netip.AddrPortFrom(g.LANInterface.V4(), UPnPHTTPPort))
+		pc.WriteTo([]byte(res), addr)
+	}
+}
+
+const upnpRootDesc = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+<specVersion><major>1</major><minor>0</minor></specVersion>
+<device>
+<deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+<friendlyName>natlab gateway</friendlyName>
+<manufacturer>natlab</manufacturer>
+<deviceList><device>
+<deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+<deviceList><device>
+<deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+<serviceList><service>
+<serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+<serviceId>urn:upnp-org:serviceId:WANIPConn1</serviceId>
+<controlURL>/ctl/IPConn</controlURL>
+</service></serviceList>
+</device></deviceList>
+</device></deviceList>
+</device>
+</root>
+`
+
+const upnpWANIPConnection = "urn:schemas-upnp-org:service:WANIPConnection:1"
+
+// ServeHTTP serves the UPnP description and control endpoints. Only
+// the WANIPConnection:1 actions that port mapping clients use are
+// supported.
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/rootDesc.xml":
+		w.Header().Set("Content-Type", "text/xml")
+		io.WriteString(w, upnpRootDesc)
+	case r.Method == "POST" && r.URL.Path == "/ctl/IPConn":
+		action := strings.Trim(r.Header.Get("SOAPAction"), `"`)
+		ns, name, _ := strings.Cut(action, "#")
+		if ns != upnpWANIPConnection {
+			http.Error(w, "unknown service", http.StatusBadRequest)
+			return
+		}
+		args, err := parseSOAPArgs(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		res, upnpErr := g.upnpAction(name, args)
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		if upnpErr != 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, soapFault, upnpErr)
+			return
+		}
+		var body strings.Builder
+		for _, kv := range res {
+			fmt.Fprintf(&body, "<%s>", kv[0])
+			xml.EscapeText(&body, []byte(kv[1]))
+			fmt.Fprintf(&body, "</%s>", kv[0])
+		}
+		fmt.Fprintf(w, soapResponse, name, upnpWANIPConnection, body.String(), name)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+const (
+	soapResponse = xml.Header + `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>` +
+		`<u:%sResponse xmlns:u="%s">%s</u:%sResponse></s:Body></s:Envelope>`
+	soapFault = xml.Header + `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>` +
+		`<s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail>` +
+		`<UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode></UPnPError>` +
+		`</detail></s:Fault></s:Body></s:Envelope>`
+)
+
+// UPnP error codes, from the WANIPConnection:1 spec.
+const (
+	upnpInvalidAction     = 401
+	upnpInvalidArgs       = 402
+	upnpNoSuchEntry       = 714
+	upnpConflictInMapping = 718
+)
+
+// upnpAction runs the WANIPConnection action name, returning its output
+// arguments or a UPnP error code.
+func (g *Gateway) upnpAction(name string, args map[string]string) (res [][2]string, upnpErr int) {
+	switch name {
+	case "GetExternalIPAddress":
+		return [][2]string{{"NewExternalIPAddress", g.NAT.ExternalInterface.V4().String()}}, 0
+	case "AddPortMapping":
+		wanPort, err1 := strconv.ParseUint(args["NewExternalPort"], 10, 16)
+		lanPort, err2 := strconv.ParseUint(args["NewInternalPort"], 10, 16)
+		lanIP, err3 := netip.ParseAddr(args["NewInternalClient"])
+		lease, err4 := strconv.ParseUint(args["NewLeaseDuration"], 10, 32)
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil || wanPort == 0 || !strings.EqualFold(args["NewProtocol"], "UDP") {
+			return nil, upnpInvalidArgs
+		}
+		lifetime := time.Duration(lease) * time.Second
+		if lifetime == 0 {
+			lifetime = defaultUPnPLease
+		}
+		lanSrc := netip.AddrPortFrom(lanIP, uint16(lanPort))
+		if cur, ok := g.NAT.portMappingOf(uint16(wanPort)); !ok {
+			// Replace any mapping of lanSrc to another port.
+			g.NAT.DeletePortMapping(lanSrc)
+		} else if cur != lanSrc {
+			return nil, upnpConflictInMapping
+		}
+		wan, err := g.NAT.AddPortMapping(lanSrc, uint16(wanPort), lifetime)
+		if err != nil {
+			return nil, upnpConflictInMapping
+		}
+		if wan.Port() != uint16(wanPort) {
+			// The port is in use by another NAT session.
+			g.NAT.DeletePortMapping(lanSrc)
+			return nil, upnpConflictInMapping
+		}
+		return nil, 0
+	case "DeletePortMapping":
+		wanPort, err := strconv.ParseUint(args["NewExternalPort"], 10, 16)
+		if err != nil {
+			return nil, upnpInvalidArgs
+		}
+		lanSrc, ok := g.NAT.portMappingOf(uint16(wanPort))
+		if !ok {
+			return nil, upnpNoSuchEntry
+		}
+		g.NAT.DeletePortMapping(lanSrc)
+		return nil, 0
+	}
+	return nil, upnpInvalidAction
+}
+
+// parseSOAPArgs returns the arguments of the action in the SOAP
+// envelope read from r.
+func parseSOAPArgs(r io.Reader) (map[string]string, error) {
+	var env struct {
+		Body struct {
+			Action struct {
+				Args []struct {
+					XMLName xml.Name
+					Value   string `xml:",chardata"`
+				} `xml:",any"`
+			} `xml:",any"`
+		}
+	}
+	if err := xml.NewDecoder(r).Decode(&env); err != nil {
+		return nil, err
+	}
+	args := map[string]string{}
+	for _, a := range env.Body.Action.Args {
+		args[a.XMLName.Local] = a.Value
+	}
+	return args, nil
+}
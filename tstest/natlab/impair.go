@@ -0,0 +1,254 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package natlab
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"tailscale.com/types/ipproto"
+)
+
+// An Impairment describes how a Network degrades the packets that cross
+// it. The zero value is a perfect network, which delivers packets
+// immediately and in order.
+//
+// Impairments apply to packets as they cross the Network, after the
+// sending Machine's PacketHandler has run and before the receiving
+// one's does, so they compose with NATs and firewalls.
+//
+// Fields must not be changed once packets have crossed the Network.
+type Impairment struct {
+	// Latency is the one-way delay of packets.
+	Latency time.Duration
+	// Jitter is the maximum random delay added to Latency, uniformly
+	// distributed.
+	Jitter time.Duration
+	// Delay, if non-nil, returns the one-way delay of a packet,
+	// replacing Latency and Jitter. It's for latency distributions
+	// other than uniform. It's called with the Impairment's lock
+	// held, and r must not be retained.
+	Delay func(r *rand.Rand) time.Duration
+
+	// Loss is the probability, from 0 to 1, that a packet is dropped.
+	Loss float64
+
+	// Reorder is the probability, from 0 to 1, that a packet is held
+	// back by ReorderDelay, letting later packets overtake it.
+	Reorder float64
+	// ReorderDelay is how long reordered packets are held back. If
+	// zero, DefaultReorderDelay is used.
+	ReorderDelay time.Duration
+
+	// Bandwidth is the network's capacity in bytes per second, counting
+	// IP, UDP and TCP headers. Packets queue for it in order. If zero, the
+	// bandwidth is unlimited.
+	Bandwidth int
+	// MaxQueueDelay is how long a packet may queue for bandwidth
+	// before it's dropped instead. If zero, DefaultMaxQueueDelay is
+	// used.
+	MaxQueueDelay time.Duration
+
+	// MTU is the largest IP packet that the network carries. Larger
+	// packets are dropped, and their sending Machine learns the
+	// network's MTU as its path MTU to the destination, as if from an
+	// ICMP "packet too big" message: later writes of packets too big
+	// for the path fail with EMSGSIZE. If zero, packets of any size
+	// are carried.
+	MTU int
+
+	// Clock schedules the delivery of packets. If nil, real time is
+	// used. With a ManualClock, packets are only delivered when it's
+	// advanced, even if they aren't delayed.
+	Clock Clock
+	// Rand is the source of randomness for delays and losses, which
+	// tests can seed to make impairments reproducible. If nil, the
+	// math/rand global source is used.
+	Rand *rand.Rand
+
+	mu        sync.Mutex
+	busyUntil time.Time // when the queued packets will have been sent
+}
+
+// Defaults of Impairment fields.
+const (
+	DefaultReorderDelay  = 10 * time.Millisecond
+	DefaultMaxQueueDelay = 100 * time.Millisecond
+)
+
+// ipOverhead returns the size of p's headers that aren't in its
+// Payload: the IP header, and the UDP header if it's a UDP packet.
+func ipOverhead(p *Packet) int {
+	n := 40
+	if p.Dst.Addr().Is4() {
+		n = 20
+	}
+	if p.proto() == ipproto.UDP {
+		n += 8
+	}
+	return n
+}
+
+func (imp *Impairment) clock() Clock {
+	if imp.Clock != nil {
+		return imp.Clock
+	}
+	return realClock{}
+}
+
+func (imp *Impairment) float64() float64 {
+	if imp.Rand != nil {
+		return imp.Rand.Float64()
+	}
+	return rand.Float64()
+}
+
+func (imp *Impairment) int63n(n int64) int64 {
+	if imp.Rand != nil {
+		return imp.Rand.Int63n(n)
+	}
+	return rand.Int63n(n)
+}
+
+// apply decides the fate of p crossing the network, returning how long
+// it takes to cross, or false if it's dropped.
+func (imp *Impairment) apply(p *Packet) (delay time.Duration, ok bool) {
+	size := len(p.Payload) + ipOverhead(p)
+	if imp.MTU > 0 && size > imp.MTU {
+		p.Trace("drop, %d bytes exceeds MTU %d", size, imp.MTU)
+		if p.origin != nil {
+			p.origin.notePathMTU(p.origDst.Addr(), imp.MTU)
+		}
+		return 0, false
+	}
+
+	imp.mu.Lock()
+	defer imp.mu.Unlock()
+
+	if imp.Loss > 0 && imp.float64() < imp.Loss {
+		p.Trace("drop, lost")
+		return 0, false
+	}
+
+	if imp.Bandwidth > 0 {
+		now := imp.clock().Now()
+		start := imp.busyUntil
+		if start.Before(now) {
+			start = now
+		}
+		maxQueue := imp.MaxQueueDelay
+		if maxQueue == 0 {
+			maxQueue = DefaultMaxQueueDelay
+		}
+		if start.Sub(now) > maxQueue {
+			p.Trace("drop, queue full")
+			return 0, false
+		}
+		imp.busyUntil = start.Add(time.Duration(size) * time.Second / time.Duration(imp.Bandwidth))
+		delay = imp.busyUntil.Sub(now)
+	}
+
+	switch {
+	case imp.Delay != nil:
+		r := imp.Rand
+		if r == nil {
+			r = rand.New(rand.NewSource(rand.Int63()))
+		}
+		delay += imp.Delay(r)
+	default:
+		delay += imp.Latency
+		if imp.Jitter > 0 {
+			delay += time.Duration(imp.int63n(int64(imp.Jitter)))
+		}
+	}
+
+	if imp.Reorder > 0 && imp.float64() < imp.Reorder {
+		p.Trace("reordered")
+		if imp.ReorderDelay == 0 {
+			delay += DefaultReorderDelay
+		} else {
+			delay += imp.ReorderDelay
+		}
+	}
+	return delay, true
+}
+
+// A Clock tells the time and runs functions after delays. Impairments
+// use one to deliver delayed packets.
+type Clock interface {
+	Now() time.Time
+	// AfterFunc arranges for f to be called after d.
+	AfterFunc(d time.Duration, f func())
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                      { return time.Now() }
+func (realClock) AfterFunc(d time.Duration, f func()) { time.AfterFunc(d, f) }
+
+// A ManualClock is a Clock whose time only moves when Advance is
+// called. Tests use it to control when delayed packets are delivered.
+//
+// Its Now method can be used as the TimeNow of SNAT44 and Firewall.
+type ManualClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	seq    int // of the last scheduled func
+	timers []manualTimer
+}
+
+type manualTimer struct {
+	when time.Time
+	seq  int // order of scheduling, to run funcs due at once in order
+	f    func()
+}
+
+// NewManualClock returns a ManualClock whose time starts at start.
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+// Now returns c's current time.
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// AfterFunc schedules f to run when c is advanced by d or more.
+func (c *ManualClock) AfterFunc(d time.Duration, f func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	c.timers = append(c.timers, manualTimer{c.now.Add(d), c.seq, f})
+	sort.Slice(c.timers, func(i, j int) bool {
+		ti, tj := c.timers[i], c.timers[j]
+		if !ti.when.Equal(tj.when) {
+			return ti.when.Before(tj.when)
+		}
+		return ti.seq < tj.seq
+	})
+}
+
+// Advance moves c's time forward by d, running the funcs that come due,
+// in order, before returning. While each func runs, Now returns the
+// time at which it was due, so that funcs it schedules are relative to
+// that. Funcs that are due run in the calling goroutine, not their own.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	for len(c.timers) > 0 && !c.timers[0].when.After(end) {
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		c.now = t.when
+		c.mu.Unlock()
+		t.f()
+		c.mu.Lock()
+	}
+	c.now = end
+	c.mu.Unlock()
+}
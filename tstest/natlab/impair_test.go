@@ -0,0 +1,171 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package natlab
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net"
+	"net/netip"
+	"sort"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// impairedPair is two machines on a network with imp.
+type impairedPair struct {
+	clock *ManualClock
+	a, b  net.PacketConn
+	bAddr *net.UDPAddr
+}
+
+func newImpairedPair(t *testing.T, imp *Impairment) *impairedPair {
+	clock := NewManualClock(time.Unix(1e9, 0))
+	imp.Clock = clock
+	imp.Rand = rand.New(rand.NewSource(1))
+	n := NewInternet()
+	n.Impairment = imp
+	ma, mb := &Machine{Name: "a"}, &Machine{Name: "b"}
+	aIP := ma.Attach("eth0", n).V4()
+	bIP := mb.Attach("eth0", n).V4()
+	ctx := context.Background()
+	a, err := ma.ListenPacket(ctx, "udp4", netip.AddrPortFrom(aIP, 1).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { a.Close() })
+	b, err := mb.ListenPacket(ctx, "udp4", netip.AddrPortFrom(bIP, 2).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return &impairedPair{
+		clock: clock,
+		a:     a,
+		b:     b,
+		bAddr: net.UDPAddrFromAddrPort(netip.AddrPortFrom(bIP, 2)),
+	}
+}
+
+// send sends n packets of size bytes from a to b, numbered from 0.
+func (p *impairedPair) send(t *testing.T, n, size int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		pkt := make([]byte, size)
+		binary.BigEndian.PutUint32(pkt, uint32(i))
+		if _, err := p.a.WriteTo(pkt, p.bAddr); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// received returns the numbers of the packets that b has received.
+func (p *impairedPair) received(t *testing.T) []int {
+	t.Helper()
+	var got []int
+	buf := make([]byte, 2000)
+	for {
+		// Delivered packets are already queued, so don't wait long.
+		p.b.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+		_, _, err := p.b.ReadFrom(buf)
+		if err != nil {
+			return got
+		}
+		got = append(got, int(binary.BigEndian.Uint32(buf)))
+	}
+}
+
+func TestImpairmentLatency(t *testing.T) {
+	p := newImpairedPair(t, &Impairment{Latency: 50 * time.Millisecond})
+	p.send(t, 2, 10)
+	p.clock.Advance(49 * time.Millisecond)
+	if got := p.received(t); len(got) != 0 {
+		t.Fatalf("received %v before latency elapsed", got)
+	}
+	p.clock.Advance(time.Millisecond)
+	if got := p.received(t); len(got) != 2 {
+		t.Fatalf("received %v; want 2 packets", got)
+	}
+}
+
+func TestImpairmentJitter(t *testing.T) {
+	p := newImpairedPair(t, &Impairment{Latency: 50 * time.Millisecond, Jitter: 50 * time.Millisecond})
+	p.send(t, 100, 10)
+	p.clock.Advance(75 * time.Millisecond)
+	if got := len(p.received(t)); got < 25 || got > 75 {
+		t.Errorf("received %d of 100 packets halfway through the jitter; want about 50", got)
+	}
+	p.clock.Advance(25 * time.Millisecond)
+	p.received(t) // the rest
+	p.clock.Advance(time.Hour)
+	if got := p.received(t); len(got) != 0 {
+		t.Errorf("received %d packets after the maximum delay", len(got))
+	}
+}
+
+func TestImpairmentLoss(t *testing.T) {
+	// Send no more packets than fit in b's receive queue.
+	p := newImpairedPair(t, &Impairment{Loss: 0.3})
+	p.send(t, 100, 10)
+	p.clock.Advance(0)
+	if got := len(p.received(t)); got < 55 || got > 85 {
+		t.Errorf("received %d of 100 packets; want about 70", got)
+	}
+}
+
+func TestImpairmentReorder(t *testing.T) {
+	p := newImpairedPair(t, &Impairment{Latency: time.Millisecond, Reorder: 0.2})
+	p.send(t, 100, 10)
+	p.clock.Advance(time.Second)
+	got := p.received(t)
+	if len(got) != 100 {
+		t.Fatalf("received %d of 100 packets", len(got))
+	}
+	if sort.IntsAreSorted(got) {
+		t.Error("packets were not reordered")
+	}
+}
+
+func TestImpairmentBandwidth(t *testing.T) {
+	// 100 byte packets take 100ms each, so three fit in the queue.
+	p := newImpairedPair(t, &Impairment{Bandwidth: 1000, MaxQueueDelay: 250 * time.Millisecond})
+	p.send(t, 5, 100-28)
+	for i := 0; i < 3; i++ {
+		p.clock.Advance(99 * time.Millisecond)
+		if got := p.received(t); len(got) != 0 {
+			t.Fatalf("received %v before packet %d was sent", got, i)
+		}
+		p.clock.Advance(time.Millisecond)
+		if got := p.received(t); len(got) != 1 || got[0] != i {
+			t.Fatalf("received %v; want [%d]", got, i)
+		}
+	}
+	p.clock.Advance(time.Hour)
+	if got := p.received(t); len(got) != 0 {
+		t.Errorf("received %v, which should have overflowed the queue", got)
+	}
+}
+
+func TestImpairmentMTU(t *testing.T) {
+	p := newImpairedPair(t, &Impairment{MTU: 1280})
+	p.send(t, 1, 1280-28)
+	p.send(t, 1, 1280-27) // dropped
+	p.clock.Advance(0)
+	if got := p.received(t); len(got) != 1 {
+		t.Fatalf("received %d packets; want 1", len(got))
+	}
+	_, err := p.a.WriteTo(make([]byte, 1280-27), p.bAddr)
+	if !errors.Is(err, syscall.EMSGSIZE) {
+		t.Errorf("write after learning path MTU = %v; want EMSGSIZE", err)
+	}
+	p.send(t, 1, 1280-28)
+	p.clock.Advance(0)
+	if got := p.received(t); len(got) != 1 {
+		t.Errorf("received %d packets; want 1", len(got))
+	}
+}
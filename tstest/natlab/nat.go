@@ -11,10 +11,13 @@
 	"net/netip"
 	"sync"
 	"time"
+
+	"tailscale.com/types/ipproto"
 )
 
 // mapping is the state of an allocated NAT session.
 type mapping struct {
+	proto    ipproto.Proto
 	lanSrc   netip.AddrPort
 	lanDst   netip.AddrPort
 	wanSrc   netip.AddrPort
@@ -27,6 +30,10 @@ type mapping struct {
 	// from the NAT box won't conflict with NAT mappings, since both
 	// use PacketConn to reserve ports on the machine.
 	pc net.PacketConn
+
+	// static is whether the mapping is a port mapping made with
+	// AddPortMapping, rather than one made by outbound traffic.
+	static bool
 }
 
 // NATType is the mapping behavior of a NAT device. Values express
@@ -52,12 +59,14 @@ type mapping struct {
 // 4-tuple ({src,dst} {ip,port}), some NATTypes will zero out some
 // fields, so in practice the key is either a 2-tuple (src only),
 // 3-tuple (src ip+port and dst ip) or 4-tuple (src+dst ip+port).
+// Sessions of different protocols are always distinct.
 type natKey struct {
+	proto    ipproto.Proto
 	src, dst netip.AddrPort
 }
 
-func (t NATType) key(src, dst netip.AddrPort) natKey {
-	k := natKey{src: src}
+func (t NATType) key(proto ipproto.Proto, src, dst netip.AddrPort) natKey {
+	k := natKey{proto: proto, src: src}
 	switch t {
 	case EndpointIndependentNAT:
 	case AddressDependentNAT:
@@ -98,9 +107,10 @@ type SNAT44 struct {
 	// nil, time.Now is used.
 	TimeNow func() time.Time
 
-	mu    sync.Mutex
-	byLAN map[natKey]*mapping         // lookup by outbound packet tuple
-	byWAN map[netip.AddrPort]*mapping // lookup by wan ip:port only
+	mu       sync.Mutex
+	byLAN    map[natKey]*mapping         // lookup by outbound packet tuple
+	byWAN    map[netip.AddrPort]*mapping // lookup by wan ip:port only
+	byLANSrc map[netip.AddrPort]*mapping // port mappings, by lan ip:port
 }
 
 func (n *SNAT44) timeNow() time.Time {
@@ -121,6 +131,7 @@ func (n *SNAT44) initLocked() {
 	if n.byLAN == nil {
 		n.byLAN = map[natKey]*mapping{}
 		n.byWAN = map[netip.AddrPort]*mapping{}
+		n.byLANSrc = map[netip.AddrPort]*mapping{}
 	}
 	if n.ExternalInterface.Machine() != n.Machine {
 		panic(fmt.Sprintf("NAT given interface %s that is not part of given machine %s", n.ExternalInterface, n.Machine.Name))
@@ -150,7 +161,7 @@ func (n *SNAT44) HandleIn(p *Packet, iif *Interface) *Packet {
 
 	now := n.timeNow()
 	mapping := n.byWAN[p.Dst]
-	if mapping == nil || now.After(mapping.deadline) {
+	if mapping == nil || mapping.proto != p.proto() || now.After(mapping.deadline) {
 		// NAT didn't hit, defer to firewall or allow in for local
 		// socket handling.
 		if n.Firewall != nil {
@@ -192,12 +203,19 @@ func (n *SNAT44) HandleForward(p *Packet, iif, oif *Interface) *Packet {
 		defer n.mu.Unlock()
 		n.initLocked()
 
-		k := n.Type.key(p.Src, p.Dst)
 		now := n.timeNow()
+		if m := n.byLANSrc[p.Src]; m != nil && m.proto == p.proto() && !now.After(m.deadline) {
+			p.Src = m.wanSrc
+			p.Trace("snat from %v (port mapping)", p.Src)
+			return p
+		}
+
+		k := n.Type.key(p.proto(), p.Src, p.Dst)
 		m := n.byLAN[k]
 		if m == nil || now.After(m.deadline) {
 			pc, wanAddr := n.allocateMappedPort()
 			m = &mapping{
+				proto:  p.proto(),
 				lanSrc: p.Src,
 				lanDst: p.Dst,
 				wanSrc: wanAddr,
@@ -212,7 +230,15 @@ func (n *SNAT44) HandleForward(p *Packet, iif, oif *Interface) *Packet {
 		return p
 	case iif == n.ExternalInterface:
 		// Packet was already un-NAT-ed, we just need to either
-		// firewall it or let it through.
+		// firewall it or let it through. Port mappings are open to
+		// everyone, like a firewall pinhole.
+		n.mu.Lock()
+		m := n.byLANSrc[p.Dst]
+		pinhole := m != nil && m.proto == p.proto() && !n.timeNow().After(m.deadline)
+		n.mu.Unlock()
+		if pinhole {
+			return p
+		}
 		if n.Firewall != nil {
 			return n.Firewall.HandleForward(p, iif, oif)
 		}
@@ -240,14 +266,96 @@ func (n *SNAT44) allocateMappedPort() (net.PacketConn, netip.AddrPort) {
 	return pc, addr
 }
 
+// AddPortMapping maps a UDP port on the NAT's external interface to
+// lanSrc for lifetime, as a NAT-PMP, PCP or UPnP gateway does on
+// request.
+// Packets from anyone to the mapped port are forwarded to lanSrc,
+// bypassing the Firewall, and packets from lanSrc to anywhere are
+// NATed to the mapped port, whatever the NAT's Type.
+//
+// If lanSrc already has a port mapping, it's renewed for lifetime.
+// Otherwise wanPort is mapped if it's free; if it isn't, or if it's
+// zero, some other free port is. AddPortMapping returns the mapped
+// external address.
+func (n *SNAT44) AddPortMapping(lanSrc netip.AddrPort, wanPort uint16, lifetime time.Duration) (netip.AddrPort, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.initLocked()
+
+	now := n.timeNow()
+	if m := n.byLANSrc[lanSrc]; m != nil && !now.After(m.deadline) {
+		m.deadline = now.Add(lifetime)
+		return m.wanSrc, nil
+	}
+	n.gc()
+
+	var pc net.PacketConn
+	var wanAddr netip.AddrPort
+	ip := n.ExternalInterface.V4()
+	if wanPort != 0 {
+		var err error
+		pc, err = n.Machine.ListenPacket(context.Background(), "udp", netip.AddrPortFrom(ip, wanPort).String())
+		if err == nil {
+			wanAddr = netip.AddrPortFrom(ip, wanPort)
+		}
+	}
+	if pc == nil {
+		pc, wanAddr = n.allocateMappedPort()
+	}
+	m := &mapping{
+		proto:    ipproto.UDP,
+		lanSrc:   lanSrc,
+		wanSrc:   wanAddr,
+		deadline: now.Add(lifetime),
+		pc:       pc,
+		static:   true,
+	}
+	n.byLANSrc[lanSrc] = m
+	n.byWAN[wanAddr] = m
+	return wanAddr, nil
+}
+
+// DeletePortMapping deletes lanSrc's port mapping, if any.
+func (n *SNAT44) DeletePortMapping(lanSrc netip.AddrPort) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.initLocked()
+	if m := n.byLANSrc[lanSrc]; m != nil {
+		n.deleteLocked(m)
+	}
+}
+
+// portMappingOf returns the LAN address that the external port wanPort
+// is port mapped to, if any.
+func (n *SNAT44) portMappingOf(wanPort uint16) (lanSrc netip.AddrPort, ok bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.initLocked()
+	m := n.byWAN[netip.AddrPortFrom(n.ExternalInterface.V4(), wanPort)]
+	if m == nil || !m.static || n.timeNow().After(m.deadline) {
+		return netip.AddrPort{}, false
+	}
+	return m.lanSrc, true
+}
+
+func (n *SNAT44) deleteLocked(m *mapping) {
+	m.pc.Close()
+	// Expired mappings may have been replaced in the LAN side maps.
+	if m.static {
+		if n.byLANSrc[m.lanSrc] == m {
+			delete(n.byLANSrc, m.lanSrc)
+		}
+	} else if k := n.Type.key(m.proto, m.lanSrc, m.lanDst); n.byLAN[k] == m {
+		delete(n.byLAN, k)
+	}
+	delete(n.byWAN, m.wanSrc)
+}
+
 func (n *SNAT44) gc() {
 	now := n.timeNow()
-	for _, m := range n.byLAN {
-		if !now.After(m.deadline) {
-			continue
+	for _, m := range n.byWAN {
+		if now.After(m.deadline) {
+			n.deleteLocked(m)
 		}
-		m.pc.Close()
-		delete(n.byLAN, n.Type.key(m.lanSrc, m.lanDst))
-		delete(n.byWAN, m.wanSrc)
 	}
 }
@@ -22,36 +22,60 @@
 	"sort"
 	"strconv"
 	"sync"
+	"syscall"
 	"time"
 
 	"tailscale.com/net/netaddr"
+	"tailscale.com/types/ipproto"
 )
 
 var traceOn, _ = strconv.ParseBool(os.Getenv("NATLAB_TRACE"))
 
-// Packet represents a UDP packet flowing through the virtual network.
+// Packet represents a UDP or TCP packet flowing through the virtual
+// network.
 type Packet struct {
+	// Proto is the packet's protocol: ipproto.UDP, which the zero
+	// value also means, or ipproto.TCP.
+	Proto    ipproto.Proto
 	Src, Dst netip.AddrPort
-	Payload  []byte
+	// Payload is the UDP payload, or for TCP packets, the TCP segment
+	// including its header. Src and Dst take precedence over the
+	// ports in the segment's header.
+	Payload []byte
 
 	// Prefix set by various internal methods of natlab, to locate
 	// where in the network a trace occurred.
 	locator string
+
+	// origin is the Machine that sent the packet, to Dst origDst,
+	// if known. It's told of the path MTU if the packet is too big.
+	origin  *Machine
+	origDst netip.AddrPort
 }
 
-// Equivalent returns true if Src, Dst and Payload are the same in p
-// and p2.
+// Equivalent returns true if Proto, Src, Dst and Payload are the same
+// in p and p2.
 func (p *Packet) Equivalent(p2 *Packet) bool {
-	return p.Src == p2.Src && p.Dst == p2.Dst && bytes.Equal(p.Payload, p2.Payload)
+	return p.proto() == p2.proto() && p.Src == p2.Src && p.Dst == p2.Dst && bytes.Equal(p.Payload, p2.Payload)
+}
+
+func (p *Packet) proto() ipproto.Proto {
+	if p.Proto == ipproto.Unknown {
+		return ipproto.UDP
+	}
+	return p.Proto
 }
 
 // Clone returns a copy of p that shares nothing with p.
 func (p *Packet) Clone() *Packet {
 	return &Packet{
+		Proto:   p.Proto,
 		Src:     p.Src,
 		Dst:     p.Dst,
 		Payload: append([]byte(nil), p.Payload...),
 		locator: p.locator,
+		origin:  p.origin,
+		origDst: p.origDst,
 	}
 }
 
@@ -103,6 +127,10 @@ type Network struct {
 	Prefix4 netip.Prefix
 	Prefix6 netip.Prefix
 
+	// Impairment, if non-nil, is how the network degrades packets
+	// crossing it. It must be set before traffic starts flowing.
+	Impairment *Impairment
+
 	mu        sync.Mutex
 	machine   map[netip.Addr]*Interface
 	defaultGW *Interface // optional
@@ -179,33 +207,51 @@ func addOne(a *[16]byte, index int) {
 func (n *Network) write(p *Packet) (num int, err error) {
 	p.setLocator("net=%s", n.Name)
 
+	iface, ok := n.route(p)
+	if !ok {
+		return len(p.Payload), nil
+	}
+
+	// Pretend it went across the network. Make a copy so nobody
+	// can later mess with caller's memory.
+	p.Trace("-> mach=%s if=%s", iface.machine.Name, iface.name)
+	deliver := func() { iface.machine.deliverIncomingPacket(p, iface) }
+	if n.Impairment == nil {
+		go deliver()
+		return len(p.Payload), nil
+	}
+	delay, ok := n.Impairment.apply(p)
+	if !ok {
+		return len(p.Payload), nil
+	}
+	n.Impairment.clock().AfterFunc(delay, deliver)
+	return len(p.Payload), nil
+}
+
+// route returns the interface on n that p should be delivered to.
+func (n *Network) route(p *Packet) (iface *Interface, ok bool) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
-	iface, ok := n.machine[p.Dst.Addr()]
+	iface, ok = n.machine[p.Dst.Addr()]
 	if !ok {
 		// If the destination is within the network's authoritative
 		// range, no route to host.
 		if p.Dst.Addr().Is4() && n.Prefix4.Contains(p.Dst.Addr()) {
 			p.Trace("no route to %v", p.Dst.Addr())
-			return len(p.Payload), nil
+			return nil, false
 		}
 		if p.Dst.Addr().Is6() && n.Prefix6.Contains(p.Dst.Addr()) {
 			p.Trace("no route to %v", p.Dst.Addr())
-			return len(p.Payload), nil
+			return nil, false
 		}
 
 		if n.defaultGW == nil {
 			p.Trace("no route to %v", p.Dst.Addr())
-			return len(p.Payload), nil
+			return nil, false
 		}
 		iface = n.defaultGW
 	}
-
-	// Pretend it went across the network. Make a copy so nobody
-	// can later mess with caller's memory.
-	p.Trace("-> mach=%s if=%s", iface.machine.Name, iface.name)
-	go iface.machine.deliverIncomingPacket(p, iface)
-	return len(p.Payload), nil
+	return iface, true
 }
 
 type Interface struct {
@@ -343,6 +389,28 @@ type Machine struct {
 
 	conns4 map[netip.AddrPort]*conn // conns that want IPv4 packets
 	conns6 map[netip.AddrPort]*conn // conns that want IPv6 packets
+	pmtu   map[netip.Addr]int       // learned path MTUs, by destination
+
+	tcpStack *tcpStack // created by the first Dial or Listen
+}
+
+// notePathMTU records that packets to dst must be at most mtu bytes.
+func (m *Machine) notePathMTU(dst netip.Addr, mtu int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.pmtu == nil {
+		m.pmtu = map[netip.Addr]int{}
+	}
+	if cur, ok := m.pmtu[dst]; !ok || mtu < cur {
+		m.pmtu[dst] = mtu
+	}
+}
+
+// pathMTU returns the path MTU to dst, or 0 if it's not known.
+func (m *Machine) pathMTU(dst netip.Addr) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.pmtu[dst]
 }
 
 func (m *Machine) isLocalIP(ip netip.Addr) bool {
@@ -385,6 +453,11 @@ func (m *Machine) deliverLocalPacket(p *Packet, iface *Interface) {
 		}
 	}
 
+	if p.proto() == ipproto.TCP {
+		m.deliverTCP(p, iface)
+		return
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -502,6 +575,15 @@ func (m *Machine) Attach(interfaceName string, n *Network) *Interface {
 		return m.routes[i].prefix.Bits() > m.routes[j].prefix.Bits()
 	})
 
+	if ts := m.tcpStack; ts != nil {
+		if err := ts.addNICLocked(m, f); err != nil {
+			panic(err)
+		}
+		if err := ts.setRoutesLocked(m); err != nil {
+			panic(err)
+		}
+	}
+
 	return f
 }
 
@@ -759,7 +841,7 @@ func (c *conn) canRead() error {
 		return net.ErrClosed
 	}
 	if !c.readDeadline.IsZero() && c.readDeadline.Before(time.Now()) {
-		return errors.New("read deadline exceeded")
+		return os.ErrDeadlineExceeded
 	}
 	return nil
 }
@@ -825,6 +907,16 @@ func (c *conn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
 	c.registerActiveRead(ar, true)
 	defer c.registerActiveRead(ar, false)
 
+	c.mu.Lock()
+	deadline := c.readDeadline
+	c.mu.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		timeout = t.C
+	}
+
 	select {
 	case pkt := <-c.in:
 		n = copy(p, pkt.Payload)
@@ -836,7 +928,12 @@ func (c *conn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
 		}
 		return n, ua, nil
 	case <-ctx.Done():
+		if err := c.canRead(); err != nil {
+			return 0, nil, err
+		}
 		return 0, nil, context.DeadlineExceeded
+	case <-timeout:
+		return 0, nil, os.ErrDeadlineExceeded
 	}
 }
 
@@ -850,9 +947,15 @@ func (c *conn) WriteTo(p []byte, addr net.Addr) (n int, err error) {
 
 func (c *conn) WriteToUDPAddrPort(p []byte, ipp netip.AddrPort) (n int, err error) {
 	pkt := &Packet{
+		Proto:   ipproto.UDP,
 		Src:     c.ipp,
 		Dst:     ipp,
 		Payload: append([]byte(nil), p...),
+		origin:  c.m,
+		origDst: ipp,
+	}
+	if mtu := c.m.pathMTU(ipp.Addr()); mtu > 0 && len(p)+ipOverhead(pkt) > mtu {
+		return 0, &net.OpError{Op: "write", Net: "udp", Addr: net.UDPAddrFromAddrPort(ipp), Err: syscall.EMSGSIZE}
 	}
 	pkt.setLocator("mach=%s", c.m.Name)
 	pkt.Trace("PacketConn.WriteTo")
@@ -860,21 +963,21 @@ func (c *conn) WriteToUDPAddrPort(p []byte, ipp netip.AddrPort) (n int, err erro
 }
 
 func (c *conn) SetDeadline(t time.Time) error {
-	panic("SetWriteDeadline unsupported; TODO when needed")
+	return c.SetReadDeadline(t)
 }
+
+// SetWriteDeadline does nothing, since writes never block.
 func (c *conn) SetWriteDeadline(t time.Time) error {
-	panic("SetWriteDeadline unsupported; TODO when needed")
+	return nil
 }
+
+// SetReadDeadline sets the read deadline. Reads that are in progress
+// when it's set observe it only if it's in the past.
 func (c *conn) SetReadDeadline(t time.Time) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	now := time.Now()
-	if t.After(now) {
-		panic("SetReadDeadline in the future not yet supported; TODO?")
-	}
-
-	if !t.IsZero() && t.Before(now) {
+	if !t.IsZero() && t.Before(time.Now()) {
 		c.breakActiveReadsLocked()
 	}
 	c.readDeadline = t
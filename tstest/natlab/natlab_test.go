@@ -508,3 +508,44 @@ func testNAT(t *testing.T, n *SNAT44, lanIf, wanIf *Interface, tests []natTest)
 		}
 	}
 }
+
+func TestScenarioBuild(t *testing.T) {
+	for _, sc := range Scenarios {
+		sc := sc
+		t.Run(sc.Name, func(t *testing.T) {
+			topo := sc.Build()
+			ctx := context.Background()
+			stunAddr := netip.AddrPortFrom(topo.STUNIP, 3478)
+			stunPC, err := topo.STUN.ListenPacket(ctx, "udp4", stunAddr.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer stunPC.Close()
+			peerPC, err := topo.Peer1.ListenPacket(ctx, "udp4", netip.AddrPortFrom(topo.Peer1IP, 123).String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer peerPC.Close()
+
+			if _, err := peerPC.WriteTo([]byte("hello"), net.UDPAddrFromAddrPort(stunAddr)); err != nil {
+				t.Fatal(err)
+			}
+			stunPC.SetReadDeadline(time.Now().Add(time.Second))
+			buf := make([]byte, 100)
+			_, addr, err := stunPC.ReadFrom(buf)
+			if sc.Peer1.BlockUDP {
+				if err == nil {
+					t.Fatalf("got packet from %v; want it dropped", addr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			src := addr.(*net.UDPAddr).AddrPort()
+			if sc.Peer1.NAT && src.Addr() == topo.Peer1IP {
+				t.Errorf("packet from %v was not NATed", src)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package natlab
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+)
+
+// A Scenario is a NAT traversal test case: two peers, each behind its
+// own Edge, that want a direct path to each other, and a STUN server
+// on the internet between them.
+type Scenario struct {
+	Name         string
+	Peer1, Peer2 Edge
+
+	// WantDirect is whether peers doing NAT traversal (with STUN and
+	// simultaneous sends, but no port prediction) should find a
+	// direct path.
+	WantDirect bool
+	// DirectWithin is how long finding the direct path may take. If
+	// zero, DefaultDirectWithin is used.
+	DirectWithin time.Duration
+}
+
+// DefaultDirectWithin is the default of Scenario.DirectWithin.
+const DefaultDirectWithin = 30 * time.Second
+
+// An Edge describes what sits between a peer and the internet.
+type Edge struct {
+	// NAT is whether the peer is on a LAN behind a NAT, rather than
+	// directly on the internet.
+	NAT bool
+	// NATType is the NAT's mapping behavior, if NAT is set.
+	NATType NATType
+	// Firewall is the filtering done in front of the peer: by the NAT
+	// if NAT is set, and by the peer itself otherwise.
+	Firewall FirewallType
+	// BlockUDP drops all UDP between the peer and the internet.
+	BlockUDP bool
+}
+
+// Scenarios are the standard NAT traversal scenarios.
+var Scenarios = []Scenario{
+	{
+		Name:       "easy_nat",
+		Peer1:      Edge{NAT: true, NATType: EndpointIndependentNAT},
+		Peer2:      Edge{NAT: true, NATType: EndpointIndependentNAT},
+		WantDirect: true,
+	},
+	{
+		// A hard (symmetric) NAT can reach a peer whose NAT doesn't
+		// filter by the sender's address and port.
+		Name:       "hard_nat",
+		Peer1:      Edge{NAT: true, NATType: AddressAndPortDependentNAT},
+		Peer2:      Edge{NAT: true, NATType: EndpointIndependentNAT, Firewall: EndpointIndependentFirewall},
+		WantDirect: true,
+	},
+	{
+		Name:  "symmetric_nat_both_sides",
+		Peer1: Edge{NAT: true, NATType: AddressAndPortDependentNAT},
+		Peer2: Edge{NAT: true, NATType: AddressAndPortDependentNAT},
+	},
+	{
+		Name:  "udp_blocked",
+		Peer1: Edge{BlockUDP: true},
+		Peer2: Edge{NAT: true, NATType: EndpointIndependentNAT},
+	},
+}
+
+// Topology is the network of a Scenario.
+type Topology struct {
+	Internet *Network
+
+	// Peer1 and Peer2 are the peers' machines, and Peer1IP and
+	// Peer2IP their (private, if behind a NAT) IPv4 addresses.
+	Peer1, Peer2     *Machine
+	Peer1IP, Peer2IP netip.Addr
+
+	// STUN is a machine on the internet, at STUNIP, for running a
+	// STUN server.
+	STUN   *Machine
+	STUNIP netip.Addr
+}
+
+// Build returns a new network for s.
+func (s Scenario) Build() *Topology {
+	inet := NewInternet()
+	stun := &Machine{Name: "stun"}
+	t := &Topology{
+		Internet: inet,
+		STUN:     stun,
+		STUNIP:   stun.Attach("eth0", inet).V4(),
+	}
+	t.Peer1, t.Peer1IP = s.Peer1.build("peer1", inet, 1)
+	t.Peer2, t.Peer2IP = s.Peer2.build("peer2", inet, 2)
+	return t
+}
+
+// build returns a machine named name, connected to inet through e, and
+// its IPv4 address. n numbers the LAN, if there is one.
+func (e Edge) build(name string, inet *Network, n int) (*Machine, netip.Addr) {
+	m := &Machine{Name: name}
+	if !e.NAT {
+		ip := m.Attach("eth0", inet).V4()
+		var h PacketHandler = &Firewall{Type: e.Firewall}
+		if e.BlockUDP {
+			h = dropAll{}
+		}
+		m.PacketHandler = h
+		return m, ip
+	}
+
+	lan := &Network{
+		Name:    fmt.Sprintf("lan%d", n),
+		Prefix4: netip.MustParsePrefix(fmt.Sprintf("192.168.%d.0/24", n)),
+	}
+	nat := &Machine{Name: fmt.Sprintf("nat%d", n)}
+	wanIf := nat.Attach("wan", inet)
+	lanIf := nat.Attach("lan", lan)
+	lan.SetDefaultGateway(lanIf)
+	var h PacketHandler = &SNAT44{
+		Machine:           nat,
+		ExternalInterface: wanIf,
+		Type:              e.NATType,
+		Firewall: &Firewall{
+			Type:             e.Firewall,
+			TrustedInterface: lanIf,
+		},
+	}
+	if e.BlockUDP {
+		h = dropAll{}
+	}
+	nat.PacketHandler = h
+	return m, m.Attach("eth0", lan).V4()
+}
+
+// dropAll is a PacketHandler that drops all packets.
+type dropAll struct{}
+
+func (dropAll) HandleIn(p *Packet, iif *Interface) *Packet {
+	p.Trace("drop all")
+	return nil
+}
+
+func (dropAll) HandleOut(p *Packet, oif *Interface) *Packet {
+	p.Trace("drop all")
+	return nil
+}
+
+func (dropAll) HandleForward(p *Packet, iif, oif *Interface) *Packet {
+	p.Trace("drop all")
+	return nil
+}
@@ -0,0 +1,314 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package natlab
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/tailscale/goupnp"
+	"github.com/tailscale/goupnp/dcps/internetgateway2"
+	"tailscale.com/derp"
+	"tailscale.com/derp/derphttp"
+	"tailscale.com/net/netcheck"
+	"tailscale.com/net/portmapper"
+	"tailscale.com/types/key"
+)
+
+// gatewayNet is a peer behind a hard NAT with port mapping services,
+// and a machine on the internet.
+type gatewayNet struct {
+	peer, remote     *Machine
+	peerIP, remoteIP netip.Addr
+	gw               *Gateway
+}
+
+func newGatewayNet(t *testing.T, gw *Gateway) *gatewayNet {
+	inet := NewInternet()
+	lan := &Network{
+		Name:    "lan",
+		Prefix4: netip.MustParsePrefix("192.168.0.0/24"),
+	}
+	nat := &Machine{Name: "nat"}
+	wanIf := nat.Attach("wan", inet)
+	lanIf := nat.Attach("lan", lan)
+	lan.SetDefaultGateway(lanIf)
+	snat := &SNAT44{
+		Machine:           nat,
+		ExternalInterface: wanIf,
+		Type:              AddressAndPortDependentNAT,
+		Firewall: &Firewall{
+			Type:             AddressAndPortDependentFirewall,
+			TrustedInterface: lanIf,
+		},
+	}
+	nat.PacketHandler = snat
+	gw.NAT = snat
+	gw.LANInterface = lanIf
+	if err := gw.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { gw.Close() })
+
+	n := &gatewayNet{
+		peer:   &Machine{Name: "peer"},
+		remote: &Machine{Name: "remote"},
+		gw:     gw,
+	}
+	n.peerIP = n.peer.Attach("eth0", lan).V4()
+	n.remoteIP = n.remote.Attach("eth0", inet).V4()
+	return n
+}
+
+// newPortMapper returns a portmapper for the peer, with its local port
+// set to that of pc.
+func (n *gatewayNet) newPortMapper(t *testing.T, pc net.PacketConn, onChange func()) *portmapper.Client {
+	c := portmapper.NewClient(t.Logf, onChange)
+	c.SetPacketListener(n.peer)
+	c.SetGatewayLookupFunc(func() (gw, myIP netip.Addr, ok bool) {
+		return n.gw.LANInterface.V4(), n.peerIP, true
+	})
+	c.SetLocalPort(uint16(pc.LocalAddr().(*net.UDPAddr).Port))
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// checkInbound checks that a packet from the internet to external
+// arrives on pc.
+func (n *gatewayNet) checkInbound(t *testing.T, external netip.AddrPort, pc net.PacketConn) {
+	t.Helper()
+	rpc, err := n.remote.ListenPacket(context.Background(), "udp4", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpc.Close()
+	if _, err := rpc.WriteTo([]byte("hello"), net.UDPAddrFromAddrPort(external)); err != nil {
+		t.Fatal(err)
+	}
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 100)
+	nn, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("reading packet sent to %v: %v", external, err)
+	}
+	if string(buf[:nn]) != "hello" {
+		t.Errorf("got %q; want hello", buf[:nn])
+	}
+}
+
+func TestGatewayPxP(t *testing.T) {
+	tests := []struct {
+		name string
+		gw   *Gateway
+		want portmapper.ProbeResult
+	}{
+		{"pmp", &Gateway{PMP: true}, portmapper.ProbeResult{PMP: true}},
+		{"pcp", &Gateway{PCP: true}, portmapper.ProbeResult{PCP: true}},
+		{"upnp", &Gateway{UPnP: true}, portmapper.ProbeResult{UPnP: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := newGatewayNet(t, tt.gw)
+			pc, err := n.peer.ListenPacket(context.Background(), "udp4", ":0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer pc.Close()
+			changed := make(chan struct{}, 1)
+			c := n.newPortMapper(t, pc, func() {
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			})
+
+			res, err := c.Probe(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res != tt.want {
+				t.Errorf("Probe = %+v; want %+v", res, tt.want)
+			}
+			if tt.gw.UPnP {
+				// UPnP mappings are made over HTTP; see TestGatewayUPnP.
+				return
+			}
+
+			if _, ok := c.GetCachedMappingOrStartCreatingOne(); ok {
+				t.Fatal("unexpected cached mapping")
+			}
+			select {
+			case <-changed:
+			case <-time.After(5 * time.Second):
+				t.Fatal("timeout waiting for mapping")
+			}
+			external, ok := c.GetCachedMappingOrStartCreatingOne()
+			if !ok {
+				t.Fatal("no mapping")
+			}
+			if external.Addr() != tt.gw.NAT.ExternalInterface.V4() {
+				t.Errorf("mapped address %v is not on the NAT", external)
+			}
+			n.checkInbound(t, external, pc)
+		})
+	}
+}
+
+func TestGatewayUPnP(t *testing.T) {
+	gw := &Gateway{UPnP: true}
+	n := newGatewayNet(t, gw)
+
+	// Talk to the gateway over natlab TCP, from the peer.
+	hc := &http.Client{
+		Transport: &http.Transport{
+			DialContext: n.peer.Dial,
+		},
+	}
+	defer hc.CloseIdleConnections()
+	ctx := goupnp.WithHTTPClient(context.Background(), hc)
+	u, _ := url.Parse(fmt.Sprintf("http://%v/rootDesc.xml", netip.AddrPortFrom(gw.LANInterface.V4(), UPnPHTTPPort)))
+	root, err := goupnp.DeviceByURL(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients, err := internetgateway2.NewWANIPConnection1ClientsFromRootDevice(ctx, root, u)
+	if err != nil || len(clients) != 1 {
+		t.Fatalf("got %d WANIPConnection1 clients, %v; want 1", len(clients), err)
+	}
+	c := clients[0]
+	ip, err := c.GetExternalIPAddress(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := gw.NAT.ExternalInterface.V4().String(); ip != want {
+		t.Errorf("external IP = %q; want %q", ip, want)
+	}
+
+	pc, err := n.peer.ListenPacket(ctx, "udp4", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	lanPort := uint16(pc.LocalAddr().(*net.UDPAddr).Port)
+	if err := c.AddPortMapping(ctx, "", 4242, "UDP", lanPort, n.peerIP.String(), true, "test", 3600); err != nil {
+		t.Fatal(err)
+	}
+	n.checkInbound(t, netip.AddrPortFrom(gw.NAT.ExternalInterface.V4(), 4242), pc)
+
+	if err := c.AddPortMapping(ctx, "", 4242, "UDP", lanPort+1, n.peerIP.String(), true, "test", 3600); err == nil {
+		t.Error("conflicting AddPortMapping succeeded")
+	}
+	if err := c.DeletePortMapping(ctx, "", 4242, "UDP"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeletePortMapping(ctx, "", 4242, "UDP"); err == nil {
+		t.Error("second DeletePortMapping succeeded")
+	}
+}
+
+func TestNetcheck(t *testing.T) {
+	n := newGatewayNet(t, &Gateway{PMP: true})
+	derp, err := NewDERPServer(t.Logf, n.remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer derp.Close()
+
+	pm := portmapper.NewClient(t.Logf, nil)
+	pm.SetPacketListener(n.peer)
+	pm.SetGatewayLookupFunc(func() (gw, myIP netip.Addr, ok bool) {
+		return n.gw.LANInterface.V4(), n.peerIP, true
+	})
+	defer pm.Close()
+	c := &netcheck.Client{
+		Logf:           t.Logf,
+		PacketListener: n.peer,
+		PortMapper:     pm,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	r, err := c.GetReport(ctx, derp.DERPMap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.UDP {
+		t.Error("UDP = false; want true")
+	}
+	global, err := netip.ParseAddrPort(r.GlobalV4)
+	if err != nil {
+		t.Fatalf("GlobalV4 %q: %v", r.GlobalV4, err)
+	}
+	if global.Addr() != n.gw.NAT.ExternalInterface.V4() {
+		t.Errorf("GlobalV4 = %v; want the NAT's address %v", global, n.gw.NAT.ExternalInterface.V4())
+	}
+	if v, ok := r.PMP.Get(); !v || !ok {
+		t.Errorf("PMP = %v; want true", r.PMP)
+	}
+}
+
+func TestDERPThroughNAT(t *testing.T) {
+	n := newGatewayNet(t, &Gateway{})
+	derpServer, err := NewDERPServer(t.Logf, n.remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer derpServer.Close()
+
+	// Both clients are behind the NAT, so both of their DERP
+	// connections are translated by it.
+	k1, k2 := key.NewNode(), key.NewNode()
+	c1 := derpServer.NewClient(k1, t.Logf, n.peer)
+	defer c1.Close()
+	c2 := derpServer.NewClient(k2, t.Logf, n.peer)
+	defer c2.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, c := range []*derphttp.Client{c1, c2} {
+		if err := c.Connect(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := make(chan derp.ReceivedPacket, 1)
+	go func() {
+		for {
+			m, err := c2.Recv()
+			if err != nil {
+				return
+			}
+			if p, ok := m.(derp.ReceivedPacket); ok {
+				p.Data = append([]byte(nil), p.Data...)
+				got <- p
+				return
+			}
+		}
+	}()
+	// c2 may not be registered with the server yet, in which case the
+	// server drops the packet, so keep sending until it arrives.
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		if err := c1.Send(k2.Public(), []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case p := <-got:
+			if p.Source != k1.Public() || string(p.Data) != "hello" {
+				t.Errorf("got packet %q from %v; want %q from %v", p.Data, p.Source, "hello", k1.Public())
+			}
+			return
+		case <-tick.C:
+		case <-ctx.Done():
+			t.Fatal("packet not received")
+		}
+	}
+}
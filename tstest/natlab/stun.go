@@ -0,0 +1,101 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package natlab
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+
+	"tailscale.com/net/stun"
+	"tailscale.com/net/stun/stuntest"
+	"tailscale.com/tailcfg"
+)
+
+// DefaultSTUNPort is the port that STUN servers listen on by default.
+const DefaultSTUNPort = 3478
+
+// A STUNServer answers STUN binding requests on a Machine, telling
+// clients the address that their requests arrived from.
+type STUNServer struct {
+	// Addr is the address that the server listens on.
+	Addr netip.AddrPort
+
+	pc   net.PacketConn
+	done chan struct{}
+}
+
+// NewSTUNServer starts a STUN server on m's first IPv4 address. If port
+// is zero, DefaultSTUNPort is used.
+func NewSTUNServer(m *Machine, port uint16) (*STUNServer, error) {
+	if port == 0 {
+		port = DefaultSTUNPort
+	}
+	ip := m.firstIPv4()
+	if !ip.IsValid() {
+		return nil, errors.New("machine has no IPv4 address")
+	}
+	pc, err := m.ListenPacket(context.Background(), "udp4", netip.AddrPortFrom(ip, port).String())
+	if err != nil {
+		return nil, err
+	}
+	s := &STUNServer{
+		Addr: netip.AddrPortFrom(ip, port),
+		pc:   pc,
+		done: make(chan struct{}),
+	}
+	go s.serve()
+	return s, nil
+}
+
+func (s *STUNServer) serve() {
+	defer close(s.done)
+	buf := make([]byte, 64<<10)
+	for {
+		n, addr, err := s.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		pkt := buf[:n]
+		if !stun.Is(pkt) {
+			continue
+		}
+		txid, err := stun.ParseBindingRequest(pkt)
+		if err != nil {
+			continue
+		}
+		ua := addr.(*net.UDPAddr)
+		s.pc.WriteTo(stun.Response(txid, ua.IP, uint16(ua.Port)), addr)
+	}
+}
+
+// DERPMap returns a DERP map with a single STUN-only region, whose node
+// is s.
+func (s *STUNServer) DERPMap() *tailcfg.DERPMap {
+	return stuntest.DERPMapOf(s.Addr.String())
+}
+
+// Close stops the server.
+func (s *STUNServer) Close() error {
+	err := s.pc.Close()
+	<-s.done
+	return err
+}
+
+// firstIPv4 returns the first IPv4 address of m's interfaces, or the
+// zero value if there is none.
+func (m *Machine) firstIPv4() netip.Addr {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, f := range m.interfaces {
+		for _, ip := range f.ips {
+			if ip.Is4() {
+				return ip
+			}
+		}
+	}
+	return netip.Addr{}
+}
@@ -0,0 +1,340 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package natlab
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+
+	"gvisor.dev/gvisor/pkg/bufferv2"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"tailscale.com/types/ipproto"
+)
+
+// tcpMTU is the MTU of the NICs of a Machine's TCP stack.
+const tcpMTU = 1500
+
+// A tcpStack is a Machine's TCP implementation: a gVisor netstack with
+// a NIC for each of the Machine's interfaces, whose packets go through
+// the natlab network like those of UDP conns.
+type tcpStack struct {
+	s    *stack.Stack
+	nics map[*Interface]*tcpNIC
+}
+
+// A tcpNIC links a NIC of a tcpStack to an Interface.
+type tcpNIC struct {
+	m  *Machine
+	id tcpip.NICID
+	ep *channel.Endpoint
+}
+
+// tcp returns m's TCP stack, creating it on first use.
+func (m *Machine) tcp() (*tcpStack, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.tcpStack != nil {
+		return m.tcpStack, nil
+	}
+	if len(m.interfaces) == 0 {
+		return nil, fmt.Errorf("machine %q has no interfaces", m.Name)
+	}
+
+	ts := &tcpStack{
+		s: stack.New(stack.Options{
+			NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocol},
+			TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol},
+		}),
+		nics: map[*Interface]*tcpNIC{},
+	}
+	for _, f := range m.interfaces {
+		if err := ts.addNICLocked(m, f); err != nil {
+			return nil, err
+		}
+	}
+	if err := ts.setRoutesLocked(m); err != nil {
+		return nil, err
+	}
+	m.tcpStack = ts
+	return ts, nil
+}
+
+// addNICLocked adds a NIC for f, one of m's interfaces, to ts.
+// m.mu must be held.
+func (ts *tcpStack) addNICLocked(m *Machine, f *Interface) error {
+	nic := &tcpNIC{
+		m:  m,
+		id: tcpip.NICID(len(ts.nics) + 1),
+		ep: channel.New(512, tcpMTU, ""),
+	}
+	if err := ts.s.CreateNIC(nic.id, nic.ep); err != nil {
+		return fmt.Errorf("creating NIC for %v: %v", f, err)
+	}
+	for _, ip := range f.ips {
+		pa := tcpip.ProtocolAddress{
+			AddressWithPrefix: tcpip.Address(ip.AsSlice()).WithPrefix(),
+			Protocol:          ipv4.ProtocolNumber,
+		}
+		if ip.Is6() {
+			pa.Protocol = ipv6.ProtocolNumber
+		}
+		if err := ts.s.AddProtocolAddress(nic.id, pa, stack.AddressProperties{}); err != nil {
+			return fmt.Errorf("adding %v to %v: %v", ip, f, err)
+		}
+	}
+	nic.ep.AddNotify(nic)
+	ts.nics[f] = nic
+	return nil
+}
+
+// setRoutesLocked sets ts's route table to mirror m's routing table,
+// which is already sorted the way netstack wants it.
+// m.mu must be held.
+func (ts *tcpStack) setRoutesLocked(m *Machine) error {
+	var routes []tcpip.Route
+	for _, re := range m.routes {
+		mask := net.CIDRMask(re.prefix.Bits(), re.prefix.Addr().BitLen())
+		sub, err := tcpip.NewSubnet(tcpip.Address(re.prefix.Masked().Addr().AsSlice()), tcpip.AddressMask(mask))
+		if err != nil {
+			return fmt.Errorf("route %v: %v", re.prefix, err)
+		}
+		routes = append(routes, tcpip.Route{
+			Destination: sub,
+			NIC:         ts.nics[re.iface].id,
+		})
+	}
+	ts.s.SetRouteTable(routes)
+	return nil
+}
+
+// WriteNotify implements channel.Notification. It sends the packets
+// that netstack wrote to the NIC out of its Machine.
+func (nic *tcpNIC) WriteNotify() {
+	for {
+		pkt := nic.ep.Read()
+		if pkt == nil {
+			return
+		}
+		var b []byte
+		for _, s := range pkt.AsSlices() {
+			b = append(b, s...)
+		}
+		pkt.DecRef()
+
+		p, ok := parseTCP(b)
+		if !ok {
+			continue
+		}
+		p.origin = nic.m
+		p.origDst = p.Dst
+		nic.m.writePacket(p)
+	}
+}
+
+// parseTCP returns the Packet of the TCP segment in the IP packet b.
+func parseTCP(b []byte) (p *Packet, ok bool) {
+	var src, dst netip.Addr
+	var seg []byte
+	switch {
+	case len(b) >= header.IPv4MinimumSize && b[0]>>4 == 4:
+		ip := header.IPv4(b)
+		if ip.TransportProtocol() != header.TCPProtocolNumber {
+			return nil, false
+		}
+		src, _ = netip.AddrFromSlice([]byte(ip.SourceAddress()))
+		dst, _ = netip.AddrFromSlice([]byte(ip.DestinationAddress()))
+		seg = ip.Payload()
+	case len(b) >= header.IPv6MinimumSize && b[0]>>4 == 6:
+		ip := header.IPv6(b)
+		if ip.TransportProtocol() != header.TCPProtocolNumber {
+			return nil, false
+		}
+		src, _ = netip.AddrFromSlice([]byte(ip.SourceAddress()))
+		dst, _ = netip.AddrFromSlice([]byte(ip.DestinationAddress()))
+		seg = ip.Payload()
+	default:
+		return nil, false
+	}
+	if len(seg) < header.TCPMinimumSize {
+		return nil, false
+	}
+	th := header.TCP(seg)
+	return &Packet{
+		Proto:   ipproto.TCP,
+		Src:     netip.AddrPortFrom(src, th.SourcePort()),
+		Dst:     netip.AddrPortFrom(dst, th.DestinationPort()),
+		Payload: append([]byte(nil), seg...),
+	}, true
+}
+
+// deliverTCP hands the TCP packet p, which arrived on iface, to m's
+// TCP stack.
+func (m *Machine) deliverTCP(p *Packet, iface *Interface) {
+	m.mu.Lock()
+	ts := m.tcpStack
+	m.mu.Unlock()
+	if ts == nil {
+		p.Trace("dropped, no TCP stack")
+		return
+	}
+	m.mu.Lock()
+	nic := ts.nics[iface]
+	m.mu.Unlock()
+	if nic == nil {
+		p.Trace("dropped, no TCP NIC for interface")
+		return
+	}
+	if len(p.Payload) < header.TCPMinimumSize {
+		p.Trace("dropped, short TCP segment")
+		return
+	}
+
+	src := tcpip.Address(p.Src.Addr().AsSlice())
+	dst := tcpip.Address(p.Dst.Addr().AsSlice())
+	var b []byte
+	var pn tcpip.NetworkProtocolNumber
+	if p.Dst.Addr().Is4() {
+		pn = header.IPv4ProtocolNumber
+		b = make([]byte, header.IPv4MinimumSize+len(p.Payload))
+		ip := header.IPv4(b)
+		ip.Encode(&header.IPv4Fields{
+			TotalLength: uint16(len(b)),
+			TTL:         64,
+			Protocol:    uint8(header.TCPProtocolNumber),
+			SrcAddr:     src,
+			DstAddr:     dst,
+		})
+		ip.SetChecksum(^ip.CalculateChecksum())
+	} else {
+		pn = header.IPv6ProtocolNumber
+		b = make([]byte, header.IPv6MinimumSize+len(p.Payload))
+		header.IPv6(b).Encode(&header.IPv6Fields{
+			PayloadLength:     uint16(len(p.Payload)),
+			TransportProtocol: header.TCPProtocolNumber,
+			HopLimit:          64,
+			SrcAddr:           src,
+			DstAddr:           dst,
+		})
+	}
+
+	// NATs rewrite Src and Dst but not the segment, so fix its ports
+	// and checksum up.
+	seg := header.TCP(b[len(b)-len(p.Payload):])
+	copy(seg, p.Payload)
+	seg.SetSourcePort(p.Src.Port())
+	seg.SetDestinationPort(p.Dst.Port())
+	binary.BigEndian.PutUint16(seg[header.TCPChecksumOffset:], 0)
+	xsum := header.PseudoHeaderChecksum(header.TCPProtocolNumber, src, dst, uint16(len(seg)))
+	seg.SetChecksum(^header.Checksum(seg, xsum))
+
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Payload: bufferv2.MakeWithData(b),
+	})
+	p.Trace("-> TCP stack")
+	nic.ep.InjectInbound(pn, pkt)
+	pkt.DecRef()
+}
+
+// Dial connects to address on the named network, which must be "tcp",
+// "tcp4" or "tcp6". The address's host must be an IP address.
+//
+// Dial and Listen give m a TCP stack, which has a NIC for each of m's
+// interfaces, including those attached later.
+func (m *Machine) Dial(ctx context.Context, network, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("unsupported network type %q", network)
+	}
+	ipp, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("bad address %q: %v", address, err)
+	}
+	if (network == "tcp4" && !ipp.Addr().Is4()) || (network == "tcp6" && !ipp.Addr().Is6()) {
+		return nil, fmt.Errorf("address %v is not %s", ipp.Addr(), network)
+	}
+	ts, err := m.tcp()
+	if err != nil {
+		return nil, err
+	}
+	pn := ipv4.ProtocolNumber
+	if ipp.Addr().Is6() {
+		pn = ipv6.ProtocolNumber
+	}
+	return gonet.DialContextTCP(ctx, ts.s, tcpip.FullAddress{
+		Addr: tcpip.Address(ipp.Addr().AsSlice()),
+		Port: ipp.Port(),
+	}, pn)
+}
+
+// Listen listens for TCP connections on address on the named network,
+// which must be "tcp", "tcp4" or "tcp6". If the address's host is
+// empty or unspecified, Listen listens on all of m's addresses of the
+// network.
+func (m *Machine) Listen(network, address string) (net.Listener, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	fa := tcpip.FullAddress{Port: uint16(port)}
+	var pn tcpip.NetworkProtocolNumber
+	if host != "" {
+		ip, err := netip.ParseAddr(host)
+		if err != nil {
+			return nil, fmt.Errorf("bad address %q: %v", address, err)
+		}
+		if !ip.IsUnspecified() {
+			fa.Addr = tcpip.Address(ip.AsSlice())
+		}
+		pn = ipv4.ProtocolNumber
+		if ip.Is6() {
+			pn = ipv6.ProtocolNumber
+		}
+	}
+	switch network {
+	case "tcp":
+		if host == "" {
+			// An IPv6 listener also accepts IPv4 connections.
+			pn = ipv4.ProtocolNumber
+			if m.hasv6() {
+				pn = ipv6.ProtocolNumber
+			}
+		}
+	case "tcp4":
+		if pn == ipv6.ProtocolNumber {
+			return nil, fmt.Errorf("address %q is not tcp4", address)
+		}
+		pn = ipv4.ProtocolNumber
+	case "tcp6":
+		if pn == ipv4.ProtocolNumber {
+			return nil, fmt.Errorf("address %q is not tcp6", address)
+		}
+		pn = ipv6.ProtocolNumber
+	default:
+		return nil, fmt.Errorf("unsupported network type %q", network)
+	}
+
+	ts, err := m.tcp()
+	if err != nil {
+		return nil, err
+	}
+	return gonet.ListenTCP(ts.s, fa, pn)
+}
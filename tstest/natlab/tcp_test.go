@@ -0,0 +1,192 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package natlab
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// echoServer accepts connections on ln and echoes what it reads on
+// them.
+func echoServer(t *testing.T, ln net.Listener) {
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+}
+
+// checkEcho checks that size bytes written to c come back.
+func checkEcho(t *testing.T, c net.Conn, size int) {
+	t.Helper()
+	want := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(want)
+	go c.Write(want)
+	c.SetReadDeadline(time.Now().Add(10 * time.Second))
+	got := make([]byte, size)
+	if _, err := io.ReadFull(c, got); err != nil {
+		t.Fatalf("reading echo: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("echo differs from what was sent")
+	}
+}
+
+func TestTCP(t *testing.T) {
+	tests := []struct {
+		name string
+		v6   bool
+		imp  *Impairment
+		size int
+	}{
+		{name: "v4", size: 1 << 20},
+		{name: "v6", v6: true, size: 1 << 20},
+		{name: "lossy", imp: &Impairment{
+			Latency: time.Millisecond,
+			Loss:    0.05,
+			Rand:    rand.New(rand.NewSource(1)),
+		}, size: 64 << 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := NewInternet()
+			n.Impairment = tt.imp
+			client, server := &Machine{Name: "client"}, &Machine{Name: "server"}
+			client.Attach("eth0", n)
+			serverIf := server.Attach("eth0", n)
+			serverIP := serverIf.V4()
+			if tt.v6 {
+				serverIP = serverIf.V6()
+			}
+
+			ln, err := server.Listen("tcp", ":80")
+			if err != nil {
+				t.Fatal(err)
+			}
+			echoServer(t, ln)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			c, err := client.Dial(ctx, "tcp", netip.AddrPortFrom(serverIP, 80).String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			checkEcho(t, c, tt.size)
+		})
+	}
+}
+
+func TestTCPAttachAfterListen(t *testing.T) {
+	inet := NewInternet()
+	lan := &Network{
+		Name:    "lan",
+		Prefix4: netip.MustParsePrefix("192.168.0.0/24"),
+	}
+	server := &Machine{Name: "server"}
+	server.Attach("eth0", inet)
+	ln, err := server.Listen("tcp4", ":80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	echoServer(t, ln)
+
+	// The server's TCP stack already exists, so it must gain a NIC
+	// and a route for the new interface.
+	serverIP := server.Attach("eth1", lan).V4()
+	client := &Machine{Name: "client"}
+	client.Attach("eth0", lan)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := client.Dial(ctx, "tcp4", netip.AddrPortFrom(serverIP, 80).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	checkEcho(t, c, 64<<10)
+}
+
+func TestTCPNAT(t *testing.T) {
+	inet := NewInternet()
+	lan := &Network{
+		Name:    "lan",
+		Prefix4: netip.MustParsePrefix("192.168.0.0/24"),
+	}
+	nat := &Machine{Name: "nat"}
+	wanIf := nat.Attach("wan", inet)
+	lanIf := nat.Attach("lan", lan)
+	lan.SetDefaultGateway(lanIf)
+	nat.PacketHandler = &SNAT44{
+		Machine:           nat,
+		ExternalInterface: wanIf,
+		Type:              EndpointIndependentNAT,
+		Firewall: &Firewall{
+			Type:             AddressAndPortDependentFirewall,
+			TrustedInterface: lanIf,
+		},
+	}
+	peer, remote := &Machine{Name: "peer"}, &Machine{Name: "remote"}
+	peerIP := peer.Attach("eth0", lan).V4()
+	remoteIP := remote.Attach("eth0", inet).V4()
+
+	ln, err := remote.Listen("tcp4", ":80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(chan net.Addr, 1)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		accepted <- c.RemoteAddr()
+		io.Copy(c, c)
+		c.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := peer.Dial(ctx, "tcp4", netip.AddrPortFrom(remoteIP, 80).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	checkEcho(t, c, 64<<10)
+	if got := (<-accepted).(*net.TCPAddr).AddrPort().Addr(); got != wanIf.V4() {
+		t.Errorf("remote saw connection from %v; want NAT's %v", got, wanIf.V4())
+	}
+
+	// Connections from the internet to the LAN are firewalled.
+	peerLn, err := peer.Listen("tcp4", ":80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peerLn.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	for _, dst := range []netip.Addr{wanIf.V4(), peerIP} {
+		if c, err := remote.Dial(ctx, "tcp4", netip.AddrPortFrom(dst, 80).String()); err == nil {
+			c.Close()
+			t.Errorf("dial from internet to %v succeeded", dst)
+		}
+	}
+}
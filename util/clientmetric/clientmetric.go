@@ -61,6 +61,16 @@ type Metric struct {
 	name   string
 	typ    Type
 
+	// label and labelValue are the name and value of the metric's
+	// label, if it's part of a LabelMap. Otherwise they're empty.
+	label      string
+	labelValue string
+
+	// hist is the Histogram that the metric is a bucket or sum of, if
+	// any. le is the upper bound of its bucket, if it's a bucket.
+	hist *Histogram
+	le   string
+
 	// The following fields are owned by the package-level 'mu':
 
 	// wireID is the lazily-allocated "wire ID". Until a metric is encoded
@@ -78,6 +88,24 @@ func (m *Metric) Name() string { return m.name }
 func (m *Metric) Value() int64 { return atomic.LoadInt64(m.v) }
 func (m *Metric) Type() Type   { return m.typ }
 
+// Label returns the name and value of m's label, if m is part of a
+// LabelMap. Otherwise it returns empty strings.
+func (m *Metric) Label() (name, value string) { return m.label, m.labelValue }
+
+// key returns the key of m in the metrics map: its name, followed by its
+// label in braces if it has one.
+func (m *Metric) key() string {
+	switch {
+	case m.label != "" && m.le != "":
+		return m.name + "{" + m.label + "=" + m.labelValue + ",le=" + m.le + "}"
+	case m.label != "":
+		return m.name + "{" + m.label + "=" + m.labelValue + "}"
+	case m.le != "":
+		return m.name + "{le=" + m.le + "}"
+	}
+	return m.name
+}
+
 // Add increments m's value by n.
 //
 // If m is of type counter, n should not be negative.
@@ -100,10 +128,11 @@ func (m *Metric) Publish() {
 	if m.name == "" {
 		panic("unnamed Metric")
 	}
-	if _, dup := metrics[m.name]; dup {
-		panic("duplicate metric " + m.name)
+	key := m.key()
+	if _, dup := metrics[key]; dup {
+		panic("duplicate metric " + key)
 	}
-	metrics[m.name] = m
+	metrics[key] = m
 	sortedDirty = true
 
 	if len(valFreeList) == 0 {
@@ -117,7 +146,8 @@ func (m *Metric) Publish() {
 	lastLogVal = append(lastLogVal, scanEntry{v: m.v})
 }
 
-// Metrics returns the sorted list of metrics.
+// Metrics returns the list of metrics, sorted by name, then by label
+// value, and then by the order in which they were published.
 //
 // The returned slice should not be mutated.
 func Metrics() []*Metric {
@@ -130,7 +160,13 @@ func Metrics() []*Metric {
 			sorted = append(sorted, m)
 		}
 		sort.Slice(sorted, func(i, j int) bool {
-			return sorted[i].name < sorted[j].name
+			if sorted[i].name != sorted[j].name {
+				return sorted[i].name < sorted[j].name
+			}
+			if sorted[i].labelValue != sorted[j].labelValue {
+				return sorted[i].labelValue < sorted[j].labelValue
+			}
+			return sorted[i].regIdx < sorted[j].regIdx
 		})
 	}
 	return sorted
@@ -178,19 +214,102 @@ func NewGauge(name string) *Metric {
 	return m
 }
 
+// LabelMap is a set of metrics with the same name and type that are
+// distinguished by the value of a single label, such as a counter of
+// packets sent with a "path" label of "derp" or "direct".
+//
+// Label values should come from a small, fixed set, as each one is a
+// separate metric for the life of the process.
+//
+// It's safe for concurrent use.
+type LabelMap struct {
+	name  string
+	typ   Type
+	label string
+
+	mu sync.Mutex
+	m  map[string]*Metric // by label value
+}
+
+// NewLabelMap returns a new LabelMap of metrics with the given name and
+// type, and a label named label. Its metrics are published as they're
+// first used by Get.
+func NewLabelMap(name string, typ Type, label string) *LabelMap {
+	if i := strings.IndexFunc(name, isIllegalMetricRune); name == "" || i != -1 {
+		panic(fmt.Sprintf("illegal metric name %q (index %v)", name, i))
+	}
+	if i := strings.IndexFunc(label, isIllegalMetricRune); label == "" || i != -1 {
+		panic(fmt.Sprintf("illegal metric label %q (index %v)", label, i))
+	}
+	return &LabelMap{
+		name:  name,
+		typ:   typ,
+		label: label,
+	}
+}
+
+// Get returns the metric in lm whose label has the given value, creating
+// and publishing it if needed. The value must be non-empty and consist
+// of the same characters as metric names.
+func (lm *LabelMap) Get(value string) *Metric {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	if m, ok := lm.m[value]; ok {
+		return m
+	}
+	if i := strings.IndexFunc(value, isIllegalMetricRune); value == "" || i != -1 {
+		panic(fmt.Sprintf("illegal label value %q for metric %q (index %v)", value, lm.name, i))
+	}
+	m := &Metric{
+		name:       lm.name,
+		typ:        lm.typ,
+		label:      lm.label,
+		labelValue: value,
+	}
+	m.Publish()
+	if lm.m == nil {
+		lm.m = make(map[string]*Metric)
+	}
+	lm.m[value] = m
+	return m
+}
+
 // WritePrometheusExpositionFormat writes all client metrics to w in
 // the Prometheus text-based exposition format.
 //
 // See https://github.com/prometheus/docs/blob/main/content/docs/instrumenting/exposition_formats.md
 func WritePrometheusExpositionFormat(w io.Writer) {
+	var lastName string
 	for _, m := range Metrics() {
-		switch m.Type() {
-		case TypeGauge:
-			fmt.Fprintf(w, "# TYPE %s gauge\n", m.Name())
-		case TypeCounter:
-			fmt.Fprintf(w, "# TYPE %s counter\n", m.Name())
+		if h := m.hist; h != nil {
+			// Histograms are written all at once, when their
+			// first bucket is reached.
+			if m != h.buckets[0] {
+				continue
+			}
+			if h.name != lastName {
+				lastName = h.name
+				fmt.Fprintf(w, "# TYPE %s histogram\n", h.name)
+			}
+			h.writePrometheus(w)
+			continue
+		}
+		// Metrics in the same LabelMap are adjacent and share one TYPE
+		// line.
+		if m.Name() != lastName {
+			lastName = m.Name()
+			switch m.Type() {
+			case TypeGauge:
+				fmt.Fprintf(w, "# TYPE %s gauge\n", m.Name())
+			case TypeCounter:
+				fmt.Fprintf(w, "# TYPE %s counter\n", m.Name())
+			}
+		}
+		if m.label != "" {
+			fmt.Fprintf(w, "%s{%s=%q} %v\n", m.Name(), m.label, m.labelValue, m.Value())
+		} else {
+			fmt.Fprintf(w, "%s %v\n", m.Name(), m.Value())
 		}
-		fmt.Fprintf(w, "%s %v\n", m.Name(), m.Value())
 	}
 }
 
@@ -218,6 +337,8 @@ func WritePrometheusExpositionFormat(w io.Writer) {
 // The current encoding is:
 //   - name immediately following metric:
 //     'N' + hex(varint(len(name))) + name
+//     where name is followed by "{label=value}" for metrics in a LabelMap;
+//     see Histogram for how histograms are named
 //   - set value of a metric:
 //     'S' + hex(varint(wireid)) + hex(varint(value))
 //   - increment a metric: (decrements if negative)
@@ -250,7 +371,7 @@ func EncodeLogTailMetricsDelta() string {
 			m.wireID = numWireID
 		}
 		if m.lastNamed.IsZero() || now.Sub(m.lastNamed) > metricLogNameFrequency {
-			enc.writeName(m.Name(), m.Type())
+			enc.writeName(m.key(), m.Type())
 			m.lastNamed = now
 			enc.writeValue(m.wireID, val)
 		} else {
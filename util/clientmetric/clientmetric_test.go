@@ -5,6 +5,7 @@
 package clientmetric
 
 import (
+	"bytes"
 	"testing"
 	"time"
 )
@@ -73,3 +74,48 @@ func TestEncodeLogTailMetricsDelta(t *testing.T) {
 		t.Errorf("with increments = %q; want %q", got, want)
 	}
 }
+
+func TestLabelMap(t *testing.T) {
+	clearMetrics()
+
+	lm := NewLabelMap("sent", TypeCounter, "path")
+	c := NewGauge("conns")
+	lm.Get("direct").Add(3)
+	lm.Get("derp").Add(2)
+	lm.Get("direct").Add(1)
+	c.Set(1)
+
+	if got, want := lm.Get("direct").Value(), int64(4); got != want {
+		t.Errorf("direct = %v; want %v", got, want)
+	}
+	if name, value := lm.Get("derp").Label(); name != "path" || value != "derp" {
+		t.Errorf("Label = %q, %q; want path, derp", name, value)
+	}
+
+	var buf bytes.Buffer
+	WritePrometheusExpositionFormat(&buf)
+	const wantProm = `# TYPE conns gauge
+conns 1
+# TYPE sent counter
+sent{path="derp"} 2
+sent{path="direct"} 4
+`
+	if got := buf.String(); got != wantProm {
+		t.Errorf("got:\n%s\nwant:\n%s", got, wantProm)
+	}
+
+	if got, want := EncodeLogTailMetricsDelta(), "N16gauge_connsS0202N22sent{path=direct}S0408N1esent{path=derp}S0604"; got != want {
+		t.Errorf("delta = %q; want %q", got, want)
+	}
+
+	for _, bad := range []string{"", "has space", `quo"te`} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Get(%q) didn't panic", bad)
+				}
+			}()
+			lm.Get(bad)
+		}()
+	}
+}
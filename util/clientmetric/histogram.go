@@ -0,0 +1,182 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package clientmetric
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Histogram is a metric that counts observed values, such as latencies,
+// in buckets with fixed upper bounds.
+//
+// Each bucket is a counter, so EncodeLogTailMetricsDelta only encodes
+// the buckets that changed. In the logs, the bucket for values up to and
+// including bound B is named "NAME_bucket{le=B}" (and the last one, for
+// values greater than all bounds, "NAME_bucket{le=inf}"). Unlike in the
+// Prometheus exposition format, it only counts the values in that
+// bucket, not the values in lower buckets too. The sum of all observed
+// values is "NAME_sum".
+//
+// It's safe for concurrent use.
+type Histogram struct {
+	name       string
+	label      string
+	labelValue string
+	bounds     []int64
+	buckets    []*Metric // len(bounds)+1; the last is for values over all bounds
+	sum        *Metric
+}
+
+// NewHistogram returns a new published Histogram with the given name and
+// bucket upper bounds, which must be in increasing order. The name should
+// end with the unit of the values, such as "_ms".
+func NewHistogram(name string, bounds []int64) *Histogram {
+	checkHistogram(name, bounds)
+	return newHistogram(name, "", "", bounds)
+}
+
+func checkHistogram(name string, bounds []int64) {
+	if i := strings.IndexFunc(name, isIllegalMetricRune); name == "" || i != -1 {
+		panic(fmt.Sprintf("illegal metric name %q (index %v)", name, i))
+	}
+	if len(bounds) == 0 {
+		panic(fmt.Sprintf("histogram %q has no buckets", name))
+	}
+	for i := 1; i < len(bounds); i++ {
+		if bounds[i] <= bounds[i-1] {
+			panic(fmt.Sprintf("histogram %q bounds not in increasing order", name))
+		}
+	}
+}
+
+func newHistogram(name, label, labelValue string, bounds []int64) *Histogram {
+	h := &Histogram{
+		name:       name,
+		label:      label,
+		labelValue: labelValue,
+		bounds:     append([]int64(nil), bounds...),
+	}
+	newMember := func(name, le string) *Metric {
+		m := &Metric{
+			name:       name,
+			typ:        TypeCounter,
+			label:      label,
+			labelValue: labelValue,
+			hist:       h,
+			le:         le,
+		}
+		m.Publish()
+		return m
+	}
+	for _, b := range h.bounds {
+		h.buckets = append(h.buckets, newMember(name+"_bucket", strconv.FormatInt(b, 10)))
+	}
+	h.buckets = append(h.buckets, newMember(name+"_bucket", "inf"))
+	h.sum = newMember(name+"_sum", "")
+	return h
+}
+
+func (h *Histogram) Name() string { return h.name }
+
+// Observe records the value v.
+func (h *Histogram) Observe(v int64) {
+	i := sort.Search(len(h.bounds), func(i int) bool { return v <= h.bounds[i] })
+	h.buckets[i].Add(1)
+	h.sum.Add(v)
+}
+
+// Counts returns the number of values observed in each bucket, not
+// counting those in lower buckets. The last element is the number of
+// values greater than all of h's bounds.
+func (h *Histogram) Counts() []int64 {
+	ret := make([]int64, len(h.buckets))
+	for i, m := range h.buckets {
+		ret[i] = m.Value()
+	}
+	return ret
+}
+
+// Sum returns the sum of the values observed.
+func (h *Histogram) Sum() int64 { return h.sum.Value() }
+
+// writePrometheus writes h's samples, without the TYPE line, to w in the
+// Prometheus text-based exposition format.
+func (h *Histogram) writePrometheus(w io.Writer) {
+	var labels string
+	if h.label != "" {
+		labels = fmt.Sprintf("%s=%q,", h.label, h.labelValue)
+	}
+	var total int64
+	for i, c := range h.Counts() {
+		total += c
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = strconv.FormatInt(h.bounds[i], 10)
+		}
+		fmt.Fprintf(w, "%s_bucket{%sle=%q} %v\n", h.name, labels, le, total)
+	}
+	labels = strings.TrimSuffix(labels, ",")
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %v\n", h.name, labels, h.Sum())
+	fmt.Fprintf(w, "%s_count%s %v\n", h.name, labels, total)
+}
+
+// HistogramMap is a set of histograms with the same name and buckets
+// that are distinguished by the value of a single label, such as DNS
+// forwarding latency with a "proto" label of "udp" or "doh".
+//
+// As with LabelMap, label values should come from a small, fixed set.
+//
+// It's safe for concurrent use.
+type HistogramMap struct {
+	name   string
+	label  string
+	bounds []int64
+
+	mu sync.Mutex
+	m  map[string]*Histogram // by label value
+}
+
+// NewHistogramMap returns a new HistogramMap of histograms with the given
+// name and bucket upper bounds, and a label named label. Its histograms
+// are published as they're first used by Get.
+func NewHistogramMap(name, label string, bounds []int64) *HistogramMap {
+	checkHistogram(name, bounds)
+	if i := strings.IndexFunc(label, isIllegalMetricRune); label == "" || label == "le" || i != -1 {
+		panic(fmt.Sprintf("illegal metric label %q (index %v)", label, i))
+	}
+	return &HistogramMap{
+		name:   name,
+		label:  label,
+		bounds: append([]int64(nil), bounds...),
+	}
+}
+
+// Get returns the histogram in hm whose label has the given value,
+// creating and publishing it if needed. The value must be non-empty and
+// consist of the same characters as metric names.
+func (hm *HistogramMap) Get(value string) *Histogram {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	if h, ok := hm.m[value]; ok {
+		return h
+	}
+	if i := strings.IndexFunc(value, isIllegalMetricRune); value == "" || i != -1 {
+		panic(fmt.Sprintf("illegal label value %q for histogram %q (index %v)", value, hm.name, i))
+	}
+	h := newHistogram(hm.name, hm.label, value, hm.bounds)
+	if hm.m == nil {
+		hm.m = make(map[string]*Histogram)
+	}
+	hm.m[value] = h
+	return h
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package clientmetric
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+	clearMetrics()
+
+	h := NewHistogram("rtt_ms", []int64{10, 100})
+	for _, v := range []int64{1, 10, 11, 500, 50} {
+		h.Observe(v)
+	}
+	if got, want := h.Counts(), []int64{2, 2, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Counts = %v; want %v", got, want)
+	}
+	if got, want := h.Sum(), int64(572); got != want {
+		t.Errorf("Sum = %v; want %v", got, want)
+	}
+
+	// Only the buckets that were used, and the sum, are encoded.
+	if got, want := EncodeLogTailMetricsDelta(), "N28rtt_ms_bucket{le=10}S0204N2artt_ms_bucket{le=100}S0404N2artt_ms_bucket{le=inf}S0602N14rtt_ms_sumS08f808"; got != want {
+		t.Errorf("first delta = %q; want %q", got, want)
+	}
+	h.Observe(5)
+	advanceTime()
+	if got, want := EncodeLogTailMetricsDelta(), "I0202I080a"; got != want {
+		t.Errorf("second delta = %q; want %q", got, want)
+	}
+
+	var buf bytes.Buffer
+	WritePrometheusExpositionFormat(&buf)
+	const want = `# TYPE rtt_ms histogram
+rtt_ms_bucket{le="10"} 3
+rtt_ms_bucket{le="100"} 5
+rtt_ms_bucket{le="+Inf"} 6
+rtt_ms_sum 577
+rtt_ms_count 6
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHistogramMap(t *testing.T) {
+	clearMetrics()
+
+	hm := NewHistogramMap("fwd_ms", "proto", []int64{5})
+	c := NewCounter("a_counter")
+	hm.Get("udp").Observe(1)
+	hm.Get("doh").Observe(7)
+	hm.Get("udp").Observe(3)
+	c.Add(1)
+
+	var buf bytes.Buffer
+	WritePrometheusExpositionFormat(&buf)
+	const want = `# TYPE a_counter counter
+a_counter 1
+# TYPE fwd_ms histogram
+fwd_ms_bucket{proto="doh",le="5"} 0
+fwd_ms_bucket{proto="doh",le="+Inf"} 1
+fwd_ms_sum{proto="doh"} 7
+fwd_ms_count{proto="doh"} 1
+fwd_ms_bucket{proto="udp",le="5"} 2
+fwd_ms_bucket{proto="udp",le="+Inf"} 2
+fwd_ms_sum{proto="udp"} 4
+fwd_ms_count{proto="udp"} 2
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	if got, want := EncodeLogTailMetricsDelta(), "N12a_counterS0202N3afwd_ms_bucket{proto=udp,le=5}S0404N2afwd_ms_sum{proto=udp}S0608N3efwd_ms_bucket{proto=doh,le=inf}S0802N2afwd_ms_sum{proto=doh}S0a0e"; got != want {
+		t.Errorf("delta = %q; want %q", got, want)
+	}
+}
+
+func TestHistogramBadBounds(t *testing.T) {
+	for _, bounds := range [][]int64{nil, {10, 10}, {10, 5}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("NewHistogram with bounds %v didn't panic", bounds)
+				}
+			}()
+			NewHistogram("bad", bounds)
+		}()
+	}
+}
@@ -477,7 +477,8 @@ type Options struct {
 	// it's been since a TUN packet was sent or received.
 	IdleFunc func() time.Duration
 
-	// TestOnlyPacketListener optionally specifies how to create PacketConns.
+	// TestOnlyPacketListener optionally specifies how to create PacketConns,
+	// for magicsock and its netcheck and portmapper clients.
 	// Only used by tests.
 	TestOnlyPacketListener nettype.PacketListener
 
@@ -553,6 +554,9 @@ func NewConn(opts Options) (*Conn, error) {
 	if opts.LinkMonitor != nil {
 		c.portMapper.SetGatewayLookupFunc(opts.LinkMonitor.GatewayAndSelfIP)
 	}
+	if opts.TestOnlyPacketListener != nil {
+		c.portMapper.SetPacketListener(opts.TestOnlyPacketListener)
+	}
 	c.linkMon = opts.LinkMonitor
 
 	if err := c.initialBind(); err != nil {
@@ -566,6 +570,7 @@ func NewConn(opts Options) (*Conn, error) {
 		GetSTUNConn4:        func() netcheck.STUNConn { return c.pconn4 },
 		SkipExternalNetwork: inTest(),
 		PortMapper:          c.portMapper,
+		PacketListener:      opts.TestOnlyPacketListener,
 	}
 
 	if c.pconn6 != nil {
@@ -864,6 +869,32 @@ func (c *Conn) SetNetInfoCallback(fn func(*tailcfg.NetInfo)) {
 	}
 }
 
+// LastNetcheckReport returns the most recent netcheck report, or nil if
+// none has completed yet.
+func (c *Conn) LastNetcheckReport() *netcheck.Report {
+	return c.lastNetCheckReport.Load()
+}
+
+// PingHomeDERP pings the home DERP server over the existing connection
+// to it and returns the home region and round-trip time.
+func (c *Conn) PingHomeDERP(ctx context.Context) (regionID int, latency time.Duration, err error) {
+	c.mu.Lock()
+	regionID = c.myDerp
+	ad, ok := c.activeDerp[regionID]
+	c.mu.Unlock()
+	if regionID == 0 {
+		return 0, 0, errors.New("no home DERP region")
+	}
+	if !ok {
+		return regionID, 0, fmt.Errorf("not connected to home DERP region %d", regionID)
+	}
+	t0 := time.Now()
+	if err := ad.c.Ping(ctx); err != nil {
+		return regionID, 0, fmt.Errorf("ping home DERP region %d: %w", regionID, err)
+	}
+	return regionID, time.Since(t0), nil
+}
+
 // LastRecvActivityOfNodeKey describes the time we last got traffic from
 // this endpoint (updated every ~10 seconds).
 func (c *Conn) LastRecvActivityOfNodeKey(nk key.NodePublic) string {
@@ -1197,6 +1228,7 @@ func (c *Conn) sendUDP(ipp netip.AddrPort, b []byte) (sent bool, err error) {
 	} else {
 		if sent {
 			metricSendUDP.Add(1)
+			metricSendBytesDirect.Add(int64(len(b)))
 		}
 	}
 	return
@@ -1626,6 +1658,7 @@ func (c *Conn) runDerpWriter(ctx context.Context, dc *derphttp.Client, ch <-chan
 				metricSendDERPError.Add(1)
 			} else {
 				metricSendDERP.Add(1)
+				metricSendBytesDERP.Add(int64(len(wr.b)))
 			}
 		}
 	}
@@ -1642,6 +1675,7 @@ func (c *Conn) receiveIPv6(b []byte) (int, conn.Endpoint, error) {
 		}
 		if ep, ok := c.receiveIP(b[:n], ipp, &c.ippEndpoint6); ok {
 			metricRecvDataIPv6.Add(1)
+			metricRecvDataBytesDirect.Add(int64(n))
 			return n, ep, nil
 		}
 	}
@@ -1658,6 +1692,7 @@ func (c *Conn) receiveIPv4(b []byte) (n int, ep conn.Endpoint, err error) {
 		}
 		if ep, ok := c.receiveIP(b[:n], ipp, &c.ippEndpoint4); ok {
 			metricRecvDataIPv4.Add(1)
+			metricRecvDataBytesDirect.Add(int64(n))
 			return n, ep, nil
 		}
 	}
@@ -1717,6 +1752,7 @@ func (c *connBind) receiveDERP(b []byte) (n int, ep conn.Endpoint, err error) {
 			continue
 		}
 		metricRecvDataDERP.Add(1)
+		metricRecvDataBytesDERP.Add(int64(n))
 		return n, ep, nil
 	}
 	return 0, nil, net.ErrClosed
@@ -3851,6 +3887,11 @@ func (de *endpoint) handlePongConnLocked(m *disco.Pong, di *discoInfo, src netip
 
 	now := mono.Now()
 	latency := now.Sub(sp.at)
+	if isDerp {
+		metricDiscoPingRTTDERP.Observe(latency.Milliseconds())
+	} else {
+		metricDiscoPingRTTDirect.Observe(latency.Milliseconds())
+	}
 
 	if !isDerp {
 		st, ok := de.endpointState[sp.to]
@@ -4164,6 +4205,11 @@ func (s derpAddrFamSelector) PreferIPv6() bool {
 	metricSendDERP            = clientmetric.NewCounter("magicsock_send_derp")
 	metricSendDERPError       = clientmetric.NewCounter("magicsock_send_derp_error")
 
+	// Bytes sent (data or disco), by path: "direct" or "derp"
+	metricSendBytes       = clientmetric.NewLabelMap("magicsock_send_bytes", clientmetric.TypeCounter, "path")
+	metricSendBytesDirect = metricSendBytes.Get("direct")
+	metricSendBytesDERP   = metricSendBytes.Get("derp")
+
 	// Data packets (non-disco)
 	metricSendData            = clientmetric.NewCounter("magicsock_send_data")
 	metricSendDataNetworkDown = clientmetric.NewCounter("magicsock_send_data_network_down")
@@ -4171,6 +4217,11 @@ func (s derpAddrFamSelector) PreferIPv6() bool {
 	metricRecvDataIPv4        = clientmetric.NewCounter("magicsock_recv_data_ipv4")
 	metricRecvDataIPv6        = clientmetric.NewCounter("magicsock_recv_data_ipv6")
 
+	// Data bytes received (non-disco), by path: "direct" or "derp"
+	metricRecvDataBytes       = clientmetric.NewLabelMap("magicsock_recv_data_bytes", clientmetric.TypeCounter, "path")
+	metricRecvDataBytesDirect = metricRecvDataBytes.Get("direct")
+	metricRecvDataBytesDERP   = metricRecvDataBytes.Get("derp")
+
 	// Disco packets
 	metricSendDiscoUDP         = clientmetric.NewCounter("magicsock_disco_send_udp")
 	metricSendDiscoDERP        = clientmetric.NewCounter("magicsock_disco_send_derp")
@@ -4191,6 +4242,12 @@ func (s derpAddrFamSelector) PreferIPv6() bool {
 	metricRecvDiscoCallMeMaybeBadNode  = clientmetric.NewCounter("magicsock_disco_recv_callmemaybe_bad_node")
 	metricRecvDiscoCallMeMaybeBadDisco = clientmetric.NewCounter("magicsock_disco_recv_callmemaybe_bad_disco")
 
+	// Round trip times of disco pings that got pongs, by path: "direct"
+	// or "derp"
+	metricDiscoPingRTT       = clientmetric.NewHistogramMap("magicsock_disco_ping_rtt_ms", "path", []int64{5, 10, 25, 50, 100, 250, 500, 1000, 2500})
+	metricDiscoPingRTTDirect = metricDiscoPingRTT.Get("direct")
+	metricDiscoPingRTTDERP   = metricDiscoPingRTT.Get("derp")
+
 	// metricDERPHomeChange is how many times our DERP home region DI has
 	// changed from non-zero to a different non-zero.
 	metricDERPHomeChange = clientmetric.NewCounter("derp_home_change")
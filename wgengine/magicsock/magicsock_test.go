@@ -831,6 +831,81 @@ func TestActiveDiscovery(t *testing.T) {
 	})
 }
 
+// TestNATScenarios runs two magicsocks across each of the natlab
+// scenarios, checking whether they find a direct path and how long it
+// takes.
+func TestNATScenarios(t *testing.T) {
+	for _, sc := range natlab.Scenarios {
+		sc := sc
+		t.Run(sc.Name, func(t *testing.T) {
+			topo := sc.Build()
+			d := &devices{
+				m1:     topo.Peer1,
+				m1IP:   topo.Peer1IP,
+				m2:     topo.Peer2,
+				m2IP:   topo.Peer2IP,
+				stun:   topo.STUN,
+				stunIP: topo.STUNIP,
+			}
+			testNATScenario(t, sc, d)
+		})
+	}
+}
+
+func testNATScenario(t *testing.T, sc natlab.Scenario, d *devices) {
+	tstest.PanicOnLog()
+	tstest.ResourceCheck(t)
+
+	logf, closeLogf := logger.LogfCloser(t.Logf)
+	defer closeLogf()
+
+	derpMap, cleanup := runDERPAndStun(t, logf, d.stun, d.stunIP)
+	defer cleanup()
+
+	m1 := newMagicStack(t, logger.WithPrefix(logf, "conn1: "), d.m1, derpMap)
+	defer m1.Close()
+	m2 := newMagicStack(t, logger.WithPrefix(logf, "conn2: "), d.m2, derpMap)
+	defer m2.Close()
+
+	start := time.Now()
+	cleanup = meshStacks(logf, nil, m1, m2)
+	defer cleanup()
+
+	// Once pings transit (over DERP, at first), the peers know of
+	// each other and can start NAT traversal.
+	cleanup = newPinger(t, logf, m1, m2)
+	defer cleanup()
+
+	if !sc.WantDirect {
+		const window = 5 * time.Second
+		if addr, ok := waitDirect(m1, m2, window); ok {
+			t.Errorf("found unexpected direct path %s->%s with addr %s", m1, m2, addr)
+		}
+		return
+	}
+	within := sc.DirectWithin
+	if within == 0 {
+		within = natlab.DefaultDirectWithin
+	}
+	for _, p := range [][2]*magicStack{{m1, m2}, {m2, m1}} {
+		if _, ok := waitDirect(p[0], p[1], within-time.Since(start)); !ok {
+			t.Fatalf("no direct path %s->%s within %v", p[0], p[1], within)
+		}
+	}
+	t.Logf("direct paths found in %v", time.Since(start).Round(time.Millisecond))
+}
+
+// waitDirect waits up to timeout for m1 to have a direct path to m2,
+// returning its address.
+func waitDirect(m1, m2 *magicStack, timeout time.Duration) (addr string, ok bool) {
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if pst := m1.Status().Peer[m2.Public()]; pst.CurAddr != "" {
+			return pst.CurAddr, true
+		}
+	}
+	return "", false
+}
+
 type devices struct {
 	m1   nettype.PacketListener
 	m1IP netip.Addr
@@ -185,7 +185,7 @@ func Create(logf logger.Logf, tundev *tstun.Wrapper, e wgengine.Engine, mc *magi
 		},
 	})
 	ns := &Impl{
-		logf:                logf,
+		logf:                logger.WithPrefix(logf, "netstack: "),
 		ipstack:             ipstack,
 		linkEP:              linkEP,
 		tundev:              tundev,
@@ -220,7 +220,7 @@ func (ns *Impl) wrapProtoHandler(h func(stack.TransportEndpointID, *stack.Packet
 		addr := tei.LocalAddress
 		ip, ok := netip.AddrFromSlice(net.IP(addr))
 		if !ok {
-			ns.logf("netstack: could not parse local address for incoming connection")
+			ns.logf("could not parse local address for incoming connection")
 			return false
 		}
 		ip = ip.Unmap()
@@ -345,9 +345,9 @@ func (ns *Impl) updateIPs(nm *netmap.NetworkMap) {
 	for ipp := range ipsToBeRemoved {
 		err := ns.ipstack.RemoveAddress(nicID, ipp.Address)
 		if err != nil {
-			ns.logf("netstack: could not deregister IP %s: %v", ipp, err)
+			ns.logf("could not deregister IP %s: %v", ipp, err)
 		} else {
-			ns.logf("[v2] netstack: deregistered IP %s", ipp)
+			ns.logf("[v2] deregistered IP %s", ipp)
 		}
 	}
 	for ipp := range ipsToBeAdded {
@@ -365,9 +365,9 @@ func (ns *Impl) updateIPs(nm *netmap.NetworkMap) {
 			ConfigType: stack.AddressConfigStatic,  // zero value default
 		})
 		if err != nil {
-			ns.logf("netstack: could not register IP %s: %v", ipp, err)
+			ns.logf("could not register IP %s: %v", ipp, err)
 		} else {
-			ns.logf("[v2] netstack: registered IP %s", ipp)
+			ns.logf("[v2] registered IP %s", ipp)
 		}
 	}
 }
@@ -818,7 +818,7 @@ func (ns *Impl) forwardTCP(client *gonet.TCPConn, clientRemoteIP netip.Addr, wq
 	defer client.Close()
 	dialAddrStr := dialAddr.String()
 	if debugNetstack {
-		ns.logf("[v2] netstack: forwarding incoming connection to %s", dialAddrStr)
+		ns.logf("[v2] forwarding incoming connection to %s", dialAddrStr)
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -834,7 +834,7 @@ func (ns *Impl) forwardTCP(client *gonet.TCPConn, clientRemoteIP netip.Addr, wq
 		select {
 		case <-notifyCh:
 			if debugNetstack {
-				ns.logf("[v2] netstack: forwardTCP notifyCh fired; canceling context for %s", dialAddrStr)
+				ns.logf("[v2] forwardTCP notifyCh fired; canceling context for %s", dialAddrStr)
 			}
 		case <-done:
 		}
@@ -843,7 +843,7 @@ func (ns *Impl) forwardTCP(client *gonet.TCPConn, clientRemoteIP netip.Addr, wq
 	var stdDialer net.Dialer
 	server, err := stdDialer.DialContext(ctx, "tcp", dialAddrStr)
 	if err != nil {
-		ns.logf("netstack: could not connect to local server at %s: %v", dialAddrStr, err)
+		ns.logf("could not connect to local server at %s: %v", dialAddrStr, err)
 		return
 	}
 	defer server.Close()
@@ -864,7 +864,7 @@ func (ns *Impl) forwardTCP(client *gonet.TCPConn, clientRemoteIP netip.Addr, wq
 	if err != nil {
 		ns.logf("proxy connection closed with error: %v", err)
 	}
-	ns.logf("[v2] netstack: forwarder connection to %s closed", dialAddrStr)
+	ns.logf("[v2] forwarder connection to %s closed", dialAddrStr)
 }
 
 func (ns *Impl) acceptUDP(r *udp.ForwarderRequest) {
@@ -946,7 +946,7 @@ func (ns *Impl) handleMagicDNSUDP(srcAddr netip.AddrPort, c *gonet.UDPConn) {
 func (ns *Impl) forwardUDP(client *gonet.UDPConn, wq *waiter.Queue, clientAddr, dstAddr netip.AddrPort) {
 	port, srcPort := dstAddr.Port(), clientAddr.Port()
 	if debugNetstack {
-		ns.logf("[v2] netstack: forwarding incoming UDP connection on port %v", port)
+		ns.logf("[v2] forwarding incoming UDP connection on port %v", port)
 	}
 
 	var backendListenAddr *net.UDPAddr
@@ -969,11 +969,11 @@ func (ns *Impl) forwardUDP(client *gonet.UDPConn, wq *waiter.Queue, clientAddr,
 
 	backendConn, err := net.ListenUDP("udp", backendListenAddr)
 	if err != nil {
-		ns.logf("netstack: could not bind local port %v: %v, trying again with random port", backendListenAddr.Port, err)
+		ns.logf("could not bind local port %v: %v, trying again with random port", backendListenAddr.Port, err)
 		backendListenAddr.Port = 0
 		backendConn, err = net.ListenUDP("udp", backendListenAddr)
 		if err != nil {
-			ns.logf("netstack: could not create UDP socket, preventing forwarding to %v: %v", dstAddr, err)
+			ns.logf("could not create UDP socket, preventing forwarding to %v: %v", dstAddr, err)
 			return
 		}
 	}
@@ -1002,7 +1002,7 @@ func (ns *Impl) forwardUDP(client *gonet.UDPConn, wq *waiter.Queue, clientAddr,
 		if isLocal {
 			ns.e.UnregisterIPPortIdentity(backendLocalIPPort)
 		}
-		ns.logf("netstack: UDP session between %s and %s timed out", backendListenAddr, backendRemoteAddr)
+		ns.logf("UDP session between %s and %s timed out", backendListenAddr, backendRemoteAddr)
 		cancel()
 		client.Close()
 		backendConn.Close()
@@ -1022,7 +1022,7 @@ func (ns *Impl) forwardUDP(client *gonet.UDPConn, wq *waiter.Queue, clientAddr,
 
 func startPacketCopy(ctx context.Context, cancel context.CancelFunc, dst net.PacketConn, dstAddr net.Addr, src net.PacketConn, logf logger.Logf, extend func()) {
 	if debugNetstack {
-		logf("[v2] netstack: startPacketCopy to %v (%T) from %T", dstAddr, dst, src)
+		logf("[v2] startPacketCopy to %v (%T) from %T", dstAddr, dst, src)
 	}
 	go func() {
 		defer cancel() // tear down the other direction's copy
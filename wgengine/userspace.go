@@ -85,6 +85,10 @@
 // status (as long as there's activity). See docs on its use below.
 const statusPollInterval = 1 * time.Minute
 
+// birdStatusInterval is how often the state of BIRD's protocols is
+// fetched for UpdateStatus, when there's a BIRDClient.
+const birdStatusInterval = 15 * time.Second
+
 type userspaceEngine struct {
 	logf              logger.Logf
 	wgLogger          *wglog.Logger //a wireguard-go logging wrapper
@@ -101,6 +105,7 @@ type userspaceEngine struct {
 	linkMonOwned      bool       // whether we created linkMon (and thus need to close it)
 	linkMonUnregister func()     // unsubscribes from changes; used regardless of linkMonOwned
 	birdClient        BIRDClient // or nil
+	bgpSpeaker        BGPSpeaker // or nil
 
 	testMaybeReconfigHook func() // for tests; if non-nil, fires if maybeReconfigWireguardLocked called
 
@@ -120,7 +125,8 @@ type userspaceEngine struct {
 	lastEngineSigFull   deephash.Sum // of full wireguard config
 	lastEngineSigTrim   deephash.Sum // of trimmed wireguard config
 	lastDNSConfig       *dns.Config
-	lastIsSubnetRouter  bool // was the node a primary subnet router in the last run.
+	lastIsSubnetRouter  bool           // was the node a primary subnet router in the last run.
+	lastBIRDRoutes      []netip.Prefix // routes last exported to BIRD; nil until the first export
 	recvActivityAt      map[key.NodePublic]mono.Time
 	trimmedNodes        map[key.NodePublic]bool   // set of node keys of peers currently excluded from wireguard config
 	sentActivityAt      map[netip.Addr]*mono.Time // value is accessed atomically
@@ -137,6 +143,7 @@ type userspaceEngine struct {
 	pendOpen            map[flowtrack.Tuple]*pendingOpenFlow // see pendopen.go
 	networkMapCallbacks map[*someHandle]NetworkMapCallback
 	tsIPByIPPort        map[netip.AddrPort]netip.Addr // allows registration of IP:ports as belonging to a certain Tailscale IP for whois lookups
+	birdProtocols       []*ipnstate.BIRDProtocol      // last fetched by pollBIRDProtocols, or nil
 
 	// pongCallback is the map of response handlers waiting for disco or TSMP
 	// pong callbacks. The map key is a random slice of bytes.
@@ -176,6 +183,20 @@ func (e *userspaceEngine) GetResolver() (r *resolver.Resolver, ok bool) {
 type BIRDClient interface {
 	EnableProtocol(proto string) error
 	DisableProtocol(proto string) error
+	// ExportRoutes makes BIRD aware of routes, which point at the
+	// Tailscale network interface dev. It's a no-op if route export
+	// isn't configured.
+	ExportRoutes(dev string, routes []netip.Prefix) error
+	// Protocols returns the state of BIRD's protocols. It's called
+	// periodically in the background, not for each status request.
+	Protocols() ([]*ipnstate.BIRDProtocol, error)
+	Close() error
+}
+
+// BGPSpeaker announces routes to a BGP neighbor.
+type BGPSpeaker interface {
+	// SetRoutes sets the routes to announce, withdrawing any others.
+	SetRoutes([]netip.Prefix)
 	Close() error
 }
 
@@ -216,8 +237,15 @@ type Config struct {
 	RespondToPing bool
 
 	// BIRDClient, if non-nil, will be used to configure BIRD whenever
-	// this node is a primary subnet router.
+	// this node is a primary subnet router, to export the routes that
+	// point into Tailscale to BIRD, and to report BIRD's state in
+	// status.
 	BIRDClient BIRDClient
+
+	// BGPSpeaker, if non-nil, is used to announce the subnet routes
+	// this node advertises while it's their primary router. It's an
+	// alternative to BIRDClient that doesn't need a BIRD daemon.
+	BGPSpeaker BGPSpeaker
 }
 
 func NewFakeUserspaceEngine(logf logger.Logf, listenPort uint16) (Engine, error) {
@@ -304,6 +332,7 @@ func NewUserspaceEngine(logf logger.Logf, conf Config) (_ Engine, reterr error)
 		router:         conf.Router,
 		confListenPort: conf.ListenPort,
 		birdClient:     conf.BIRDClient,
+		bgpSpeaker:     conf.BGPSpeaker,
 	}
 
 	if e.birdClient != nil {
@@ -455,6 +484,9 @@ func NewUserspaceEngine(logf logger.Logf, conf Config) (_ Engine, reterr error)
 	e.linkMon.Start()
 
 	go e.pollResolver()
+	if e.birdClient != nil {
+		go e.pollBIRDProtocols()
+	}
 
 	e.logf("Engine created.")
 	return e, nil
@@ -536,6 +568,39 @@ func (e *userspaceEngine) pollResolver() {
 	}
 }
 
+// pollBIRDProtocols fetches the state of BIRD's protocols every
+// birdStatusInterval for UpdateStatus, so that status requests don't
+// wait on BIRD, until the engine is closed.
+func (e *userspaceEngine) pollBIRDProtocols() {
+	t := time.NewTicker(birdStatusInterval)
+	defer t.Stop()
+	var lastErr string
+	for {
+		ps, err := e.birdClient.Protocols()
+		e.mu.Lock()
+		closing := e.closing
+		e.birdProtocols = ps
+		e.mu.Unlock()
+		if closing {
+			return
+		}
+		// Only log when the error changes, rather than every interval
+		// while BIRD is down.
+		if err != nil && err.Error() != lastErr {
+			e.logf("wgengine: getting BIRD protocols: %v", err)
+		}
+		lastErr = ""
+		if err != nil {
+			lastErr = err.Error()
+		}
+		select {
+		case <-e.waitCh:
+			return
+		case <-t.C:
+		}
+	}
+}
+
 var debugTrimWireguard = envknob.OptBool("TS_DEBUG_TRIM_WIREGUARD")
 
 // forceFullWireguardConfig reports whether we should give wireguard
@@ -855,9 +920,24 @@ func (e *userspaceEngine) Reconfig(cfg *wgcfg.Config, routerCfg *router.Config,
 	}
 	isSubnetRouterChanged := isSubnetRouter != e.lastIsSubnetRouter
 
+	if e.bgpSpeaker != nil {
+		var primary []netip.Prefix
+		if nm != nil && nm.SelfNode != nil {
+			primary = primarySubnetRoutes(nm.SelfNode.PrimaryRoutes, nm.Hostinfo.RoutableIPs)
+		}
+		e.bgpSpeaker.SetRoutes(primary)
+	}
+
+	var birdRoutes []netip.Prefix
+	birdRoutesChanged := false
+	if e.birdClient != nil {
+		birdRoutes = birdExportRoutes(routerCfg.Routes)
+		birdRoutesChanged = e.lastBIRDRoutes == nil || !prefixesEqual(birdRoutes, e.lastBIRDRoutes)
+	}
+
 	engineChanged := deephash.Update(&e.lastEngineSigFull, cfg)
 	routerChanged := deephash.Update(&e.lastRouterSig, routerCfg, dnsCfg)
-	if !engineChanged && !routerChanged && listenPort == e.magicConn.LocalPort() && !isSubnetRouterChanged {
+	if !engineChanged && !routerChanged && listenPort == e.magicConn.LocalPort() && !isSubnetRouterChanged && !birdRoutesChanged {
 		return ErrNoChanges
 	}
 
@@ -943,10 +1023,60 @@ func (e *userspaceEngine) Reconfig(cfg *wgcfg.Config, routerCfg *router.Config,
 		}
 	}
 
+	if birdRoutesChanged {
+		e.logf("wgengine: Reconfig: exporting %d routes to BIRD", len(birdRoutes))
+		tunName, _ := e.tundev.Name()
+		if err := e.birdClient.ExportRoutes(tunName, birdRoutes); err != nil {
+			// Log but don't fail here.
+			e.logf("wgengine: error exporting routes to BIRD: %v", err)
+		} else {
+			e.lastBIRDRoutes = birdRoutes
+		}
+	}
+
 	e.logf("[v1] wgengine: Reconfig done")
 	return nil
 }
 
+// primarySubnetRoutes returns the routes in primary, the routes
+// control says this node is the primary router for, that the node
+// still advertises in advertised. Exit node routes are left out.
+func primarySubnetRoutes(primary, advertised []netip.Prefix) []netip.Prefix {
+	var ret []netip.Prefix
+	for _, r := range primary {
+		if r.Bits() != 0 && hasOverlap([]netip.Prefix{r}, advertised) {
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
+
+// birdExportRoutes returns the routes in routes to export to BIRD.
+// Default routes, as used by exit nodes, are left out so as to not
+// take over the routing of whatever BIRD peers with. The result is
+// non-nil.
+func birdExportRoutes(routes []netip.Prefix) []netip.Prefix {
+	ret := make([]netip.Prefix, 0, len(routes))
+	for _, r := range routes {
+		if r.Bits() != 0 {
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
+
+func prefixesEqual(a, b []netip.Prefix) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func (e *userspaceEngine) GetFilter() *filter.Filter {
 	return e.tundev.GetFilter()
 }
@@ -1164,8 +1294,12 @@ func (e *userspaceEngine) Close() {
 	e.tundev.Close()
 	if e.birdClient != nil {
 		e.birdClient.DisableProtocol("tailscale")
+		e.birdClient.ExportRoutes("", nil)
 		e.birdClient.Close()
 	}
+	if e.bgpSpeaker != nil {
+		e.bgpSpeaker.Close()
+	}
 	close(e.waitCh)
 }
 
@@ -1287,6 +1421,15 @@ func (e *userspaceEngine) UpdateStatus(sb *ipnstate.StatusBuilder) {
 	}
 
 	e.magicConn.UpdateStatus(sb)
+
+	e.mu.Lock()
+	birdProtocols := e.birdProtocols
+	e.mu.Unlock()
+	if birdProtocols != nil {
+		sb.MutateStatus(func(s *ipnstate.Status) {
+			s.BIRDProtocols = birdProtocols
+		})
+	}
 }
 
 func (e *userspaceEngine) Ping(ip netip.Addr, pingType tailcfg.PingType, cb func(*ipnstate.PingResult)) {
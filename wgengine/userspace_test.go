@@ -8,9 +8,12 @@
 	"fmt"
 	"net/netip"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	"go4.org/mem"
+	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/net/dns"
 	"tailscale.com/net/netaddr"
 	"tailscale.com/net/tstun"
@@ -210,6 +213,120 @@ func TestUserspaceEnginePortReconfig(t *testing.T) {
 	}
 }
 
+type fakeBIRDClient struct {
+	exported [][]netip.Prefix
+
+	mu         sync.Mutex
+	protocols  []*ipnstate.BIRDProtocol
+	protoCalls int
+}
+
+func (*fakeBIRDClient) EnableProtocol(string) error  { return nil }
+func (*fakeBIRDClient) DisableProtocol(string) error { return nil }
+func (*fakeBIRDClient) Close() error                 { return nil }
+
+func (c *fakeBIRDClient) Protocols() ([]*ipnstate.BIRDProtocol, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.protoCalls++
+	return c.protocols, nil
+}
+
+func (c *fakeBIRDClient) ExportRoutes(dev string, routes []netip.Prefix) error {
+	c.exported = append(c.exported, routes)
+	return nil
+}
+
+func TestUserspaceEngineBIRDExport(t *testing.T) {
+	bird := new(fakeBIRDClient)
+	e, err := NewUserspaceEngine(t.Logf, Config{BIRDClient: bird})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Close)
+
+	cfg := &wgcfg.Config{}
+	reconfig := func(routes ...string) {
+		t.Helper()
+		routerCfg := &router.Config{}
+		for _, r := range routes {
+			routerCfg.Routes = append(routerCfg.Routes, netip.MustParsePrefix(r))
+		}
+		if err := e.Reconfig(cfg, routerCfg, &dns.Config{}, nil); err != nil && err != ErrNoChanges {
+			t.Fatal(err)
+		}
+	}
+	want := func(routes ...string) {
+		t.Helper()
+		if len(bird.exported) != 1 {
+			t.Fatalf("exported %d times; want once", len(bird.exported))
+		}
+		got := bird.exported[0]
+		bird.exported = nil
+		if len(got) != len(routes) {
+			t.Fatalf("exported %v; want %v", got, routes)
+		}
+		for i, r := range routes {
+			if got[i] != netip.MustParsePrefix(r) {
+				t.Fatalf("exported %v; want %v", got, routes)
+			}
+		}
+	}
+
+	reconfig()
+	want()
+	reconfig("100.64.0.1/32", "10.0.0.0/24")
+	want("100.64.0.1/32", "10.0.0.0/24")
+	reconfig("100.64.0.1/32", "10.0.0.0/24")
+	if len(bird.exported) != 0 {
+		t.Errorf("unchanged routes were exported again: %v", bird.exported)
+	}
+	reconfig("100.64.0.1/32", "0.0.0.0/0", "::/0")
+	want("100.64.0.1/32")
+}
+
+func TestUserspaceEngineBIRDStatus(t *testing.T) {
+	bird := &fakeBIRDClient{protocols: []*ipnstate.BIRDProtocol{
+		{Name: "bgp1", Proto: "BGP", State: "up", Routes: 3},
+	}}
+	e, err := NewUserspaceEngine(t.Logf, Config{BIRDClient: bird})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Close)
+	ue := e.(*userspaceEngine)
+
+	// The protocols are fetched in the background as soon as the
+	// engine starts.
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		ue.mu.Lock()
+		fetched := ue.birdProtocols != nil
+		ue.mu.Unlock()
+		if fetched {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("BIRD protocols weren't fetched")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	bird.mu.Lock()
+	calls := bird.protoCalls
+	bird.mu.Unlock()
+	sb := new(ipnstate.StatusBuilder)
+	e.UpdateStatus(sb)
+	st := sb.Status()
+	if len(st.BIRDProtocols) != 1 || st.BIRDProtocols[0].Name != "bgp1" {
+		t.Errorf("BIRDProtocols = %v", st.BIRDProtocols)
+	}
+	bird.mu.Lock()
+	defer bird.mu.Unlock()
+	if bird.protoCalls != calls {
+		t.Errorf("UpdateStatus asked BIRD for its protocols")
+	}
+}
+
 func nkFromHex(hex string) key.NodePublic {
 	if len(hex) != 64 {
 		panic(fmt.Sprintf("%q is len %d; want 64", hex, len(hex)))